@@ -47,6 +47,76 @@ type Client struct {
 	// time. This can be overridden by retry values sent by the server.
 	// Defaults to 5 seconds.
 	DefaultReconnectionTime time.Duration
+	// MinRetry and MaxRetry bound the reconnection delay, so it stays within sane limits
+	// regardless of the retry values sent by the server. The retry values and the default
+	// reconnection time are clamped to these bounds, and the delays of subsequent reconnections
+	// do not grow beyond MaxRetry. Zero means no bound. Servers can't disable the reconnection delay
+	// by sending a retry value of 0, unless MinRetry is set.
+	//
+	// Use Connection.ServerRetry and Connection.ReconnectionTime to inspect the values in use.
+	MinRetry, MaxRetry time.Duration
+	// IsTerminalEvent is an optional function used to determine whether an event signals
+	// the completion of the stream – many APIs send an event such as "data: [DONE]" or
+	// "event: end" when they are finished. The terminal event is delivered to the subscribers
+	// and then the connection is closed without any reconnection attempts, with Connect
+	// returning ErrStreamCompleted. See TerminalEventType for a simple implementation.
+	IsTerminalEvent func(Event) bool
+	// StrictParsing enables the diagnosis of the received streams. As the specification requires,
+	// anomalies such as unknown fields are ignored, but in strict mode they are counted
+	// and reported through the OnParseAnomaly callback. This makes stream corruption, for example
+	// by misbehaving proxies, visible. See ParseAnomalyKind for the detected anomalies and
+	// Connection.ParseAnomalies for the counters.
+	StrictParsing bool
+	// OnParseAnomaly is an optional callback that's executed for each anomaly found
+	// in the received streams, if StrictParsing is enabled. It is called synchronously,
+	// so it must not block.
+	OnParseAnomaly func(ParseAnomaly)
+	// MaxParseAnomalies is the number of anomalies after which the connection fails
+	// with ErrTooManyParseAnomalies, if StrictParsing is enabled. The anomalies are counted
+	// over the whole lifetime of the connection, across reconnections. The failure is permanent.
+	// Defaults to 0, which means that the connection never fails because of anomalies.
+	MaxParseAnomalies int
+	// MaxEventSize is the maximum size of a received event, in bytes, including its field names and
+	// line endings. Connections which receive bigger events fail permanently with a LimitError, so
+	// a misbehaving server can't make the client allocate unbounded memory. Defaults to DefaultMaxEventSize.
+	MaxEventSize int
+	// MaxFieldLength is the maximum length of the value of a received field – a single data line, for example –,
+	// in bytes. Connections which receive longer fields fail permanently with a LimitError. Defaults to 0,
+	// which means that fields are limited only by MaxEventSize.
+	MaxFieldLength int
+	// ResumeSessions configures the connections to keep the resumption token received from the server,
+	// either in the ResumptionTokenHeader response header or in an event of type ResumptionTokenEventType,
+	// and to send it back in the ResumptionTokenHeader request header when reconnecting. This way,
+	// servers can reattach the state they hold for the session. See ResumptionTokens for more information.
+	ResumeSessions bool
+	// IdleTimeout is the maximum duration for which a connection may receive nothing from the server,
+	// not even comments, before it fails with ErrIdleTimeout and is reattempted. This detects connections
+	// which are silently dead, for example after a network change. Servers usually send keep-alive comments,
+	// so set it to a multiple of their interval. Defaults to 0, which means that connections never time out.
+	IdleTimeout time.Duration
+	// KeepAliveHint enables the negotiation of the idle timeout from the keep-alive comments sent by the server,
+	// so the client doesn't need to be configured in sync with it. It is called with each received comment and
+	// reports whether the comment is a keep-alive and, optionally, the interval at which keep-alives are sent.
+	// The idle timeout becomes three times the interval. If no interval is given, it is measured as the time
+	// between two consecutive keep-alives. Until the interval is known, IdleTimeout is used.
+	//
+	// See ParseKeepAliveHint for an implementation of a simple convention.
+	KeepAliveHint func(comment string) (interval time.Duration, isKeepAlive bool)
+	// Recorder is an optional RecordingWriter which records the events received
+	// by the connections, so the streams can be served later using ReplayFileHandler.
+	Recorder *RecordingWriter
+	// Shared is an optional registry of shared connections. If it is set, the connections created by the Client
+	// to the same stream share a single upstream connection. See SharedConnections for more information.
+	Shared *SharedConnections
+}
+
+// TerminalEventType returns a function to be used as the Client's IsTerminalEvent field
+// which treats events of the given type as terminal.
+func TerminalEventType(typ string) func(Event) bool {
+	typ = canonicalEventType(typ)
+	return func(e Event) bool {
+		return e.Type == typ
+	}
 }
 
 // NewConnection initializes and configures a connection. On connect, the given
@@ -70,6 +140,9 @@ func (c *Client) NewConnection(r *http.Request) *Connection {
 		callbacks:    map[string]map[int]EventCallback{},
 		callbacksAll: map[int]EventCallback{},
 	}
+	conn.idleTimeout = conn.client.IdleTimeout
+	conn.serverRetry.Store(-1)
+	conn.effectiveRetryTime.Store(int64(conn.client.clampRetry(conn.client.DefaultReconnectionTime)))
 
 	return conn
 }
@@ -80,7 +153,10 @@ func (c *Client) do(r *http.Request) (*http.Response, error) {
 
 func (c *Client) newBackoff(ctx context.Context) (backoff.BackOff, *time.Duration) {
 	base := backoff.NewExponentialBackOff()
-	base.InitialInterval = c.DefaultReconnectionTime
+	base.InitialInterval = c.clampRetry(c.DefaultReconnectionTime)
+	if c.MaxRetry > 0 {
+		base.MaxInterval = c.MaxRetry
+	}
 	initialReconnectionTime := &base.InitialInterval
 	b := backoff.WithContext(base, ctx)
 	if c.MaxRetries >= 0 {
@@ -89,6 +165,17 @@ func (c *Client) newBackoff(ctx context.Context) (backoff.BackOff, *time.Duratio
 	return b, initialReconnectionTime
 }
 
+// clampRetry bounds the given reconnection delay to the MinRetry and MaxRetry values.
+func (c *Client) clampRetry(d time.Duration) time.Duration {
+	if d < c.MinRetry {
+		return c.MinRetry
+	}
+	if c.MaxRetry > 0 && d > c.MaxRetry {
+		return c.MaxRetry
+	}
+	return d
+}
+
 func contentType(header string) string {
 	cts := strings.FieldsFunc(header, func(r rune) bool {
 		return unicode.IsSpace(r) || r == ';' || r == ','
@@ -2,13 +2,16 @@ package sse
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -20,10 +23,14 @@ type Event struct {
 	// The last non-empty ID of all the events received. This may not be
 	// the ID of the latest event!
 	LastEventID string
-	// The event's type. It is empty if the event is unnamed.
+	// The event's type. It is empty if the event is unnamed or if its type is
+	// the DefaultEventType, as clients treat these events the same.
 	Type string
 	// The events's payload.
 	Data string
+	// Binary reports whether the event's data was sent as binary data, encoded using Base64, and Data holds
+	// the decoded bytes. See Message.SetBinaryData.
+	Binary bool
 }
 
 // EventCallback is a function that is used to receive events from a Connection.
@@ -47,9 +54,26 @@ type Connection struct { //nolint:govet // The current order aids readability.
 	callbacksAll     map[int]EventCallback
 	reconnectionTime *time.Duration
 	lastEventID      string
-	client           Client
-	callbackID       int
-	isRetry          bool
+	resumptionToken  string
+	// recordedID is the last event ID recorded by the client's Recorder.
+	recordedID string
+	// idleTimeout is the current idle timeout, which is negotiated if the client has a KeepAliveHint.
+	idleTimeout time.Duration
+	// lastKeepAlive is the time the last keep-alive was received on the current connection.
+	lastKeepAlive time.Time
+	// shared is set if the connection is the upstream connection of a SharedConnections.
+	shared         *sharedConnection
+	client         Client
+	callbackID     int
+	isRetry        bool
+	anomalies      [parseAnomalyKinds]atomic.Int64
+	anomaliesTotal int
+	// serverRetry is the last retry value received from the server, or -1 if none was received.
+	serverRetry        atomic.Int64
+	effectiveRetryTime atomic.Int64
+	// onEvent is called synchronously with each event, before the callbacks, so the events are handled
+	// in the order they were received. See RelayProvider.
+	onEvent EventCallback
 }
 
 // SubscribeMessages subscribes the given callback to all events without type (without or with empty `event“ field).
@@ -59,7 +83,8 @@ func (c *Connection) SubscribeMessages(cb EventCallback) EventCallbackRemover {
 }
 
 // SubscribeEvent subscribes the given callback to all the events with the provided type
-// (the `event` field has the value given here).
+// (the `event` field has the value given here). Subscribing to the DefaultEventType is
+// the same as subscribing to the events without type – see SubscribeMessages.
 // Remove the callback by calling the returned function.
 func (c *Connection) SubscribeEvent(typ string, cb EventCallback) EventCallbackRemover {
 	return c.addSubscriber(typ, cb)
@@ -88,6 +113,8 @@ func (c *Connection) addSubscriberToAll(cb EventCallback) EventCallbackRemover {
 }
 
 func (c *Connection) addSubscriber(event string, cb EventCallback) EventCallbackRemover {
+	event = canonicalEventType(event)
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -166,6 +193,9 @@ func (c *Connection) resetRequest() error {
 	} else {
 		c.request.Header.Set("Last-Event-ID", c.lastEventID)
 	}
+	if c.resumptionToken != "" {
+		c.request.Header.Set(ResumptionTokenHeader, c.resumptionToken)
+	}
 	return nil
 }
 
@@ -176,7 +206,57 @@ func (c *Connection) executeCallback(cb EventCallback, ev Event) {
 	}()
 }
 
-func (c *Connection) dispatch(ev Event) {
+// dispatch sends the event to the subscribed callbacks. It returns true if the event is terminal.
+func (c *Connection) dispatch(ev Event) bool {
+	if l := len(ev.Data); l > 0 {
+		ev.Data = ev.Data[:l-1]
+	}
+	ev.LastEventID = c.lastEventID
+	ev.Type = canonicalEventType(ev.Type)
+
+	if c.client.ResumeSessions && ev.Type == ResumptionTokenEventType {
+		c.resumptionToken = ev.Data
+	}
+	if rec := c.client.Recorder; rec != nil && !rec.Raw {
+		rec.record(ev, &c.recordedID)
+	}
+
+	if c.onEvent != nil {
+		c.onEvent(ev)
+	}
+	c.dispatchToCallbacks(ev)
+	if c.shared != nil {
+		c.shared.dispatch(ev)
+	}
+
+	return c.client.IsTerminalEvent != nil && c.client.IsTerminalEvent(ev)
+}
+
+// decodeBinaryData decodes the data of an event marked with the BinaryDataComment. If the data is not valid
+// Base64, the event is dispatched as is and the anomaly is reported.
+func (c *Connection) decodeBinaryData(ev *Event) error {
+	// The decoder ignores newlines, including the one which ends the data.
+	data, err := base64.StdEncoding.DecodeString(ev.Data)
+	if err != nil {
+		ev.Binary = false
+		return c.reportAnomaly(ParseAnomalyInvalidBinaryData, strings.TrimSuffix(ev.Data, "\n"))
+	}
+
+	// dispatch removes the newline which ends the data.
+	ev.Data = string(data) + "\n"
+	return nil
+}
+
+// canonicalEventType returns the empty type for the DefaultEventType, which the client uses
+// for all the events without type, and the given type otherwise.
+func canonicalEventType(typ string) string {
+	if typ == DefaultEventType {
+		return ""
+	}
+	return typ
+}
+
+func (c *Connection) dispatchToCallbacks(ev Event) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -186,11 +266,6 @@ func (c *Connection) dispatch(ev Event) {
 		return
 	}
 
-	if l := len(ev.Data); l > 0 {
-		ev.Data = ev.Data[:l-1]
-	}
-	ev.LastEventID = c.lastEventID
-
 	c.wg.Add(cbCount)
 	for _, cb := range c.callbacks[ev.Type] {
 		c.executeCallback(cb, ev)
@@ -201,11 +276,53 @@ func (c *Connection) dispatch(ev Event) {
 }
 
 func (c *Connection) read(r io.Reader, reset func()) error {
+	if rec := c.client.Recorder; rec != nil && rec.Raw {
+		r = io.TeeReader(r, rec)
+	}
+
+	limits := parseLimits{maxEventSize: c.client.MaxEventSize, maxFieldLength: c.client.MaxFieldLength}
 	p := parser.New(r)
+	p.KeepUnknown(c.client.StrictParsing)
+	// Comments are kept for the keep-alive hints and for the BinaryDataComment.
+	p.KeepComments(true)
+	limits.apply(p)
 	ev, dirty := Event{}, false
+	blankLines := 0
+	reportBlankLines := func() error {
+		for n := p.BlankLines(); blankLines < n; blankLines++ {
+			if err := c.reportAnomaly(ParseAnomalyBlankLine, ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
 
 	for f := (parser.Field{}); p.Next(&f); {
-		switch f.Name { //nolint:exhaustive // Comment fields are not parsed.
+		if err := reportBlankLines(); err != nil {
+			return err
+		}
+		if err := limits.check(f); err != nil {
+			e := &ConnectionError{Req: c.request, Reason: "reading response body failed", Err: err}
+			return e.toPermanent()
+		}
+
+		switch f.Name {
+		case parser.FieldNameComment:
+			if f.Value == BinaryDataComment {
+				ev.Binary = true
+				break
+			}
+			if c.client.KeepAliveHint != nil {
+				c.handleKeepAlive(f.Value)
+			}
+		case parser.FieldNameUnknown:
+			kind := ParseAnomalyUnknownField
+			if strings.IndexByte(f.Value, ':') == -1 {
+				kind = ParseAnomalyMalformedLine
+			}
+			if err := c.reportAnomaly(kind, f.Value); err != nil {
+				return err
+			}
 		case parser.FieldNameData:
 			ev.Data += f.Value + "\n"
 			dirty = true
@@ -222,25 +339,45 @@ func (c *Connection) read(r io.Reader, reset func()) error {
 			c.lastEventID = f.Value
 			dirty = true
 		case parser.FieldNameRetry:
-			n, err := strconv.ParseInt(f.Value, 10, 64)
-			if err != nil {
+			retry, ok := parseRetry(f.Value)
+			if !ok {
+				if err := c.reportAnomaly(ParseAnomalyInvalidRetry, f.Value); err != nil {
+					return err
+				}
 				break
 			}
-			if n > 0 {
-				*c.reconnectionTime = time.Duration(n) * time.Millisecond
+			c.serverRetry.Store(int64(retry))
+			if retry > 0 || c.client.MinRetry > 0 {
+				c.setReconnectionTime(c.client.clampRetry(retry))
 				reset()
 			}
 			dirty = true
 		default:
-			c.dispatch(ev)
+			if ev.Binary {
+				if err := c.decodeBinaryData(&ev); err != nil {
+					return err
+				}
+			}
+			if c.dispatch(ev) {
+				return backoff.Permanent(ErrStreamCompleted)
+			}
 			ev = Event{}
 			dirty = false
 		}
 	}
 
-	err := p.Err()
-	if dirty && err == nil {
-		c.dispatch(ev)
+	if err := reportBlankLines(); err != nil {
+		return err
+	}
+
+	err := limits.err(p.Err())
+	if dirty && err == nil && c.dispatch(ev) {
+		return backoff.Permanent(ErrStreamCompleted)
+	}
+	if errors.Is(err, parser.ErrUnexpectedEOF) {
+		if err := c.reportAnomaly(ParseAnomalyIncompleteEvent, ""); err != nil {
+			return err
+		}
 	}
 	if isSuccess(err) {
 		return nil
@@ -249,6 +386,55 @@ func (c *Connection) read(r io.Reader, reset func()) error {
 	return e.toPermanent()
 }
 
+// parseRetry parses the value of a retry field. As the specification requires,
+// the value is valid only if it consists of ASCII digits.
+func parseRetry(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	const maxMs = math.MaxInt64 / int64(time.Millisecond)
+
+	var ms int64
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, false
+		}
+		if ms <= maxMs {
+			// Once the value is too big, the digits are only validated.
+			ms = ms*10 + int64(value[i]-'0')
+		}
+	}
+
+	if ms > maxMs {
+		return math.MaxInt64, true
+	}
+
+	return time.Duration(ms) * time.Millisecond, true
+}
+
+func (c *Connection) setReconnectionTime(d time.Duration) {
+	*c.reconnectionTime = d
+	c.effectiveRetryTime.Store(int64(d))
+}
+
+// ServerRetry returns the last retry value received from the server,
+// as is, and whether a retry value was received at all.
+func (c *Connection) ServerRetry() (time.Duration, bool) {
+	v := c.serverRetry.Load()
+	if v < 0 {
+		return 0, false
+	}
+	return time.Duration(v), true
+}
+
+// ReconnectionTime returns the reconnection delay in use: either the Client's default reconnection time
+// or the last retry value received from the server, clamped to the Client's MinRetry and MaxRetry values.
+// Reconnections after consecutive failures use longer delays.
+func (c *Connection) ReconnectionTime() time.Duration {
+	return time.Duration(c.effectiveRetryTime.Load())
+}
+
 func isSuccess(err error) bool {
 	return err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, parser.ErrUnexpectedEOF)
 }
@@ -262,15 +448,28 @@ func isSuccess(err error) bool {
 // using an exponential backoff that has the initial time set to either the
 // client's default value or to the retry value received from the server.
 // If an error is permanent (e.g. no internet connection), no retries are done.
-// All errors returned are of type *ConnectionError.
+// All errors returned are of type *ConnectionError, except ErrStreamCompleted,
+// which is returned when a terminal event is received – see Client.IsTerminalEvent.
 //
 // After Connect returns, all subscriptions will be closed. Make sure to wait
 // for the subscribers' goroutines to exit, as they may still be running after
 // Connect has returned. Connect cannot be called twice for the same connection.
+//
+// If the Client shares connections, Connect joins the shared upstream connection instead –
+// see SharedConnections.
 func (c *Connection) Connect() error {
+	if c.client.Shared != nil {
+		return c.client.Shared.connect(c)
+	}
+
+	return c.connect()
+}
+
+func (c *Connection) connect() error {
 	b, interval := c.client.newBackoff(c.request.Context())
 
 	c.reconnectionTime = interval
+	c.effectiveRetryTime.Store(int64(*interval))
 	c.request.Header.Set("Accept", "text/event-stream")
 	c.request.Header.Set("Connection", "keep-alive")
 	c.request.Header.Set("Cache", "no-cache")
@@ -292,9 +491,23 @@ func (c *Connection) Connect() error {
 			return e.toPermanent()
 		}
 
+		if token := res.Header.Get(ResumptionTokenHeader); token != "" && c.client.ResumeSessions {
+			c.resumptionToken = token
+		}
+
 		b.Reset()
 
-		return c.read(res.Body, b.Reset)
+		// Keep-alives received on previous connections are not used to measure the interval.
+		c.lastKeepAlive = time.Time{}
+		body := &idleReader{body: res.Body, conn: c}
+
+		err = c.read(body, b.Reset)
+		if body.stop() {
+			e := &ConnectionError{Req: c.request, Reason: "reading response body failed", Err: ErrIdleTimeout}
+			return e.toPermanent()
+		}
+
+		return err
 	}
 
 	err := backoff.RetryNotify(op, b, c.client.OnRetry)
@@ -303,6 +516,94 @@ func (c *Connection) Connect() error {
 	return err
 }
 
+// ParseAnomalyKind is the kind of an anomaly found in a received stream. See Client.StrictParsing.
+type ParseAnomalyKind int
+
+// The detected anomalies.
+const (
+	// ParseAnomalyUnknownField is reported for fields with an unknown name, such as "dta: value".
+	ParseAnomalyUnknownField ParseAnomalyKind = iota
+	// ParseAnomalyMalformedLine is reported for lines which are neither fields nor comments,
+	// such as lines of data without a field name.
+	ParseAnomalyMalformedLine
+	// ParseAnomalyInvalidRetry is reported for retry fields whose value is not a number.
+	ParseAnomalyInvalidRetry
+	// ParseAnomalyBlankLine is reported for each excessive blank line: those that follow
+	// the blank line which ends an event or that precede the first event.
+	ParseAnomalyBlankLine
+	// ParseAnomalyIncompleteEvent is reported when the stream ends in the middle of an event.
+	ParseAnomalyIncompleteEvent
+	// ParseAnomalyInvalidBinaryData is reported for events marked with the BinaryDataComment whose data
+	// is not valid Base64. The events are dispatched with their data as received.
+	ParseAnomalyInvalidBinaryData
+
+	parseAnomalyKinds int = iota
+)
+
+func (k ParseAnomalyKind) String() string {
+	switch k {
+	case ParseAnomalyUnknownField:
+		return "unknown field"
+	case ParseAnomalyMalformedLine:
+		return "malformed line"
+	case ParseAnomalyInvalidRetry:
+		return "invalid retry"
+	case ParseAnomalyBlankLine:
+		return "excessive blank line"
+	case ParseAnomalyIncompleteEvent:
+		return "incomplete event"
+	case ParseAnomalyInvalidBinaryData:
+		return "invalid binary data"
+	default:
+		return "ParseAnomalyKind(" + strconv.Itoa(int(k)) + ")"
+	}
+}
+
+// ParseAnomaly describes an anomaly found in a received stream. See Client.StrictParsing.
+type ParseAnomaly struct {
+	// The offending line, if there is one.
+	Line string
+	// The kind of the anomaly.
+	Kind ParseAnomalyKind
+}
+
+// ParseAnomalies returns the number of anomalies of the given kind found in the streams
+// received by the connection until now. Anomalies are counted only if the Client's
+// StrictParsing field is set. It is safe to call ParseAnomalies concurrently with Connect.
+func (c *Connection) ParseAnomalies(kind ParseAnomalyKind) int64 {
+	if kind < 0 || int(kind) >= parseAnomalyKinds {
+		return 0
+	}
+	return c.anomalies[kind].Load()
+}
+
+func (c *Connection) reportAnomaly(kind ParseAnomalyKind, line string) error {
+	if !c.client.StrictParsing {
+		return nil
+	}
+
+	c.anomalies[kind].Add(1)
+	c.anomaliesTotal++
+
+	if c.client.OnParseAnomaly != nil {
+		c.client.OnParseAnomaly(ParseAnomaly{Kind: kind, Line: line})
+	}
+
+	if limit := c.client.MaxParseAnomalies; limit > 0 && c.anomaliesTotal >= limit {
+		return backoff.Permanent(&ConnectionError{Req: c.request, Reason: "reading response body failed", Err: ErrTooManyParseAnomalies})
+	}
+
+	return nil
+}
+
+// ErrTooManyParseAnomalies is returned when the connection fails because the number of anomalies
+// found in the received streams reached the configured maximum. See Client.MaxParseAnomalies.
+var ErrTooManyParseAnomalies = errors.New("go-sse.client: too many parse anomalies")
+
+// ErrStreamCompleted is returned by Connect when a terminal event is received.
+// It signals that the stream completed successfully. See Client.IsTerminalEvent.
+var ErrStreamCompleted = errors.New("go-sse.client: event stream completed")
+
 // ErrNoGetBody is a sentinel error returned when the connection cannot be reattempted
 // due to GetBody not existing on the original request.
 var ErrNoGetBody = errors.New("the GetBody function doesn't exist on the request")
@@ -0,0 +1,208 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// GroupEventCallback is a function that is used to receive events from the connections of a ConnectionGroup.
+// The source is the label with which the connection that received the event was added to the group.
+type GroupEventCallback func(source string, e Event)
+
+// ConnectionGroup owns multiple connections, possibly to different streams, and manages them together:
+// callbacks are registered once for all the connections and all of them are connected and stopped
+// at the same time. Each connection is identified by a label, its source.
+//
+// The connections are created as usual, so their options are configured individually, using the Client
+// they are created with. Callbacks registered on the connections directly continue to work.
+//
+// Callbacks registered on the group are run sequentially for each event, on the goroutine the connection
+// runs the event's callbacks on. The ConnectionGroup must not be copied after it is used.
+type ConnectionGroup struct { //nolint:govet // The current order aids readability.
+	// OnRetry is an optional callback that's executed whenever a connection of the group
+	// starts a reconnection attempt. It is called after the Client's OnRetry, if any.
+	OnRetry func(source string, err error, next time.Duration)
+	// OnConnectionEnd is an optional callback that's executed whenever a connection of the group ends.
+	// The error is the one returned by the connection's Connect method.
+	OnConnectionEnd func(source string, err error)
+
+	mu         sync.RWMutex
+	members    []groupMember
+	callbacks  map[int]groupCallback
+	callbackID int
+}
+
+type groupMember struct {
+	conn     *Connection
+	source   string
+	required bool
+}
+
+type groupCallback struct {
+	cb      GroupEventCallback
+	typ     string
+	sources []string
+	all     bool
+}
+
+func (g groupCallback) matches(source string, e Event) bool {
+	if !g.all && g.typ != e.Type {
+		return false
+	}
+	if len(g.sources) == 0 {
+		return true
+	}
+
+	for _, s := range g.sources {
+		if s == source {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Add adds a connection to the group, identified by the given source label. If the connection
+// is required, its permanent failure stops the whole group – see Connect.
+//
+// Connections must be added before Connect is called and they must not be connected individually.
+// Add panics if the source is not unique.
+func (g *ConnectionGroup) Add(source string, conn *Connection, required bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, m := range g.members {
+		if m.source == source {
+			panic(fmt.Sprintf("go-sse.client.ConnectionGroup.Add: duplicate source %q", source))
+		}
+	}
+
+	onRetry := conn.client.OnRetry
+	conn.client.OnRetry = func(err error, next time.Duration) {
+		if onRetry != nil {
+			onRetry(err, next)
+		}
+		if g.OnRetry != nil {
+			g.OnRetry(source, err, next)
+		}
+	}
+
+	conn.SubscribeToAll(func(e Event) { g.dispatch(source, e) })
+
+	g.members = append(g.members, groupMember{conn: conn, source: source, required: required})
+}
+
+// SubscribeEvent subscribes the given callback to all the events with the provided type received
+// by the connections of the group. If sources are given, the callback receives only the events
+// received by the connections with those sources.
+// Remove the callback by calling the returned function.
+func (g *ConnectionGroup) SubscribeEvent(typ string, cb GroupEventCallback, sources ...string) EventCallbackRemover {
+	return g.addCallback(groupCallback{cb: cb, typ: canonicalEventType(typ), sources: sources})
+}
+
+// SubscribeToAll subscribes the given callback to all the events received by the connections
+// of the group, with or without type. If sources are given, the callback receives only the events
+// received by the connections with those sources.
+// Remove the callback by calling the returned function.
+func (g *ConnectionGroup) SubscribeToAll(cb GroupEventCallback, sources ...string) EventCallbackRemover {
+	return g.addCallback(groupCallback{cb: cb, sources: sources, all: true})
+}
+
+func (g *ConnectionGroup) addCallback(cb groupCallback) EventCallbackRemover {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.callbacks == nil {
+		g.callbacks = map[int]groupCallback{}
+	}
+
+	id := g.callbackID
+	g.callbacks[id] = cb
+	g.callbackID++
+
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+
+		delete(g.callbacks, id)
+	}
+}
+
+func (g *ConnectionGroup) dispatch(source string, e Event) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+
+	for _, cb := range g.callbacks {
+		if cb.matches(source, e) {
+			cb.cb(source, e)
+		}
+	}
+}
+
+// GroupConnectionError is returned by ConnectionGroup.Connect when a required connection fails.
+type GroupConnectionError struct {
+	// The error returned by the connection's Connect method.
+	Err error
+	// The source of the failed connection.
+	Source string
+}
+
+func (e *GroupConnectionError) Error() string {
+	return fmt.Sprintf("connection %q failed: %v", e.Source, e.Err)
+}
+
+func (e *GroupConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// Connect connects all the connections of the group and blocks until all of them end.
+// The contexts of the connections' requests are replaced with the given context,
+// so use it to stop all the connections at once.
+//
+// If a required connection fails – its Connect method returns an error other than ErrStreamCompleted –
+// all the other connections are stopped and Connect returns a *GroupConnectionError. Connect returns
+// nil if all the connections end successfully or if the context is done. Failures of connections
+// which are not required are reported only through the OnConnectionEnd callback.
+//
+// As with Connection, make sure to wait for the callbacks to exit after Connect returns.
+// Connect cannot be called twice for the same group.
+func (g *ConnectionGroup) Connect(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	g.mu.RLock()
+	members := g.members
+	g.mu.RUnlock()
+
+	errs := make(chan *GroupConnectionError, len(members))
+
+	for _, m := range members {
+		m.conn.request = m.conn.request.WithContext(ctx)
+
+		go func(m groupMember) {
+			err := m.conn.Connect()
+			if g.OnConnectionEnd != nil {
+				g.OnConnectionEnd(m.source, err)
+			}
+
+			if m.required && err != nil && !errors.Is(err, ErrStreamCompleted) && ctx.Err() == nil {
+				errs <- &GroupConnectionError{Source: m.source, Err: err}
+				cancel()
+			} else {
+				errs <- nil
+			}
+		}(m)
+	}
+
+	var err error
+	for range members {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,109 @@
+package sse
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIdleTimeout is the error with which a connection fails when nothing is received from the server
+// for longer than the idle timeout. It is a timeout error, so the connection is reattempted.
+// See the Client's IdleTimeout and KeepAliveHint fields for more information.
+var ErrIdleTimeout error = idleTimeoutError{}
+
+type idleTimeoutError struct{}
+
+func (idleTimeoutError) Error() string { return "go-sse.client: idle timeout exceeded" }
+func (idleTimeoutError) Timeout() bool { return true }
+
+// keepAliveTimeoutFactor is the multiple of the keep-alive interval used as the idle timeout,
+// so that a couple of delayed keep-alives don't end the connection.
+const keepAliveTimeoutFactor = 3
+
+// ParseKeepAliveHint recognizes the keep-alive comments that follow a simple convention and can be used
+// as the Client's KeepAliveHint. The comment "keep-alive" is recognized as a keep-alive without an interval,
+// while "ka-interval=15" announces that keep-alives are sent every 15 seconds. The interval can also
+// be given as a duration with a unit, such as "ka-interval=500ms".
+func ParseKeepAliveHint(comment string) (interval time.Duration, isKeepAlive bool) {
+	comment = strings.TrimSpace(comment)
+	if comment == "keep-alive" {
+		return 0, true
+	}
+
+	const prefix = "ka-interval="
+	if !strings.HasPrefix(comment, prefix) {
+		return 0, false
+	}
+
+	value := comment[len(prefix):]
+	if seconds, err := strconv.ParseUint(value, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d, true
+	}
+
+	return 0, false
+}
+
+// handleKeepAlive updates the idle timeout using the given comment, if it is a keep-alive.
+func (c *Connection) handleKeepAlive(comment string) {
+	interval, ok := c.client.KeepAliveHint(comment)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if interval <= 0 && !c.lastKeepAlive.IsZero() {
+		interval = now.Sub(c.lastKeepAlive)
+	}
+	c.lastKeepAlive = now
+
+	if interval > 0 {
+		c.idleTimeout = interval * keepAliveTimeoutFactor
+	}
+}
+
+// idleReader closes the response body if a read doesn't return for longer than the connection's idle timeout.
+type idleReader struct {
+	body    io.ReadCloser
+	conn    *Connection
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	// The timer is reset before each read, so it measures the time spent waiting
+	// for the server using the latest timeout, which may have been negotiated
+	// after the previous read returned.
+	r.resetTimer()
+	return r.body.Read(p)
+}
+
+func (r *idleReader) resetTimer() {
+	timeout := r.conn.idleTimeout
+	if timeout <= 0 {
+		return
+	}
+
+	if r.timer == nil {
+		r.timer = time.AfterFunc(timeout, r.expire)
+	} else {
+		r.timer.Reset(timeout)
+	}
+}
+
+func (r *idleReader) expire() {
+	r.expired.Store(true)
+	_ = r.body.Close()
+}
+
+// stop stops the idle timer and reports whether the idle timeout was exceeded.
+func (r *idleReader) stop() bool {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	return r.expired.Load()
+}
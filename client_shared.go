@@ -0,0 +1,168 @@
+package sse
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// SharedConnections multiplexes the connections to the same stream onto a single upstream connection.
+// Set it as the Client's Shared field to enable sharing for the connections created by the Client:
+// when such a connection is connected, it joins the upstream connection for its request, creating it
+// if it doesn't exist. The upstream connection is closed when the contexts of all the connections
+// which joined it are done.
+//
+// The requests are identified by their method, URL and headers. By default, all the headers are taken
+// into account – set Headers to use only some of them. Requests with a body are never shared.
+//
+// The upstream connection is configured using the Client of the connection which created it, and it tracks
+// the last event ID itself. The events are dispatched to the callbacks of all the connections that joined it,
+// starting with the first event received after they joined – events received before are not replayed.
+// If the upstream connection fails or ends, the Connect method of all the connections that joined it returns
+// the same error, after which new connections create a new upstream connection. The methods of the joined
+// connections which report the connection's state, such as ServerRetry or ParseAnomalies, don't reflect
+// the state of the upstream connection.
+//
+// A SharedConnections must not be copied after it is used.
+type SharedConnections struct {
+	// Headers are the names of the request headers used to identify the requests, in addition to
+	// their method and URL. If it is nil, all the headers are used.
+	Headers []string
+
+	mu    sync.Mutex
+	conns map[string]*sharedConnection
+}
+
+// sharedConnection is an upstream connection and the connections which joined it.
+type sharedConnection struct {
+	owner    *SharedConnections
+	upstream *Connection
+	members  map[*Connection]struct{}
+	cancel   context.CancelFunc
+	done     chan struct{}
+	err      error
+}
+
+// connect connects the given connection to its shared upstream connection.
+func (s *SharedConnections) connect(c *Connection) error {
+	key, ok := s.key(c.request)
+	if !ok {
+		return c.connect()
+	}
+
+	s.mu.Lock()
+	sc := s.conns[key]
+	if sc == nil {
+		sc = s.start(key, c)
+	}
+	sc.members[c] = struct{}{}
+	s.mu.Unlock()
+
+	select {
+	case <-c.request.Context().Done():
+		s.leave(key, sc, c)
+		c.wg.Wait()
+		return nil
+	case <-sc.done:
+		c.wg.Wait()
+		return sc.err
+	}
+}
+
+// start creates and connects the upstream connection for the given key. It must be called with the lock held.
+func (s *SharedConnections) start(key string, c *Connection) *sharedConnection {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	client := c.client
+	client.Shared = nil
+	upstream := client.NewConnection(c.request.WithContext(ctx))
+
+	sc := &sharedConnection{owner: s, upstream: upstream, members: map[*Connection]struct{}{}, cancel: cancel, done: make(chan struct{})}
+	upstream.shared = sc
+
+	if s.conns == nil {
+		s.conns = map[string]*sharedConnection{}
+	}
+	s.conns[key] = sc
+
+	go func() {
+		err := upstream.Connect()
+
+		s.mu.Lock()
+		if s.conns[key] == sc {
+			delete(s.conns, key)
+		}
+		sc.members = nil
+		sc.err = err
+		s.mu.Unlock()
+
+		cancel()
+		close(sc.done)
+	}()
+
+	return sc
+}
+
+// leave removes the connection from the shared connection, closing the upstream connection
+// if no connections are left.
+func (s *SharedConnections) leave(key string, sc *sharedConnection, c *Connection) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(sc.members, c)
+	if len(sc.members) == 0 && s.conns[key] == sc {
+		delete(s.conns, key)
+		sc.cancel()
+	}
+}
+
+// dispatch sends the event received by the upstream connection to all the connections which joined it.
+func (sc *sharedConnection) dispatch(ev Event) {
+	sc.owner.mu.Lock()
+	defer sc.owner.mu.Unlock()
+
+	for c := range sc.members {
+		c.dispatchToCallbacks(ev)
+	}
+}
+
+// key returns the fingerprint of the request. It returns false if the request can't be shared.
+func (s *SharedConnections) key(r *http.Request) (string, bool) {
+	if r.Body != nil && r.Body != http.NoBody {
+		return "", false
+	}
+
+	var names []string
+	if s.Headers == nil {
+		for name := range r.Header {
+			names = append(names, name)
+		}
+	} else {
+		for _, name := range s.Headers {
+			names = append(names, http.CanonicalHeaderKey(name))
+		}
+	}
+	sort.Strings(names)
+
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var b strings.Builder
+	b.WriteString(method)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.String())
+	for _, name := range names {
+		for _, value := range r.Header[name] {
+			b.WriteByte('\n')
+			b.WriteString(name)
+			b.WriteString(": ")
+			b.WriteString(value)
+		}
+	}
+
+	return b.String(), true
+}
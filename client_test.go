@@ -5,10 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,6 +22,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"github.com/tmaxmax/go-sse"
 	"github.com/tmaxmax/go-sse/internal/parser"
+	"github.com/tmaxmax/go-sse/internal/tests"
 )
 
 type roundTripperFunc func(*http.Request) (*http.Response, error)
@@ -664,3 +671,791 @@ func TestConnection_reconnect(t *testing.T) {
 		require.InEpsilon(t, expectedRetries[i], recvRetries[i], backoff.DefaultRandomizationFactor, "invalid retry value")
 	}
 }
+
+func TestConnection_terminalEvent(t *testing.T) {
+	var requests int
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+
+		_, _ = io.WriteString(w, "data: a\n\nevent: end\ndata: done\n\ndata: after\n\n")
+		w.(http.Flusher).Flush()
+
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	c := &sse.Client{
+		HTTPClient:        ts.Client(),
+		ResponseValidator: sse.NoopValidator,
+		MaxRetries:        -1,
+		IsTerminalEvent:   sse.TerminalEventType("end"),
+	}
+	conn := c.NewConnection(req(t, "", ts.URL, nil))
+
+	var mu sync.Mutex
+	var received []sse.Event
+
+	conn.SubscribeToAll(func(e sse.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		received = append(received, e)
+	})
+
+	require.ErrorIs(t, conn.Connect(), sse.ErrStreamCompleted, "unexpected Connect error")
+	// Callbacks are run concurrently, so the order of the events is not guaranteed.
+	require.ElementsMatch(t, []sse.Event{{Data: "a"}, {Type: "end", Data: "done"}}, received, "unexpected events received")
+	require.Equal(t, 1, requests, "connection should not be reattempted")
+}
+
+func TestConnectionGroup(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(stream string, fail bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if fail {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, stream)
+			w.(http.Flusher).Flush()
+
+			<-r.Context().Done()
+		}))
+	}
+
+	orders := newServer("event: order\ndata: 1\n\nevent: end\ndata: \n\n", false)
+	defer orders.Close()
+	alerts := newServer("event: alert\ndata: fire\n\n", false)
+	defer alerts.Close()
+	broken := newServer("", true)
+	defer broken.Close()
+
+	newConn := func(ts *httptest.Server) *sse.Connection {
+		c := &sse.Client{HTTPClient: ts.Client(), ResponseValidator: sse.DefaultValidator, IsTerminalEvent: sse.TerminalEventType("end")}
+		return c.NewConnection(req(t, "", ts.URL, nil))
+	}
+
+	type received struct {
+		source string
+		ev     sse.Event
+	}
+
+	var mu sync.Mutex
+	var all, filtered []received
+	ended := map[string]error{}
+
+	g := &sse.ConnectionGroup{
+		OnConnectionEnd: func(source string, err error) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			ended[source] = err
+		},
+	}
+	g.Add("orders", newConn(orders), true)
+	g.Add("alerts", newConn(alerts), true)
+	g.Add("broken", newConn(broken), false)
+
+	require.Panics(t, func() { g.Add("orders", newConn(orders), false) }, "duplicate source not rejected")
+
+	g.SubscribeToAll(func(source string, e sse.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		all = append(all, received{source: source, ev: e})
+	})
+	g.SubscribeEvent("alert", func(source string, e sse.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		filtered = append(filtered, received{source: source, ev: e})
+	}, "alerts")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() { errs <- g.Connect(ctx) }()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(all) == 3 && len(ended) == 2
+	}, time.Second, time.Millisecond, "events not received")
+
+	cancel()
+	require.NoError(t, <-errs, "unexpected Connect error")
+
+	expected := []received{
+		{source: "orders", ev: sse.Event{Type: "order", Data: "1"}},
+		{source: "orders", ev: sse.Event{Type: "end"}},
+		{source: "alerts", ev: sse.Event{Type: "alert", Data: "fire"}},
+	}
+	require.ElementsMatch(t, expected, all, "invalid events received")
+	require.Equal(t, expected[2:], filtered, "invalid filtered events received")
+	require.ErrorIs(t, ended["orders"], sse.ErrStreamCompleted, "invalid orders end")
+	require.Error(t, ended["broken"], "broken connection didn't fail")
+	require.NoError(t, ended["alerts"], "invalid alerts end")
+
+	t.Run("Required failure", func(t *testing.T) {
+		t.Parallel()
+
+		g := &sse.ConnectionGroup{}
+		g.Add("alerts", newConn(alerts), false)
+		g.Add("broken", newConn(broken), true)
+
+		err := g.Connect(context.Background())
+
+		var gerr *sse.GroupConnectionError
+		require.ErrorAs(t, err, &gerr, "invalid Connect error")
+		require.Equal(t, "broken", gerr.Source, "invalid failed source")
+	})
+}
+
+func TestConnection_strictParsing(t *testing.T) {
+	t.Parallel()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, "dta: x\ndata: a\n\n\n\ngarbage\nretry: soon\n: comment\n\ndata: b")
+	}))
+	defer ts.Close()
+
+	var anomalies []sse.ParseAnomaly
+
+	c := &sse.Client{
+		HTTPClient:        ts.Client(),
+		ResponseValidator: sse.NoopValidator,
+		StrictParsing:     true,
+		OnParseAnomaly:    func(a sse.ParseAnomaly) { anomalies = append(anomalies, a) },
+	}
+	conn := c.NewConnection(req(t, "", ts.URL, nil))
+
+	var mu sync.Mutex
+	var received []sse.Event
+
+	conn.SubscribeToAll(func(e sse.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		received = append(received, e)
+	})
+
+	require.NoError(t, conn.Connect(), "unexpected Connect error")
+	require.Equal(t, []sse.ParseAnomaly{
+		{Kind: sse.ParseAnomalyUnknownField, Line: "dta: x"},
+		{Kind: sse.ParseAnomalyBlankLine},
+		{Kind: sse.ParseAnomalyBlankLine},
+		{Kind: sse.ParseAnomalyMalformedLine, Line: "garbage"},
+		{Kind: sse.ParseAnomalyInvalidRetry, Line: "soon"},
+		{Kind: sse.ParseAnomalyIncompleteEvent},
+	}, anomalies, "invalid anomalies reported")
+	require.Equal(t, int64(2), conn.ParseAnomalies(sse.ParseAnomalyBlankLine), "invalid blank line count")
+	require.Equal(t, int64(1), conn.ParseAnomalies(sse.ParseAnomalyUnknownField), "invalid unknown field count")
+	require.ElementsMatch(t, []sse.Event{{Data: "a"}, {}}, received, "events not received")
+
+	t.Run("Limit", func(t *testing.T) {
+		c := &sse.Client{HTTPClient: ts.Client(), ResponseValidator: sse.NoopValidator, StrictParsing: true, MaxParseAnomalies: 2}
+		conn := c.NewConnection(req(t, "", ts.URL, nil))
+
+		require.ErrorIs(t, conn.Connect(), sse.ErrTooManyParseAnomalies, "connection should fail")
+		require.Equal(t, int64(1), conn.ParseAnomalies(sse.ParseAnomalyBlankLine), "invalid blank line count")
+	})
+}
+
+func TestConnection_binaryData(t *testing.T) {
+	t.Parallel()
+
+	binary := []byte{0, '\n', 0xff, '\r', 'a'}
+	m := &sse.Message{Type: sse.Type("blob")}
+	m.SetBinaryData(binary)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, m.String()+": "+sse.BinaryDataComment+"\ndata: not base64\n\n")
+	}))
+	defer ts.Close()
+
+	var anomalies []sse.ParseAnomaly
+
+	c := &sse.Client{
+		HTTPClient:        ts.Client(),
+		ResponseValidator: sse.NoopValidator,
+		StrictParsing:     true,
+		OnParseAnomaly:    func(a sse.ParseAnomaly) { anomalies = append(anomalies, a) },
+	}
+	conn := c.NewConnection(req(t, "", ts.URL, nil))
+
+	var mu sync.Mutex
+	var received []sse.Event
+
+	conn.SubscribeToAll(func(e sse.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		received = append(received, e)
+	})
+
+	require.NoError(t, conn.Connect(), "unexpected Connect error")
+	require.ElementsMatch(t, []sse.Event{{Type: "blob", Data: string(binary), Binary: true}, {Data: "not base64"}}, received, "invalid events")
+	require.Equal(t, []sse.ParseAnomaly{{Kind: sse.ParseAnomalyInvalidBinaryData, Line: "not base64"}}, anomalies, "invalid anomalies reported")
+}
+
+func TestConnection_parseLimits(t *testing.T) {
+	t.Parallel()
+
+	stream := "data: small\n\n: " + strings.Repeat("c", 32) + "\ndata: " + strings.Repeat("d", 64) + "\n\ndata: unreachable\n\n"
+
+	tests := []struct {
+		name   string
+		client sse.Client
+		err    *sse.LimitError
+	}{
+		{name: "No limits", client: sse.Client{}},
+		{name: "Event size", client: sse.Client{MaxEventSize: 80}, err: &sse.LimitError{Limit: 80}},
+		{name: "Comment length", client: sse.Client{MaxFieldLength: 16}, err: &sse.LimitError{Field: "comment", Limit: 16}},
+		{name: "Data length", client: sse.Client{MaxFieldLength: 48}, err: &sse.LimitError{Field: "data", Limit: 48}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			var attempts int32
+			c := test.client
+			c.HTTPClient = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				atomic.AddInt32(&attempts, 1)
+				header := http.Header{"Content-Type": []string{"text/event-stream"}}
+				return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(stream)), Request: r}, nil
+			})}
+			c.DefaultReconnectionTime = time.Millisecond
+			c.MaxRetries = 2
+
+			conn := c.NewConnection(req(t, "", "", nil))
+
+			var mu sync.Mutex
+			var received []string
+			conn.SubscribeToAll(func(e sse.Event) {
+				mu.Lock()
+				defer mu.Unlock()
+				received = append(received, e.Data)
+			})
+
+			err := conn.Connect()
+			if test.err == nil {
+				require.NoError(t, err, "unexpected Connect error")
+				require.ElementsMatch(t, []string{"small", strings.Repeat("d", 64), "unreachable"}, received, "invalid events")
+				return
+			}
+
+			var limitErr *sse.LimitError
+			require.ErrorAs(t, err, &limitErr, "expected limit error")
+			require.Equal(t, test.err, limitErr, "invalid limit error")
+			require.Equal(t, int32(1), atomic.LoadInt32(&attempts), "limit errors must not be retried")
+			require.Equal(t, []string{"small"}, received, "invalid events")
+		})
+	}
+}
+
+func TestConnection_retryBounds(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name                string
+		stream              string
+		minRetry, maxRetry  time.Duration
+		expectedServerRetry time.Duration
+		expectedReconnect   time.Duration
+		expectedReceived    bool
+	}{
+		{name: "None", stream: "data: a\n\n", expectedReconnect: time.Second},
+		{name: "Invalid", stream: "retry: +5\nretry: 1x\nretry: -1\nretry: 1.5\nretry:\n\n", expectedReconnect: time.Second},
+		{name: "Valid", stream: "retry: 1500\n\n", expectedServerRetry: 1500 * time.Millisecond, expectedReconnect: 1500 * time.Millisecond, expectedReceived: true},
+		{name: "Max", stream: "retry: 86400000\n\n", maxRetry: 2 * time.Second, expectedServerRetry: 24 * time.Hour, expectedReconnect: 2 * time.Second, expectedReceived: true},
+		{name: "Overflow", stream: "retry: 99999999999999999999\n\n", maxRetry: 2 * time.Second, expectedServerRetry: math.MaxInt64, expectedReconnect: 2 * time.Second, expectedReceived: true},
+		{name: "Zero ignored", stream: "retry: 0\n\n", expectedReconnect: time.Second, expectedReceived: true},
+		{name: "Min", stream: "retry: 0\n\n", minRetry: 100 * time.Millisecond, expectedReconnect: 100 * time.Millisecond, expectedReceived: true},
+		{name: "Default clamped", stream: "data: a\n\n", minRetry: 3 * time.Second, expectedReconnect: 3 * time.Second},
+	}
+
+	for _, test := range tests {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := &sse.Client{
+				HTTPClient: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+					return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": []string{"text/event-stream"}}, Body: io.NopCloser(strings.NewReader(test.stream)), Request: r}, nil
+				})},
+				DefaultReconnectionTime: time.Second,
+				MinRetry:                test.minRetry,
+				MaxRetry:                test.maxRetry,
+			}
+			conn := c.NewConnection(req(t, "", "", nil))
+
+			require.NoError(t, conn.Connect(), "unexpected Connect error")
+
+			serverRetry, received := conn.ServerRetry()
+			require.Equal(t, test.expectedReceived, received, "invalid retry received flag")
+			require.Equal(t, test.expectedServerRetry, serverRetry, "invalid server retry")
+			require.Equal(t, test.expectedReconnect, conn.ReconnectionTime(), "invalid reconnection time")
+		})
+	}
+}
+
+func TestConnection_resumeSessions(t *testing.T) {
+	t.Parallel()
+
+	var attempts int
+	var received []string
+
+	c := &sse.Client{
+		HTTPClient: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			attempts++
+			received = append(received, r.Header.Get(sse.ResumptionTokenHeader))
+
+			body := io.NopCloser(strings.NewReader(""))
+			header := http.Header{"Content-Type": []string{"text/event-stream"}}
+			switch attempts {
+			case 1:
+				header.Set(sse.ResumptionTokenHeader, "from-header")
+				body = &reconnectWriter{}
+			case 2:
+				body = &reconnectWriter{event: "event: sse-resumption-token\ndata: from-event\n\n"}
+			}
+
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: body, Request: r}, nil
+		})},
+		MaxRetries:              -1,
+		DefaultReconnectionTime: time.Millisecond,
+		ResumeSessions:          true,
+	}
+	conn := c.NewConnection(req(t, "", "", nil))
+
+	for {
+		err := conn.Connect()
+		if err == nil {
+			break
+		}
+	}
+
+	require.Equal(t, []string{"", "from-header", "from-event"}, received, "invalid resumption tokens sent")
+}
+
+func TestRecordingWriter(t *testing.T) {
+	t.Parallel()
+
+	const stream = "id: 1\ndata: a\n\n: comment\nevent: e\ndata: b\nretry: 10\n\nid: 2\ndata: c\n\n"
+
+	newClient := func(rec *sse.RecordingWriter) *sse.Client {
+		return &sse.Client{
+			HTTPClient: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				header := http.Header{"Content-Type": []string{"text/event-stream"}}
+				return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(stream)), Request: r}, nil
+			})},
+			Recorder: rec,
+		}
+	}
+
+	t.Run("Raw", func(t *testing.T) {
+		t.Parallel()
+
+		var b strings.Builder
+		rec := &sse.RecordingWriter{W: &b, Raw: true}
+		require.NoError(t, newClient(rec).NewConnection(req(t, "", "", nil)).Connect())
+		require.NoError(t, rec.Err())
+		require.Equal(t, stream, b.String(), "stream not recorded as is")
+	})
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var recording strings.Builder
+	rec := &sse.RecordingWriter{W: &recording, Now: func() time.Time {
+		now = now.Add(100 * time.Millisecond)
+		return now
+	}}
+	require.NoError(t, newClient(rec).NewConnection(req(t, "", "", nil)).Connect())
+	require.NoError(t, rec.Err())
+
+	expected := "id: 1\n: recorded-at 2026-01-01T00:00:00.1Z\ndata: a\n\n" +
+		"event: e\n: recorded-at 2026-01-01T00:00:00.2Z\ndata: b\n\n" +
+		"id: 2\n: recorded-at 2026-01-01T00:00:00.3Z\ndata: c\n\n"
+	require.Equal(t, expected, recording.String(), "invalid recording")
+
+	path := filepath.Join(t.TempDir(), "recording.sse")
+	require.NoError(t, os.WriteFile(path, []byte(recording.String()), 0o600))
+
+	t.Run("Replay", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		sse.ReplayFileHandler(path, 10).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+		require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond, "recorded timing not honored")
+		require.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"), "invalid content type")
+		require.Equal(t, "id: 1\ndata: a\n\nevent: e\ndata: b\n\nid: 2\ndata: c\n\n", rec.Body.String(), "invalid replay")
+	})
+
+	t.Run("LastEventID", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+		r.Header.Set("Last-Event-ID", "1")
+		sse.ReplayFileHandler(path, 0).ServeHTTP(rec, r)
+
+		require.Equal(t, "event: e\ndata: b\n\nid: 2\ndata: c\n\n", rec.Body.String(), "invalid replay after ID")
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		sse.ReplayFileHandler(path+".missing", 1).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+		require.Equal(t, http.StatusNotFound, rec.Code, "invalid status code")
+	})
+}
+
+func TestParseKeepAliveHint(t *testing.T) {
+	t.Parallel()
+
+	type hint struct {
+		interval    time.Duration
+		isKeepAlive bool
+	}
+
+	tests := map[string]hint{
+		"keep-alive":        {isKeepAlive: true},
+		" keep-alive ":      {isKeepAlive: true},
+		"ka-interval=15":    {interval: 15 * time.Second, isKeepAlive: true},
+		"ka-interval=500ms": {interval: 500 * time.Millisecond, isKeepAlive: true},
+		"ka-interval=-1s":   {},
+		"ka-interval=":      {},
+		"hello":             {},
+	}
+
+	for comment, expected := range tests {
+		interval, isKeepAlive := sse.ParseKeepAliveHint(comment)
+		require.Equal(t, expected, hint{interval: interval, isKeepAlive: isKeepAlive}, "invalid hint for %q", comment)
+	}
+}
+
+func TestConnection_idleTimeout(t *testing.T) {
+	t.Parallel()
+
+	// connect returns the error and the duration of a connection whose response body
+	// contains the given comments, sent at the given interval, and then blocks.
+	connect := func(t *testing.T, c *sse.Client, interval time.Duration, comments ...string) (time.Duration, error) {
+		t.Helper()
+
+		c.HTTPClient = &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			pr, pw := io.Pipe()
+			go func() {
+				for i, comment := range comments {
+					if i > 0 {
+						time.Sleep(interval)
+					}
+					if _, err := io.WriteString(pw, ": "+comment+"\n\n"); err != nil {
+						return
+					}
+				}
+			}()
+
+			header := http.Header{"Content-Type": []string{"text/event-stream"}}
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: pr, Request: r}, nil
+		})}
+
+		start := time.Now()
+		err := c.NewConnection(req(t, "", "", nil)).Connect()
+		return time.Since(start), err
+	}
+
+	t.Run("Static", func(t *testing.T) {
+		t.Parallel()
+
+		d, err := connect(t, &sse.Client{IdleTimeout: 20 * time.Millisecond}, 0)
+		require.ErrorIs(t, err, sse.ErrIdleTimeout, "invalid error")
+		require.GreaterOrEqual(t, d, 20*time.Millisecond, "timed out too early")
+	})
+
+	t.Run("Hint", func(t *testing.T) {
+		t.Parallel()
+
+		c := &sse.Client{IdleTimeout: time.Hour, KeepAliveHint: sse.ParseKeepAliveHint}
+		d, err := connect(t, c, 0, "ka-interval=10ms")
+		require.ErrorIs(t, err, sse.ErrIdleTimeout, "invalid error")
+		require.GreaterOrEqual(t, d, 30*time.Millisecond, "timed out too early")
+	})
+
+	t.Run("Measured", func(t *testing.T) {
+		t.Parallel()
+
+		c := &sse.Client{IdleTimeout: time.Hour, KeepAliveHint: sse.ParseKeepAliveHint}
+		d, err := connect(t, c, 10*time.Millisecond, "keep-alive", "other", "keep-alive")
+		require.ErrorIs(t, err, sse.ErrIdleTimeout, "invalid error")
+		require.GreaterOrEqual(t, d, 80*time.Millisecond, "timed out too early")
+	})
+}
+
+func TestConnection_defaultEventType(t *testing.T) {
+	t.Parallel()
+
+	const stream = "event: message\ndata: a\n\ndata: b\n\nevent:\ndata: c\n\nevent: other\ndata: d\n\n"
+
+	c := &sse.Client{
+		HTTPClient: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			header := http.Header{"Content-Type": []string{"text/event-stream"}}
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(stream)), Request: r}, nil
+		})},
+	}
+	conn := c.NewConnection(req(t, "", "", nil))
+
+	var mu sync.Mutex
+	received := map[string][]sse.Event{}
+	record := func(name string) sse.EventCallback {
+		return func(e sse.Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			received[name] = append(received[name], e)
+		}
+	}
+
+	conn.SubscribeEvent(sse.DefaultEventType, record("default"))
+	conn.SubscribeMessages(record("messages"))
+	conn.SubscribeEvent("other", record("other"))
+
+	require.NoError(t, conn.Connect())
+
+	expected := []sse.Event{{Data: "a"}, {Data: "b"}, {Data: "c"}}
+	require.ElementsMatch(t, expected, received["default"], "invalid events for the default type")
+	require.ElementsMatch(t, expected, received["messages"], "invalid untyped events")
+	require.Equal(t, []sse.Event{{Type: "other", Data: "d"}}, received["other"], "invalid typed events")
+	require.True(t, sse.TerminalEventType(sse.DefaultEventType)(sse.Event{}), "untyped event is not of default type")
+}
+
+func TestTypedServer(t *testing.T) {
+	t.Parallel()
+
+	type update struct {
+		Price int `json:"price"`
+	}
+
+	p := newMockProvider(t, nil)
+	s := &sse.Server{Provider: p}
+	updates := &sse.TypedServer[update]{Server: s, EventType: "update"}
+	counts := &sse.TypedServer[int]{Server: s, EventType: "count", Codec: sse.Codec[int]{
+		Encode: func(v int) (string, error) { return strconv.Itoa(v), nil },
+		Decode: strconv.Atoi,
+	}}
+
+	require.NoError(t, updates.Publish(update{Price: 42}, "prices"))
+	require.Equal(t, "event: update\ndata: {\"price\":42}\n\n", p.Pub.String(), "invalid published event")
+	require.Equal(t, []string{"prices"}, p.PubTopics, "invalid topics")
+
+	_, err := (&sse.TypedServer[update]{Server: s, EventType: "in\nvalid"}).Message(update{})
+	require.Error(t, err, "invalid event type accepted")
+
+	count, err := counts.Message(7)
+	require.NoError(t, err, "failed to encode count")
+	stream := p.Pub.String() + count.String() + "event: update\ndata: not json\n\n"
+
+	c := &sse.Client{
+		HTTPClient: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			header := http.Header{"Content-Type": []string{"text/event-stream"}}
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(stream)), Request: r}, nil
+		})},
+	}
+	conn := c.NewConnection(req(t, "", "", nil))
+
+	var (
+		mu         sync.Mutex
+		gotUpdates []update
+		gotCounts  []int
+		errs       []error
+	)
+	sse.SubscribeAs(conn, "update", sse.Codec[update]{}, func(u update, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		gotUpdates = append(gotUpdates, u)
+	})
+	sse.SubscribeAs(conn, "count", counts.Codec, func(v int, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			errs = append(errs, err)
+		}
+		gotCounts = append(gotCounts, v)
+	})
+
+	require.NoError(t, conn.Connect())
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.ElementsMatch(t, []update{{Price: 42}, {}}, gotUpdates, "invalid updates")
+	require.Equal(t, []int{7}, gotCounts, "invalid counts")
+	require.Len(t, errs, 1, "decoding error not reported")
+}
+
+// dispatchedEvents returns the events a connection dispatches while reading the given stream, in no particular order.
+func dispatchedEvents(tb testing.TB, stream string) []tests.ConformanceEvent {
+	tb.Helper()
+
+	c := &sse.Client{
+		HTTPClient: &http.Client{Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			header := http.Header{"Content-Type": []string{"text/event-stream"}}
+			return &http.Response{StatusCode: http.StatusOK, Header: header, Body: io.NopCloser(strings.NewReader(stream)), Request: r}, nil
+		})},
+		MaxRetries: -1,
+	}
+	conn := c.NewConnection(req(tb, "", "", nil))
+
+	var mu sync.Mutex
+	var events []tests.ConformanceEvent
+	conn.SubscribeToAll(func(e sse.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, tests.ConformanceEvent{Type: e.Type, Data: e.Data, LastEventID: e.LastEventID})
+	})
+
+	require.NoError(tb, conn.Connect(), "unexpected Connect error")
+
+	return events
+}
+
+func TestConnection_conformance(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range tests.Conformance {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			require.ElementsMatch(t, c.Dispatched(), dispatchedEvents(t, c.Stream), "invalid events dispatched")
+		})
+	}
+}
+
+func TestMessage_conformanceRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range tests.Conformance {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			var stream strings.Builder
+			lastEventID := ""
+			for _, e := range c.Events {
+				m := &sse.Message{}
+				if e.LastEventID != lastEventID {
+					m.ID = sse.ID(e.LastEventID)
+					lastEventID = e.LastEventID
+				}
+				if e.Type != "" {
+					m.Type = sse.Type(e.Type)
+				}
+				m.AppendData(e.Data)
+				_, _ = m.WriteTo(&stream)
+			}
+
+			require.ElementsMatch(t, c.RoundTripped(), dispatchedEvents(t, stream.String()), "encoded events not parsed back:\n%s", stream.String())
+		})
+	}
+}
+
+func TestClient_Shared(t *testing.T) {
+	t.Parallel()
+
+	var requests atomic.Int64
+	closed := make(chan struct{}, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		defer func() { closed <- struct{}{} }()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		for id := 1; ; id++ {
+			_, _ = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", id, r.Header.Get("X-Tenant"))
+			w.(http.Flusher).Flush()
+
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}))
+	defer ts.Close()
+
+	c := &sse.Client{HTTPClient: ts.Client(), Shared: &sse.SharedConnections{Headers: []string{"x-tenant"}}}
+
+	type member struct {
+		cancel   context.CancelFunc
+		received chan sse.Event
+		done     chan error
+	}
+	connect := func(tenant string) member {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := reqCtx(t, ctx, "", ts.URL, nil)
+		r.Header.Set("X-Tenant", tenant)
+		r.Header.Set("X-Ignored", tenant+strconv.Itoa(int(requests.Load())))
+
+		m := member{cancel: cancel, received: make(chan sse.Event, 1000), done: make(chan error, 1)}
+		conn := c.NewConnection(r)
+		conn.SubscribeMessages(func(e sse.Event) { m.received <- e })
+		go func() { m.done <- conn.Connect() }()
+
+		<-m.received
+		return m
+	}
+
+	first := connect("a")
+	second := connect("a")
+	require.Equal(t, int64(1), requests.Load(), "identical connections not shared")
+
+	other := connect("b")
+	require.Equal(t, int64(2), requests.Load(), "connections with different headers shared")
+	require.Equal(t, "b", (<-other.received).Data, "invalid events for different headers")
+	other.cancel()
+	require.NoError(t, <-other.done)
+	<-closed
+
+	first.cancel()
+	require.NoError(t, <-first.done)
+
+	// The upstream connection is still used by the second connection.
+	var last sse.Event
+	for i := 0; i < 3; i++ {
+		last = <-second.received
+	}
+	require.Equal(t, "a", last.Data, "invalid event data")
+	require.NotEmpty(t, last.LastEventID, "last event ID not tracked by the upstream connection")
+	require.Equal(t, int64(2), requests.Load(), "upstream connection reopened")
+
+	second.cancel()
+	require.NoError(t, <-second.done)
+	<-closed
+
+	third := connect("a")
+	require.Equal(t, int64(3), requests.Load(), "closed upstream connection reused")
+	third.cancel()
+	require.NoError(t, <-third.done)
+	<-closed
+
+	unshared := &sse.Client{HTTPClient: ts.Client()}
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		conn := unshared.NewConnection(reqCtx(t, ctx, "", ts.URL, nil))
+		received := make(chan struct{}, 1000)
+		conn.SubscribeMessages(func(sse.Event) { received <- struct{}{} })
+		done := make(chan error, 1)
+		go func() { done <- conn.Connect() }()
+		<-received
+		cancel()
+		<-done
+		<-closed
+	}
+	require.Equal(t, int64(5), requests.Load(), "connections shared without registry")
+}
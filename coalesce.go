@@ -0,0 +1,104 @@
+package sse
+
+import (
+	"sync"
+	"time"
+)
+
+// coalescingWriter defers the flushes requested by the provider, so the messages sent in quick succession
+// are flushed together: once the flush interval passes or, if a batch size is set, once that many messages
+// are waiting. The writes of the provider and the deferred flushes are serialized, so the provider's
+// guarantee of not writing concurrently to the client is kept. See Server.FlushInterval.
+type coalescingWriter struct {
+	MessageWriter
+	interval  time.Duration
+	batchSize int
+	timer     *time.Timer
+	mu        sync.Mutex
+	// pending is the number of messages sent since the last flush.
+	pending int
+	// scheduled is true while a deferred flush is waiting for the timer.
+	scheduled bool
+	// err is the error of the last deferred flush, which is returned by the next write.
+	err     error
+	stopped bool
+}
+
+func (w *coalescingWriter) Send(m *Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if err := w.MessageWriter.Send(m); err != nil {
+		return err
+	}
+
+	w.pending++
+	return nil
+}
+
+func (w *coalescingWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return w.err
+	}
+	if w.pending == 0 || w.batchSize > 0 && w.pending >= w.batchSize {
+		return w.flush()
+	}
+
+	if !w.scheduled {
+		w.scheduled = true
+		if w.timer == nil {
+			w.timer = time.AfterFunc(w.interval, w.flushDeferred)
+		} else {
+			w.timer.Reset(w.interval)
+		}
+	}
+
+	return nil
+}
+
+// flushDeferred flushes the waiting messages once the flush interval passed.
+func (w *coalescingWriter) flushDeferred() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.scheduled || w.stopped {
+		return
+	}
+
+	w.err = w.flush()
+}
+
+func (w *coalescingWriter) flush() error {
+	if w.scheduled {
+		w.timer.Stop()
+		w.scheduled = false
+	}
+	w.pending = 0
+
+	return w.MessageWriter.Flush()
+}
+
+// startCoalescing wraps the client so its flushes are coalesced. The returned function flushes
+// the waiting messages and returns after the client isn't used for deferred flushes anymore.
+func startCoalescing(client MessageWriter, interval time.Duration, batchSize int) (MessageWriter, func()) {
+	w := &coalescingWriter{MessageWriter: client, interval: interval, batchSize: batchSize}
+
+	return w, func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		w.stopped = true
+		if w.pending > 0 && w.err == nil {
+			_ = w.flush()
+		} else if w.scheduled {
+			w.timer.Stop()
+			w.scheduled = false
+		}
+	}
+}
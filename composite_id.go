@@ -0,0 +1,135 @@
+package sse
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// CompositeID is the position of a client in the event streams of multiple topics, which can be encoded
+// as a single event ID. Backends which store the events of each topic separately – a Redis stream or a Kafka
+// topic per topic, for example – give the same event a different position in each topic it is published to,
+// so a client subscribed to multiple topics can resume only if its last event ID holds all of them.
+//
+// The keys are the topics and the values are the positions in their streams, which are opaque to go-sse:
+// stream entry IDs, offsets and so on. Replay providers which use composite IDs set on each event the ID
+// which holds the positions of all the topics of the subscription, updated with the event's positions.
+//
+// The encoded IDs are versioned and are at most MaxCompositeIDLength bytes long. Composite IDs received
+// from clients may reference topics the subscription doesn't include anymore – use Only to ignore them.
+// Topics without a position should be replayed as if the client had no last event ID.
+type CompositeID map[string]string
+
+// MaxCompositeIDLength is the maximum length of an encoded composite ID.
+// Positions of many topics or long positions should be avoided, as clients send the ID on each reconnection.
+const MaxCompositeIDLength = 1024
+
+// Errors returned when encoding or parsing composite IDs.
+var (
+	ErrInvalidCompositeID = errors.New("go-sse: invalid composite event ID")
+	ErrCompositeIDTooLong = errors.New("go-sse: composite event ID is too long")
+)
+
+// compositeIDPrefix identifies the version of the encoding. The encoded payload is the list of topics,
+// sorted, each followed by its position, all of them prefixed by their length as an unsigned varint.
+const compositeIDPrefix = "c1."
+
+var compositeIDEncoding = base64.RawURLEncoding
+
+// EventID encodes the composite ID as an event ID. The encoding is deterministic: composite IDs
+// with the same positions are encoded to the same event ID. An error wrapping ErrCompositeIDTooLong
+// is returned if the encoded ID is longer than MaxCompositeIDLength.
+func (c CompositeID) EventID() (EventID, error) {
+	topics := make([]string, 0, len(c))
+	for topic := range c {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+
+	var payload []byte
+	for _, topic := range topics {
+		payload = binary.AppendUvarint(payload, uint64(len(topic)))
+		payload = append(payload, topic...)
+		payload = binary.AppendUvarint(payload, uint64(len(c[topic])))
+		payload = append(payload, c[topic]...)
+	}
+
+	if len(compositeIDPrefix)+compositeIDEncoding.EncodedLen(len(payload)) > MaxCompositeIDLength {
+		return EventID{}, ErrCompositeIDTooLong
+	}
+
+	return ID(compositeIDPrefix + compositeIDEncoding.EncodeToString(payload)), nil
+}
+
+// ParseCompositeID decodes a composite ID encoded by the EventID method. It returns ErrCompositeIDTooLong
+// for IDs longer than MaxCompositeIDLength and ErrInvalidCompositeID for IDs which are not valid
+// composite IDs, such as IDs of another version or plain IDs – providers may interpret these themselves.
+func ParseCompositeID(id EventID) (CompositeID, error) {
+	s := id.String()
+	if len(s) > MaxCompositeIDLength {
+		return nil, ErrCompositeIDTooLong
+	}
+	if !strings.HasPrefix(s, compositeIDPrefix) {
+		return nil, ErrInvalidCompositeID
+	}
+
+	payload, err := compositeIDEncoding.DecodeString(s[len(compositeIDPrefix):])
+	if err != nil {
+		return nil, ErrInvalidCompositeID
+	}
+
+	c := CompositeID{}
+	for len(payload) > 0 {
+		var topic, position string
+		if topic, payload, err = readCompositeIDPart(payload); err != nil {
+			return nil, err
+		}
+		if position, payload, err = readCompositeIDPart(payload); err != nil {
+			return nil, err
+		}
+		if _, ok := c[topic]; ok {
+			return nil, ErrInvalidCompositeID
+		}
+
+		c[topic] = position
+	}
+
+	return c, nil
+}
+
+func readCompositeIDPart(payload []byte) (part string, rest []byte, err error) {
+	n, size := binary.Uvarint(payload)
+	if size <= 0 || n > uint64(len(payload)-size) {
+		return "", nil, ErrInvalidCompositeID
+	}
+
+	end := size + int(n)
+
+	return string(payload[size:end]), payload[end:], nil
+}
+
+// With returns a copy of the composite ID with the position of the given topic set.
+func (c CompositeID) With(topic, position string) CompositeID {
+	updated := make(CompositeID, len(c)+1)
+	for t, p := range c {
+		updated[t] = p
+	}
+	updated[topic] = position
+
+	return updated
+}
+
+// Only returns a copy of the composite ID with the positions of the given topics only.
+// Use it to ignore the topics a client's subscription doesn't include anymore.
+func (c CompositeID) Only(topics []string) CompositeID {
+	only := make(CompositeID, len(topics))
+	for _, topic := range topics {
+		if position, ok := c[topic]; ok {
+			only[topic] = position
+		}
+	}
+
+	return only
+}
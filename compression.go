@@ -0,0 +1,192 @@
+package sse
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Compressor is a writer which compresses the data written to it, such as a *gzip.Writer.
+// Flush must write all the data written so far to the underlying writer, so it can be decompressed,
+// and Close must finish the compressed stream.
+type Compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Compression configures the compression of the event streams, which reduces the bandwidth used
+// by verbose events – JSON payloads, for example. The encoding is negotiated using the request's
+// Accept-Encoding header: Brotli is used if it is configured and accepted, gzip otherwise, and the
+// stream is not compressed if the client accepts neither. See the Server's Compression field for usage.
+//
+// The compressor is flushed each time the events sent to the client are flushed, so the events
+// are delivered in real time, as if the stream was not compressed. Keep in mind that each flush
+// adds a few bytes of overhead, so streams which are flushed after every small event are compressed
+// less efficiently – use Server.FlushInterval to flush the events in batches.
+type Compression struct {
+	// Level is the gzip compression level. Defaults to gzip.DefaultCompression.
+	Level int
+	// Brotli optionally returns a Brotli compressor writing to the given writer, which is preferred to gzip
+	// when the client accepts it. The standard library doesn't implement Brotli, so use a third-party
+	// package such as github.com/andybalholm/brotli:
+	//
+	//	Brotli: func(w io.Writer) sse.Compressor { return brotli.NewWriter(w) }
+	Brotli func(w io.Writer) Compressor
+
+	gzipWriters sync.Pool
+}
+
+// The names of the encodings the Compression supports.
+const (
+	encodingGzip   = "gzip"
+	encodingBrotli = "br"
+)
+
+// negotiate returns the encoding the response to the request is compressed with, if any.
+func (c *Compression) negotiate(r *http.Request) string {
+	var gzipOK, brotliOK, anyOK, gzipRefused, brotliRefused bool
+	for _, h := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(h, ",") {
+			name, params, _ := strings.Cut(enc, ";")
+			name = strings.ToLower(strings.TrimSpace(name))
+			ok := acceptable(params)
+
+			switch name {
+			case encodingGzip:
+				gzipOK, gzipRefused = ok, !ok
+			case encodingBrotli:
+				brotliOK, brotliRefused = ok, !ok
+			case "*":
+				anyOK = ok
+			}
+		}
+	}
+
+	switch {
+	case c.Brotli != nil && (brotliOK || (anyOK && !brotliRefused)):
+		return encodingBrotli
+	case gzipOK || (anyOK && !gzipRefused):
+		return encodingGzip
+	default:
+		return ""
+	}
+}
+
+// acceptable reports whether the parameters of an Accept-Encoding entry don't have a zero quality value.
+func acceptable(params string) bool {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if strings.EqualFold(key, "q") {
+			q, err := strconv.ParseFloat(value, 64)
+			return err == nil && q > 0
+		}
+	}
+
+	return true
+}
+
+// newCompressor returns a compressor for the given encoding, writing to w.
+func (c *Compression) newCompressor(encoding string, w io.Writer) Compressor {
+	if encoding == encodingBrotli {
+		return c.Brotli(w)
+	}
+
+	if gw, ok := c.gzipWriters.Get().(*gzip.Writer); ok {
+		gw.Reset(w)
+		return gw
+	}
+
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	gw, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		gw = gzip.NewWriter(w)
+	}
+
+	return gw
+}
+
+// release finishes the compressed stream and reuses the compressor, if possible.
+func (c *Compression) release(cw Compressor) error {
+	err := cw.Close()
+	if gw, ok := cw.(*gzip.Writer); ok {
+		c.gzipWriters.Put(gw)
+	}
+
+	return err
+}
+
+// wrap returns a response writer which compresses the response to the request, if the client accepts
+// any of the supported encodings. The returned function must be called after the response is written
+// to finish the compressed stream.
+func (c *Compression) wrap(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func() error) {
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	encoding := c.negotiate(r)
+	rw := getResponseWriter(w)
+	if encoding == "" || rw == nil {
+		return w, func() error { return nil }
+	}
+
+	cw := &compressingWriter{ResponseWriter: w, flusher: rw, c: c, encoding: encoding}
+	return cw, cw.close
+}
+
+// compressingWriter compresses the response written through it. The compressor is created, and the
+// Content-Encoding header set, once the response is committed.
+type compressingWriter struct {
+	http.ResponseWriter
+	flusher  ResponseWriter
+	c        *Compression
+	cw       Compressor
+	encoding string
+}
+
+func (w *compressingWriter) commit() {
+	if w.cw != nil {
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	w.cw = w.c.newCompressor(w.encoding, w.ResponseWriter)
+}
+
+func (w *compressingWriter) WriteHeader(code int) {
+	w.commit()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressingWriter) Write(p []byte) (int, error) {
+	w.commit()
+	return w.cw.Write(p)
+}
+
+func (w *compressingWriter) FlushError() error {
+	w.commit()
+	if err := w.cw.Flush(); err != nil {
+		return err
+	}
+
+	return w.flusher.Flush()
+}
+
+func (w *compressingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressingWriter) close() error {
+	if w.cw == nil {
+		return nil
+	}
+
+	err := w.c.release(w.cw)
+	w.cw = nil
+	return err
+}
@@ -0,0 +1,104 @@
+package sse
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORS configures the cross-origin resource sharing headers the Server sends, so event streams can be
+// consumed from other origins. Browsers send the credentials – cookies, for example – of cross-origin
+// EventSource requests only if they are created with withCredentials, and they accept the response only if it
+// allows the exact origin of the page and credentials, which a wildcard origin doesn't. See the Server's CORS
+// field for usage.
+//
+// Requests without an Origin header, such as those of non-browser clients, are served without CORS headers.
+// Requests from origins which are not allowed are rejected with a 403 Forbidden response, before OnSession
+// is called, as browsers wouldn't let the page read the stream anyway.
+type CORS struct {
+	// AllowedOrigins are the origins allowed to consume the event streams, such as "https://example.com".
+	// They are compared case-insensitively. The "*" origin allows all origins.
+	AllowedOrigins []string
+	// AllowOrigin is an optional function which allows the origins that are not in AllowedOrigins,
+	// for example all the subdomains of a domain.
+	AllowOrigin func(origin string) bool
+	// AllowCredentials allows the browsers to send credentials, such as cookies, with the requests – it must
+	// be set for EventSource instances created with withCredentials. The request's origin is then sent back
+	// instead of a wildcard, even if all origins are allowed.
+	AllowCredentials bool
+	// AllowedHeaders are the request headers allowed in addition to Last-Event-ID and ResumptionTokenHeader,
+	// which are always allowed. They are sent in response to preflight requests, which browsers make before
+	// requests with custom headers, such as those of fetch-based clients that set Authorization.
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers the pages can read in addition to ResumptionTokenHeader,
+	// which is always exposed.
+	ExposedHeaders []string
+	// MaxAge is for how long the browsers can cache the responses to preflight requests. It is sent
+	// in seconds. Defaults to 0, which means the browser's default is used.
+	MaxAge time.Duration
+}
+
+// ErrOriginNotAllowed is the error of the requests rejected because their origin is not allowed by the CORS
+// configuration.
+var ErrOriginNotAllowed = errors.New("go-sse.server: origin not allowed")
+
+// allowed reports whether the origin is allowed and whether it is allowed because all origins are.
+func (c *CORS) allowed(origin string) (ok, wildcard bool) {
+	for _, o := range c.AllowedOrigins {
+		if o == "*" {
+			return true, true
+		}
+		if strings.EqualFold(o, origin) {
+			return true, false
+		}
+	}
+
+	return c.AllowOrigin != nil && c.AllowOrigin(origin), false
+}
+
+// apply sets the CORS headers of the response. It returns ErrOriginNotAllowed if the request's origin
+// is not allowed and whether the request is a preflight request, which must not be served further.
+func (c *CORS) apply(w http.ResponseWriter, r *http.Request) (preflight bool, err error) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false, nil
+	}
+
+	h := w.Header()
+	h.Add("Vary", "Origin")
+
+	preflight = r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	if preflight {
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	ok, wildcard := c.allowed(origin)
+	if !ok {
+		return preflight, ErrOriginNotAllowed
+	}
+
+	if wildcard && !c.AllowCredentials {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.AllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		h.Set("Access-Control-Expose-Headers", strings.Join(append([]string{ResumptionTokenHeader}, c.ExposedHeaders...), ", "))
+		return false, nil
+	}
+
+	h.Set("Access-Control-Allow-Methods", http.MethodGet)
+	h.Set("Access-Control-Allow-Headers", strings.Join(append([]string{"Last-Event-ID", ResumptionTokenHeader}, c.AllowedHeaders...), ", "))
+	if c.MaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.FormatInt(int64(c.MaxAge/time.Second), 10))
+	}
+
+	return true, nil
+}
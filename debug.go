@@ -0,0 +1,102 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// DebugInfo is a snapshot of a provider's state, as reported by DebugHandler.
+type DebugInfo struct {
+	// Subscribers is the number of subscribers. It is set if the provider implements ProviderStats.
+	Subscribers int `json:"subscribers"`
+	// TopicRegistrations is the number of topic subscriptions. It is set if the provider implements ProviderStats.
+	TopicRegistrations int `json:"topicRegistrations"`
+	// RetainedEvents is the number of events retained for replay. It is set if the provider implements ReplayInspector.
+	RetainedEvents int `json:"retainedEvents"`
+	// Topics holds the state of each topic which has subscribers or retained events.
+	Topics map[string]DebugTopicInfo `json:"topics"`
+}
+
+// DebugTopicInfo is the state of a topic, as reported by DebugHandler.
+type DebugTopicInfo struct {
+	// Subscribers is the number of subscribers which receive the messages published to the topic.
+	Subscribers int `json:"subscribers"`
+	// RetainedEvents is the number of events retained for the topic.
+	RetainedEvents int `json:"retainedEvents"`
+	// LastEventID is the ID of the last event retained for the topic. It is empty if the topic
+	// has no retained events or if the last one has no ID.
+	LastEventID string `json:"lastEventID,omitempty"`
+}
+
+// ReadDebugInfo returns a snapshot of the provider's state, using the ProviderStats and ReplayInspector
+// interfaces. The information the provider doesn't implement the interfaces for is left empty. The state
+// is read in multiple steps, so the values may reflect slightly different moments in time if the provider
+// is concurrently used. Use it to publish the state through expvar, for example:
+//
+//	expvar.Publish("sse", expvar.Func(func() any {
+//		info, _ := sse.ReadDebugInfo(context.Background(), joe)
+//		return info
+//	}))
+func ReadDebugInfo(ctx context.Context, p Provider) (DebugInfo, error) {
+	info := DebugInfo{Topics: map[string]DebugTopicInfo{}}
+
+	if s, ok := p.(ProviderStats); ok {
+		totals := s.Totals()
+		info.Subscribers, info.TopicRegistrations = totals.Subscribers, totals.TopicRegistrations
+
+		topics, err := s.Topics(ctx)
+		if err != nil {
+			return DebugInfo{}, err
+		}
+
+		for _, topic := range topics {
+			count, err := s.SubscriberCount(ctx, topic)
+			if err != nil {
+				return DebugInfo{}, err
+			}
+			info.Topics[topic] = DebugTopicInfo{Subscribers: count}
+		}
+	}
+
+	if r, ok := p.(ReplayInspector); ok {
+		stats, err := r.ReplayStats()
+		if err != nil && !errors.Is(err, ErrReplayStatsUnsupported) {
+			return DebugInfo{}, err
+		}
+
+		info.RetainedEvents = stats.Events
+		for topic, t := range stats.Topics {
+			i := info.Topics[topic]
+			i.RetainedEvents, i.LastEventID = t.Events, t.LastEventID.String()
+			info.Topics[topic] = i
+		}
+	}
+
+	return info, nil
+}
+
+// DebugHandler returns a handler which responds with the provider's state, as returned by ReadDebugInfo,
+// encoded as JSON: the subscribed topics, their subscriber counts, the number of retained events and
+// the last retained event ID of each topic. Use it to debug production deployments.
+//
+// The handler exposes the topics, so make sure to protect it, as it is intended for debugging.
+func DebugHandler(p Provider) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info, err := ReadDebugInfo(r.Context(), p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		data, err := json.Marshal(info)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	})
+}
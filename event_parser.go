@@ -0,0 +1,163 @@
+package sse
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/tmaxmax/go-sse/internal/parser"
+)
+
+// Parser reads the events of an event stream from any reader, such as a file, the standard input or
+// the payloads of a message queue, without the Client. It interprets the stream as the Client and
+// EventSource do: the events are parsed according to the specification, the last event ID is kept
+// across events and the data of the events marked with the BinaryDataComment is decoded. As with the
+// Client, an event which is not followed by a blank line is returned when the reader ends, as long as
+// its last line is complete – a stream which ends in the middle of a line is discarded.
+//
+//	p := sse.NewParser(os.Stdin)
+//	for p.Next() {
+//		fmt.Println(p.Event().Data)
+//	}
+//	if err := p.Err(); err != nil {
+//		// handle error
+//	}
+//
+// Lines which are not valid fields are ignored, as are retry fields which are not valid numbers.
+type Parser struct {
+	// MaxEventSize is the maximum size of an event, in bytes, including its field names and line endings.
+	// If an event is bigger, Next returns false and Err returns a LimitError. Defaults to DefaultMaxEventSize.
+	MaxEventSize int
+	// MaxFieldLength is the maximum length of the value of a field, in bytes. If a field is longer,
+	// Next returns false and Err returns a LimitError. Defaults to 0, which means that fields are limited
+	// only by MaxEventSize.
+	//
+	// The limits must not be modified after Next is called.
+	MaxFieldLength int
+
+	p      *parser.Parser
+	ev     Event
+	retry  time.Duration
+	limits parseLimits
+	err    error
+
+	lastEventID string
+	started     bool
+	done        bool
+}
+
+// NewParser returns a Parser which reads the events from the given reader.
+func NewParser(r io.Reader) *Parser {
+	p := parser.New(r)
+	// Comments are kept for the BinaryDataComment.
+	p.KeepComments(true)
+
+	return &Parser{p: p}
+}
+
+// Next reads the next event, which is then returned by Event. It returns false when the reader ends
+// or if reading fails – see Err.
+func (p *Parser) Next() bool {
+	if p.done {
+		return false
+	}
+	p.init()
+
+	ev, dirty := Event{}, false
+
+	for f := (parser.Field{}); p.p.Next(&f); {
+		if p.err = p.limits.check(f); p.err != nil {
+			p.done = true
+			return false
+		}
+
+		switch f.Name { //nolint:exhaustive // Unknown fields are not kept.
+		case parser.FieldNameComment:
+			if f.Value == BinaryDataComment {
+				ev.Binary = true
+			}
+		case parser.FieldNameData:
+			ev.Data += f.Value + "\n"
+			dirty = true
+		case parser.FieldNameEvent:
+			ev.Type = f.Value
+			dirty = true
+		case parser.FieldNameID:
+			// IDs which contain the null byte must be ignored, as the Client does.
+			if strings.IndexByte(f.Value, 0) == -1 {
+				p.lastEventID = f.Value
+				dirty = true
+			}
+		case parser.FieldNameRetry:
+			if retry, ok := parseRetry(f.Value); ok {
+				p.retry = retry
+				dirty = true
+			}
+		default:
+			p.ev = p.finish(ev)
+			return true
+		}
+	}
+
+	p.done = true
+	if dirty && p.p.Err() == nil {
+		p.ev = p.finish(ev)
+		return true
+	}
+
+	return false
+}
+
+func (p *Parser) init() {
+	if p.started {
+		return
+	}
+
+	p.started = true
+	p.limits = parseLimits{maxEventSize: p.MaxEventSize, maxFieldLength: p.MaxFieldLength}
+	p.limits.apply(p.p)
+}
+
+// finish prepares the event for dispatch, as the Client does.
+func (p *Parser) finish(ev Event) Event {
+	if ev.Binary {
+		// The decoder ignores newlines, including the one which ends the data.
+		if data, err := base64.StdEncoding.DecodeString(ev.Data); err == nil {
+			ev.Data = string(data)
+		} else {
+			ev.Binary = false
+		}
+	}
+	if !ev.Binary {
+		ev.Data = strings.TrimSuffix(ev.Data, "\n")
+	}
+
+	ev.LastEventID = p.lastEventID
+	ev.Type = canonicalEventType(ev.Type)
+
+	return ev
+}
+
+// Event returns the event read by the last call to Next.
+func (p *Parser) Event() Event {
+	return p.ev
+}
+
+// Retry returns the last reconnection delay received, or 0 if none was received.
+func (p *Parser) Retry() time.Duration {
+	return p.retry
+}
+
+// Err returns the error which made Next return false, if reading failed, or nil if the reader ended.
+func (p *Parser) Err() error {
+	if p.err != nil {
+		return p.err
+	}
+	// A stream which ends in the middle of a line is not an error, its last event is discarded.
+	if err := p.p.Err(); !errors.Is(err, parser.ErrUnexpectedEOF) {
+		return p.limits.err(err)
+	}
+	return nil
+}
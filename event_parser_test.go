@@ -0,0 +1,74 @@
+package sse_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/internal/tests"
+)
+
+func TestParser(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range tests.Conformance {
+		c := c
+		t.Run(c.Name, func(t *testing.T) {
+			t.Parallel()
+
+			var events []tests.ConformanceEvent
+			p := sse.NewParser(strings.NewReader(c.Stream))
+			for p.Next() {
+				e := p.Event()
+				events = append(events, tests.ConformanceEvent{Type: e.Type, Data: e.Data, LastEventID: e.LastEventID})
+			}
+
+			require.NoError(t, p.Err(), "unexpected parse error")
+			require.ElementsMatch(t, c.Dispatched(), events, "invalid events parsed")
+		})
+	}
+
+	t.Run("Binary and retry", func(t *testing.T) {
+		t.Parallel()
+
+		m := &sse.Message{ID: sse.ID("1")}
+		m.SetBinaryData([]byte{0, 1, 2})
+		stream := m.String() + "retry: 1500\n: " + sse.BinaryDataComment + "\ndata: not base64\n\n"
+
+		p := sse.NewParser(strings.NewReader(stream))
+
+		require.True(t, p.Next(), "binary event not parsed")
+		require.Equal(t, sse.Event{LastEventID: "1", Data: "\x00\x01\x02", Binary: true}, p.Event(), "invalid binary event")
+		require.True(t, p.Next(), "invalid binary event not parsed")
+		require.Equal(t, sse.Event{LastEventID: "1", Data: "not base64"}, p.Event(), "invalid binary data must be kept")
+		require.Equal(t, 1500*time.Millisecond, p.Retry(), "invalid retry")
+		require.False(t, p.Next(), "unexpected event")
+		require.NoError(t, p.Err(), "unexpected parse error")
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		t.Parallel()
+
+		stream := "data: small\n\ndata: " + strings.Repeat("d", 64) + "\n\n"
+
+		p := sse.NewParser(strings.NewReader(stream))
+		p.MaxFieldLength = 32
+		require.True(t, p.Next(), "small event not parsed")
+		require.Equal(t, "small", p.Event().Data, "invalid event")
+		require.False(t, p.Next(), "long field parsed")
+		require.Equal(t, &sse.LimitError{Field: "data", Limit: 32}, p.Err(), "invalid field limit error")
+
+		p = sse.NewParser(strings.NewReader(stream + stream))
+		p.MaxEventSize = 32
+		require.True(t, p.Next(), "small event not parsed")
+		require.False(t, p.Next(), "big event parsed")
+		require.Equal(t, &sse.LimitError{Limit: 32}, p.Err(), "invalid size limit error")
+		require.False(t, p.Next(), "parsing continued after limit error")
+
+		p = sse.NewParser(strings.NewReader("data: " + strings.Repeat("d", sse.DefaultMaxEventSize) + "\n\n"))
+		require.False(t, p.Next(), "event bigger than the default limit parsed")
+		require.Equal(t, &sse.LimitError{Limit: sse.DefaultMaxEventSize}, p.Err(), "invalid default limit error")
+	})
+}
@@ -0,0 +1,238 @@
+package sse
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An IDGenerator generates the IDs of events. The replay providers use it to set the IDs of the published
+// events – see FiniteReplayProvider's and ValidReplayProvider's IDGenerator fields –, and the Server uses it
+// to set the IDs of the events published without one – see Server.IDGenerator.
+//
+// The generated IDs must be unique and must be valid event IDs. Generators must be safe for concurrent use.
+// The generators in this package generate increasing IDs, which clients can compare to order the events.
+type IDGenerator interface {
+	// NextID returns a new ID.
+	NextID() EventID
+}
+
+// CounterIDGenerator generates consecutive decimal IDs, starting from 0. Its IDs are unique only within
+// the process, so use another generator if the IDs must stay unique across restarts or server instances.
+// The zero value is ready to use.
+type CounterIDGenerator struct {
+	next atomic.Int64
+}
+
+// NextID returns the next number.
+func (c *CounterIDGenerator) NextID() EventID {
+	return ID(strconv.FormatInt(c.next.Add(1)-1, 10))
+}
+
+// monotonicClock returns increasing timestamps in milliseconds, so the IDs based on them keep their
+// order even if the wall clock goes backwards. It must be used with its mutex locked.
+type monotonicClock struct {
+	now    func() time.Time
+	lastMs int64
+}
+
+// tick returns the current timestamp and whether it is the same as the previous one.
+func (c *monotonicClock) tick() (ms int64, same bool) {
+	now := time.Now
+	if c.now != nil {
+		now = c.now
+	}
+
+	ms = now().UnixMilli()
+	if ms <= c.lastMs {
+		return c.lastMs, true
+	}
+
+	c.lastMs = ms
+	return ms, false
+}
+
+// advance moves the clock to the next millisecond, when the IDs of the current one are exhausted.
+func (c *monotonicClock) advance() int64 {
+	c.lastMs++
+	return c.lastMs
+}
+
+// crockford is the alphabet of the ULIDs.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator generates ULIDs: 26 character identifiers made of a millisecond timestamp and random bits,
+// which are unique across processes and sort in the order they were generated. The IDs generated in the same
+// millisecond are ordered by incrementing the random bits of the previous one, as the ULID specification's
+// monotonic generation describes. The zero value is ready to use.
+type ULIDGenerator struct {
+	// Now is the function used to retrieve the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	clock   monotonicClock
+	entropy [10]byte
+	mu      sync.Mutex
+}
+
+// NextID returns a new ULID.
+func (u *ULIDGenerator) NextID() EventID {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.clock.now = u.Now
+	ms, same := u.clock.tick()
+	if !same || !increment(u.entropy[:]) {
+		if same {
+			ms = u.clock.advance()
+		}
+		randomize(u.entropy[:])
+	}
+
+	var id [16]byte
+	putUint48(id[:6], ms)
+	copy(id[6:], u.entropy[:])
+
+	return ID(encodeCrockford(id))
+}
+
+// encodeCrockford encodes the 128 bits of the ULID in 26 characters of 5 bits, the first having only 3 bits.
+func encodeCrockford(id [16]byte) string {
+	hi, lo := binary.BigEndian.Uint64(id[:8]), binary.BigEndian.Uint64(id[8:])
+
+	var b [26]byte
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+
+	return string(b[:])
+}
+
+// UUIDv7Generator generates version 7 UUIDs, as defined by RFC 9562: identifiers made of a millisecond
+// timestamp and random bits, which are unique across processes and sort in the order they were generated.
+// The 12 bits following the timestamp are a counter, so the UUIDs generated in the same millisecond keep
+// their order. The zero value is ready to use.
+type UUIDv7Generator struct {
+	// Now is the function used to retrieve the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	clock   monotonicClock
+	counter uint16
+	mu      sync.Mutex
+}
+
+// NextID returns a new UUID, in its canonical textual form.
+func (u *UUIDv7Generator) NextID() EventID {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.clock.now = u.Now
+	ms, same := u.clock.tick()
+	switch {
+	case !same:
+		u.counter = 0
+	case u.counter < 0xfff:
+		u.counter++
+	default:
+		ms = u.clock.advance()
+		u.counter = 0
+	}
+
+	var id [16]byte
+	putUint48(id[:6], ms)
+	binary.BigEndian.PutUint16(id[6:8], 0x7000|u.counter)
+	randomize(id[8:])
+	id[8] = id[8]&0x3f | 0x80
+
+	var b [36]byte
+	hex.Encode(b[0:8], id[0:4])
+	hex.Encode(b[9:13], id[4:6])
+	hex.Encode(b[14:18], id[6:8])
+	hex.Encode(b[19:23], id[8:10])
+	hex.Encode(b[24:], id[10:])
+	b[8], b[13], b[18], b[23] = '-', '-', '-', '-'
+
+	return ID(string(b[:]))
+}
+
+// DefaultSnowflakeEpoch is the time from which the timestamps of the snowflake IDs are measured, by default.
+var DefaultSnowflakeEpoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// SnowflakeGenerator generates snowflake IDs: 63-bit decimal numbers made of a millisecond timestamp,
+// the number of the node which generated the ID and a sequence number. The IDs are unique across
+// the nodes which have different numbers and they increase with time, so they can be compared as numbers
+// to order the events. Up to 4096 IDs are generated each millisecond on each node. The zero value is ready
+// to use, for a single node.
+type SnowflakeGenerator struct {
+	// Now is the function used to retrieve the current time. Defaults to time.Now.
+	Now func() time.Time
+	// Epoch is the time from which the timestamps are measured. Defaults to DefaultSnowflakeEpoch.
+	Epoch time.Time
+	// Node is the number of the node, between 0 and 1023. Give each server instance its own number.
+	Node int64
+
+	clock    monotonicClock
+	sequence int64
+	mu       sync.Mutex
+}
+
+// NextID returns a new snowflake ID.
+func (s *SnowflakeGenerator) NextID() EventID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	epoch := s.Epoch
+	if epoch.IsZero() {
+		epoch = DefaultSnowflakeEpoch
+	}
+
+	s.clock.now = s.Now
+	ms, same := s.clock.tick()
+	switch {
+	case !same:
+		s.sequence = 0
+	case s.sequence < 0xfff:
+		s.sequence++
+	default:
+		ms = s.clock.advance()
+		s.sequence = 0
+	}
+
+	id := (ms-epoch.UnixMilli())<<22 | (s.Node&0x3ff)<<12 | s.sequence
+
+	return ID(strconv.FormatInt(id, 10))
+}
+
+// increment adds one to the big-endian number, returning false if it overflowed.
+func increment(b []byte) bool {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i]++
+		if b[i] != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func randomize(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+}
+
+func putUint48(b []byte, v int64) {
+	b[0], b[1], b[2], b[3], b[4], b[5] = byte(v>>40), byte(v>>32), byte(v>>24), byte(v>>16), byte(v>>8), byte(v)
+}
+
+var (
+	_ IDGenerator = (*CounterIDGenerator)(nil)
+	_ IDGenerator = (*ULIDGenerator)(nil)
+	_ IDGenerator = (*UUIDv7Generator)(nil)
+	_ IDGenerator = (*SnowflakeGenerator)(nil)
+)
@@ -24,6 +24,10 @@ const (
 	// comment fields. It is not a valid field name that should
 	// be written to a SSE stream.
 	FieldNameComment = FieldName(":")
+	// FieldNameUnknown is a sentinel value that indicates lines
+	// which are not valid fields. The value of such fields is
+	// the whole line.
+	FieldNameUnknown = FieldName("?")
 
 	maxFieldNameLength = 5
 )
@@ -13,6 +13,7 @@ type FieldParser struct {
 	started bool
 
 	keepComments bool
+	keepUnknown  bool
 	removeBOM    bool
 }
 
@@ -33,7 +34,7 @@ func trimFirstSpace(c string) string {
 func (f *FieldParser) scanSegment(chunk string, out *Field) bool {
 	colonPos, l := strings.IndexByte(chunk, ':'), len(chunk)
 	if colonPos > maxFieldNameLength {
-		return false
+		return f.scanUnknown(chunk, out)
 	}
 	if colonPos == -1 {
 		colonPos = l
@@ -55,11 +56,23 @@ func (f *FieldParser) scanSegment(chunk string, out *Field) bool {
 		out.Name = FieldNameComment
 		out.Value = trimFirstSpace(chunk[min(1, l):])
 		return true
+	} else if colonPos != 0 {
+		return f.scanUnknown(chunk, out)
 	}
 
 	return false
 }
 
+func (f *FieldParser) scanUnknown(chunk string, out *Field) bool {
+	if !f.keepUnknown {
+		return false
+	}
+
+	out.Name = FieldNameUnknown
+	out.Value = chunk
+	return true
+}
+
 // ErrUnexpectedEOF is returned when the input is completely parsed but no complete field was found at the end.
 var ErrUnexpectedEOF = errors.New("go-sse: unexpected end of input")
 
@@ -113,6 +126,12 @@ func (f *FieldParser) KeepComments(shouldKeep bool) {
 	f.keepComments = shouldKeep
 }
 
+// KeepUnknown configures the FieldParser to return/ignore lines which are not valid fields.
+// Such lines are returned as fields named FieldNameUnknown. By default they are ignored.
+func (f *FieldParser) KeepUnknown(shouldKeep bool) {
+	f.keepUnknown = shouldKeep
+}
+
 // RemoveBOM configures the FieldParser to try and remove the Unicode BOM
 // when parsing the first field, if it exists.
 // If, at the time this option is set, the input is untouched (no fields were parsed),
@@ -10,14 +10,27 @@ import (
 // bytes into SSE events. Each event ends with two consecutive newline sequences,
 // where a newline sequence is defined as either "\n", "\r", or "\r\n".
 func splitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	advance, token, _ = splitFrom(data, atEOF, 0)
+	return
+}
+
+// splitFrom is splitFunc resuming the search for the end of the event at the given offset, which must be
+// the resume offset returned by the previous call. The previous lines are not scanned again when more data
+// is read, so splitting a long event is not quadratic. The blank lines preceding an event are consumed
+// even if the event is not complete yet, so they don't fill the buffer.
+func splitFrom(data []byte, atEOF bool, from int) (advance int, token []byte, resume int) {
 	if len(data) == 0 {
-		return 0, nil, nil
+		return 0, nil, 0
 	}
 
-	var start int
+	start, lastChar := 0, from
+	advance = from
 	for {
 		index, endlineLen := NewlineIndex((*(*string)(unsafe.Pointer(&data)))[advance:])
 		advance += index + endlineLen
+		// The search resumes from the last character of the last line, so the line is
+		// still seen as non-blank and a "\r" is still joined with a following "\n".
+		lastChar = advance - endlineLen - 1
 		if index == 0 {
 			// If it was a blank line, skip it.
 			start += endlineLen
@@ -32,7 +45,14 @@ func splitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
 	if l := len(data); advance == l && !atEOF {
 		// We have reached the end of the buffer but have not yet seen two consecutive
 		// newline sequences, so we request more data.
-		return 0, nil, nil
+		if start == l {
+			// Only blank lines were read. Keep a trailing "\r", as it may be followed by a "\n".
+			if data[l-1] == '\r' {
+				start--
+			}
+			return start, nil, 0
+		}
+		return start, nil, lastChar - start
 	} else if advance < l {
 		// We have found a newline. Consume the end-of-line sequence.
 		advance++
@@ -44,7 +64,7 @@ func splitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
 
 	token = data[start:advance]
 
-	return advance, token, nil
+	return advance, token, 0
 }
 
 // Parser extracts fields from a reader. Reading is buffered using a bufio.Scanner.
@@ -52,6 +72,10 @@ func splitFunc(data []byte, atEOF bool) (advance int, token []byte, err error) {
 type Parser struct {
 	inputScanner *bufio.Scanner
 	fieldScanner *FieldParser
+
+	blankLines int
+	// resume is the offset from which the split function resumes the search for the end of the event.
+	resume int
 }
 
 // Next parses a single field from the reader. It returns false when there are no more fields to parse.
@@ -88,6 +112,39 @@ func (r *Parser) Err() error {
 	return r.fieldScanner.Err()
 }
 
+// KeepComments configures the Parser to return/ignore comment fields.
+// See the FieldParser's KeepComments method for more information.
+func (r *Parser) KeepComments(shouldKeep bool) {
+	r.fieldScanner.KeepComments(shouldKeep)
+}
+
+// KeepUnknown configures the Parser to return/ignore lines which are not valid fields.
+// See the FieldParser's KeepUnknown method for more information.
+func (r *Parser) KeepUnknown(shouldKeep bool) {
+	r.fieldScanner.KeepUnknown(shouldKeep)
+}
+
+// BlankLines returns the number of blank lines skipped until now. These are the blank lines
+// that follow the blank line that ends an event or that precede the first event, which
+// are not necessary in a well-formed stream.
+func (r *Parser) BlankLines() int {
+	return r.blankLines
+}
+
+// split wraps splitFunc to count the skipped blank lines.
+func (r *Parser) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	advance, token, r.resume = splitFrom(data, atEOF, r.resume)
+
+	skipped := (*(*string)(unsafe.Pointer(&data)))[:advance-len(token)]
+	for skipped != "" {
+		index, endlineLen := NewlineIndex(skipped)
+		skipped = skipped[index+endlineLen:]
+		r.blankLines++
+	}
+
+	return
+}
+
 // Buffer sets the buffer used to scan the input.
 // For more information, see the documentation on bufio.Scanner.Buffer.
 // Do not call this after parsing has started – the method will panic!
@@ -98,10 +155,12 @@ func (r *Parser) Buffer(buf []byte, max int) {
 // New returns a Parser that extracts fields from a reader.
 func New(r io.Reader) *Parser {
 	sc := bufio.NewScanner(r)
-	sc.Split(splitFunc)
 
 	fsc := NewFieldParser("")
 	fsc.RemoveBOM(true)
 
-	return &Parser{inputScanner: sc, fieldScanner: fsc}
+	p := &Parser{inputScanner: sc, fieldScanner: fsc}
+	sc.Split(p.split)
+
+	return p
 }
@@ -7,8 +7,11 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/tmaxmax/go-sse/internal/parser"
+	"github.com/tmaxmax/go-sse/internal/tests"
 )
 
 type errReader struct {
@@ -138,6 +141,176 @@ data: still, here's some data: you deserve it
 			t.Fatalf("expected error %v, received %v", bufio.ErrTooLong, p.Err())
 		}
 	})
+
+	t.Run("Unknown fields and blank lines", func(t *testing.T) {
+		p := parser.New(strings.NewReader("\r\ndta: a\ndata: b\nsomething\n: comment\nverylongname: c\n\n\r\n\rid: 1\n\n"))
+		p.KeepUnknown(true)
+
+		var fields []parser.Field
+		for f := (parser.Field{}); p.Next(&f); {
+			fields = append(fields, f)
+		}
+
+		expected := []parser.Field{
+			newField(t, parser.FieldNameUnknown, "dta: a"),
+			newDataField(t, "b"),
+			newField(t, parser.FieldNameUnknown, "something"),
+			newField(t, parser.FieldNameUnknown, "verylongname: c"),
+			{},
+			newIDField(t, "1"),
+			{},
+		}
+
+		if !reflect.DeepEqual(expected, fields) {
+			t.Fatalf("parse failed:\nreceived: %#v\nexpected: %#v", fields, expected)
+		}
+		if n := p.BlankLines(); n != 3 {
+			t.Fatalf("expected 3 blank lines, received %d", n)
+		}
+	})
+}
+
+func parseAll(r io.Reader) ([]parser.Field, error) {
+	p := parser.New(r)
+	p.KeepComments(true)
+	p.KeepUnknown(true)
+
+	var fields []parser.Field
+	for f := (parser.Field{}); p.Next(&f); {
+		fields = append(fields, f)
+	}
+
+	return fields, p.Err()
+}
+
+func TestParser_EOF(t *testing.T) {
+	t.Parallel()
+
+	// The parser returns the complete lines of an event which is not terminated by a blank line,
+	// without the empty field which ends an event. Per the specification, such events must be discarded
+	// by the client – the client's behavior is covered by the conformance corpus. A truncated line is discarded.
+	fields, err := parseAll(strings.NewReader("data: a\n\ndata: b\nid: 1\n"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []parser.Field{newDataField(t, "a"), {}, newDataField(t, "b"), newIDField(t, "1")}
+	if !reflect.DeepEqual(expected, fields) {
+		t.Fatalf("parse failed:\nreceived: %#v\nexpected: %#v", fields, expected)
+	}
+
+	fields, err = parseAll(strings.NewReader("data: a\n\ndata: b\nid: 1"))
+	if err != parser.ErrUnexpectedEOF { //nolint
+		t.Fatalf("invalid error: received %v, expected %v", err, parser.ErrUnexpectedEOF)
+	}
+	expected = []parser.Field{newDataField(t, "a"), {}, newDataField(t, "b")}
+	if !reflect.DeepEqual(expected, fields) {
+		t.Fatalf("parse failed:\nreceived: %#v\nexpected: %#v", fields, expected)
+	}
+}
+
+func TestParser_pathological(t *testing.T) {
+	t.Parallel()
+
+	const maxDuration = 5 * time.Second
+
+	type test struct {
+		name     string
+		input    string
+		expected []parser.Field
+	}
+
+	// The inputs are read one byte at a time, to check that the parser doesn't scan
+	// the buffered input again for every read. All of them fit in the default buffer.
+	longLine := strings.Repeat("a", 60_000)
+	cases := []test{
+		{
+			name:     "Millions of blank lines",
+			input:    strings.Repeat("\n", 2_000_000) + strings.Repeat("\r\n", 1_000_000) + "data: a\n\n",
+			expected: []parser.Field{newDataField(t, "a"), {}},
+		},
+		{
+			name:     "Long field name",
+			input:    longLine + ": b\ndata: a\n\n",
+			expected: []parser.Field{newField(t, parser.FieldNameUnknown, longLine+": b"), newDataField(t, "a"), {}},
+		},
+		{
+			name:     "Long event",
+			input:    strings.Repeat("data: a\n", 7_000) + "\n",
+			expected: append(repeatField(newDataField(t, "a"), 7_000), parser.Field{}),
+		},
+		{
+			name:  "Interleaved NULs",
+			input: "\x00\n\x00data: a\nda\x00ta: b\ndata: c\x00d\nid: \x00\n\x00\n\n",
+			expected: []parser.Field{
+				newField(t, parser.FieldNameUnknown, "\x00"),
+				newField(t, parser.FieldNameUnknown, "\x00data: a"),
+				newField(t, parser.FieldNameUnknown, "da\x00ta: b"),
+				newDataField(t, "c\x00d"),
+				newIDField(t, "\x00"),
+				newField(t, parser.FieldNameUnknown, "\x00"),
+				{},
+			},
+		},
+	}
+
+	for _, test := range cases {
+		test := test
+
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			start := time.Now()
+			fields, err := parseAll(iotest.OneByteReader(strings.NewReader(test.input)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if d := time.Since(start); d > maxDuration {
+				t.Fatalf("parsing took too long: %v", d)
+			}
+			if !reflect.DeepEqual(test.expected, fields) {
+				t.Fatalf("parse failed:\nreceived: %#v\nexpected: %#v", fields, test.expected)
+			}
+		})
+	}
+}
+
+func repeatField(f parser.Field, n int) []parser.Field {
+	fields := make([]parser.Field, n)
+	for i := range fields {
+		fields[i] = f
+	}
+	return fields
+}
+
+// FuzzParser checks that the parser doesn't depend on how the input is read. Besides the seeds below,
+// testdata/fuzz/FuzzParser holds streams shaped like the ones of some public event stream APIs.
+func FuzzParser(f *testing.F) {
+	for _, c := range tests.Conformance {
+		f.Add([]byte(c.Stream))
+	}
+	f.Add([]byte(benchmarkText))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		fields, err := parseAll(strings.NewReader(string(input)))
+		if err != nil && err != parser.ErrUnexpectedEOF { //nolint
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		for _, field := range fields {
+			if strings.ContainsAny(field.Value, "\r\n") {
+				t.Fatalf("field %q has a value with newlines: %q", field.Name, field.Value)
+			}
+		}
+
+		// Reading the input in other increments must give the same result.
+		oneByte, oneByteErr := parseAll(iotest.OneByteReader(strings.NewReader(string(input))))
+		if oneByteErr != err { //nolint
+			t.Fatalf("different errors when reading one byte at a time: %v, expected %v", oneByteErr, err)
+		}
+		if !reflect.DeepEqual(fields, oneByte) {
+			t.Fatalf("different fields when reading one byte at a time:\nreceived: %#v\nexpected: %#v", oneByte, fields)
+		}
+	})
 }
 
 func BenchmarkParser(b *testing.B) {
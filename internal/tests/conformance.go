@@ -0,0 +1,178 @@
+package tests
+
+// ConformanceEvent is an event dispatched by a client.
+type ConformanceEvent struct {
+	// Type is empty for events of the default type.
+	Type        string
+	Data        string
+	LastEventID string
+}
+
+// ConformanceCase is an event stream and the events a client dispatches while reading it,
+// as required by the WHATWG processing model:
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+type ConformanceCase struct {
+	Name   string
+	Stream string
+	// Events are the events the specification requires, in order.
+	Events []ConformanceEvent
+	// Divergence explains why go-sse dispatches other events than the specification requires, if it does.
+	// The events it dispatches are then in Actual.
+	Divergence string
+	Actual     []ConformanceEvent
+	// EncoderDivergence explains why the events, encoded by go-sse's server, are parsed back as other events
+	// than the specification requires, if they are. The events parsed back are then in RoundTrip.
+	EncoderDivergence string
+	RoundTrip         []ConformanceEvent
+}
+
+// Dispatched returns the events go-sse's client dispatches.
+func (c ConformanceCase) Dispatched() []ConformanceEvent {
+	if c.Divergence != "" {
+		return c.Actual
+	}
+	return c.Events
+}
+
+// RoundTripped returns the events go-sse's client dispatches after the events are encoded by go-sse's server.
+func (c ConformanceCase) RoundTripped() []ConformanceEvent {
+	if c.EncoderDivergence != "" {
+		return c.RoundTrip
+	}
+	return c.Events
+}
+
+const (
+	divergenceIncompleteEvent = "Intentional: an event which is not followed by a blank line is dispatched when the stream ends, " +
+		"as long as its last line is complete, because many servers close the stream right after writing the last event " +
+		"without terminating it. A stream which ends in the middle of a line is still discarded, as the line may be truncated."
+	divergenceEmptyBlock = "Known divergence: the client dispatches an event at the end of every block, even if the block " +
+		"had no data field – for example, if it had only comments, unknown fields or event and id fields. " +
+		"The specification requires such blocks to be ignored, only updating the last event ID."
+	divergenceEncodedData = "Known divergence: Message.AppendData splits the data into lines and a line terminator " +
+		"at the end of the data doesn't start a new line, so a trailing newline is lost and empty data adds no field. " +
+		"Messages without fields are not sent at all."
+)
+
+// Conformance is the corpus used to check that the client parses event streams and the server encodes events
+// as the specification requires. Change it only when the wire behavior is changed on purpose.
+var Conformance = []ConformanceCase{
+	{
+		Name:   "multiline data",
+		Stream: "data: YHOO\ndata: +2\ndata: 10\n\n",
+		Events: []ConformanceEvent{{Data: "YHOO\n+2\n10"}},
+	},
+	{
+		Name:   "spec example with IDs and comments",
+		Stream: ": test stream\n\ndata: first event\nid: 1\n\ndata:second event\nid\n\ndata:  third event\n",
+		Events: []ConformanceEvent{
+			{Data: "first event", LastEventID: "1"},
+			{Data: "second event"},
+		},
+		Divergence: divergenceEmptyBlock + " " + divergenceIncompleteEvent,
+		Actual: []ConformanceEvent{
+			{},
+			{Data: "first event", LastEventID: "1"},
+			{Data: "second event"},
+			{Data: " third event"},
+		},
+	},
+	{
+		Name:              "field names without values",
+		Stream:            "data\n\ndata\ndata\n\ndata:",
+		Events:            []ConformanceEvent{{Data: ""}, {Data: "\n"}},
+		EncoderDivergence: divergenceEncodedData,
+		RoundTrip:         []ConformanceEvent{{Data: ""}},
+	},
+	{
+		Name:   "single leading space is stripped",
+		Stream: "data:test\n\ndata: test\n\ndata:   x\nevent:  y\n\n",
+		Events: []ConformanceEvent{{Data: "test"}, {Data: "test"}, {Type: " y", Data: "  x"}},
+	},
+	{
+		Name:   "all line endings",
+		Stream: "data: a\r\ndata: b\rdata: c\n\r\n",
+		Events: []ConformanceEvent{{Data: "a\nb\nc"}},
+	},
+	{
+		Name:   "stream ending with carriage return",
+		Stream: "data: a\n\ndata: b\r",
+		Events: []ConformanceEvent{{Data: "a"}},
+		// The carriage return completes the line, so the event is dispatched.
+		Divergence: divergenceIncompleteEvent,
+		Actual:     []ConformanceEvent{{Data: "a"}, {Data: "b"}},
+	},
+	{
+		Name:   "byte order mark",
+		Stream: "\ufeffdata: a\n\n",
+		Events: []ConformanceEvent{{Data: "a"}},
+	},
+	{
+		Name:   "empty event field resets type",
+		Stream: "event: x\nevent\ndata: a\n\n",
+		Events: []ConformanceEvent{{Data: "a"}},
+	},
+	{
+		Name:   "default type",
+		Stream: "event: message\ndata: a\n\n",
+		Events: []ConformanceEvent{{Data: "a"}},
+	},
+	{
+		Name:   "unknown fields are ignored",
+		Stream: "foo: bar\ndata: a\n\n",
+		Events: []ConformanceEvent{{Data: "a"}},
+	},
+	{
+		Name:       "field names are not trimmed",
+		Stream:     "data : a\n\n:data: b\n\n",
+		Divergence: divergenceEmptyBlock,
+		Actual:     []ConformanceEvent{{}, {}},
+	},
+	{
+		Name:   "invalid retry is ignored",
+		Stream: "retry: 1x\ndata: a\n\n",
+		Events: []ConformanceEvent{{Data: "a"}},
+	},
+	{
+		Name:              "empty data",
+		Stream:            "data:\n\n",
+		Events:            []ConformanceEvent{{Data: ""}},
+		EncoderDivergence: divergenceEncodedData,
+	},
+	{
+		Name:   "multiple blank lines",
+		Stream: "data: a\n\n\n\ndata: b\n\n",
+		Events: []ConformanceEvent{{Data: "a"}, {Data: "b"}},
+	},
+	{
+		Name:       "event without data",
+		Stream:     "event: x\n\ndata: a\n\n",
+		Events:     []ConformanceEvent{{Data: "a"}},
+		Divergence: divergenceEmptyBlock,
+		Actual:     []ConformanceEvent{{Type: "x"}, {Data: "a"}},
+	},
+	{
+		Name:       "ID without data",
+		Stream:     "id: 1\n\ndata: a\n\n",
+		Events:     []ConformanceEvent{{Data: "a", LastEventID: "1"}},
+		Divergence: divergenceEmptyBlock,
+		Actual:     []ConformanceEvent{{LastEventID: "1"}, {Data: "a", LastEventID: "1"}},
+	},
+	{
+		Name:   "IDs with null bytes are ignored",
+		Stream: "id: 1\ndata: a\n\nid: 2\x003\ndata: b\n\n",
+		Events: []ConformanceEvent{{Data: "a", LastEventID: "1"}, {Data: "b", LastEventID: "1"}},
+	},
+	{
+		Name:       "incomplete event",
+		Stream:     "data: a\n\ndata: b\n",
+		Events:     []ConformanceEvent{{Data: "a"}},
+		Divergence: divergenceIncompleteEvent,
+		Actual:     []ConformanceEvent{{Data: "a"}, {Data: "b"}},
+	},
+	{
+		Name:   "truncated line",
+		Stream: "data: a\n\ndata: b",
+		Events: []ConformanceEvent{{Data: "a"}},
+	},
+}
@@ -1,8 +1,14 @@
 package sse
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -43,10 +49,12 @@ type ReplayProvider interface {
 	// can be replayed. If an error occurs internally when putting the new message
 	// and retrying the operation would block for too long, it can be aborted.
 	// The errors aren't returned as the server providers won't be able to handle them in a useful manner.
+	// Replay providers which want to report them implement ReplayProviderWithPutError.
 	Put(message *Message, topics []string) *Message
 	// Replay sends to a new subscriber all the valid events received by the provider
 	// since the event with the listener's ID. If the ID the listener provides
-	// is invalid, the provider should not replay any events.
+	// is invalid, the provider should not replay any events. If the subscription requests
+	// an inclusive replay, the event with the listener's ID should also be replayed, if available.
 	//
 	// Replay operations must be executed in the same goroutine as the one it is called in.
 	// Other goroutines may be launched from inside the Replay method, but the events must
@@ -58,6 +66,45 @@ type ReplayProvider interface {
 	Replay(subscription Subscription) error
 }
 
+// ReplayProviderWithContext is a ReplayProvider which can stop replaying events when a context is done.
+// Joe uses it to stop the replays to subscribers which left and to bound the duration of replays – see
+// its ReplayTimeout field.
+type ReplayProviderWithContext interface {
+	ReplayProvider
+	// ReplayContext replays the events like Replay, but it stops when the context is done, returning
+	// a *PartialReplayError. Replay providers should send an event of type ReplayGapEventType before
+	// stopping, if the context's deadline was exceeded, so the subscriber knows it missed events.
+	ReplayContext(ctx context.Context, subscription Subscription) error
+}
+
+// PartialReplayError is returned by replay providers which stopped replaying events because the context was done.
+type PartialReplayError struct {
+	// Err is the context's error.
+	Err error
+	// Replayed is the number of events which were replayed.
+	Replayed int
+	// Remaining is the number of events which weren't replayed.
+	Remaining int
+}
+
+func (e *PartialReplayError) Error() string {
+	return fmt.Sprintf("go-sse.server: replay stopped after %d events, %d events not replayed: %v", e.Replayed, e.Remaining, e.Err)
+}
+
+func (e *PartialReplayError) Unwrap() error {
+	return e.Err
+}
+
+// ReplayProviderWithTime is a ReplayProvider which replays the events published since a point in time
+// to the subscriptions which set Since instead of a LastEventID – see Subscription.Since.
+type ReplayProviderWithTime interface {
+	ReplayProvider
+	// OldestEventTime returns the time the oldest retained event was put at, or the zero time if no events
+	// are retained. The events published before it can't be replayed anymore, so a subscription whose Since
+	// is before it may have missed events.
+	OldestEventTime() time.Time
+}
+
 // ReplayProviderWithGC is a ReplayProvider that must have invalid messages cleaned up from time to time.
 // This may be the case for a provider that replays messages that are not expired: at a certain interval,
 // expired messages must be removed from the provider to free up resources.
@@ -76,28 +123,77 @@ type ReplayProviderWithGC interface {
 	GC() error
 }
 
+// ReplayProviderWithTopicCleanup is a ReplayProvider that can remove all the messages of a topic.
+// Providers can use it to release the resources held for topics which will never be subscribed to again,
+// such as short-lived per-entity topics. See Joe's DropIdleTopicsAfter field, for example.
+type ReplayProviderWithTopicCleanup interface {
+	ReplayProvider
+	// DropTopic removes the given topic from all the messages in the provider. The messages
+	// which were published only to the given topic must not be replayed ever again.
+	//
+	// If DropTopic returns an error, providers are not required to call it ever again.
+	DropTopic(topic string) error
+}
+
+// ReplayProviderWithPutError is a ReplayProvider which reports the failures to put a message by returning
+// an error, instead of panicking. Joe uses PutWithError instead of Put for such providers, so a failure
+// doesn't stop its event loop – see Joe's OnReplayError and ReplayPutRetries fields.
+type ReplayProviderWithPutError interface {
+	ReplayProvider
+	// PutWithError adds a new event to the replay buffer, like Put. If the message couldn't be queued,
+	// an error is returned instead of panicking and the message must not be replayed. Returning an error
+	// for temporary failures is allowed, as PutWithError may be called again for the same message.
+	PutWithError(message *Message, topics []string) (*Message, error)
+}
+
 type (
 	subscriber   chan<- error
-	subscribers  map[subscriber]MessageWriter
+	subscribers  map[subscriber]interruptibleWriter
 	subscription struct {
+		ctx  context.Context //nolint:containedctx // The context is used by operations done on other goroutines.
 		done subscriber
 		Subscription
+		// writer wraps the subscription's client, so Joe can stop waiting for it. See interruptibleWriter.
+		writer interruptibleWriter
+		// snapshot is the function which sends the initial snapshot, instead of replaying events.
+		snapshot SnapshotFunc
+		// merged holds the subscribers of duplicate subscriptions that were merged into this one.
+		merged []subscriber
+		// limit is the subscriber's state with respect to Joe's SoftLimit.
+		limit limitState
+		// queue holds the messages sent by the subscriber's goroutine, once started. See Joe.SubscriberBufferSize.
+		queue *subscriberQueue
 	}
 
 	messageWithTopics struct {
 		message *Message
 		topics  []string
+		// putAt is the time the message was put in the replay provider, if it records it.
+		putAt time.Time
+		// seq is the order in which the message was put in the buffer, if the buffer records it.
+		seq int64
 	}
 )
 
 // Joe is a basic server provider that synchronously executes operations by queueing them in channels.
 // Events are also sent synchronously to subscribers, so if a subscriber's callback blocks, the others
-// have to wait.
+// have to wait. Use the Server's WriteTimeout field to bound the writes to clients which stopped reading,
+// or see the InterruptibleWrites field for writers which can block otherwise. To send the events to each
+// subscriber on its own goroutine instead, see the SubscriberBufferSize field.
 //
 // Joe optionally supports event replaying with the help of a replay provider.
 //
+// Joe delivers the messages to each subscriber in the order they were published, regardless of
+// their topics: if a Publish call returns before another one starts – for example, when both are made
+// from the same goroutine – every subscriber that receives both messages receives them in that order.
+// Concurrent Publish calls are ordered arbitrarily, but the same way for all subscribers. This holds for
+// all delivery modes: replayed or backfilled messages are always sent before the live ones, and the messages
+// queued during a backfill or sent while draining keep their publish order. All operations are executed
+// sequentially on a single goroutine, which is what makes the guarantee possible.
+//
 // If due to some unexpected scenario (the replay provider has a bug, for example) a panic occurs,
-// Joe will remove all subscribers, so requests don't hang.
+// Joe will remove all subscribers, so requests don't hang. Failures to put messages in the replay provider
+// can be recovered from instead – see the OnReplayError field.
 //
 // He serves simple use-cases well, as he's light on resources, and does not require any external
 // services. Also, he is the default provider for Servers.
@@ -105,31 +201,330 @@ type Joe struct {
 	message        chan messageWithTopics
 	subscription   chan subscription
 	unsubscription chan subscriber
+	backfilled     chan backfillResult
+	tasks          chan func()
 	done           chan struct{}
 	closed         chan struct{}
-	topics         map[string]subscribers
+	// topics holds the subscribers of the topics which are not patterns.
+	topics topicTrie
+	// patterns holds the subscribers of the topic patterns, if WildcardTopics is set.
+	patterns map[string]subscribers
+	// subscribers is the set of unique subscribers, which also acts as a reverse index
+	// for the topics map: each subscription holds its deduplicated topics.
+	subscribers map[subscriber]*subscription
+	// writers indexes the subscribers by their clients, used to detect duplicate subscriptions.
+	writers map[MessageWriter]subscriber
+	// merged maps the subscribers of merged duplicate subscriptions to the subscriber they were merged into.
+	merged map[subscriber]subscriber
+	// backfills holds the state of the subscribers for which the backfill is running.
+	backfills map[subscriber]*backfill
+	// idleTopics holds the time of the last activity of the topics without subscribers,
+	// if idle topics are dropped from the replay provider.
+	idleTopics   map[string]time.Time
+	topicCleanup ReplayProviderWithTopicCleanup
+	replay       ReplayProvider
+	// replayQueue holds the subscribers whose replay waits for the replay budget, in arrival order.
+	replayQueue  []subscriber
+	replayBudget ReplayBudget
+	// replayTokens is the number of events that can still be replayed, according to the replay budget.
+	replayTokens   float64
+	replayRefilled time.Time
+	// activeReplays is the number of running backfills and replays.
+	activeReplays int
+	// replayTimer starts the queued replays when the replay budget is refilled.
+	replayTimer *time.Timer
+	replayWake  <-chan time.Time
+
+	subscriberCount   atomic.Int64
+	registrationCount atomic.Int64
+	queuedReplays     atomic.Int64
+	droppedMessages   atomic.Int64
+	// lastDispatch is the time the last message was dispatched, in Unix nanoseconds.
+	lastDispatch atomic.Int64
+	// sequences holds the last sequence number of each topic, if TopicSequence is set.
+	sequences map[string]uint64
 
 	// An optional replay provider that Joe uses to resend older messages to new subscribers.
 	ReplayProvider ReplayProvider
 	// An optional interval at which Joe triggers a cleanup of expired messages, if the replay provider supports it.
 	// See the desired provider's documentation to determine if periodic cleanup is necessary.
 	ReplayGCInterval time.Duration
+	// OnReplayGCError is an optional callback that is called when the replay provider's GC fails.
+	// By default, Joe doesn't run GC anymore after it fails once; the callback decides whether GC keeps
+	// being run at ReplayGCInterval, by returning true, or is stopped, by returning false. Use it to notify
+	// operators, so a stopped GC doesn't go unnoticed. It is called on Joe's event loop, so it must return quickly.
+	OnReplayGCError func(err error) (keepRunning bool)
+	// OnDuplicateSubscription configures what Joe does when a client that is already
+	// subscribed is subscribed again. By default, the duplicate subscription is rejected
+	// with ErrAlreadySubscribed. See DuplicateSubscriptionPolicy for more information.
+	OnDuplicateSubscription DuplicateSubscriptionPolicy
+	// OnPublish is an optional callback that is called for each published message,
+	// after it was put in the replay provider and before it is sent to subscribers.
+	// The message it receives is the one sent to subscribers, so its ID is the final one,
+	// even if the replay provider sets IDs automatically. The message must not be modified.
+	//
+	// OnPublish is called exactly once for each message, in the order the messages are published.
+	// It is called on Joe's event loop, so it must return quickly, as no messages are dispatched
+	// and no clients are subscribed until it does. Heavy work, like writing an audit log to
+	// persistent storage, should be queued by the callback and done on another goroutine.
+	OnPublish func(msg *Message, topics []string)
+	// OnDispatch is an optional callback that is called after each published message is sent to the subscribers,
+	// with the number of subscribers of each topic which received it – see PublishResult. Like OnPublish,
+	// it is called on Joe's event loop, so it must return quickly. Use it to update delivery metrics.
+	OnDispatch func(msg *Message, res PublishResult)
+	// TopicSequence is an optional function used to stamp each published message with its sequence number
+	// in each of its topics: the first message published to a topic has the number 1, the next one 2 and so on.
+	// Clients can then detect the messages they missed – after reconnecting, for example – even if the event IDs
+	// are opaque. The function is called for each topic of the message, in order, with the message returned
+	// by the previous call. The given message must not be modified; return a new message instead – see
+	// SequenceInComment.
+	//
+	// The messages are stamped before they are put in the replay provider, so replayed messages have the same
+	// numbers. The numbers are kept in memory, so they start over when Joe is created again. The function is
+	// called on Joe's event loop, so it must return quickly.
+	TopicSequence func(msg *Message, topic string, seq uint64) *Message
+	// OnReplayError configures what Joe does when the replay provider fails to put a published message,
+	// which replay providers signal by panicking. By default, the panic is not recovered, so Joe stops
+	// and all subscribers are removed. See ReplayErrorPolicy for more information.
+	OnReplayError ReplayErrorPolicy
+	// ReplayErrorHook is an optional callback that is called when the replay provider fails to put
+	// a message and the failure is recovered from, as configured by OnReplayError. Use it to log the failure
+	// or to update metrics, so operators know the replay history has a gap. It is called on Joe's event loop,
+	// so it must return quickly.
+	ReplayErrorHook func(err error, msg *Message, topics []string)
+	// ReplayPutRetries is the number of times Joe calls PutWithError again for a message, if the replay
+	// provider implements ReplayProviderWithPutError and fails to put the message. The retries are done
+	// immediately, on Joe's event loop, so keep their number low. The message is handled according to
+	// OnReplayError only if all the retries fail. Defaults to 0, so the message is put once.
+	ReplayPutRetries int
+	// Backfill is an optional function used to send events from an external source, such as a database,
+	// to new subscribers, before any replayed or live events are sent. See the BackfillFunc type
+	// for more information.
+	Backfill BackfillFunc
+	// ReplayTimeout is the maximum duration of replaying events to a new subscriber, if the replay provider
+	// implements ReplayProviderWithContext, as the built-in replay providers do. This way, a client doesn't
+	// wait for too long for the new events when there are many events to replay. If the replay takes longer,
+	// it is stopped, the subscriber is notified that some events weren't replayed and it receives the new
+	// events as usual. The Server logs the partial replays. Replays are also stopped if the subscriber leaves
+	// while they are in progress.
+	//
+	// Defaults to 0, which means that the replay is not bounded.
+	ReplayTimeout time.Duration
+	// ReplayBudget limits the number of concurrent replays and the rate of replayed events, queueing
+	// the replays over the limits. It can be changed while Joe is running using SetReplayBudget.
+	// See ReplayBudget for more information.
+	ReplayBudget ReplayBudget
+	// DrainTimeout is the maximum duration for which Joe tries to send the messages already queued
+	// for a subscriber after its context is done, before closing it. This way, the last messages
+	// published before the subscription ended are not lost. Write errors end the draining immediately.
+	// Subscribe returns after the draining is done.
+	//
+	// Messages are queued for subscribers whose backfill is running – see the Backfill field – and,
+	// if SubscriberBufferSize is set, for all subscribers. Writes on the subscribers' goroutines
+	// are not interrupted by the timeout, though: it only stops sending the messages still queued.
+	// Defaults to 0, which means that queued messages are dropped.
+	DrainTimeout time.Duration
+	// InterruptibleWrites configures Joe to run each write to the subscribers on a separate goroutine,
+	// so a subscriber whose writes block forever doesn't stop Joe from shutting down or from ending
+	// the draining of a subscriber: Joe stops waiting for the write, abandons the subscriber and its
+	// Subscribe call returns after the write does. This has a cost for every message sent, so enable it
+	// only for writers which can block without a bound – the Server's WriteTimeout field is preferred
+	// for bounding the writes to HTTP clients, as it uses write deadlines instead.
+	//
+	// Defaults to false, which means that the writes run on the goroutine which sends the messages.
+	InterruptibleWrites bool
+	// ReleaseMessages configures Joe to take ownership of the published messages and to release them
+	// with ReleaseMessage once they are dispatched, so publishers can create them with AcquireMessage
+	// without producing garbage. If the message may be held after it is dispatched – by the replay provider,
+	// the subscribers' queues or the pending backfills –, a copy of it is dispatched and the message is
+	// released right away. The message is not released if Publish returns an error, or if PublishWithResult
+	// returns an error other than the context's.
+	//
+	// The published messages must not be used after Publish returns, and the OnPublish and OnDispatch
+	// callbacks must not keep the messages they receive.
+	ReleaseMessages bool
+	// Aliases maps alternative topic names to the topic they stand for. Messages published
+	// to an alias reach the subscribers of the topic and vice versa, as both names behave
+	// as one topic – the topic is used everywhere, including in the replay provider, so
+	// replaying works for both names, too. This is useful when topics are renamed.
+	//
+	// An alias must not stand for another alias, nor for itself – Joe panics on first use
+	// if that's the case. The map must not be modified after Joe is used.
+	Aliases map[string]string
+	// DropIdleTopicsAfter configures Joe to remove from the replay provider the messages of the topics
+	// which have no subscribers, if the replay provider implements ReplayProviderWithTopicCleanup.
+	// A topic is dropped after it had no subscribers and no messages were published to it for
+	// at least this duration – the check is done periodically, so it may be dropped up to
+	// twice as late. This keeps memory bounded for workloads with many short-lived topics.
+	//
+	// Defaults to 0, which means that topics are never dropped.
+	DropIdleTopicsAfter time.Duration
+	// WildcardTopics configures Joe to treat the topics of the subscriptions which contain the wildcards
+	// of path.Match – '*', '?', '[' and '\' – as patterns: the subscribers receive the messages published
+	// to all the topics matching the patterns. For example, subscribers of "orders.*" receive the messages
+	// published to "orders.created" and "orders.shipped". As with path.Match, '*' doesn't match slashes.
+	// Subscribing with a malformed pattern fails with an error wrapping path.ErrBadPattern.
+	//
+	// The patterns are matched when messages are published, so topics which are published to for the first
+	// time reach the existing subscribers. The built-in replay providers replay the events of the matching
+	// topics, too. Topics of published messages are never patterns, and aliases don't apply to patterns.
+	//
+	// Defaults to false, which means that the wildcards have no special meaning.
+	WildcardTopics bool
+	// HierarchicalTopics configures Joe to treat the topics as paths whose levels are separated by slashes:
+	// the subscribers of a topic also receive the messages published to its descendants. For example,
+	// subscribers of "sensors/eu" receive the messages published to "sensors/eu" and "sensors/eu/paris",
+	// but not those published to "sensors/europe". The events are annotated with the topic they were published
+	// to and the built-in replay providers replay the events of the descendants, too. Patterns are matched
+	// against whole topics – see WildcardTopics.
+	//
+	// Defaults to false, which means that slashes have no special meaning.
+	HierarchicalTopics bool
+	// TopicSubscriberLimit optionally returns the maximum number of subscribers of each topic. A subscription
+	// to a topic which already has this many subscribers fails with a *TopicFullError, and so do the topic
+	// changes of ReplaceTopics and AddTopics which would exceed the limit. Patterns are limited as topics
+	// of their own. Zero or negative limits mean no limit. The function is called on Joe's event loop,
+	// so it must be fast. Defaults to nil, which means no limits.
+	TopicSubscriberLimit func(topic string) int
+	// SubscriberBufferSize configures Joe to send the live messages to each subscriber on a separate goroutine,
+	// through a queue which holds up to this many messages, so a slow subscriber doesn't delay the delivery
+	// to the others. When a subscriber's queue is full, its overflow policy is applied: by default, Joe waits
+	// for it to have room before dispatching further messages, so a subscriber can fall behind by this many
+	// messages before it delays the others – see the OnSubscriberOverflow field.
+	//
+	// The messages are still sent to each subscriber in publish order and replayed or backfilled messages
+	// are still sent before the live ones, on Joe's event loop. Each message is flushed after it is sent.
+	// If sending a message fails, the subscription is ended with the error, as usual. Messages still queued
+	// when the subscription ends are dropped, unless DrainTimeout is set.
+	//
+	// Defaults to 0, which means that messages are sent to subscribers on Joe's event loop.
+	SubscriberBufferSize int
+	// OnSubscriberOverflow configures what Joe does when the queue of a subscriber is full, for the
+	// subscriptions which don't configure it themselves. It is used only if SubscriberBufferSize is set.
+	// Defaults to BlockOnOverflow. See OverflowPolicy for more information.
+	OnSubscriberOverflow OverflowPolicy
+	// SlowSubscribers configures Joe to warn the subscribers which are too slow to receive messages and
+	// to disconnect them if they don't recover in time. See SoftLimit for more information.
+	SlowSubscribers SoftLimit
 
 	initDone sync.Once
 }
 
+// DuplicateSubscriptionPolicy determines how Joe handles subscriptions whose client
+// is already subscribed. Without a policy, a client subscribed twice would receive each
+// message twice, which is never desired.
+//
+// Clients are identified using pointer equality, so duplicates are detected only for clients
+// whose MessageWriter implementation is a pointer – *Session, for example.
+type DuplicateSubscriptionPolicy int
+
+// The available duplicate subscription policies.
+const (
+	// RejectDuplicateSubscriptions makes Subscribe return ErrAlreadySubscribed
+	// for duplicate subscriptions. The existing subscription is not affected.
+	RejectDuplicateSubscriptions DuplicateSubscriptionPolicy = iota
+	// MergeDuplicateSubscriptions adds the topics of the duplicate subscription to the
+	// existing one. No events are replayed for the duplicate subscription. Its Subscribe call
+	// blocks until the existing subscription ends, returning the same error, or until its
+	// own context is done – the merged topics are kept in the latter case.
+	MergeDuplicateSubscriptions
+)
+
+// ReplayErrorPolicy determines how Joe handles the failures of the replay provider to put
+// a published message. Failing to put a message means that it can't be replayed, but
+// for many applications delivering it to the current subscribers is more important.
+//
+// The errors returned by replay providers which implement ReplayProviderWithPutError are
+// handled like the panics, except that PanicOnReplayError is handled like DeliverWithoutReplay,
+// as there is no panic to propagate.
+type ReplayErrorPolicy int
+
+// The available replay error policies.
+const (
+	// PanicOnReplayError doesn't recover from the replay provider's panic, so Joe stops
+	// and all subscribers are removed.
+	PanicOnReplayError ReplayErrorPolicy = iota
+	// DropOnReplayError recovers from the replay provider's panic and drops the message,
+	// so it is not sent to any subscriber. OnPublish is not called for the message.
+	DropOnReplayError
+	// DeliverWithoutReplay recovers from the replay provider's panic and sends the message,
+	// as published, to the current subscribers. The message won't be replayed.
+	DeliverWithoutReplay
+)
+
+// ErrReplayPutFailed is the error passed to Joe's ReplayErrorHook when the replay provider fails to put a message.
+// The hook receives a *ReplayPutError, which matches ErrReplayPutFailed when using errors.Is.
+var ErrReplayPutFailed = errors.New("go-sse.server: replay provider failed to put message")
+
+// ReplayPutError is the error passed to Joe's ReplayErrorHook when the replay provider fails to put a message.
+type ReplayPutError struct {
+	// Err is the error returned by the replay provider's PutWithError, or the value the replay provider's
+	// Put panicked with, converted to an error if needed.
+	Err error
+}
+
+func (e *ReplayPutError) Error() string {
+	return ErrReplayPutFailed.Error() + ": " + e.Err.Error()
+}
+
+func (e *ReplayPutError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is ErrReplayPutFailed.
+func (e *ReplayPutError) Is(target error) bool {
+	return target == ErrReplayPutFailed //nolint:errorlint // The sentinel is compared directly.
+}
+
+// ErrAlreadySubscribed is returned by Joe when a client is subscribed while it already has
+// an active subscription. See DuplicateSubscriptionPolicy for more information.
+var ErrAlreadySubscribed = errors.New("go-sse.server: client is already subscribed")
+
 // Subscribe tells Joe to send new messages to this subscriber. The subscription
 // is automatically removed when the context is done, a callback error occurs
-// or Joe is stopped.
+// or Joe is stopped. If Joe is stopped, Subscribe returns ErrProviderClosed.
 func (j *Joe) Subscribe(ctx context.Context, sub Subscription) error {
+	return j.doSubscribe(subscription{ctx: ctx, Subscription: sub})
+}
+
+// SnapshotFunc is the type of the functions which send to a new subscriber the current
+// state of the application, as events. See Joe.SubscribeWithSnapshot for more information.
+type SnapshotFunc func(w MessageWriter) error
+
+// SubscribeWithSnapshot subscribes to Joe like Subscribe, but instead of replaying events, the given function
+// is used to send the initial events – for example, a snapshot of the application's current state, computed
+// from a database. The function is called while no messages are published, so every message published after
+// the function returns is sent to the subscriber and no message published before it is. For this guarantee
+// to be useful, messages must be published only after the state they reflect is committed.
+//
+// The function runs on Joe's event loop, so it must return quickly – no messages are published and no clients
+// are subscribed until it does. If it returns an error, the subscription is not created and the error is returned.
+// The Backfill function and the replay provider are not used for subscriptions created this way.
+func (j *Joe) SubscribeWithSnapshot(ctx context.Context, sub Subscription, snapshot SnapshotFunc) error {
+	return j.doSubscribe(subscription{ctx: ctx, Subscription: sub, snapshot: snapshot})
+}
+
+func (j *Joe) doSubscribe(sub subscription) error {
 	j.init()
 
+	ctx := sub.ctx
 	done := make(chan error, 1)
+	sub.done = done
+	sub.Subscription = j.resolveAliases(sub.Subscription)
+	if j.WildcardTopics {
+		if err := validateTopicPatterns(sub.Topics); err != nil {
+			return err
+		}
+	}
+	if j.WildcardTopics || j.HierarchicalTopics {
+		sub.matchTopic = j.matchTopic
+	}
+	sub.writer = interruptibleWriter{MessageWriter: sub.Client, done: j.done, interruptible: j.InterruptibleWrites}
 
 	select {
 	case <-j.done:
 		return ErrProviderClosed
-	case j.subscription <- subscription{done: done, Subscription: sub}:
+	case j.subscription <- sub:
 	}
 
 	select {
@@ -142,6 +537,9 @@ func (j *Joe) Subscribe(ctx context.Context, sub Subscription) error {
 	case err := <-done:
 		return err
 	case j.unsubscription <- done:
+		// The subscriber may be closed only after operations running
+		// on other goroutines, such as backfills, stopped using the client.
+		<-done
 		return nil
 	}
 }
@@ -152,6 +550,8 @@ func (j *Joe) Subscribe(ctx context.Context, sub Subscription) error {
 // to more than one topic that receive the given Message. Every client
 // receives each unique message once, regardless of how many topics it
 // is subscribed to or to how many topics the message is published.
+// Nil or invalid messages are rejected before they are queued, with
+// ErrNilMessage or ErrInvalidMessage.
 func (j *Joe) Publish(msg *Message, topics []string) error {
 	if len(topics) == 0 {
 		return ErrNoTopic
@@ -159,18 +559,377 @@ func (j *Joe) Publish(msg *Message, topics []string) error {
 
 	j.init()
 
+	select {
+	case <-j.done:
+		return ErrProviderClosed
+	default:
+	}
+	if err := validateMessage(msg, true); err != nil {
+		return err
+	}
+
 	// Waiting on done ensures Publish doesn't block the caller goroutine
 	// when Joe is stopped and implements the required Provider behavior.
 	select {
-	case j.message <- messageWithTopics{message: msg, topics: topics}:
+	case j.message <- messageWithTopics{message: msg, topics: j.resolveTopics(topics)}:
+		return nil
+	case <-j.done:
+		return ErrProviderClosed
+	}
+}
+
+// PublishWithResult publishes the message like Publish, but it waits for the message to be dispatched
+// on Joe's event loop and reports to how many subscribers it was delivered. The subscribers which are still
+// being replayed events count, as they receive the message after the replay, while those whose filter
+// rejects the message or whose write fails don't. If the context is done before the message is dispatched,
+// its error is returned and the message may or may not be published.
+func (j *Joe) PublishWithResult(ctx context.Context, msg *Message, topics []string) (PublishResult, error) {
+	if len(topics) == 0 {
+		return PublishResult{}, ErrNoTopic
+	}
+	if err := validateMessage(msg, true); err != nil {
+		return PublishResult{}, err
+	}
+
+	var res PublishResult
+	if err := j.run(ctx, func() {
+		res = j.dispatch(messageWithTopics{message: msg, topics: j.resolveTopics(topics)})
+	}); err != nil {
+		return PublishResult{}, err
+	}
+
+	return res, nil
+}
+
+// WriteHistory writes the retained history of the given topic, if Joe's replay provider implements
+// the HistoryWriter interface – otherwise, ErrHistoryUnsupported is returned. The history is read
+// on Joe's event loop, so it is safe to call WriteHistory concurrently with the other methods.
+// See HistoryWriter and HistoryHandler for more information.
+func (j *Joe) WriteHistory(w io.Writer, topic string, maxBytes int) (int64, error) {
+	var buf bytes.Buffer
+	var err error
+
+	if runErr := j.run(context.Background(), func() {
+		h, ok := j.replay.(HistoryWriter)
+		if !ok {
+			err = ErrHistoryUnsupported
+			return
+		}
+
+		_, err = h.WriteHistory(&buf, j.resolveTopics([]string{topic})[0], maxBytes)
+	}); runErr != nil {
+		return 0, runErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return buf.WriteTo(w)
+}
+
+// ReplayStats returns the statistics of the events retained by Joe's replay provider, if it implements
+// the ReplayInspector interface – otherwise, ErrReplayStatsUnsupported is returned. The statistics are read
+// on Joe's event loop, so it is safe to call ReplayStats concurrently with the other methods.
+func (j *Joe) ReplayStats() (ReplayStats, error) {
+	var stats ReplayStats
+	var err error
+
+	if runErr := j.run(context.Background(), func() {
+		r, ok := j.replay.(ReplayInspector)
+		if !ok {
+			err = ErrReplayStatsUnsupported
+			return
+		}
+
+		stats, err = r.ReplayStats()
+	}); runErr != nil {
+		return ReplayStats{}, runErr
+	}
+
+	return stats, err
+}
+
+// Ping checks that Joe's event loop is responsive, by running a no-op operation on it.
+// It returns the context's error if the operation isn't started before the context is done –
+// for example, because the loop is blocked by a client whose writes block –, and ErrProviderClosed
+// if Joe is shut down. Use it to implement health or readiness checks.
+func (j *Joe) Ping(ctx context.Context) error {
+	return j.run(ctx, func() {})
+}
+
+// ErrGCUnsupported is returned by Joe's TriggerGC if the replay provider doesn't implement ReplayProviderWithGC.
+var ErrGCUnsupported = errors.New("go-sse.server: replay provider does not support GC")
+
+// TriggerGC runs the replay provider's GC immediately, on Joe's event loop, and returns its error.
+// Use it to clean up the replay provider on the application's own signals – memory pressure or
+// an administration endpoint, for example –, besides or instead of the ReplayGCInterval.
+// The periodic GC is not affected by the result and OnReplayGCError is not called, as the error
+// is returned. ErrGCUnsupported is returned if the replay provider doesn't implement ReplayProviderWithGC.
+//
+// Like Ping, TriggerGC returns the context's error if the GC isn't started before the context is done,
+// and ErrProviderClosed if Joe is shut down.
+func (j *Joe) TriggerGC(ctx context.Context) error {
+	var err error
+
+	if runErr := j.run(ctx, func() {
+		provider, ok := j.replay.(ReplayProviderWithGC)
+		if !ok {
+			err = ErrGCUnsupported
+			return
+		}
+
+		err = provider.GC()
+	}); runErr != nil {
+		return runErr
+	}
+
+	return err
+}
+
+// LastDispatchTime returns the time at which Joe finished dispatching the last published message.
+// It is the zero time if no messages were published. Together with the rate at which messages are
+// expected to be published, it can be used to detect that Joe stopped delivering messages.
+func (j *Joe) LastDispatchTime() time.Time {
+	ns := j.lastDispatch.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// ErrNotSubscribed is returned by Joe's methods which change the subscription of a client, or send to it,
+// if the client is not subscribed – for example, because its subscription has ended.
+var ErrNotSubscribed = errors.New("go-sse.server: client is not subscribed")
+
+// ReplaceTopics atomically replaces the topics of the client's subscription with the given topics
+// and returns the previous ones, so the subscriptions can be changed when the client's permissions
+// change, for example. The replacement is a single operation on Joe's event loop, so every message
+// is evaluated against either the previous or the new topics, never against a mix of them.
+//
+// The client is identified the same way duplicate subscriptions are – see DuplicateSubscriptionPolicy.
+// If the client is not subscribed, ErrNotSubscribed is returned; subscriptions whose context is done,
+// including those being drained, are not subscribed anymore. The context can be used to stop waiting
+// for Joe to be available. No messages are replayed for the new topics.
+func (j *Joe) ReplaceTopics(ctx context.Context, client MessageWriter, topics []string) (previous []string, err error) {
+	if len(topics) == 0 {
+		return nil, ErrNoTopic
+	}
+	if j.WildcardTopics {
+		if err := validateTopicPatterns(topics); err != nil {
+			return nil, err
+		}
+	}
+
+	err = j.updateSubscription(ctx, client, func(s *subscription) error {
+		resolved := j.resolveTopics(topics)
+		if err := j.checkTopicLimits(s.done, resolved); err != nil {
+			return err
+		}
+
+		previous = append([]string(nil), s.Topics...)
+		// Emptied topics are marked as idle, but registering the new topics unmarks the reused ones.
+		j.unregisterTopics(s)
+		j.registerTopics(s, resolved)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return previous, nil
+}
+
+// AddTopics adds the given topics to the client's subscription, so it receives their messages from now on
+// without reconnecting. The topics the client is already subscribed to are ignored. The addition is a single
+// operation on Joe's event loop, so concurrent calls of AddTopics, RemoveTopics and ReplaceTopics don't
+// overwrite each other's changes. The same rules as for ReplaceTopics apply.
+func (j *Joe) AddTopics(ctx context.Context, client MessageWriter, topics []string) error {
+	if len(topics) == 0 {
+		return ErrNoTopic
+	}
+	if j.WildcardTopics {
+		if err := validateTopicPatterns(topics); err != nil {
+			return err
+		}
+	}
+
+	return j.updateSubscription(ctx, client, func(s *subscription) error {
+		resolved := j.resolveTopics(topics)
+		if err := j.checkTopicLimits(s.done, resolved); err != nil {
+			return err
+		}
+
+		j.registerTopics(s, resolved)
 		return nil
+	})
+}
+
+// RemoveTopics removes the given topics from the client's subscription, so it doesn't receive their messages
+// anymore, without reconnecting. The topics the client isn't subscribed to are ignored. If no topics would be
+// left, the subscription is not changed and ErrNoTopic is returned – end the subscription instead. The same
+// rules as for AddTopics apply.
+func (j *Joe) RemoveTopics(ctx context.Context, client MessageWriter, topics []string) error {
+	if len(topics) == 0 {
+		return ErrNoTopic
+	}
+
+	return j.updateSubscription(ctx, client, func(s *subscription) error {
+		removed := map[string]struct{}{}
+		for _, topic := range j.resolveTopics(topics) {
+			removed[topic] = struct{}{}
+		}
+
+		var kept, dropped []string
+		for _, topic := range s.Topics {
+			if _, ok := removed[topic]; ok {
+				dropped = append(dropped, topic)
+			} else {
+				kept = append(kept, topic)
+			}
+		}
+		if len(kept) == 0 {
+			return ErrNoTopic
+		}
+
+		for _, topic := range dropped {
+			j.removeFromTopic(topic, s.done)
+		}
+
+		j.registrationCount.Add(-int64(len(dropped)))
+		s.Topics = kept
+
+		return nil
+	})
+}
+
+// updateSubscription runs the update of the client's subscription on Joe's event loop.
+func (j *Joe) updateSubscription(ctx context.Context, client MessageWriter, update func(s *subscription) error) (err error) {
+	key, ok := writerKey(client)
+	if !ok {
+		return ErrNotSubscribed
+	}
+
+	if runErr := j.run(ctx, func() {
+		done, ok := j.writers[key]
+		if !ok {
+			err = ErrNotSubscribed
+			return
+		}
+		if b, ok := j.backfills[done]; ok && !b.drainDeadline.IsZero() {
+			// The subscription's context is done and only the queued messages are still sent.
+			err = ErrNotSubscribed
+			return
+		}
+
+		err = update(j.subscribers[done])
+	}); runErr != nil {
+		return runErr
+	}
+
+	return err
+}
+
+// SendTo sends the message only to the given client, so servers can notify a single subscriber –
+// of an expiring token, for example – without publishing to a topic. The message is not put in the replay
+// provider and it is not annotated with a topic.
+//
+// The message is sent on Joe's event loop, so it is never interleaved with the published messages.
+// A message sent after Publish returned is sent after the published message, and a message
+// published after SendTo returned is sent after the unicast message. If the client's replay
+// or backfill is running, the message is held back and sent after it, in order with the messages
+// published meanwhile. The order of concurrent calls is arbitrary.
+//
+// The client is identified the same way duplicate subscriptions are – see DuplicateSubscriptionPolicy.
+// If the client is not subscribed, ErrNotSubscribed is returned. If sending the message fails,
+// the subscription is ended and the error is returned – if SubscriberBufferSize is set, the message
+// is queued instead and sending errors end the subscription without being returned. The context
+// can be used to stop waiting for Joe to be available.
+func (j *Joe) SendTo(ctx context.Context, client MessageWriter, msg *Message) (err error) {
+	if err := validateMessage(msg, false); err != nil {
+		return err
+	}
+
+	key, ok := writerKey(client)
+	if !ok {
+		return ErrNotSubscribed
+	}
+
+	if runErr := j.run(ctx, func() {
+		done, ok := j.writers[key]
+		if !ok {
+			err = ErrNotSubscribed
+			return
+		}
+		if b, ok := j.backfills[done]; ok {
+			if !b.drainDeadline.IsZero() {
+				// The subscription's context is done and only the queued messages are still sent.
+				err = ErrNotSubscribed
+				return
+			}
+
+			b.queued = append(b.queued, msg)
+			return
+		}
+
+		s := j.subscribers[done]
+		if j.SubscriberBufferSize > 0 {
+			err = j.enqueue(s, msg)
+		} else {
+			err = s.writer.sendAndFlush(msg)
+		}
+		if err != nil {
+			j.removeSubscriber(done, err)
+		}
+	}); runErr != nil {
+		return runErr
+	}
+
+	return err
+}
+
+var (
+	_ TopicReplacer   = (*Joe)(nil)
+	_ TopicUpdater    = (*Joe)(nil)
+	_ PublishReporter = (*Joe)(nil)
+	_ TopicLister     = (*Joe)(nil)
+	_ ProviderStats   = (*Joe)(nil)
+	_ ReplayInspector = (*Joe)(nil)
+	_ Pinger          = (*Joe)(nil)
+	_ UnicastSender   = (*Joe)(nil)
+)
+
+// run executes the task on Joe's event loop and waits for it to finish.
+// The context can stop only the wait for the task to be started – once started, the task is awaited.
+func (j *Joe) run(ctx context.Context, task func()) error {
+	j.init()
+
+	done := make(chan struct{})
+
+	select {
+	case j.tasks <- func() { task(); close(done) }:
 	case <-j.done:
 		return ErrProviderClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-j.closed:
+		select {
+		case <-done:
+			return nil
+		default:
+			// The task panicked and the event loop stopped.
+			return ErrProviderClosed
+		}
 	}
 }
 
 // Stop signals Joe to close all subscribers and stop receiving messages.
-// It returns when all the subscribers are closed.
+// It returns when all the subscribers are closed. If InterruptibleWrites is set, subscribers whose
+// writes are blocked are abandoned, so a client that never returns from Send or Flush doesn't block Shutdown.
 //
 // Further calls to Stop will return ErrProviderClosed.
 func (j *Joe) Shutdown(ctx context.Context) (err error) {
@@ -193,103 +952,662 @@ func (j *Joe) Shutdown(ctx context.Context) (err error) {
 	return
 }
 
-func (j *Joe) topic(identifier string) subscribers {
-	if _, ok := j.topics[identifier]; !ok {
-		j.topics[identifier] = subscribers{}
+// JoeStats is a snapshot of Joe's subscriptions.
+type JoeStats struct {
+	// The number of unique subscribers (connections) currently registered.
+	Subscribers int
+	// The number of topic registrations. A subscriber which is subscribed to
+	// multiple topics is counted once for each of them.
+	TopicRegistrations int
+	// The number of subscribers whose replay is queued. See ReplayBudget.
+	QueuedReplays int
+	// The number of messages dropped since Joe started because the subscribers' queues were full.
+	// See OverflowPolicy.
+	DroppedMessages int
+}
+
+// Stats returns the current subscription statistics. The values are read independently,
+// so they may reflect slightly different moments in time if Joe is concurrently
+// subscribing or unsubscribing clients.
+func (j *Joe) Stats() JoeStats {
+	return JoeStats{
+		Subscribers:        int(j.subscriberCount.Load()),
+		TopicRegistrations: int(j.registrationCount.Load()),
+		QueuedReplays:      int(j.queuedReplays.Load()),
+		DroppedMessages:    int(j.droppedMessages.Load()),
 	}
-	return j.topics[identifier]
 }
 
-func (j *Joe) removeSubscriber(sub subscriber) {
-	for _, subs := range j.topics {
-		delete(subs, sub)
+// Totals returns the current number of subscribers and of their topic registrations, like Stats.
+// Together with Topics and SubscriberCount, it implements the ProviderStats interface.
+func (j *Joe) Totals() ProviderTotals {
+	stats := j.Stats()
+	return ProviderTotals{Subscribers: stats.Subscribers, TopicRegistrations: stats.TopicRegistrations}
+}
+
+// writerKey returns the key used to identify the subscription's client
+// and whether the client can be identified at all.
+func writerKey(w MessageWriter) (MessageWriter, bool) {
+	if w == nil || reflect.TypeOf(w).Kind() != reflect.Pointer {
+		return nil, false
+	}
+	return w, true
+}
+
+// handleDuplicate checks if the given subscription's client is already subscribed and
+// handles the subscription according to the configured policy. It returns true if the
+// subscription was a duplicate.
+func (j *Joe) handleDuplicate(sub subscription) bool {
+	key, ok := writerKey(sub.Client)
+	if !ok {
+		return false
+	}
+	existing, ok := j.writers[key]
+	if !ok {
+		return false
 	}
 
+	switch j.OnDuplicateSubscription {
+	case MergeDuplicateSubscriptions:
+		// The topics the client already has are not checked, so only the added ones can be full.
+		if err := j.checkTopicLimits(existing, sub.Topics); err != nil {
+			closeSubscriber(sub.done, err)
+			return true
+		}
+
+		s := j.subscribers[existing]
+		j.registerTopics(s, sub.Topics)
+		s.merged = append(s.merged, sub.done)
+		j.merged[sub.done] = existing
+	default:
+		sub.done <- ErrAlreadySubscribed
+		close(sub.done)
+	}
+
+	return true
+}
+
+// registerTopics adds the subscriber to the given topics, if it isn't already registered to them.
+func (j *Joe) registerTopics(sub *subscription, topics []string) {
+	n := len(sub.Topics)
+	for _, topic := range topics {
+		subs := j.topic(topic)
+		if _, ok := subs[sub.done]; ok {
+			continue
+		}
+
+		subs[sub.done] = sub.writer
+		sub.Topics = append(sub.Topics, topic)
+		if j.idleTopics != nil {
+			delete(j.idleTopics, topic)
+		}
+	}
+
+	j.registrationCount.Add(int64(len(sub.Topics) - n))
+}
+
+func (j *Joe) addSubscriber(sub subscription) {
+	topics := sub.Topics
+	sub.Topics = make([]string, 0, len(topics))
+
+	s := &sub
+	j.registerTopics(s, topics)
+	j.subscribers[sub.done] = s
+	if key, ok := writerKey(sub.Client); ok {
+		j.writers[key] = sub.done
+	}
+
+	j.subscriberCount.Add(1)
+}
+
+// removeSubscriber removes the subscriber and any subscriptions merged into it,
+// sending them the given error, if it is non-nil.
+func (j *Joe) removeSubscriber(sub subscriber, err error) {
+	if primary, ok := j.merged[sub]; ok {
+		s := j.subscribers[primary]
+		for i := range s.merged {
+			if s.merged[i] == sub {
+				s.merged = append(s.merged[:i], s.merged[i+1:]...)
+				break
+			}
+		}
+		delete(j.merged, sub)
+		closeSubscriber(sub, err)
+		return
+	}
+
+	s, ok := j.subscribers[sub]
+	if !ok {
+		// The subscriber was already removed, for example after a sending error.
+		return
+	}
+
+	j.unregisterTopics(s)
+
+	delete(j.subscribers, sub)
+	if b, ok := j.backfills[sub]; ok {
+		if b.pending {
+			j.dequeueReplay(sub)
+		} else {
+			// The backfill still uses the client, so the subscriber is closed after it finishes.
+			b.removed, b.err = true, err
+		}
+	}
+	if key, ok := writerKey(s.Client); ok {
+		delete(j.writers, key)
+	}
+
+	j.subscriberCount.Add(-1)
+
+	for _, m := range s.merged {
+		delete(j.merged, m)
+		closeSubscriber(m, err)
+	}
+
+	if _, ok := j.backfills[sub]; ok {
+		return
+	}
+	if s.queue != nil {
+		s.queue.close(sub, err)
+	} else {
+		closeSubscriber(sub, err)
+	}
+}
+
+// unregisterTopics removes the subscriber from all its topics, so it receives no more messages.
+func (j *Joe) unregisterTopics(s *subscription) {
+	for _, topic := range s.Topics {
+		j.removeFromTopic(topic, s.done)
+	}
+
+	j.registrationCount.Add(-int64(len(s.Topics)))
+	s.Topics = nil
+}
+
+// unsubscribe removes a subscriber whose context is done. If draining is enabled,
+// the messages already queued for the subscriber are sent before it is closed.
+func (j *Joe) unsubscribe(sub subscriber) {
+	if b, ok := j.backfills[sub]; ok && j.DrainTimeout > 0 && !b.removed && !b.pending {
+		b.drainDeadline = time.Now().Add(j.DrainTimeout)
+		j.unregisterTopics(j.subscribers[sub])
+		return
+	}
+	if s := j.subscribers[sub]; s != nil && s.queue != nil && j.DrainTimeout > 0 {
+		s.queue.drainDeadline = time.Now().Add(j.DrainTimeout)
+	}
+
+	j.removeSubscriber(sub, nil)
+}
+
+func closeSubscriber(sub subscriber, err error) {
+	var interrupted *interruptedWriteError
+	if errors.As(err, &interrupted) {
+		// The client is used until the interrupted write returns, so Subscribe must not return before.
+		go func() {
+			<-interrupted.finished
+			closeSubscriber(sub, interrupted.err)
+		}()
+		return
+	}
+
+	if err != nil {
+		sub <- err
+	}
 	close(sub)
 }
 
-func (j *Joe) start(replay ReplayProvider, gcFn func() error, gcSignal <-chan time.Time, stopGCSignal func()) {
+// interruptibleWriter stops writing to the MessageWriter it wraps when Joe is shut down or, for
+// a drained subscriber, when the drain timeout is exceeded. If it is interruptible, it runs the operations
+// on separate goroutines, so Joe's event loop also stops waiting for them: a client whose writes block
+// forever can't stop Joe from shutting down, as it is abandoned and the subscriber is closed after
+// the write returns. Otherwise, the operations run inline, which is cheaper.
+type interruptibleWriter struct {
+	MessageWriter
+	// done is Joe's done channel.
+	done <-chan struct{}
+	// drainDone is closed when the drain timeout is exceeded, if the subscriber is drained.
+	drainDone <-chan struct{}
+	// interruptible is set if the operations run on separate goroutines – see Joe.InterruptibleWrites.
+	interruptible bool
+}
+
+// interruptedWriteError is returned by an interruptibleWriter if it stopped waiting for a write.
+type interruptedWriteError struct {
+	err error
+	// finished is closed when the interrupted write returns.
+	finished <-chan struct{}
+}
+
+func (e *interruptedWriteError) Error() string { return e.err.Error() }
+func (e *interruptedWriteError) Unwrap() error { return e.err }
+
+func (w interruptibleWriter) Send(m *Message) error {
+	return w.run(func() error { return w.MessageWriter.Send(m) })
+}
+
+func (w interruptibleWriter) Flush() error {
+	return w.run(w.MessageWriter.Flush)
+}
+
+// sendAndFlush sends the message and flushes the client, using a single goroutine if it is interruptible.
+func (w interruptibleWriter) sendAndFlush(m *Message) error {
+	return w.run(func() error {
+		if err := w.MessageWriter.Send(m); err != nil {
+			return err
+		}
+		return w.MessageWriter.Flush()
+	})
+}
+
+func (w interruptibleWriter) run(op func() error) error {
+	// Inline writes are never interrupted, so the messages accepted before shutdown are flushed.
+	if !w.interruptible {
+		return op()
+	}
+
+	select {
+	case <-w.done:
+		return ErrProviderClosed
+	case <-w.drainDone:
+		return errDrainTimeout
+	default:
+	}
+
+	var err error
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		err = op()
+	}()
+
+	select {
+	case <-finished:
+		return err
+	case <-w.done:
+		return &interruptedWriteError{err: ErrProviderClosed, finished: finished}
+	case <-w.drainDone:
+		return &interruptedWriteError{err: errDrainTimeout, finished: finished}
+	}
+}
+
+// markIdle records the current time as the last activity of a topic without subscribers.
+func (j *Joe) markIdle(topic string) {
+	if j.idleTopics != nil {
+		j.idleTopics[topic] = time.Now()
+	}
+}
+
+// dropIdleTopics removes from the replay provider the topics which are idle for long enough.
+func (j *Joe) dropIdleTopics() error {
+	deadline := time.Now().Add(-j.DropIdleTopicsAfter)
+
+	for topic, lastActive := range j.idleTopics {
+		if lastActive.After(deadline) {
+			continue
+		}
+		if j.subscribedIndirectly(topic) {
+			// The topic has subscribers, through its ancestors or the patterns it matches.
+			j.idleTopics[topic] = time.Now()
+			continue
+		}
+
+		delete(j.idleTopics, topic)
+		if err := j.topicCleanup.DropTopic(topic); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (j *Joe) start(gcFn func() error, gcSignal <-chan time.Time, stopGCSignal func(), cleanupSignal <-chan time.Time, stopCleanupSignal func()) {
 	defer close(j.closed)
 	// defer closing all subscribers instead of closing them when done is closed
 	// so in case of a panic subscribers won't block the request goroutines forever.
 	defer j.closeSubscribers()
 	defer stopGCSignal()
+	defer stopCleanupSignal()
+	defer func() {
+		if j.replayTimer != nil {
+			j.replayTimer.Stop()
+		}
+	}()
 
 	for {
 		select {
 		case msg := <-j.message:
-			toDispatch := replay.Put(msg.message, msg.topics)
-			seen := map[subscriber]struct{}{}
-
-			for _, topic := range msg.topics {
-				for done, c := range j.topics[topic] {
-					if _, ok := seen[done]; ok {
-						continue
-					}
-
-					err := c.Send(toDispatch)
-					if err == nil {
-						err = c.Flush()
-					}
-
-					if err != nil {
-						done <- err
-						j.removeSubscriber(done)
-					} else {
-						seen[done] = struct{}{}
-					}
-				}
-			}
+			j.dispatch(msg)
 		case sub := <-j.subscription:
-			if err := replay.Replay(sub.Subscription); err != nil {
-				sub.done <- err
-				close(sub.done)
-				continue
-			}
-
-			for _, topic := range sub.Topics {
-				j.topic(topic)[sub.done] = sub.Client
-			}
+			j.subscribe(sub)
 		case sub := <-j.unsubscription:
-			j.removeSubscriber(sub)
+			j.unsubscribe(sub)
+		case res := <-j.backfilled:
+			j.finishBackfill(res)
+			j.startQueuedReplays()
+		case <-j.replayWake:
+			j.replayTimer, j.replayWake = nil, nil
+			j.startQueuedReplays()
+		case task := <-j.tasks:
+			task()
 		case <-gcSignal:
-			if err := gcFn(); err != nil {
+			if err := gcFn(); err != nil && (j.OnReplayGCError == nil || !j.OnReplayGCError(err)) {
 				stopGCSignal()
 			}
+		case <-cleanupSignal:
+			if err := j.dropIdleTopics(); err != nil {
+				stopCleanupSignal()
+			}
 		case <-j.done:
 			return
 		}
 	}
 }
 
-func (j *Joe) closeSubscribers() {
+// dispatch puts the message in the replay provider and sends it to its subscribers.
+func (j *Joe) dispatch(msg messageWithTopics) PublishResult {
+	if j.ReleaseMessages {
+		published := msg.message
+		if j.ReplayProvider != nil || j.SubscriberBufferSize > 0 || len(j.backfills) > 0 {
+			msg.message = published.detach()
+			ReleaseMessage(published)
+		} else {
+			// Registered first, so the message is released after OnDispatch is called.
+			defer ReleaseMessage(published)
+		}
+	}
+
+	res := PublishResult{Topics: make(map[string]int, len(msg.topics))}
+	for _, topic := range msg.topics {
+		res.Topics[topic] = 0
+	}
+
+	msg = j.stampSequences(msg)
+
+	toDispatch, ok := j.put(msg)
+	if !ok {
+		return res
+	}
+	if j.subscriberCount.Load() > 1 && !toDispatch.raw.matches(toDispatch) {
+		// The message is encoded once, instead of once for each subscriber.
+		toDispatch = toDispatch.Encode().Message
+	}
+	if j.OnPublish != nil {
+		j.OnPublish(toDispatch, msg.topics)
+	}
+
+	defer func() {
+		j.lastDispatch.Store(time.Now().UnixNano())
+		if j.OnDispatch != nil {
+			j.OnDispatch(toDispatch, res)
+		}
+	}()
+
 	seen := map[subscriber]struct{}{}
 
-	for _, subs := range j.topics {
-		for sub := range subs {
-			if _, ok := seen[sub]; ok {
+	for _, topic := range msg.topics {
+		subscribed := j.forEachSubscribers(topic, func(subs subscribers) {
+			delivered := j.deliver(seen, subs, topic, toDispatch)
+			res.Topics[topic] += delivered
+			res.Subscribers += delivered
+		})
+
+		if !subscribed {
+			j.markIdle(topic)
+		}
+	}
+
+	return res
+}
+
+// deliver sends the message, published to the given topic, to the subscribers which weren't sent it yet.
+// It returns the number of subscribers the message was delivered to.
+func (j *Joe) deliver(seen map[subscriber]struct{}, subs subscribers, topic string, toDispatch *Message) (delivered int) {
+	for done, c := range subs {
+		if _, ok := seen[done]; ok {
+			continue
+		}
+
+		seen[done] = struct{}{}
+
+		m := toDispatch
+		if sub := j.subscribers[done]; sub != nil {
+			if !sub.accepts(m) {
 				continue
 			}
+			m = sub.TopicAttribution.annotate(m, topic)
+		}
 
-			seen[sub] = struct{}{}
-			close(sub)
+		if b, ok := j.backfills[done]; ok {
+			b.queued = append(b.queued, m)
+			delivered++
+			continue
 		}
+
+		if err := j.send(j.subscribers[done], c, m); err != nil {
+			j.removeSubscriber(done, err)
+			continue
+		}
+		delivered++
+	}
+
+	return delivered
+}
+
+// send sends a live message to the subscriber, through its queue if SubscriberBufferSize is set.
+func (j *Joe) send(s *subscription, w interruptibleWriter, m *Message) error {
+	if s == nil {
+		return w.sendAndFlush(m)
+	}
+	if j.SubscriberBufferSize > 0 {
+		return j.enqueue(s, m)
+	}
+
+	return j.SlowSubscribers.send(&s.limit, s.Subscription, w, m)
+}
+
+// put puts the message in the replay provider, handling failures according to the replay error policy.
+// It returns the message to dispatch and whether it should be dispatched.
+func (j *Joe) put(msg messageWithTopics) (toDispatch *Message, ok bool) {
+	if provider, isV2 := j.replay.(ReplayProviderWithPutError); isV2 {
+		return j.putWithError(provider, msg)
+	}
+
+	if j.OnReplayError == PanicOnReplayError {
+		return j.replay.Put(msg.message, msg.topics), true
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+
+		err, isErr := r.(error)
+		if !isErr {
+			err = fmt.Errorf("%v", r)
+		}
+
+		toDispatch, ok = j.handlePutError(err, msg)
+	}()
+
+	return j.replay.Put(msg.message, msg.topics), true
+}
+
+// putWithError puts the message in a replay provider which returns errors, retrying as configured
+// by ReplayPutRetries.
+func (j *Joe) putWithError(provider ReplayProviderWithPutError, msg messageWithTopics) (*Message, bool) {
+	var err error
+	for i := 0; i <= j.ReplayPutRetries; i++ {
+		var put *Message
+		if put, err = provider.PutWithError(msg.message, msg.topics); err == nil {
+			return put, true
+		}
+	}
+
+	return j.handlePutError(err, msg)
+}
+
+// handlePutError reports the failure to put the message and returns the message to dispatch
+// and whether it should be dispatched, according to the replay error policy.
+func (j *Joe) handlePutError(err error, msg messageWithTopics) (toDispatch *Message, ok bool) {
+	if j.ReplayErrorHook != nil {
+		j.ReplayErrorHook(&ReplayPutError{Err: err}, msg.message, msg.topics)
+	}
+
+	return msg.message, j.OnReplayError != DropOnReplayError
+}
+
+func (j *Joe) subscribe(sub subscription) {
+	if j.handleDuplicate(sub) {
+		return
+	}
+
+	if err := j.checkTopicLimits(sub.done, sub.Topics); err != nil {
+		closeSubscriber(sub.done, err)
+		return
+	}
+
+	if sub.flushOnAccept {
+		if err := sub.writer.Flush(); err != nil {
+			closeSubscriber(sub.done, err)
+			return
+		}
+	}
+
+	if sub.snapshot != nil {
+		err := sub.snapshot(sub.writer)
+		if err == nil {
+			err = sub.writer.Flush()
+		}
+		if err != nil {
+			closeSubscriber(sub.done, err)
+			return
+		}
+
+		j.addSubscriber(sub)
+		return
+	}
+
+	if j.mustQueueReplay() {
+		j.queueReplay(sub)
+		return
+	}
+
+	if j.Backfill != nil {
+		j.startBackfill(sub)
+		return
+	}
+
+	replaySub := sub.Subscription
+	replaySub.Client = sub.writer
+
+	if err := j.replayTo(sub.ctx, replaySub); err != nil {
+		closeSubscriber(sub.done, err)
+		return
+	}
+
+	j.addSubscriber(sub)
+}
+
+// replayTo replays the events to the subscription, reporting the replay if the subscription requests it.
+// The replay stops when the context is done or after the ReplayTimeout, if the replay provider implements
+// ReplayProviderWithContext. A partial replay is not an error: it is reported if the context is not done,
+// as the subscriber then continues to receive the new messages. Subscriptions whose context is already done
+// are replayed all the events, as they are used only to retrieve the events to replay.
+func (j *Joe) replayTo(ctx context.Context, sub Subscription) error {
+	replayCtx := ctx
+	if ctx.Err() != nil {
+		replayCtx = context.Background()
+	}
+	if j.ReplayTimeout > 0 {
+		var cancel context.CancelFunc
+		replayCtx, cancel = context.WithTimeout(replayCtx, j.ReplayTimeout)
+		defer cancel()
+	}
+
+	var counter *countingWriter
+	if sub.onReplayDone != nil || j.replayBudget.EventsPerSecond > 0 {
+		counter = &countingWriter{MessageWriter: sub.Client}
+		sub.Client = counter
+	}
+
+	start := time.Now()
+
+	var err error
+	if r, ok := j.replay.(ReplayProviderWithContext); ok {
+		err = r.ReplayContext(replayCtx, sub)
+	} else {
+		err = j.replay.Replay(sub)
+	}
+
+	if counter != nil {
+		j.chargeReplay(counter.count)
+	}
+
+	var partial *PartialReplayError
+	if errors.As(err, &partial) {
+		if ctx.Err() == nil && sub.onPartialReplay != nil {
+			sub.onPartialReplay(err)
+		}
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if sub.onReplayDone != nil {
+		sub.onReplayDone(time.Since(start), counter.count)
+	}
+
+	return nil
+}
+
+// countingWriter counts the messages sent successfully.
+type countingWriter struct {
+	MessageWriter
+	count int
+}
+
+func (c *countingWriter) Send(m *Message) error {
+	if err := c.MessageWriter.Send(m); err != nil {
+		return err
+	}
+	c.count++
+	return nil
+}
+
+func (j *Joe) closeSubscribers() {
+	for sub := range j.subscribers {
+		j.removeSubscriber(sub, ErrProviderClosed)
 	}
 }
 
 func (j *Joe) init() {
 	j.initDone.Do(func() {
+		if err := validateAliases(j.Aliases); err != nil {
+			panic(err)
+		}
+
 		j.message = make(chan messageWithTopics)
 		j.subscription = make(chan subscription)
 		j.unsubscription = make(chan subscriber)
 		j.done = make(chan struct{})
 		j.closed = make(chan struct{})
-		j.topics = map[string]subscribers{}
+		j.patterns = map[string]subscribers{}
+		j.subscribers = map[subscriber]*subscription{}
+		j.writers = map[MessageWriter]subscriber{}
+		j.merged = map[subscriber]subscriber{}
+		j.backfills = map[subscriber]*backfill{}
+		j.sequences = map[string]uint64{}
+		j.backfilled = make(chan backfillResult)
+		j.tasks = make(chan func())
+		j.replayBudget = j.ReplayBudget
 
 		replay := j.ReplayProvider
 		if replay == nil {
 			replay = noopReplayProvider{}
 		}
+		j.replay = replay
 
 		var gcFn func() error
 		replayGCInterval := j.ReplayGCInterval
@@ -303,10 +1621,67 @@ func (j *Joe) init() {
 
 		gc, stopGCTicker := ticker(replayGCInterval)
 
-		go j.start(replay, gcFn, gc, stopGCTicker)
+		cleanupInterval := j.DropIdleTopicsAfter
+		if cleanup, ok := replay.(ReplayProviderWithTopicCleanup); ok && cleanupInterval > 0 {
+			j.topicCleanup = cleanup
+			j.idleTopics = map[string]time.Time{}
+		} else {
+			cleanupInterval = 0
+		}
+
+		cleanup, stopCleanupTicker := ticker(cleanupInterval)
+
+		go j.start(gcFn, gc, stopGCTicker, cleanup, stopCleanupTicker)
 	})
 }
 
+// validateAliases checks that no alias stands for another alias or for itself.
+func validateAliases(aliases map[string]string) error {
+	for alias, topic := range aliases {
+		if alias == topic {
+			return fmt.Errorf("go-sse.server: topic alias %q stands for itself", alias)
+		}
+		if _, ok := aliases[topic]; ok {
+			return fmt.Errorf("go-sse.server: topic alias %q stands for alias %q", alias, topic)
+		}
+	}
+	return nil
+}
+
+// resolveTopics returns the given topics with the aliases replaced by the topics they stand for.
+// The given slice is not modified and it is returned as is if it contains no aliases.
+func (j *Joe) resolveTopics(topics []string) []string {
+	if len(j.Aliases) == 0 {
+		return topics
+	}
+
+	var resolved []string
+	for i, t := range topics {
+		topic, ok := j.Aliases[t]
+		if !ok {
+			if resolved != nil {
+				resolved = append(resolved, t)
+			}
+			continue
+		}
+		if resolved == nil {
+			resolved = append(make([]string, 0, len(topics)), topics[:i]...)
+		}
+		resolved = append(resolved, topic)
+	}
+
+	if resolved == nil {
+		return topics
+	}
+	return resolved
+}
+
+// resolveAliases returns the subscription with the aliases in its topics replaced by the topics they stand for.
+func (j *Joe) resolveAliases(sub Subscription) Subscription {
+	sub.Topics = j.resolveTopics(sub.Topics)
+	return sub
+}
+
 // ticker creates a time.Ticker, if duration is positive, and returns its channel and stop function.
 // If the duration is negative, it returns a nil channel and a noop function.
 func ticker(duration time.Duration) (ticks <-chan time.Time, stop func()) {
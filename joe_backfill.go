@@ -0,0 +1,180 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// BackfillFunc is the type of the function Joe uses to send events from an external source,
+// for example a database with the full history of events, to a new subscriber.
+//
+// The function must send the events to the subscription's client, flush them and return the ID of
+// the last event sent. After it returns, Joe replays to the subscriber the events that come after the
+// returned ID, if the replay provider has them, and then the subscriber starts receiving live events.
+// If the returned ID is unset, the subscription's LastEventID is used for the replay instead.
+//
+// Events published while the backfill runs are held back and sent after the replay, so none are lost.
+// Those which were already replayed or which were published before or with the event with the
+// returned ID, if the backfill also sent them, are not sent again. This means that the backfill
+// must send events in the order they were published. As the events are held in memory, backfills
+// should be as short as possible.
+//
+// The function is run on a separate goroutine and its context is done when the subscription's context
+// is done. Joe doesn't use the client until the function returns, and Subscribe doesn't return before
+// that. If an error is returned, the subscription is ended and Subscribe returns the error.
+type BackfillFunc func(ctx context.Context, sub Subscription) (resumeFrom EventID, err error)
+
+type (
+	// backfill is the state of a subscriber for which the backfill is running.
+	backfill struct {
+		// drainDeadline is set if the subscription's context is done and the
+		// queued messages must be sent until this deadline. See Joe.DrainTimeout.
+		drainDeadline time.Time
+		err           error
+		// queued holds the messages published while the backfill runs.
+		queued []*Message
+		// removed is set if the subscriber was removed while the backfill was running.
+		removed bool
+		// pending is set if neither the backfill nor the replay were started yet,
+		// as they wait for the replay budget. See ReplayBudget.
+		pending bool
+	}
+
+	backfillResult struct {
+		err        error
+		done       subscriber
+		resumeFrom EventID
+	}
+)
+
+func (j *Joe) startBackfill(sub subscription) {
+	j.addSubscriber(sub)
+	j.backfills[sub.done] = &backfill{}
+	j.runBackfill(sub)
+}
+
+// runBackfill runs the backfill of a registered subscriber on a separate goroutine.
+func (j *Joe) runBackfill(sub subscription) {
+	j.activeReplays++
+
+	go func() {
+		resumeFrom, err := j.Backfill(sub.ctx, sub.Subscription)
+
+		select {
+		case j.backfilled <- backfillResult{done: sub.done, resumeFrom: resumeFrom, err: err}:
+		case <-j.closed:
+			// Joe was stopped while backfilling, so no one else can close the subscriber.
+			closeSubscriber(sub.done, ErrProviderClosed)
+		}
+	}()
+}
+
+// finishBackfill replays the events to a subscriber after its backfill and sends the messages held back meanwhile.
+func (j *Joe) finishBackfill(res backfillResult) {
+	j.activeReplays--
+
+	b := j.backfills[res.done]
+	delete(j.backfills, res.done)
+
+	if b.removed {
+		closeSubscriber(res.done, b.err)
+		return
+	}
+	if res.err != nil {
+		j.removeSubscriber(res.done, res.err)
+		return
+	}
+
+	sub := j.subscribers[res.done]
+	var client MessageWriter = sub.writer
+	replayCtx := sub.ctx
+	if !b.drainDeadline.IsZero() {
+		// The writes are interrupted when the drain timeout is exceeded.
+		ctx, cancel := context.WithDeadline(context.Background(), b.drainDeadline)
+		defer cancel()
+		replayCtx = ctx
+
+		client = interruptibleWriter{
+			MessageWriter: deadlineWriter{MessageWriter: sub.Client, deadline: b.drainDeadline},
+			done:          j.done,
+			drainDone:     ctx.Done(),
+			interruptible: j.InterruptibleWrites,
+		}
+		// The subscription is done, so it must be closed after the queued messages are sent.
+		defer j.removeSubscriber(res.done, nil)
+	}
+
+	replayed := &recordingWriter{MessageWriter: client, ids: map[EventID]struct{}{}}
+
+	replaySub := sub.Subscription
+	replaySub.Client = replayed
+	if res.resumeFrom.IsSet() {
+		replaySub.LastEventID = res.resumeFrom
+	}
+
+	if err := j.replayTo(replayCtx, replaySub); err != nil {
+		j.removeSubscriber(res.done, err)
+		return
+	}
+
+	queued := b.queued
+	if res.resumeFrom.IsSet() {
+		// The messages published before or with the last backfilled one were already sent.
+		for i := range queued {
+			if queued[i].ID == res.resumeFrom {
+				queued = queued[i+1:]
+				break
+			}
+		}
+	}
+
+	sent := false
+	for _, m := range queued {
+		if _, ok := replayed.ids[m.ID]; ok && m.ID.IsSet() {
+			continue
+		}
+		if err := client.Send(m); err != nil {
+			j.removeSubscriber(res.done, err)
+			return
+		}
+		sent = true
+	}
+
+	if sent {
+		if err := client.Flush(); err != nil {
+			j.removeSubscriber(res.done, err)
+		}
+	}
+}
+
+// recordingWriter records the IDs of the messages sent through it.
+type recordingWriter struct {
+	MessageWriter
+	ids map[EventID]struct{}
+}
+
+func (r *recordingWriter) Send(m *Message) error {
+	if err := r.MessageWriter.Send(m); err != nil {
+		return err
+	}
+	r.ids[m.ID] = struct{}{}
+	return nil
+}
+
+// errDrainTimeout is returned by a deadlineWriter after its deadline.
+var errDrainTimeout = errors.New("go-sse.server: drain timeout exceeded")
+
+// deadlineWriter is a MessageWriter which fails once its deadline is exceeded.
+// Messages already buffered are still flushed.
+type deadlineWriter struct {
+	MessageWriter
+	deadline time.Time
+}
+
+func (d deadlineWriter) Send(m *Message) error {
+	if !time.Now().Before(d.deadline) {
+		return errDrainTimeout
+	}
+	return d.MessageWriter.Send(m)
+}
@@ -0,0 +1,152 @@
+package sse
+
+import (
+	"context"
+	"time"
+)
+
+// ReplayBudget limits the work Joe does to replay events to new subscribers, so that when many clients
+// reconnect at once their replays don't delay the delivery of live messages for too long.
+//
+// The subscriptions which arrive when the budget is exhausted are queued and their replays are started
+// in the order they arrived, once the budget allows it. Queued subscribers are registered right away and
+// the messages published while they wait are held back and sent after the replay, like for backfills,
+// so none are lost – see BackfillFunc. If a queued subscriber leaves, it is removed without being replayed
+// any events. The number of queued subscribers is reported by Joe's Stats method.
+//
+// The budget is used only if Joe has a replay provider or a backfill function. The zero value means no limits.
+type ReplayBudget struct {
+	// MaxConcurrent is the maximum number of subscribers which are replayed events at the same time.
+	// The replays run on Joe's event loop, so they are never concurrent, but backfills run on
+	// separate goroutines: a subscriber takes a slot from the start of its backfill to the end of its replay.
+	// If it is not positive, the number of concurrent replays is not limited.
+	MaxConcurrent int
+	// EventsPerSecond is the maximum average number of events replayed per second, across all subscribers.
+	// Up to a second's worth of events can be replayed at once, after which the replays wait for the budget
+	// to be refilled: a replay is started only if at least one event can be replayed, and the events it replays
+	// over the budget are subtracted from the budget of the following replays. Use the subscriptions'
+	// MaxReplayed limit or Joe's ReplayTimeout to bound the size of a single replay.
+	// If it is not positive, the number of replayed events is not limited.
+	EventsPerSecond int
+}
+
+// SetReplayBudget changes the replay budget while Joe is running. The queued replays are started
+// if the new budget allows it. The context can be used to stop waiting for Joe to be available.
+// See ReplayBudget for more information.
+func (j *Joe) SetReplayBudget(ctx context.Context, budget ReplayBudget) error {
+	return j.run(ctx, func() {
+		j.replayBudget = budget
+		if rate := float64(budget.EventsPerSecond); j.replayTokens > rate {
+			j.replayTokens = rate
+		}
+
+		j.startQueuedReplays()
+	})
+}
+
+// mustQueueReplay tells whether the replay to a new subscriber must be queued.
+func (j *Joe) mustQueueReplay() bool {
+	if j.ReplayProvider == nil && j.Backfill == nil {
+		return false
+	}
+
+	return len(j.replayQueue) > 0 || !j.replayAllowed()
+}
+
+// replayAllowed tells whether the budget allows starting a replay.
+func (j *Joe) replayAllowed() bool {
+	if j.replayBudget.MaxConcurrent > 0 && j.activeReplays >= j.replayBudget.MaxConcurrent {
+		return false
+	}
+	if j.replayBudget.EventsPerSecond <= 0 {
+		return true
+	}
+
+	j.refillReplayTokens()
+
+	return j.replayTokens >= 1
+}
+
+// refillReplayTokens adds to the budget the events that can be replayed since it was last refilled.
+func (j *Joe) refillReplayTokens() {
+	now := time.Now()
+	rate := float64(j.replayBudget.EventsPerSecond)
+
+	if j.replayRefilled.IsZero() {
+		j.replayTokens = rate
+	} else if j.replayTokens += now.Sub(j.replayRefilled).Seconds() * rate; j.replayTokens > rate {
+		j.replayTokens = rate
+	}
+
+	j.replayRefilled = now
+}
+
+// chargeReplay subtracts the replayed events from the budget.
+func (j *Joe) chargeReplay(events int) {
+	if j.replayBudget.EventsPerSecond > 0 {
+		j.replayTokens -= float64(events)
+	}
+}
+
+// queueReplay registers the subscriber and queues its replay until the budget allows it.
+func (j *Joe) queueReplay(sub subscription) {
+	j.addSubscriber(sub)
+	j.backfills[sub.done] = &backfill{pending: true}
+	j.replayQueue = append(j.replayQueue, sub.done)
+	j.queuedReplays.Add(1)
+
+	j.scheduleQueuedReplays()
+}
+
+// dequeueReplay removes a subscriber whose replay is queued from the queue.
+func (j *Joe) dequeueReplay(sub subscriber) {
+	delete(j.backfills, sub)
+	for i, queued := range j.replayQueue {
+		if queued == sub {
+			j.replayQueue = append(j.replayQueue[:i], j.replayQueue[i+1:]...)
+			j.queuedReplays.Add(-1)
+			break
+		}
+	}
+}
+
+// startQueuedReplays starts the queued replays the budget allows.
+func (j *Joe) startQueuedReplays() {
+	for len(j.replayQueue) > 0 && j.replayAllowed() {
+		done := j.replayQueue[0]
+		j.replayQueue[0] = nil
+		j.replayQueue = j.replayQueue[1:]
+		j.queuedReplays.Add(-1)
+
+		j.backfills[done].pending = false
+		if j.Backfill != nil {
+			j.runBackfill(*j.subscribers[done])
+		} else {
+			j.activeReplays++
+			j.finishBackfill(backfillResult{done: done})
+		}
+	}
+
+	j.scheduleQueuedReplays()
+}
+
+// scheduleQueuedReplays arms the timer which starts the queued replays when the budget is refilled.
+// Replays waiting for a slot are started when another replay ends instead.
+func (j *Joe) scheduleQueuedReplays() {
+	if j.replayTimer != nil {
+		j.replayTimer.Stop()
+		j.replayTimer, j.replayWake = nil, nil
+	}
+
+	rate := float64(j.replayBudget.EventsPerSecond)
+	if len(j.replayQueue) == 0 || rate <= 0 {
+		return
+	}
+	if j.refillReplayTokens(); j.replayTokens >= 1 {
+		return
+	}
+
+	wait := time.Duration((1 - j.replayTokens) / rate * float64(time.Second))
+	j.replayTimer = time.NewTimer(wait)
+	j.replayWake = j.replayTimer.C
+}
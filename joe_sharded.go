@@ -0,0 +1,206 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"runtime"
+	"sync"
+)
+
+// ErrCrossShard is returned by ShardedJoe when a subscription has topics which belong to different shards.
+var ErrCrossShard = errors.New("go-sse.server: topics belong to different shards")
+
+// ShardedJoe is a provider which partitions the topics across multiple Joe instances, the shards, so that
+// publishing scales with the number of cores instead of being serialized through a single event loop.
+// Each topic belongs to a single shard, chosen by consistently hashing the topic's shard key, which is
+// the topic itself by default.
+//
+// Each subscription is handled by a single shard, so ShardedJoe keeps the guarantees of Joe: subscribers
+// receive each message once, in the order it was published, and the replay provider of the shard has all
+// the messages of the subscribed topics. For this reason, the topics of a subscription must belong to the
+// same shard, otherwise ErrCrossShard is returned. Use the ShardKey field to keep the topics which are
+// subscribed to together on the same shard – all the topics of a tenant, for example.
+//
+// A message published to topics of different shards is published to each of these shards, with the topics
+// it owns, so it can be used like a Joe to publish to multiple topics. The publishing is not atomic: if it
+// fails for a shard, the message is still published to the others.
+//
+// The shards must not use HierarchicalTopics or WildcardTopics, as the topics related by them may belong
+// to different shards. Aliases must be configured identically on all the shards and the aliases are hashed,
+// not the topics they stand for. The replay providers are per shard, so automatically set IDs are unique
+// only within a shard.
+//
+// The zero value is ready to use. The fields must not be modified after the ShardedJoe is used.
+type ShardedJoe struct {
+	// Shards is the number of Joe instances. Defaults to runtime.GOMAXPROCS(0).
+	Shards int
+	// New optionally creates the Joe of each shard, so the shards can be configured – with a replay
+	// provider, for example. It must return a new Joe each time it is called. By default, the shards are
+	// zero value Joe instances.
+	New func(shard int) *Joe
+	// ShardKey optionally returns the key which is hashed to choose the shard of the topic.
+	// Topics with the same key belong to the same shard. Defaults to the topic itself.
+	ShardKey func(topic string) string
+
+	shards   []*Joe
+	initDone sync.Once
+}
+
+func (s *ShardedJoe) init() {
+	s.initDone.Do(func() {
+		count := s.Shards
+		if count <= 0 {
+			count = runtime.GOMAXPROCS(0)
+		}
+
+		s.shards = make([]*Joe, count)
+		for i := range s.shards {
+			if s.New != nil {
+				s.shards[i] = s.New(i)
+			} else {
+				s.shards[i] = &Joe{}
+			}
+		}
+	})
+}
+
+// Shard returns the index of the shard the topic belongs to.
+func (s *ShardedJoe) Shard(topic string) int {
+	s.init()
+
+	key := topic
+	if s.ShardKey != nil {
+		key = s.ShardKey(topic)
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+
+	return jumpHash(h.Sum64(), len(s.shards))
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach: it maps the key to one of the given number
+// of buckets so that only the keys of one in n buckets move when the number of buckets grows to n.
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
+
+// shardOf returns the shard all the topics belong to, or ErrCrossShard if they belong to different ones.
+func (s *ShardedJoe) shardOf(topics []string) (*Joe, error) {
+	if len(topics) == 0 {
+		return nil, ErrNoTopic
+	}
+
+	shard := s.Shard(topics[0])
+	for _, topic := range topics[1:] {
+		if s.Shard(topic) != shard {
+			return nil, ErrCrossShard
+		}
+	}
+
+	return s.shards[shard], nil
+}
+
+// Subscribe subscribes to the shard of the subscription's topics.
+func (s *ShardedJoe) Subscribe(ctx context.Context, sub Subscription) error {
+	s.init()
+
+	j, err := s.shardOf(sub.Topics)
+	if err != nil {
+		return err
+	}
+
+	return j.Subscribe(ctx, sub)
+}
+
+// Publish publishes the message to the shards of the given topics. The message is published to all
+// the shards even if publishing to one of them fails and the first error is returned.
+func (s *ShardedJoe) Publish(msg *Message, topics []string) error {
+	s.init()
+
+	if msg == nil {
+		return ErrNilMessage
+	}
+	if len(topics) == 0 {
+		return ErrNoTopic
+	}
+
+	groups := s.byShard(topics)
+	if len(groups) == 1 {
+		return s.shards[groups[0].shard].Publish(msg, topics)
+	}
+
+	// The shards may release the message once it is dispatched – see Joe.ReleaseMessages –, so the other
+	// shards are given copies which don't share its data, made before it is published.
+	msgs := make([]*Message, len(groups))
+	msgs[0] = msg
+	for i := 1; i < len(groups); i++ {
+		msgs[i] = msg.detach()
+	}
+
+	var err error
+	for i, g := range groups {
+		pubErr := s.shards[g.shard].Publish(msgs[i], g.topics)
+		if err == nil {
+			err = pubErr
+		}
+	}
+
+	return err
+}
+
+// shardTopics are the topics of a message which belong to a shard.
+type shardTopics struct {
+	shard  int
+	topics []string
+}
+
+// byShard groups the topics by the shard they belong to, in the order the shards first appear.
+func (s *ShardedJoe) byShard(topics []string) []shardTopics {
+	var groups []shardTopics
+
+outer:
+	for _, topic := range topics {
+		shard := s.Shard(topic)
+		for i := range groups {
+			if groups[i].shard == shard {
+				groups[i].topics = append(groups[i].topics, topic)
+				continue outer
+			}
+		}
+		groups = append(groups, shardTopics{shard: shard, topics: []string{topic}})
+	}
+
+	return groups
+}
+
+// Shutdown shuts down all the shards concurrently and waits for them. All the shards are shut down
+// even if shutting down one of them fails and the first error is returned.
+func (s *ShardedJoe) Shutdown(ctx context.Context) error {
+	s.init()
+
+	errs := make(chan error, len(s.shards))
+	for _, j := range s.shards {
+		go func(j *Joe) { errs <- j.Shutdown(ctx) }(j)
+	}
+
+	var err error
+	for range s.shards {
+		shardErr := <-errs
+		if err == nil {
+			err = shardErr
+		}
+	}
+
+	return err
+}
+
+var _ Provider = (*ShardedJoe)(nil)
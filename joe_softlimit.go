@@ -0,0 +1,134 @@
+package sse
+
+import (
+	"errors"
+	"time"
+)
+
+// SoftLimit configures how Joe handles subscribers which are too slow to receive messages.
+// Instead of disconnecting a slow subscriber right away, Joe warns it first and degrades its service,
+// and disconnects it only if it doesn't recover in time:
+//
+//   - when a write to the subscriber takes longer than MaxWriteDuration, the subscriber is warned:
+//     it is sent the Warning message and it transitions to the SubscriberWarned state;
+//   - while warned, the subscriber receives at most one message per MaxWriteDuration, measured from
+//     the end of the previous write – the other messages are dropped, so the other subscribers
+//     wait less for it;
+//   - if a write takes less than MaxWriteDuration, the subscriber recovers and it is sent all
+//     the messages again, transitioning back to the SubscriberOK state;
+//   - if a write takes longer than MaxWriteDuration after the subscriber was warned for at least
+//     Grace, the subscriber is disconnected with ErrSlowSubscriber, transitioning to the SubscriberDropped state.
+//
+// Replayed and backfilled messages are not taken into account. The zero value disables the limit.
+type SoftLimit struct {
+	// MaxWriteDuration is the maximum duration of sending and flushing a message to a subscriber.
+	// The limit is disabled if it is not positive.
+	MaxWriteDuration time.Duration
+	// Grace is the duration for which a subscriber may stay over the limit before it is disconnected.
+	// If it is 0, the subscriber is disconnected if the first write after it was warned is too slow.
+	Grace time.Duration
+	// Warning is the message sent to subscribers when they are warned. By default, an event of type
+	// WarningEventType is sent. The message must not be modified after Joe is used.
+	Warning *Message
+	// OnStateChange is an optional callback that's called when a subscriber transitions from a state
+	// to another, which can be used to update metrics, for example. It is called on Joe's event loop,
+	// so it must return quickly. If Joe's SubscriberBufferSize is set, it is called on the goroutine
+	// of the subscriber instead, so it may be called concurrently for different subscribers.
+	OnStateChange func(sub Subscription, state SubscriberState)
+}
+
+// SubscriberState is the state of a subscriber, with respect to Joe's SoftLimit.
+type SubscriberState int
+
+// The states of a subscriber.
+const (
+	// SubscriberOK is the state of the subscribers which receive messages fast enough.
+	SubscriberOK SubscriberState = iota
+	// SubscriberWarned is the state of the slow subscribers which were warned and receive
+	// only some of the messages.
+	SubscriberWarned
+	// SubscriberDropped is the state of the subscribers which were disconnected for being too slow.
+	SubscriberDropped
+)
+
+func (s SubscriberState) String() string {
+	switch s {
+	case SubscriberOK:
+		return "ok"
+	case SubscriberWarned:
+		return "warned"
+	case SubscriberDropped:
+		return "dropped"
+	default:
+		return "unknown"
+	}
+}
+
+// WarningEventType is the type of the event Joe sends by default to subscribers that are too slow,
+// before disconnecting them. See SoftLimit for more information.
+const WarningEventType = "sse-warning"
+
+// ErrSlowSubscriber is the error with which Joe ends the subscriptions that stay too slow
+// for longer than allowed, or whose queue overflows with the DisconnectOnOverflow policy.
+// See SoftLimit and OverflowPolicy for more information.
+var ErrSlowSubscriber = errors.New("go-sse.server: subscriber is too slow")
+
+// limitState is the state of a subscriber with respect to the SoftLimit.
+type limitState struct {
+	state     SubscriberState
+	warnedAt  time.Time
+	lastWrite time.Time
+}
+
+func (l *SoftLimit) warning() *Message {
+	if l.Warning != nil {
+		return l.Warning
+	}
+
+	m := &Message{Type: Type(WarningEventType)}
+	m.AppendData("slow subscriber: some messages are dropped until it keeps up")
+	return m
+}
+
+func (l *SoftLimit) setState(limit *limitState, sub Subscription, state SubscriberState) {
+	limit.state = state
+	if l.OnStateChange != nil {
+		l.OnStateChange(sub, state)
+	}
+}
+
+// send sends the message to the subscriber, enforcing the limit.
+func (l *SoftLimit) send(limit *limitState, sub Subscription, w messageSender, m *Message) error {
+	if l.MaxWriteDuration <= 0 {
+		return w.sendAndFlush(m)
+	}
+
+	start := time.Now()
+	if limit.state == SubscriberWarned && start.Sub(limit.lastWrite) < l.MaxWriteDuration {
+		return nil
+	}
+
+	if err := w.sendAndFlush(m); err != nil {
+		return err
+	}
+
+	end := time.Now()
+	limit.lastWrite = end
+
+	slow := end.Sub(start) > l.MaxWriteDuration
+	switch {
+	case !slow && limit.state == SubscriberWarned:
+		l.setState(limit, sub, SubscriberOK)
+	case slow && limit.state == SubscriberWarned && end.Sub(limit.warnedAt) >= l.Grace:
+		l.setState(limit, sub, SubscriberDropped)
+		return ErrSlowSubscriber
+	case slow && limit.state == SubscriberOK:
+		limit.warnedAt = end
+		l.setState(limit, sub, SubscriberWarned)
+		err := w.sendAndFlush(l.warning())
+		limit.lastWrite = time.Now()
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package sse
+
+import "time"
+
+// subscriberQueue holds the messages waiting to be sent to a subscriber by its own goroutine,
+// so a slow subscriber doesn't delay the delivery to the others. See Joe.SubscriberBufferSize.
+type subscriberQueue struct {
+	messages chan *Message
+	// stop is closed when the subscriber is removed, to stop the goroutine.
+	stop chan struct{}
+	// stopped is closed when the goroutine returns, after which the client isn't used anymore.
+	stopped chan struct{}
+	// err is the error which stopped the goroutine, if any. It is set before stopped is closed.
+	err error
+	// drainDeadline is set before stop is closed if the queued messages must be sent until this deadline.
+	// See Joe.DrainTimeout.
+	drainDeadline time.Time
+	// limit is the subscriber's state with respect to Joe's SoftLimit, used only by the goroutine.
+	limit limitState
+}
+
+// OverflowPolicy determines what Joe does when a subscriber can't keep up with the published messages
+// and its queue is full. See Joe.SubscriberBufferSize for more information.
+type OverflowPolicy int
+
+// The available overflow policies.
+const (
+	// BlockOnOverflow makes Joe wait for the subscriber to have room in its queue, which delays
+	// the delivery to all the other subscribers. No messages are lost.
+	BlockOnOverflow OverflowPolicy = iota
+	// DropOldestOnOverflow drops the oldest message in the subscriber's queue to make room for the new one,
+	// so the subscriber receives the most recent messages. Use it for streams where only the latest state matters.
+	DropOldestOnOverflow
+	// DropNewestOnOverflow drops the new message, so the subscriber receives the messages already queued.
+	DropNewestOnOverflow
+	// DisconnectOnOverflow ends the subscription with ErrSlowSubscriber, so the client can reconnect
+	// and resume from its last event ID, if events are replayed.
+	DisconnectOnOverflow
+)
+
+// messageSender is implemented by the writers through which the subscribers are sent the live messages.
+type messageSender interface {
+	sendAndFlush(m *Message) error
+}
+
+// directWriter sends the messages to the client on the calling goroutine.
+type directWriter struct {
+	MessageWriter
+}
+
+func (w directWriter) sendAndFlush(m *Message) error {
+	if err := w.Send(m); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// enqueue queues the message for the subscriber, starting its goroutine on first use. If the queue is full,
+// the subscriber's overflow policy is applied. It returns the error which stopped the goroutine, if it stopped.
+func (j *Joe) enqueue(s *subscription, m *Message) error {
+	if s.queue == nil {
+		s.queue = j.startQueue(s)
+	}
+
+	q := s.queue
+	select {
+	case <-q.stopped:
+		return q.err
+	case q.messages <- m:
+		return nil
+	default:
+	}
+
+	policy := s.OnOverflow
+	if policy == BlockOnOverflow {
+		policy = j.OnSubscriberOverflow
+	}
+
+	switch policy {
+	case DropOldestOnOverflow:
+		// Only the subscriber's goroutine receives from the queue, so there is room afterwards.
+		select {
+		case <-q.messages:
+			j.droppedMessages.Add(1)
+		default:
+		}
+	case DropNewestOnOverflow:
+		j.droppedMessages.Add(1)
+		return nil
+	case DisconnectOnOverflow:
+		return ErrSlowSubscriber
+	}
+
+	select {
+	case q.messages <- m:
+		return nil
+	case <-q.stopped:
+		return q.err
+	case <-j.done:
+		return ErrProviderClosed
+	}
+}
+
+// startQueue starts the goroutine which sends the queued messages to the subscriber. If sending a message
+// fails, the goroutine stops and the subscriber is removed with the error.
+func (j *Joe) startQueue(s *subscription) *subscriberQueue {
+	q := &subscriberQueue{
+		messages: make(chan *Message, j.SubscriberBufferSize),
+		stop:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	// The goroutine must not use the subscription, which is modified on the event loop.
+	info := s.Subscription
+	info.Topics = append([]string(nil), s.Topics...)
+	done, client := s.done, s.Client
+
+	go func() {
+		err := q.run(&j.SlowSubscribers, info, client)
+		if err == nil {
+			return
+		}
+
+		select {
+		case j.tasks <- func() { j.removeSubscriber(done, err) }:
+		case <-j.done:
+		}
+	}()
+
+	return q
+}
+
+// run sends the queued messages until the subscriber is removed or a message fails to be sent.
+func (q *subscriberQueue) run(limit *SoftLimit, sub Subscription, client MessageWriter) (err error) {
+	defer func() {
+		q.err = err
+		close(q.stopped)
+	}()
+
+	w := directWriter{MessageWriter: client}
+
+	for {
+		select {
+		case m := <-q.messages:
+			if err := limit.send(&q.limit, sub, w, m); err != nil {
+				return err
+			}
+		case <-q.stop:
+			q.drain(client)
+			return nil
+		}
+	}
+}
+
+// drain sends the messages left in the queue after the subscriber was removed, until the drain deadline.
+// Write errors end the draining.
+func (q *subscriberQueue) drain(client MessageWriter) {
+	if q.drainDeadline.IsZero() {
+		return
+	}
+
+	w := directWriter{MessageWriter: deadlineWriter{MessageWriter: client, deadline: q.drainDeadline}}
+
+	for {
+		select {
+		case m := <-q.messages:
+			if w.sendAndFlush(m) != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// close stops the goroutine and closes the subscriber once the goroutine doesn't use the client anymore.
+func (q *subscriberQueue) close(sub subscriber, err error) {
+	close(q.stop)
+
+	go func() {
+		<-q.stopped
+		closeSubscriber(sub, err)
+	}()
+}
@@ -4,6 +4,11 @@ import (
 	"context"
 	"errors"
 	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -103,6 +108,44 @@ func TestJoe_Shutdown(t *testing.T) {
 	<-subctx.Done()
 }
 
+func TestJoe_Shutdown_blockedClient(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{InterruptibleWrites: true}
+
+	sending, unblock := make(chan struct{}), make(chan struct{})
+	client := mockClient(func(m *sse.Message) error {
+		if m != nil {
+			close(sending)
+			<-unblock
+		}
+		return nil
+	})
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: client, Topics: []string{sse.DefaultTopic}}) }()
+	<-ctx.waitingOnDone
+
+	require.NoError(t, j.Publish(msg(t, "hello", ""), []string{sse.DefaultTopic}))
+	<-sending
+
+	sctx, scancel := context.WithTimeout(context.Background(), time.Second)
+	defer scancel()
+	require.NoError(t, j.Shutdown(sctx), "shutdown blocked by client")
+
+	select {
+	case err := <-errs:
+		t.Fatalf("subscribe returned while the client is used: %v", err)
+	default:
+	}
+
+	close(unblock)
+	require.ErrorIs(t, <-errs, sse.ErrProviderClosed, "invalid subscribe error")
+}
+
 func subscribe(t testing.TB, p sse.Provider, ctx context.Context, topics ...string) <-chan []*sse.Message { //nolint
 	t.Helper()
 
@@ -151,6 +194,22 @@ func newMockContext(tb testing.TB) (*mockContext, context.CancelFunc) {
 	return &mockContext{Context: ctx, waitingOnDone: make(chan struct{})}, cancel
 }
 
+func TestJoe_ShutdownReason(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{}
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: &ptrClient{}, Topics: []string{sse.DefaultTopic}}) }()
+	<-ctx.waitingOnDone
+
+	require.NoError(t, j.Shutdown(context.Background()))
+	require.ErrorIs(t, <-errs, sse.ErrProviderClosed, "shutdown reason not delivered")
+}
+
 func TestJoe_SubscribePublish(t *testing.T) {
 	t.Parallel()
 
@@ -222,6 +281,8 @@ func TestJoe_errors(t *testing.T) {
 	_ = j.Publish(msg(t, "hello", "0"), []string{sse.DefaultTopic})
 	_ = j.Publish(msg(t, "hello", "1"), []string{sse.DefaultTopic})
 
+	require.ErrorIs(t, j.Publish(nil, []string{sse.DefaultTopic}), sse.ErrNilMessage, "nil message accepted")
+
 	callErr := errors.New("artificial fail")
 
 	var called int
@@ -282,3 +343,1801 @@ func TestJoe_GCInterval(t *testing.T) {
 	require.NoError(t, j.Shutdown(context.Background()))
 	require.Equal(t, expected, rp.callsGC)
 }
+
+func TestJoe_OnReplayGCError(t *testing.T) {
+	t.Parallel()
+
+	errGC := errors.New("gc failed")
+	rp := &mockReplayProvider{errGC: errGC}
+	interval := time.Millisecond * 2
+
+	errs := make(chan error, 3)
+	n := 0
+	j := &sse.Joe{
+		ReplayProvider:   rp,
+		ReplayGCInterval: interval,
+		OnReplayGCError: func(err error) bool {
+			n++
+			errs <- err
+			return n < 3
+		},
+	}
+	// trigger internal initialization, so GC is started.
+	_ = j.Publish(&sse.Message{}, []string{sse.DefaultTopic})
+
+	for i := 0; i < 3; i++ {
+		require.ErrorIs(t, <-errs, errGC, "invalid GC error")
+	}
+
+	time.Sleep(interval * 3)
+	require.NoError(t, j.Shutdown(context.Background()))
+	require.Equal(t, 3, rp.callsGC, "GC run after the callback stopped it")
+}
+
+func TestJoe_TriggerGC(t *testing.T) {
+	t.Parallel()
+
+	unsupported := &sse.Joe{}
+	defer unsupported.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+	require.ErrorIs(t, unsupported.TriggerGC(context.Background()), sse.ErrGCUnsupported, "GC triggered without provider")
+
+	errGC := errors.New("gc failed")
+	rp := &mockReplayProvider{}
+	j := &sse.Joe{ReplayProvider: rp}
+
+	require.NoError(t, j.TriggerGC(context.Background()), "GC failed")
+	rp.errGC = errGC
+	require.ErrorIs(t, j.TriggerGC(context.Background()), errGC, "GC error not returned")
+
+	require.NoError(t, j.Shutdown(context.Background()))
+	require.Equal(t, 2, rp.callsGC, "invalid GC calls")
+	require.ErrorIs(t, j.TriggerGC(context.Background()), sse.ErrProviderClosed, "GC triggered after shutdown")
+}
+
+func TestJoe_Stats(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	require.Equal(t, sse.JoeStats{}, j.Stats(), "unexpected initial stats")
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+	ctx2, cancel2 := newMockContext(t)
+	defer cancel2()
+
+	sub := subscribe(t, j, ctx, "a", "b", "a", "c")
+	<-ctx.waitingOnDone
+	sub2 := subscribe(t, j, ctx2, "a")
+	<-ctx2.waitingOnDone
+
+	// Publishing acts as a barrier: the subscriptions are registered after it returns.
+	require.NoError(t, j.Publish(msg(t, "hello", ""), []string{"a", "b"}))
+	require.Equal(t, sse.JoeStats{Subscribers: 2, TopicRegistrations: 4}, j.Stats(), "invalid stats after subscribe")
+
+	cancel()
+	require.Len(t, <-sub, 1, "message should be received once")
+	require.NoError(t, j.Publish(msg(t, "world", ""), []string{"d"}))
+	require.Equal(t, sse.JoeStats{Subscribers: 1, TopicRegistrations: 1}, j.Stats(), "invalid stats after unsubscribe")
+
+	require.NoError(t, j.Shutdown(context.Background()))
+	<-sub2
+	require.Equal(t, sse.JoeStats{}, j.Stats(), "invalid stats after shutdown")
+}
+
+type ptrClient struct {
+	msgs []*sse.Message
+	mu   sync.Mutex
+}
+
+func (c *ptrClient) Send(m *sse.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.msgs = append(c.msgs, m)
+	return nil
+}
+
+func (c *ptrClient) Flush() error { return nil }
+
+func (c *ptrClient) Messages() []*sse.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.msgs
+}
+
+func TestJoe_duplicateSubscriptions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Reject", func(t *testing.T) {
+		j := &sse.Joe{}
+		defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		const subscriptions = 10
+
+		c := &ptrClient{}
+		errs := make(chan error, subscriptions)
+
+		for i := 0; i < subscriptions; i++ {
+			go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{sse.DefaultTopic}}) }()
+		}
+
+		for i := 0; i < subscriptions-1; i++ {
+			require.ErrorIs(t, <-errs, sse.ErrAlreadySubscribed, "duplicate subscription not rejected")
+		}
+
+		require.NoError(t, j.Publish(msg(t, "hello", ""), []string{sse.DefaultTopic}))
+		cancel()
+		require.NoError(t, <-errs, "unexpected error from the accepted subscription")
+		require.Len(t, c.Messages(), 1, "message delivered multiple times")
+		require.NoError(t, j.Publish(msg(t, "barrier", ""), []string{"barrier"}))
+		require.Equal(t, sse.JoeStats{}, j.Stats(), "subscriber not removed")
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		j := &sse.Joe{OnDuplicateSubscription: sse.MergeDuplicateSubscriptions}
+		defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		ctx, cancel := newMockContext(t)
+		defer cancel()
+		ctx2, cancel2 := newMockContext(t)
+		defer cancel2()
+
+		c := &ptrClient{}
+		errs := make(chan error, 2)
+
+		go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{"a"}}) }()
+		<-ctx.waitingOnDone
+		go func() { errs <- j.Subscribe(ctx2, sse.Subscription{Client: c, Topics: []string{"a", "b"}}) }()
+		<-ctx2.waitingOnDone
+
+		require.NoError(t, j.Publish(msg(t, "hello", ""), []string{"a", "b"}))
+		require.NoError(t, j.Publish(msg(t, "world", ""), []string{"b"}))
+		require.Equal(t, sse.JoeStats{Subscribers: 1, TopicRegistrations: 2}, j.Stats(), "topics not merged")
+
+		cancel()
+		require.NoError(t, <-errs)
+		require.NoError(t, <-errs)
+
+		msgs := c.Messages()
+		require.Len(t, msgs, 2, "invalid message count")
+		require.Equal(t, "data: hello\n\ndata: world\n\n", msgs[0].String()+msgs[1].String())
+	})
+
+	t.Run("MergeTopicFull", func(t *testing.T) {
+		j := &sse.Joe{
+			OnDuplicateSubscription: sse.MergeDuplicateSubscriptions,
+			TopicSubscriberLimit:    func(string) int { return 1 },
+		}
+		defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		ctx, cancel := newMockContext(t)
+		defer cancel()
+		otherCtx, cancelOther := newMockContext(t)
+		defer cancelOther()
+
+		c := &ptrClient{}
+		errs := make(chan error, 1)
+
+		go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{"a"}}) }()
+		<-ctx.waitingOnDone
+		go j.Subscribe(otherCtx, sse.Subscription{Client: &ptrClient{}, Topics: []string{"b"}}) //nolint:errcheck // irrelevant
+		<-otherCtx.waitingOnDone
+
+		err := j.Subscribe(context.Background(), sse.Subscription{Client: c, Topics: []string{"a", "b"}})
+		require.ErrorIs(t, err, sse.ErrTopicFull, "merged subscription exceeded the topic limit")
+		require.Equal(t, sse.JoeStats{Subscribers: 2, TopicRegistrations: 2}, j.Stats(), "full topic merged")
+
+		cancel()
+		require.NoError(t, <-errs)
+	})
+}
+
+func TestJoe_OnPublish(t *testing.T) {
+	t.Parallel()
+
+	type published struct {
+		msg    string
+		topics []string
+	}
+
+	var calls []published
+
+	j := &sse.Joe{
+		ReplayProvider: &sse.FiniteReplayProvider{Count: 5, AutoIDs: true},
+		OnPublish: func(m *sse.Message, topics []string) {
+			calls = append(calls, published{msg: m.String(), topics: topics})
+		},
+	}
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	sub := subscribe(t, j, ctx, "a")
+	<-ctx.waitingOnDone
+
+	require.NoError(t, j.Publish(msg(t, "hello", ""), []string{"a", "b"}))
+	require.NoError(t, j.Publish(msg(t, "world", ""), []string{"b"}))
+	require.NoError(t, j.Shutdown(context.Background()))
+
+	expected := []published{
+		{msg: "id: 0\ndata: hello\n\n", topics: []string{"a", "b"}},
+		{msg: "id: 1\ndata: world\n\n", topics: []string{"b"}},
+	}
+	require.Equal(t, expected, calls, "invalid OnPublish calls")
+	require.Len(t, <-sub, 1, "invalid message count")
+}
+
+func TestJoe_OnDispatch(t *testing.T) {
+	t.Parallel()
+
+	var results []sse.PublishResult
+
+	j := &sse.Joe{
+		OnDispatch: func(_ *sse.Message, res sse.PublishResult) {
+			results = append(results, res)
+		},
+	}
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	sub := subscribe(t, j, ctx, "a")
+	<-ctx.waitingOnDone
+
+	require.NoError(t, j.Publish(msg(t, "hello", ""), []string{"a", "b"}))
+	require.NoError(t, j.Publish(msg(t, "world", ""), []string{"b"}))
+	require.NoError(t, j.Shutdown(context.Background()))
+
+	expected := []sse.PublishResult{
+		{Topics: map[string]int{"a": 1, "b": 0}, Subscribers: 1},
+		{Topics: map[string]int{"b": 0}},
+	}
+	require.Equal(t, expected, results, "invalid OnDispatch calls")
+	require.Len(t, <-sub, 1, "invalid message count")
+}
+
+func TestJoe_TopicSequence(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{
+		ReplayProvider: &sse.FiniteReplayProvider{Count: 5, AutoIDs: true},
+		TopicSequence:  sse.SequenceInComment,
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := newMockContext(t)
+	sub := subscribe(t, j, ctx, "a", "b")
+	<-ctx.waitingOnDone
+
+	m := msg(t, "hello", "")
+	require.NoError(t, j.Publish(m, []string{"a"}))
+	require.NoError(t, j.Publish(msg(t, "world", ""), []string{"a", "b"}))
+	require.NoError(t, j.Ping(context.Background()))
+	cancel()
+
+	expected := []string{
+		"id: 0\n: seq: 1 a\ndata: hello\n\n",
+		"id: 1\n: seq: 2 a\n: seq: 1 b\ndata: world\n\n",
+	}
+	require.Equal(t, expected, msgStrings(<-sub), "invalid live messages")
+	require.Equal(t, "data: hello\n\n", m.String(), "published message was modified")
+
+	var replayed []*sse.Message
+	err := j.Subscribe(context.Background(), sse.Subscription{
+		Client: mockClient(func(m *sse.Message) error {
+			if m != nil {
+				replayed = append(replayed, m)
+			}
+			return errors.New("stop")
+		}),
+		LastEventID: sse.ID("0"),
+		Topics:      []string{"a"},
+	})
+	require.Error(t, err, "subscription didn't end")
+	require.Equal(t, expected[1:], msgStrings(replayed), "invalid replayed messages")
+
+	for _, c := range []struct {
+		comment string
+		topic   string
+		seq     uint64
+		ok      bool
+	}{
+		{"seq: 5 orders", "orders", 5, true},
+		{" seq: 12", "", 12, true},
+		{"seq: 1 a b", "a b", 1, true},
+		{"seq: x", "", 0, false},
+		{"topic: orders", "", 0, false},
+	} {
+		topic, seq, ok := sse.ParseSequenceComment(c.comment)
+		require.Equal(t, c.ok, ok, "invalid ok for %q", c.comment)
+		require.Equal(t, c.topic, topic, "invalid topic for %q", c.comment)
+		require.Equal(t, c.seq, seq, "invalid sequence for %q", c.comment)
+	}
+}
+
+func TestJoe_Aliases(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{
+		ReplayProvider: &sse.FiniteReplayProvider{Count: 5, AutoIDs: true},
+		Aliases:        map[string]string{"old": "new"},
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctxOld, cancelOld := newMockContext(t)
+	defer cancelOld()
+	ctxNew, cancelNew := newMockContext(t)
+	defer cancelNew()
+
+	subOld, subNew := subscribe(t, j, ctxOld, "old"), subscribe(t, j, ctxNew, "new")
+	<-ctxOld.waitingOnDone
+	<-ctxNew.waitingOnDone
+
+	require.NoError(t, j.Publish(msg(t, "hello", ""), []string{"old"}))
+	require.NoError(t, j.Publish(msg(t, "world", ""), []string{"new", "old"}))
+
+	c := &ptrClient{}
+	replayCtx, replayCancel := context.WithCancel(context.Background())
+	replayCancel()
+	require.NoError(t, j.Subscribe(replayCtx, sse.Subscription{Client: c, LastEventID: sse.ID("0"), Topics: []string{"old"}}))
+
+	cancelOld()
+	cancelNew()
+
+	expected := []string{"id: 0\ndata: hello\n\n", "id: 1\ndata: world\n\n"}
+	require.Equal(t, expected, msgStrings(<-subOld), "invalid messages for alias")
+	require.Equal(t, expected, msgStrings(<-subNew), "invalid messages for topic")
+	require.Equal(t, expected[1:], msgStrings(c.Messages()), "invalid replayed messages")
+
+	require.Panics(t, func() {
+		_ = (&sse.Joe{Aliases: map[string]string{"a": "b", "b": "c"}}).Publish(msg(t, "", ""), []string{"a"})
+	}, "alias chain not rejected")
+	require.Panics(t, func() {
+		_ = (&sse.Joe{Aliases: map[string]string{"a": "a"}}).Publish(msg(t, "", ""), []string{"a"})
+	}, "alias cycle not rejected")
+}
+
+func msgStrings(msgs []*sse.Message) []string {
+	s := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		s = append(s, m.String())
+	}
+	return s
+}
+
+type topicCleanupProvider struct {
+	sse.FiniteReplayProvider
+
+	dropped []string
+	mu      sync.Mutex
+}
+
+func (p *topicCleanupProvider) DropTopic(topic string) error {
+	p.mu.Lock()
+	p.dropped = append(p.dropped, topic)
+	p.mu.Unlock()
+
+	return p.FiniteReplayProvider.DropTopic(topic)
+}
+
+func (p *topicCleanupProvider) Dropped() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.dropped...)
+}
+
+func TestJoe_DropIdleTopicsAfter(t *testing.T) {
+	t.Parallel()
+
+	rp := &topicCleanupProvider{FiniteReplayProvider: sse.FiniteReplayProvider{Count: 5, AutoIDs: true}}
+	j := &sse.Joe{ReplayProvider: rp, DropIdleTopicsAfter: 5 * time.Millisecond}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	sub := subscribe(t, j, ctx, "a", "active")
+	<-ctx.waitingOnDone
+
+	require.NoError(t, j.Publish(msg(t, "hello", ""), []string{"a", "b"}))
+	require.Eventually(t, func() bool { return len(rp.Dropped()) == 1 }, time.Second, time.Millisecond, "unsubscribed topic not dropped")
+	require.Equal(t, []string{"b"}, rp.Dropped(), "invalid dropped topics")
+
+	cancel()
+	<-sub
+
+	require.Eventually(t, func() bool { return len(rp.Dropped()) == 3 }, time.Second, time.Millisecond, "topics not dropped after unsubscription")
+	require.ElementsMatch(t, []string{"b", "a", "active"}, rp.Dropped(), "invalid dropped topics")
+}
+
+func TestJoe_SubscribeWithSnapshot(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 5, AutoIDs: true}}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	require.NoError(t, j.Publish(msg(t, "before", ""), []string{sse.DefaultTopic}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &ptrClient{}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- j.SubscribeWithSnapshot(ctx, sse.Subscription{Client: c, LastEventID: sse.ID("0"), InclusiveReplay: true, Topics: []string{sse.DefaultTopic}}, func(w sse.MessageWriter) error {
+			return w.Send(msg(t, "snapshot", ""))
+		})
+	}()
+
+	require.Eventually(t, func() bool { return len(c.Messages()) == 1 }, time.Second, time.Millisecond, "snapshot not sent")
+	require.NoError(t, j.Publish(msg(t, "after", ""), []string{sse.DefaultTopic}))
+	cancel()
+	require.NoError(t, <-errs, "unexpected subscribe error")
+
+	require.Equal(t, []string{"data: snapshot\n\n", "id: 1\ndata: after\n\n"}, msgStrings(c.Messages()), "invalid messages")
+
+	err := errors.New("snapshot failed")
+	require.ErrorIs(t, j.SubscribeWithSnapshot(context.Background(), sse.Subscription{Client: &ptrClient{}, Topics: []string{sse.DefaultTopic}}, func(sse.MessageWriter) error {
+		return err
+	}), err, "snapshot error not returned")
+	require.Zero(t, j.Stats().Subscribers, "subscriber added after snapshot error")
+}
+
+func TestJoe_ordering(t *testing.T) {
+	t.Parallel()
+
+	const messages = 500
+
+	publishTopics := [][]string{{"a"}, {"b"}, {"c"}, {"a", "b"}, {"b", "c"}}
+	subscriptionTopics := [][]string{{"a", "b"}, {"a"}, {"b", "c"}, {"c", "a"}}
+
+	joes := map[string]*sse.Joe{
+		"Live":     {},
+		"Buffered": {SubscriberBufferSize: 8},
+		"Backfill": {
+			ReplayProvider: &sse.FiniteReplayProvider{Count: messages, AutoIDs: true},
+			Backfill: func(context.Context, sse.Subscription) (sse.EventID, error) {
+				time.Sleep(time.Millisecond)
+				return sse.EventID{}, nil
+			},
+		},
+	}
+
+	for name, j := range joes {
+		j := j
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			clients := make([]*ptrClient, len(subscriptionTopics))
+			errs := make(chan error, len(clients))
+			for i, topics := range subscriptionTopics {
+				clients[i] = &ptrClient{}
+				go func(c *ptrClient, topics []string) {
+					errs <- j.Subscribe(ctx, sse.Subscription{Client: c, LastEventID: sse.ID("0"), InclusiveReplay: true, Topics: topics})
+				}(clients[i], topics)
+			}
+
+			for i := 0; i < messages; i++ {
+				require.NoError(t, j.Publish(msg(t, strconv.Itoa(i), ""), publishTopics[i%len(publishTopics)]))
+			}
+
+			require.Eventually(t, func() bool { return j.Stats().Subscribers == len(clients) }, time.Second, time.Millisecond)
+			require.NoError(t, j.Publish(msg(t, "end", ""), []string{"a", "b", "c"}))
+			require.Eventually(t, func() bool {
+				for _, c := range clients {
+					msgs := c.Messages()
+					if len(msgs) == 0 || !strings.HasSuffix(msgs[len(msgs)-1].String(), "data: end\n\n") {
+						return false
+					}
+				}
+				return true
+			}, time.Second, time.Millisecond, "last message not received")
+
+			cancel()
+			for range clients {
+				require.NoError(t, <-errs)
+			}
+
+			for i, c := range clients {
+				prev := -1
+				msgs := c.Messages()
+				for _, m := range msgs[:len(msgs)-1] {
+					var data string
+					for _, line := range strings.Split(m.String(), "\n") {
+						if strings.HasPrefix(line, "data: ") {
+							data = strings.TrimPrefix(line, "data: ")
+						}
+					}
+
+					n, err := strconv.Atoi(data)
+					require.NoError(t, err, "invalid message data")
+					require.Greater(t, n, prev, "messages out of order for subscription %v", subscriptionTopics[i])
+					require.NotEmpty(t, intersection(publishTopics[n%len(publishTopics)], subscriptionTopics[i]), "message delivered to wrong subscriber")
+					prev = n
+				}
+			}
+		})
+	}
+}
+
+func intersection(a, b []string) string {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return x
+			}
+		}
+	}
+	return ""
+}
+
+func TestJoe_Backfill(t *testing.T) {
+	t.Parallel()
+
+	started, release := make(chan struct{}), make(chan struct{})
+
+	j := &sse.Joe{
+		ReplayProvider: &sse.FiniteReplayProvider{Count: 10, AutoIDs: true},
+		Backfill: func(_ context.Context, sub sse.Subscription) (sse.EventID, error) {
+			close(started)
+			<-release
+
+			_ = sub.Client.Send(msg(t, "db", "0"))
+			_ = sub.Client.Send(msg(t, "db", "1"))
+
+			return sse.ID("1"), sub.Client.Flush()
+		},
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, j.Publish(msg(t, "live", ""), []string{sse.DefaultTopic}))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &ptrClient{}
+	errs := make(chan error, 1)
+	go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{sse.DefaultTopic}}) }()
+
+	<-started
+	require.NoError(t, j.Publish(msg(t, "live", ""), []string{sse.DefaultTopic}))
+	close(release)
+	require.NoError(t, j.Publish(msg(t, "live", ""), []string{sse.DefaultTopic}))
+	require.Eventually(t, func() bool { return len(c.Messages()) >= 5 }, time.Millisecond*200, time.Millisecond, "messages not received")
+
+	cancel()
+	require.NoError(t, <-errs, "unexpected Subscribe error")
+
+	received := ""
+	for _, m := range c.Messages() {
+		received += m.String()
+	}
+
+	expected := "id: 0\ndata: db\n\nid: 1\ndata: db\n\nid: 2\ndata: live\n\nid: 3\ndata: live\n\nid: 4\ndata: live\n\n"
+	require.Equal(t, expected, received, "events lost or duplicated")
+}
+
+func TestJoe_Backfill_cancel(t *testing.T) {
+	t.Parallel()
+
+	var finished atomic.Bool
+
+	started := make(chan struct{}, 1)
+	errBackfill := errors.New("backfill failed")
+	j := &sse.Joe{
+		Backfill: func(ctx context.Context, sub sse.Subscription) (sse.EventID, error) {
+			if sub.LastEventID.String() == "fail" {
+				return sse.EventID{}, errBackfill
+			}
+
+			started <- struct{}{}
+			<-ctx.Done()
+			time.Sleep(time.Millisecond)
+			finished.Store(true)
+			return sse.EventID{}, errBackfill
+		},
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: &ptrClient{}, Topics: []string{sse.DefaultTopic}}) }()
+
+	<-started
+	cancel()
+	require.NoError(t, <-errs, "unexpected Subscribe error")
+	require.True(t, finished.Load(), "Subscribe returned before the backfill finished")
+
+	err := j.Subscribe(context.Background(), sse.Subscription{Client: &ptrClient{}, LastEventID: sse.ID("fail"), Topics: []string{sse.DefaultTopic}})
+	require.ErrorIs(t, err, errBackfill, "backfill error not returned")
+}
+
+func TestJoe_DrainTimeout(t *testing.T) {
+	t.Parallel()
+
+	run := func(tb testing.TB, drainTimeout time.Duration) string {
+		tb.Helper()
+
+		started := make(chan struct{})
+		j := &sse.Joe{
+			Backfill: func(ctx context.Context, sub sse.Subscription) (sse.EventID, error) {
+				close(started)
+				<-ctx.Done()
+				// Give Joe time to process the unsubscription.
+				time.Sleep(time.Millisecond * 5)
+
+				_ = sub.Client.Send(msg(tb, "db", "0"))
+				return sse.ID("0"), sub.Client.Flush()
+			},
+			DrainTimeout: drainTimeout,
+		}
+		defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		c := &ptrClient{}
+		errs := make(chan error, 1)
+		go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{sse.DefaultTopic}}) }()
+
+		<-started
+		require.NoError(tb, j.Publish(msg(tb, "saved", "1"), []string{sse.DefaultTopic}))
+		cancel()
+		require.NoError(tb, <-errs, "unexpected Subscribe error")
+
+		received := ""
+		for _, m := range c.Messages() {
+			received += m.String()
+		}
+		return received
+	}
+
+	require.Equal(t, "id: 0\ndata: db\n\nid: 1\ndata: saved\n\n", run(t, time.Second), "queued messages not drained")
+	require.Equal(t, "id: 0\ndata: db\n\n", run(t, 0), "queued messages should be dropped by default")
+}
+
+func TestJoe_ReplaceTopics(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	c := &ptrClient{}
+	_, err := j.ReplaceTopics(context.Background(), c, []string{"a"})
+	require.ErrorIs(t, err, sse.ErrNotSubscribed, "replaced topics of unsubscribed client")
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{"a", "b"}}) }()
+	<-ctx.waitingOnDone
+
+	_, err = j.ReplaceTopics(context.Background(), c, nil)
+	require.ErrorIs(t, err, sse.ErrNoTopic, "replaced topics with no topics")
+
+	previous, err := j.ReplaceTopics(context.Background(), c, []string{"b", "c", "c"})
+	require.NoError(t, err, "replace failed")
+	require.Equal(t, []string{"a", "b"}, previous, "invalid previous topics")
+	require.Equal(t, sse.JoeStats{Subscribers: 1, TopicRegistrations: 2}, j.Stats(), "invalid registrations")
+
+	for _, topic := range []string{"a", "b", "c"} {
+		require.NoError(t, j.Publish(msg(t, topic, ""), []string{topic}))
+	}
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.Equal(t, []string{"data: b\n\n", "data: c\n\n"}, msgStrings(c.Messages()), "invalid messages after replace")
+
+	_, err = j.ReplaceTopics(context.Background(), c, []string{"a"})
+	require.ErrorIs(t, err, sse.ErrNotSubscribed, "replaced topics of ended subscription")
+}
+
+func TestJoe_AddRemoveTopics(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	c := &ptrClient{}
+	require.ErrorIs(t, j.AddTopics(context.Background(), c, []string{"a"}), sse.ErrNotSubscribed, "added topics to unsubscribed client")
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{"a", "b"}}) }()
+	<-ctx.waitingOnDone
+
+	require.ErrorIs(t, j.AddTopics(context.Background(), c, nil), sse.ErrNoTopic, "added no topics")
+	require.ErrorIs(t, j.RemoveTopics(context.Background(), c, nil), sse.ErrNoTopic, "removed no topics")
+
+	require.NoError(t, j.AddTopics(context.Background(), c, []string{"b", "c", "c"}), "add failed")
+	require.Equal(t, sse.JoeStats{Subscribers: 1, TopicRegistrations: 3}, j.Stats(), "invalid registrations after add")
+
+	require.NoError(t, j.RemoveTopics(context.Background(), c, []string{"a", "d"}), "remove failed")
+	require.Equal(t, sse.JoeStats{Subscribers: 1, TopicRegistrations: 2}, j.Stats(), "invalid registrations after remove")
+
+	err := j.RemoveTopics(context.Background(), c, []string{"b", "c"})
+	require.ErrorIs(t, err, sse.ErrNoTopic, "removed all topics")
+	require.Equal(t, sse.JoeStats{Subscribers: 1, TopicRegistrations: 2}, j.Stats(), "subscription changed after removing all topics")
+
+	for _, topic := range []string{"a", "b", "c"} {
+		require.NoError(t, j.Publish(msg(t, topic, ""), []string{topic}))
+	}
+
+	cancel()
+	require.NoError(t, <-errs)
+	require.Equal(t, []string{"data: b\n\n", "data: c\n\n"}, msgStrings(c.Messages()), "invalid messages after update")
+
+	require.ErrorIs(t, j.RemoveTopics(context.Background(), c, []string{"b"}), sse.ErrNotSubscribed, "removed topics of ended subscription")
+}
+
+func TestJoe_PublishWithResult(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{}
+
+	res, err := j.PublishWithResult(context.Background(), msg(t, "nobody", ""), []string{"a"})
+	require.NoError(t, err, "publish failed")
+	require.Equal(t, sse.PublishResult{Topics: map[string]int{"a": 0}}, res, "invalid result without subscribers")
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+	otherCtx, otherCancel := newMockContext(t)
+	defer otherCancel()
+
+	c, other := &ptrClient{}, &ptrClient{}
+	errs := make(chan error, 2)
+	go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{"a", "b"}}) }()
+	go func() {
+		errs <- j.Subscribe(otherCtx, sse.Subscription{
+			Client: other,
+			Topics: []string{"b"},
+			Filter: func(m *sse.Message) bool { return m.String() != "data: filtered\n\n" },
+		})
+	}()
+	<-ctx.waitingOnDone
+	<-otherCtx.waitingOnDone
+
+	res, err = j.PublishWithResult(context.Background(), msg(t, "both", ""), []string{"a", "b", "c"})
+	require.NoError(t, err, "publish failed")
+	require.Equal(t, sse.PublishResult{Topics: map[string]int{"a": 1, "b": 1, "c": 0}, Subscribers: 2}, res, "invalid result")
+
+	res, err = j.PublishWithResult(context.Background(), msg(t, "filtered", ""), []string{"b"})
+	require.NoError(t, err, "publish failed")
+	require.Equal(t, sse.PublishResult{Topics: map[string]int{"b": 1}, Subscribers: 1}, res, "filtered subscriber counted")
+
+	cancel()
+	otherCancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+
+	require.NoError(t, j.Shutdown(context.Background()))
+	_, err = j.PublishWithResult(context.Background(), msg(t, "closed", ""), []string{"a"})
+	require.ErrorIs(t, err, sse.ErrProviderClosed, "published after shutdown")
+}
+
+func TestJoe_Topics(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{HierarchicalTopics: true}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	topics, err := j.Topics(context.Background())
+	require.NoError(t, err, "listing failed")
+	require.Empty(t, topics, "topics listed without subscribers")
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- j.Subscribe(ctx, sse.Subscription{Client: &ptrClient{}, Topics: []string{"b", "a/c/d", "a"}})
+	}()
+	<-ctx.waitingOnDone
+
+	topics, err = j.Topics(context.Background())
+	require.NoError(t, err, "listing failed")
+	require.Equal(t, []string{"a", "a/c/d", "b"}, topics, "invalid topics")
+
+	cancel()
+	require.NoError(t, <-errs)
+
+	topics, err = j.Topics(context.Background())
+	require.NoError(t, err, "listing failed")
+	require.Empty(t, topics, "topics listed after unsubscribe")
+}
+
+func TestJoe_ProviderStats(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{HierarchicalTopics: true, WildcardTopics: true}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	subscribe := func(topics ...string) (func(), <-chan error) {
+		ctx, cancel := newMockContext(t)
+		errs := make(chan error, 1)
+		go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: &ptrClient{}, Topics: topics}) }()
+		<-ctx.waitingOnDone
+		return cancel, errs
+	}
+
+	cancelA, errsA := subscribe("a", "a/b")
+	cancelB, errsB := subscribe("a/b")
+	cancelC, errsC := subscribe("*/b", "c")
+
+	counts := map[string]int{"a": 1, "a/b": 3, "c": 1, "c/b": 1, "d": 0}
+	for topic, expected := range counts {
+		count, err := j.SubscriberCount(context.Background(), topic)
+		require.NoError(t, err, "count failed")
+		require.Equal(t, expected, count, "invalid subscriber count for topic %q", topic)
+	}
+	require.Equal(t, sse.ProviderTotals{Subscribers: 3, TopicRegistrations: 5}, j.Totals(), "invalid totals")
+
+	for _, cancel := range []func(){cancelA, cancelB, cancelC} {
+		cancel()
+	}
+	for _, errs := range []<-chan error{errsA, errsB, errsC} {
+		require.NoError(t, <-errs)
+	}
+
+	require.Equal(t, sse.ProviderTotals{}, j.Totals(), "invalid totals after unsubscribe")
+}
+
+func TestJoe_TopicSubscriberLimit(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{
+		TopicSubscriberLimit: func(topic string) int {
+			if topic == "a" {
+				return 1
+			}
+			return 0
+		},
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+	otherCtx, otherCancel := newMockContext(t)
+	defer otherCancel()
+
+	c, other := &ptrClient{}, &ptrClient{}
+	errs := make(chan error, 2)
+	go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{"a"}}) }()
+	<-ctx.waitingOnDone
+
+	err := j.Subscribe(context.Background(), sse.Subscription{Client: &ptrClient{}, Topics: []string{"b", "a"}})
+	var fullErr *sse.TopicFullError
+	require.ErrorAs(t, err, &fullErr, "subscribed to full topic")
+	require.Equal(t, sse.TopicFullError{Topic: "a", Limit: 1}, *fullErr, "invalid error")
+	require.ErrorIs(t, err, sse.ErrTopicFull, "error doesn't wrap sentinel")
+
+	go func() { errs <- j.Subscribe(otherCtx, sse.Subscription{Client: other, Topics: []string{"b"}}) }()
+	<-otherCtx.waitingOnDone
+
+	require.ErrorIs(t, j.AddTopics(context.Background(), other, []string{"a"}), sse.ErrTopicFull, "added full topic")
+	_, err = j.ReplaceTopics(context.Background(), other, []string{"a", "b"})
+	require.ErrorIs(t, err, sse.ErrTopicFull, "replaced with full topic")
+
+	_, err = j.ReplaceTopics(context.Background(), c, []string{"a", "b"})
+	require.NoError(t, err, "subscriber counted against its own topic")
+	require.Equal(t, sse.JoeStats{Subscribers: 2, TopicRegistrations: 3}, j.Stats(), "invalid registrations")
+
+	cancel()
+	otherCancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+}
+
+func TestJoe_Filter(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 10}}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	require.NoError(t, j.Publish(msg(t, "start", "0"), []string{"a"}))
+	require.NoError(t, j.Publish(msg(t, "info", "1"), []string{"a"}))
+	require.NoError(t, j.Publish(msg(t, "error", "2"), []string{"a"}))
+	require.NoError(t, j.Publish(msg(t, "error", "3"), []string{"a"}))
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	c := &ptrClient{}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- j.Subscribe(ctx, sse.Subscription{
+			Client:      c,
+			Topics:      []string{"a"},
+			LastEventID: sse.ID("0"),
+			MaxReplayed: 1,
+			Filter:      func(m *sse.Message) bool { return m.ID.String() != "1" && m.ID.String() != "4" },
+		})
+	}()
+	<-ctx.waitingOnDone
+
+	require.NoError(t, j.Publish(msg(t, "filtered", "4"), []string{"a"}))
+	require.NoError(t, j.Publish(msg(t, "live", "5"), []string{"a"}))
+
+	cancel()
+	require.NoError(t, <-errs)
+
+	expected := []string{
+		"event: " + sse.ReplayGapEventType + "\ndata: 1\n\n",
+		"id: 3\ndata: error\n\n",
+		"id: 5\ndata: live\n\n",
+	}
+	require.Equal(t, expected, msgStrings(c.Messages()), "invalid messages received")
+}
+
+func TestJoe_WildcardTopics(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{WildcardTopics: true, ReplayProvider: &sse.FiniteReplayProvider{Count: 10}}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	err := j.Subscribe(context.Background(), sse.Subscription{Client: &ptrClient{}, Topics: []string{"orders.["}})
+	require.ErrorIs(t, err, path.ErrBadPattern, "subscribed with malformed pattern")
+
+	require.NoError(t, j.Publish(msg(t, "start", "0"), []string{"start"}))
+	require.NoError(t, j.Publish(msg(t, "created", "1"), []string{"orders.created"}))
+	require.NoError(t, j.Publish(msg(t, "user", "2"), []string{"users.created"}))
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	c := &ptrClient{}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{"orders.*", "orders.created"}, LastEventID: sse.ID("0")})
+	}()
+	<-ctx.waitingOnDone
+
+	require.NoError(t, j.Publish(msg(t, "shipped", "3"), []string{"orders.shipped"}))
+	require.NoError(t, j.Publish(msg(t, "nested", "4"), []string{"orders.eu/shipped"}))
+	require.NoError(t, j.Publish(msg(t, "both", "5"), []string{"orders.created", "orders.shipped"}))
+	require.NoError(t, j.Publish(msg(t, "literal", "6"), []string{"orders.*"}))
+
+	cancel()
+	require.NoError(t, <-errs)
+
+	expected := []string{
+		"id: 1\ndata: created\n\n",
+		"id: 3\ndata: shipped\n\n",
+		"id: 5\ndata: both\n\n",
+		"id: 6\ndata: literal\n\n",
+	}
+	require.Equal(t, expected, msgStrings(c.Messages()), "invalid messages received")
+
+	_, err = j.ReplaceTopics(context.Background(), c, []string{"["})
+	require.ErrorIs(t, err, path.ErrBadPattern, "replaced topics with malformed pattern")
+
+	retained := &sse.Joe{WildcardTopics: true, ReplayProvider: &sse.RetainedReplayProvider{}}
+	defer retained.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	require.NoError(t, retained.Publish(msg(t, "created", ""), []string{"orders.created"}))
+	require.NoError(t, retained.Publish(msg(t, "user", ""), []string{"users.created"}))
+
+	// The subscription's context is done, so only the retained messages are sent.
+	replayed := &ptrClient{}
+	canceled, cancelReplay := context.WithCancel(context.Background())
+	cancelReplay()
+	require.NoError(t, retained.Subscribe(canceled, sse.Subscription{Client: replayed, Topics: []string{"orders.*"}}))
+	require.Equal(t, []string{"data: created\n\n"}, msgStrings(replayed.Messages()), "invalid retained messages replayed")
+}
+
+func TestJoe_HierarchicalTopics(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{HierarchicalTopics: true, ReplayProvider: &sse.FiniteReplayProvider{Count: 10}}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	require.NoError(t, j.Publish(msg(t, "start", "0"), []string{"start"}))
+	require.NoError(t, j.Publish(msg(t, "paris", "1"), []string{"sensors/eu/paris"}))
+	require.NoError(t, j.Publish(msg(t, "europe", "2"), []string{"sensors/europe"}))
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	c := &ptrClient{}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- j.Subscribe(ctx, sse.Subscription{
+			Client:           c,
+			Topics:           []string{"sensors/eu", "sensors/eu/paris"},
+			LastEventID:      sse.ID("0"),
+			TopicAttribution: sse.TopicInComment,
+		})
+	}()
+	<-ctx.waitingOnDone
+
+	require.NoError(t, j.Publish(msg(t, "eu", "3"), []string{"sensors/eu"}))
+	require.NoError(t, j.Publish(msg(t, "berlin", "4"), []string{"sensors/eu/berlin/1"}))
+	require.NoError(t, j.Publish(msg(t, "europe", "5"), []string{"sensors/europe"}))
+	require.NoError(t, j.Publish(msg(t, "ancestor", "6"), []string{"sensors"}))
+	require.NoError(t, j.Publish(msg(t, "both", "7"), []string{"sensors/eu/paris", "sensors/eu"}))
+
+	cancel()
+	require.NoError(t, <-errs)
+
+	expected := []string{
+		"id: 1\n: topic: sensors/eu/paris\ndata: paris\n\n",
+		"id: 3\n: topic: sensors/eu\ndata: eu\n\n",
+		"id: 4\n: topic: sensors/eu/berlin/1\ndata: berlin\n\n",
+		"id: 7\n: topic: sensors/eu/paris\ndata: both\n\n",
+	}
+	require.Equal(t, expected, msgStrings(c.Messages()), "invalid messages received")
+	require.Equal(t, sse.JoeStats{}, j.Stats(), "topics not unregistered")
+}
+
+func TestJoe_SendTo(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 10}}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	c, other := &ptrClient{}, &ptrClient{}
+	err := j.SendTo(context.Background(), c, msg(t, "unicast", ""))
+	require.ErrorIs(t, err, sse.ErrNotSubscribed, "sent to unsubscribed client")
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+	otherCtx, otherCancel := newMockContext(t)
+	defer otherCancel()
+
+	errs := make(chan error, 2)
+	go func() { errs <- j.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{sse.DefaultTopic}}) }()
+	go func() { errs <- j.Subscribe(otherCtx, sse.Subscription{Client: other, Topics: []string{sse.DefaultTopic}}) }()
+	<-ctx.waitingOnDone
+	<-otherCtx.waitingOnDone
+
+	require.ErrorIs(t, j.SendTo(context.Background(), c, &sse.Message{}), sse.ErrEmptyMessage, "sent empty message")
+
+	require.NoError(t, j.Publish(msg(t, "before", "1"), []string{sse.DefaultTopic}))
+	require.NoError(t, j.SendTo(context.Background(), c, msg(t, "unicast", "")), "send failed")
+	require.NoError(t, j.Publish(msg(t, "after", "2"), []string{sse.DefaultTopic}))
+
+	cancel()
+	otherCancel()
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+
+	require.Equal(t, []string{"id: 1\ndata: before\n\n", "data: unicast\n\n", "id: 2\ndata: after\n\n"}, msgStrings(c.Messages()), "invalid messages for recipient")
+	require.Equal(t, []string{"id: 1\ndata: before\n\n", "id: 2\ndata: after\n\n"}, msgStrings(other.Messages()), "unicast message sent to other client")
+
+	replayCtx, replayCancel := context.WithCancel(context.Background())
+	replayCancel()
+
+	replayed := &ptrClient{}
+	require.NoError(t, j.Subscribe(replayCtx, sse.Subscription{Client: replayed, Topics: []string{sse.DefaultTopic}, LastEventID: sse.ID("1")}))
+	require.Equal(t, []string{"id: 2\ndata: after\n\n"}, msgStrings(replayed.Messages()), "unicast message replayed")
+
+	err = j.SendTo(context.Background(), c, msg(t, "unicast", ""))
+	require.ErrorIs(t, err, sse.ErrNotSubscribed, "sent to ended subscription")
+}
+
+func TestJoe_Ping(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{}
+
+	require.NoError(t, j.Ping(context.Background()), "ping failed")
+	require.True(t, j.LastDispatchTime().IsZero(), "dispatch time set without messages")
+
+	before := time.Now()
+	require.NoError(t, j.Publish(msg(t, "hello", ""), []string{sse.DefaultTopic}))
+	require.NoError(t, j.Ping(context.Background()), "ping failed")
+	require.False(t, j.LastDispatchTime().Before(before), "invalid dispatch time")
+
+	sending, unblock := make(chan struct{}), make(chan struct{})
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	go j.Subscribe(ctx, sse.Subscription{ //nolint:errcheck // irrelevant
+		Client: mockClient(func(m *sse.Message) error {
+			if m != nil {
+				close(sending)
+				<-unblock
+			}
+			return nil
+		}),
+		Topics: []string{sse.DefaultTopic},
+	})
+	<-ctx.waitingOnDone
+
+	require.NoError(t, j.Publish(msg(t, "block", ""), []string{sse.DefaultTopic}))
+	<-sending
+
+	pctx, pcancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer pcancel()
+	require.ErrorIs(t, j.Ping(pctx), context.DeadlineExceeded, "ping succeeded while the loop is blocked")
+
+	close(unblock)
+	require.NoError(t, j.Shutdown(context.Background()))
+	require.ErrorIs(t, j.Ping(context.Background()), sse.ErrProviderClosed, "ping succeeded after shutdown")
+}
+
+func TestJoe_OnReplayError(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []sse.ReplayErrorPolicy{sse.DropOnReplayError, sse.DeliverWithoutReplay} {
+		var hookErrs []error
+		var published []string
+
+		j := &sse.Joe{
+			// Messages without IDs can't be put in a replay provider which doesn't set them.
+			ReplayProvider: &sse.FiniteReplayProvider{Count: 5},
+			OnReplayError:  policy,
+			ReplayErrorHook: func(err error, m *sse.Message, topics []string) {
+				hookErrs = append(hookErrs, err)
+			},
+			OnPublish: func(m *sse.Message, _ []string) {
+				published = append(published, m.String())
+			},
+		}
+
+		ctx, cancel := newMockContext(t)
+
+		sub := subscribe(t, j, ctx, sse.DefaultTopic)
+		<-ctx.waitingOnDone
+
+		require.NoError(t, j.Publish(msg(t, "no id", ""), []string{sse.DefaultTopic}))
+		require.NoError(t, j.Publish(msg(t, "with id", "1"), []string{sse.DefaultTopic}))
+		require.NoError(t, j.Ping(context.Background()), "Joe stopped after replay error")
+
+		cancel()
+
+		require.Len(t, hookErrs, 1, "invalid hook call count")
+		require.ErrorIs(t, hookErrs[0], sse.ErrReplayPutFailed, "invalid hook error")
+
+		expected := []string{"id: 1\ndata: with id\n\n"}
+		if policy == sse.DeliverWithoutReplay {
+			expected = []string{"data: no id\n\n", "id: 1\ndata: with id\n\n"}
+		}
+		require.Equal(t, expected, msgStrings(<-sub), "invalid messages received")
+		require.Equal(t, expected, published, "invalid OnPublish calls")
+
+		require.NoError(t, j.Shutdown(context.Background()))
+	}
+}
+
+type putErrorReplayProvider struct {
+	mockReplayProvider
+	err   error
+	fails int
+	calls int
+}
+
+func (p *putErrorReplayProvider) PutWithError(msg *sse.Message, _ []string) (*sse.Message, error) {
+	p.calls++
+	if p.calls <= p.fails {
+		return nil, p.err
+	}
+	return msg, nil
+}
+
+func TestJoe_ReplayProviderWithPutError(t *testing.T) {
+	t.Parallel()
+
+	errPut := errors.New("storage unavailable")
+
+	for _, policy := range []sse.ReplayErrorPolicy{sse.PanicOnReplayError, sse.DropOnReplayError} {
+		rp := &putErrorReplayProvider{err: errPut, fails: 4}
+		var hookErrs []error
+		j := &sse.Joe{
+			ReplayProvider:   rp,
+			ReplayPutRetries: 2,
+			OnReplayError:    policy,
+			ReplayErrorHook: func(err error, _ *sse.Message, _ []string) {
+				hookErrs = append(hookErrs, err)
+			},
+		}
+
+		ctx, cancel := newMockContext(t)
+		sub := subscribe(t, j, ctx, sse.DefaultTopic)
+		<-ctx.waitingOnDone
+
+		// The first message fails all its 3 attempts, the second one succeeds on the second attempt.
+		require.NoError(t, j.Publish(msg(t, "failed", "1"), []string{sse.DefaultTopic}))
+		require.NoError(t, j.Publish(msg(t, "retried", "2"), []string{sse.DefaultTopic}))
+		require.NoError(t, j.Ping(context.Background()), "Joe stopped after replay error")
+		cancel()
+
+		expected := []string{"id: 2\ndata: retried\n\n"}
+		if policy == sse.PanicOnReplayError {
+			expected = []string{"id: 1\ndata: failed\n\n", "id: 2\ndata: retried\n\n"}
+		}
+		require.Equal(t, expected, msgStrings(<-sub), "invalid messages received")
+		require.Equal(t, 5, rp.calls, "invalid PutWithError calls")
+		require.Zero(t, rp.callsPut, "Put was called")
+
+		require.Len(t, hookErrs, 1, "invalid hook call count")
+		require.ErrorIs(t, hookErrs[0], sse.ErrReplayPutFailed, "invalid hook error")
+		require.ErrorIs(t, hookErrs[0], errPut, "provider error not wrapped")
+
+		require.NoError(t, j.Shutdown(context.Background()))
+	}
+}
+
+func TestJoe_SlowSubscribers(t *testing.T) {
+	t.Parallel()
+
+	const limit = 50 * time.Millisecond
+
+	var states []sse.SubscriberState
+	j := &sse.Joe{
+		SlowSubscribers: sse.SoftLimit{
+			MaxWriteDuration: limit,
+			OnStateChange: func(_ sse.Subscription, state sse.SubscriberState) {
+				states = append(states, state)
+			},
+		},
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	var slow atomic.Bool
+	var received []string
+	client := mockClient(func(m *sse.Message) error {
+		if m == nil {
+			if slow.Load() {
+				time.Sleep(limit + 10*time.Millisecond)
+			}
+			return nil
+		}
+		received = append(received, m.String())
+		return nil
+	})
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- j.Subscribe(ctx, sse.Subscription{Client: client, Topics: []string{sse.DefaultTopic}}) }()
+	<-ctx.waitingOnDone
+
+	publish := func(data string) {
+		t.Helper()
+		require.NoError(t, j.Publish(msg(t, data, ""), []string{sse.DefaultTopic}))
+		require.NoError(t, j.Ping(context.Background()))
+	}
+
+	slow.Store(true)
+	publish("slow")
+	slow.Store(false)
+	publish("dropped")
+	time.Sleep(limit)
+	publish("recovered")
+	slow.Store(true)
+	publish("slow again")
+	time.Sleep(limit)
+	publish("disconnected")
+
+	require.ErrorIs(t, <-done, sse.ErrSlowSubscriber, "slow subscriber not disconnected")
+
+	warning := "event: " + sse.WarningEventType + "\ndata: slow subscriber: some messages are dropped until it keeps up\n\n"
+	expected := []string{"data: slow\n\n", warning, "data: recovered\n\n", "data: slow again\n\n", warning, "data: disconnected\n\n"}
+	require.Equal(t, expected, received, "invalid messages received")
+
+	expectedStates := []sse.SubscriberState{sse.SubscriberWarned, sse.SubscriberOK, sse.SubscriberWarned, sse.SubscriberDropped}
+	require.Equal(t, expectedStates, states, "invalid state transitions")
+}
+
+func TestJoe_SubscriberBufferSize(t *testing.T) {
+	t.Parallel()
+
+	const bufferSize = 2
+
+	j := &sse.Joe{SubscriberBufferSize: bufferSize}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	blocked, unblock := make(chan struct{}), make(chan struct{})
+	slowReceived := make(chan string, bufferSize+1)
+	var once sync.Once
+	slow := mockClient(func(m *sse.Message) error {
+		if m == nil {
+			return nil
+		}
+		once.Do(func() {
+			close(blocked)
+			<-unblock
+		})
+		slowReceived <- m.String()
+		return nil
+	})
+	fast := &ptrClient{}
+
+	errFailed := errors.New("failed")
+	failing := mockClient(func(m *sse.Message) error {
+		if m == nil {
+			return nil
+		}
+		return errFailed
+	})
+
+	slowCtx, slowCancel := newMockContext(t)
+	defer slowCancel()
+	fastCtx, fastCancel := newMockContext(t)
+	defer fastCancel()
+
+	slowDone, fastDone, failingDone := make(chan error, 1), make(chan error, 1), make(chan error, 1)
+	go func() { slowDone <- j.Subscribe(slowCtx, sse.Subscription{Client: slow, Topics: []string{sse.DefaultTopic}}) }()
+	go func() { fastDone <- j.Subscribe(fastCtx, sse.Subscription{Client: fast, Topics: []string{sse.DefaultTopic}}) }()
+	<-slowCtx.waitingOnDone
+	<-fastCtx.waitingOnDone
+	go func() {
+		failingDone <- j.Subscribe(context.Background(), sse.Subscription{Client: failing, Topics: []string{sse.DefaultTopic}})
+	}()
+	require.Eventually(t, func() bool { return j.Stats().Subscribers == 3 }, time.Second, time.Millisecond, "not subscribed")
+
+	// The slow subscriber blocks on the first message and its queue holds the others.
+	var expected []string
+	for i := 0; i < bufferSize+1; i++ {
+		require.NoError(t, j.Publish(msg(t, strconv.Itoa(i), ""), []string{sse.DefaultTopic}))
+		expected = append(expected, "data: "+strconv.Itoa(i)+"\n\n")
+	}
+	<-blocked
+
+	require.ErrorIs(t, <-failingDone, errFailed, "failing subscriber not removed")
+	require.Eventually(t, func() bool { return len(fast.Messages()) == len(expected) }, time.Second, time.Millisecond, "fast subscriber delayed by slow one")
+
+	fastCancel()
+	require.NoError(t, <-fastDone)
+	require.Equal(t, expected, msgStrings(fast.Messages()), "invalid messages for fast subscriber")
+
+	close(unblock)
+	for _, e := range expected {
+		require.Equal(t, e, <-slowReceived, "invalid messages for slow subscriber")
+	}
+
+	slowCancel()
+	require.NoError(t, <-slowDone)
+}
+
+func TestJoe_OnSubscriberOverflow(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		joe      *sse.Joe
+		sub      sse.OverflowPolicy
+		expected []string
+		dropped  int
+		err      error
+	}{
+		"DropOldest": {
+			joe:      &sse.Joe{SubscriberBufferSize: 1},
+			sub:      sse.DropOldestOnOverflow,
+			expected: []string{"0", "2"},
+			dropped:  1,
+		},
+		"DropNewest": {
+			joe:      &sse.Joe{SubscriberBufferSize: 1, OnSubscriberOverflow: sse.DropOldestOnOverflow},
+			sub:      sse.DropNewestOnOverflow,
+			expected: []string{"0", "1"},
+			dropped:  1,
+		},
+		"Disconnect": {
+			joe:      &sse.Joe{SubscriberBufferSize: 1, OnSubscriberOverflow: sse.DisconnectOnOverflow},
+			expected: []string{"0"},
+			err:      sse.ErrSlowSubscriber,
+		},
+	}
+
+	for name, test := range tests {
+		test := test
+
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			j := test.joe
+			defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+			blocked, unblock := make(chan struct{}), make(chan struct{})
+			received := make(chan string, 3)
+			var once sync.Once
+			client := mockClient(func(m *sse.Message) error {
+				if m == nil {
+					return nil
+				}
+				once.Do(func() {
+					close(blocked)
+					<-unblock
+				})
+				received <- strings.TrimSuffix(strings.TrimPrefix(m.String(), "data: "), "\n\n")
+				return nil
+			})
+
+			ctx, cancel := newMockContext(t)
+			defer cancel()
+
+			done := make(chan error, 1)
+			go func() {
+				done <- j.Subscribe(ctx, sse.Subscription{Client: client, Topics: []string{sse.DefaultTopic}, OnOverflow: test.sub})
+			}()
+			<-ctx.waitingOnDone
+
+			require.NoError(t, j.Publish(msg(t, "0", ""), []string{sse.DefaultTopic}))
+			<-blocked
+			for _, data := range []string{"1", "2"} {
+				require.NoError(t, j.Publish(msg(t, data, ""), []string{sse.DefaultTopic}))
+			}
+			require.NoError(t, j.Ping(context.Background()))
+			close(unblock)
+
+			if test.err != nil {
+				require.ErrorIs(t, <-done, test.err, "invalid subscription error")
+			}
+
+			for _, e := range test.expected {
+				require.Equal(t, e, <-received, "invalid messages received")
+			}
+			require.Equal(t, test.dropped, j.Stats().DroppedMessages, "invalid dropped messages count")
+
+			if test.err == nil {
+				cancel()
+				require.NoError(t, <-done)
+				require.Empty(t, received, "dropped message received")
+			}
+		})
+	}
+}
+
+func TestJoe_ReplayTimeout(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{
+		ReplayProvider: &sse.FiniteReplayProvider{Count: 5, AutoIDs: true},
+		ReplayTimeout:  time.Millisecond,
+	}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	for _, data := range []string{"a", "b", "c"} {
+		require.NoError(t, j.Publish(msg(t, data, ""), []string{sse.DefaultTopic}))
+	}
+
+	var received []string
+	replayed := make(chan struct{})
+	client := mockClient(func(m *sse.Message) error {
+		if m == nil {
+			return nil
+		}
+		received = append(received, m.String())
+		if m.Type.String() == sse.ReplayGapEventType {
+			close(replayed)
+		}
+		// Exceed the replay timeout.
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- j.Subscribe(ctx, sse.Subscription{Client: client, LastEventID: sse.ID("-1"), Topics: []string{sse.DefaultTopic}})
+	}()
+	<-replayed
+
+	require.NoError(t, j.Publish(msg(t, "d", ""), []string{sse.DefaultTopic}))
+	require.NoError(t, j.Ping(context.Background()))
+
+	cancel()
+	require.NoError(t, <-done, "subscriber should continue after a partial replay")
+
+	expected := []string{"id: 0\ndata: a\n\n", "event: " + sse.ReplayGapEventType + "\ndata: 2\n\n", "id: 3\ndata: d\n\n"}
+	require.Equal(t, expected, received, "invalid messages received")
+}
+
+func TestJoe_TopicAttribution(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 5, AutoIDs: true}}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	typed := msg(t, "a", "")
+	typed.Type = sse.Type("update")
+	require.NoError(t, j.Publish(typed, []string{"orders"}))
+
+	subscribe := func(attribution sse.TopicAttribution) (*ptrClient, context.CancelFunc, <-chan error) {
+		c := &ptrClient{}
+		ctx, cancel := newMockContext(t)
+		done := make(chan error, 1)
+		go func() {
+			done <- j.Subscribe(ctx, sse.Subscription{
+				Client:           c,
+				LastEventID:      sse.ID("-1"),
+				Topics:           []string{"orders", "users", sse.DefaultTopic},
+				TopicAttribution: attribution,
+			})
+		}()
+		<-ctx.waitingOnDone
+		return c, cancel, done
+	}
+
+	inType, cancelInType, doneInType := subscribe(sse.TopicInType)
+	inComment, cancelInComment, doneInComment := subscribe(sse.TopicInComment)
+
+	require.NoError(t, j.Publish(msg(t, "b", ""), []string{"users", "orders"}))
+	require.NoError(t, j.Publish(msg(t, "c", ""), []string{sse.DefaultTopic}))
+
+	cancelInType()
+	cancelInComment()
+	require.NoError(t, <-doneInType)
+	require.NoError(t, <-doneInComment)
+
+	require.Equal(t, []string{
+		"id: 0\nevent: orders/update\ndata: a\n\n",
+		"id: 1\nevent: users/message\ndata: b\n\n",
+		"id: 2\ndata: c\n\n",
+	}, msgStrings(inType.Messages()), "invalid type attribution")
+	require.Equal(t, []string{
+		"id: 0\nevent: update\n: topic: orders\ndata: a\n\n",
+		"id: 1\n: topic: users\ndata: b\n\n",
+		"id: 2\ndata: c\n\n",
+	}, msgStrings(inComment.Messages()), "invalid comment attribution")
+	require.Equal(t, sse.Type("update"), typed.Type, "published message should not be modified")
+}
+
+func TestJoe_ReplayBudget(t *testing.T) {
+	t.Parallel()
+
+	subscribeAsync := func(j *sse.Joe, ctx context.Context, c sse.MessageWriter) <-chan error { //nolint
+		errs := make(chan error, 1)
+		go func() {
+			errs <- j.Subscribe(ctx, sse.Subscription{Client: c, LastEventID: sse.ID("-1"), Topics: []string{sse.DefaultTopic}})
+		}()
+		return errs
+	}
+
+	t.Run("EventsPerSecond", func(t *testing.T) {
+		t.Parallel()
+
+		j := &sse.Joe{
+			ReplayProvider: &sse.FiniteReplayProvider{Count: 200, AutoIDs: true},
+			ReplayBudget:   sse.ReplayBudget{EventsPerSecond: 100},
+		}
+		defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		for i := 0; i < 150; i++ {
+			require.NoError(t, j.Publish(msg(t, "replayed", ""), []string{sse.DefaultTopic}))
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		first, second := &ptrClient{}, &ptrClient{}
+		firstErrs := subscribeAsync(j, ctx, first)
+		require.Eventually(t, func() bool { return len(first.Messages()) == 150 }, time.Second, time.Millisecond, "first replay not done")
+
+		// The first replay exceeded the budget, so the second one waits for it to be refilled.
+		secondErrs := subscribeAsync(j, ctx, second)
+		require.Eventually(t, func() bool { return j.Stats().QueuedReplays == 1 }, time.Second, time.Millisecond, "replay not queued")
+
+		require.NoError(t, j.Publish(msg(t, "live", ""), []string{sse.DefaultTopic}))
+		require.NoError(t, j.Ping(context.Background()))
+		require.Len(t, first.Messages(), 151, "live message not received")
+		require.Empty(t, second.Messages(), "queued subscriber received messages")
+
+		require.Eventually(t, func() bool { return len(second.Messages()) == 151 }, 2*time.Second, time.Millisecond, "queued replay not done")
+		require.Equal(t, 0, j.Stats().QueuedReplays, "replay still queued")
+		require.Equal(t, msgStrings(first.Messages()), msgStrings(second.Messages()), "events lost or duplicated")
+
+		cancel()
+		require.NoError(t, <-firstErrs)
+		require.NoError(t, <-secondErrs)
+	})
+
+	t.Run("SetReplayBudget", func(t *testing.T) {
+		t.Parallel()
+
+		started, release := make(chan struct{}, 2), make(chan struct{})
+		j := &sse.Joe{
+			Backfill: func(_ context.Context, _ sse.Subscription) (sse.EventID, error) {
+				started <- struct{}{}
+				<-release
+				return sse.EventID{}, nil
+			},
+			ReplayBudget: sse.ReplayBudget{MaxConcurrent: 1},
+		}
+		defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		firstErrs := subscribeAsync(j, ctx, &ptrClient{})
+		<-started
+
+		secondErrs := subscribeAsync(j, ctx, &ptrClient{})
+		require.Eventually(t, func() bool { return j.Stats().QueuedReplays == 1 }, time.Second, time.Millisecond, "backfill not queued")
+
+		require.NoError(t, j.SetReplayBudget(context.Background(), sse.ReplayBudget{MaxConcurrent: 2}))
+		<-started
+		require.Equal(t, 0, j.Stats().QueuedReplays, "backfill still queued")
+
+		close(release)
+		cancel()
+		require.NoError(t, <-firstErrs)
+		require.NoError(t, <-secondErrs)
+	})
+}
+
+func TestShardedJoe(t *testing.T) {
+	t.Parallel()
+
+	shards := make([]*sse.Joe, 4)
+	s := &sse.ShardedJoe{
+		Shards: len(shards),
+		New: func(shard int) *sse.Joe {
+			shards[shard] = &sse.Joe{}
+			return shards[shard]
+		},
+		ShardKey: func(topic string) string {
+			key, _, _ := strings.Cut(topic, "/")
+			return key
+		},
+	}
+	s.Shard("x")
+	require.Equal(t, len(shards), s.Shards, "shard count modified")
+
+	defaults := &sse.ShardedJoe{}
+	defaults.Shard("x")
+	require.Zero(t, defaults.Shards, "default shard count written to the Shards field")
+
+	a, b := "a/1", ""
+	for i := 0; b == ""; i++ {
+		if topic := "b" + strconv.Itoa(i) + "/1"; s.Shard(topic) != s.Shard(a) {
+			b = topic
+		}
+	}
+	a2 := "a/2"
+	require.Equal(t, s.Shard(a), s.Shard(a2), "topics with the same key on different shards")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	require.ErrorIs(t, s.Subscribe(ctx, sse.Subscription{Client: &ptrClient{}, Topics: []string{a, b}}), sse.ErrCrossShard, "cross-shard subscription accepted")
+	require.ErrorIs(t, s.Subscribe(ctx, sse.Subscription{Client: &ptrClient{}}), sse.ErrNoTopic, "subscription without topics accepted")
+
+	both, single := &ptrClient{}, &ptrClient{}
+	errs := make(chan error, 2)
+	go func() { errs <- s.Subscribe(ctx, sse.Subscription{Client: both, Topics: []string{a, a2}}) }()
+	go func() { errs <- s.Subscribe(ctx, sse.Subscription{Client: single, Topics: []string{b}}) }()
+
+	require.Eventually(t, func() bool {
+		return shards[s.Shard(a)].Stats().Subscribers == 1 && shards[s.Shard(b)].Stats().Subscribers == 1
+	}, time.Second, time.Millisecond, "subscriptions not created")
+
+	for i := 0; i < 50; i++ {
+		topic := a
+		if i%2 == 1 {
+			topic = a2
+		}
+		require.NoError(t, s.Publish(msg(t, strconv.Itoa(i), ""), []string{topic}))
+	}
+	require.NoError(t, s.Publish(msg(t, "both", ""), []string{a, a2}))
+	require.NoError(t, s.Publish(msg(t, "b", ""), []string{b}))
+	// A cross-shard message is published to each shard, and received once.
+	require.NoError(t, s.Publish(msg(t, "cross", ""), []string{a, b, a2}))
+
+	require.Eventually(t, func() bool {
+		return len(both.Messages()) == 52 && len(single.Messages()) == 2
+	}, time.Second, time.Millisecond, "messages not received")
+
+	// The messages of the co-located topics are received once, in the order they were published.
+	expected := make([]string, 0, 52)
+	for i := 0; i < 50; i++ {
+		expected = append(expected, "data: "+strconv.Itoa(i)+"\n\n")
+	}
+	expected = append(expected, "data: both\n\n", "data: cross\n\n")
+	require.Equal(t, expected, msgStrings(both.Messages()), "invalid messages")
+	require.Equal(t, []string{"data: b\n\n", "data: cross\n\n"}, msgStrings(single.Messages()), "invalid messages")
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	require.ErrorIs(t, <-errs, sse.ErrProviderClosed, "invalid subscribe error")
+	require.ErrorIs(t, <-errs, sse.ErrProviderClosed, "invalid subscribe error")
+	require.ErrorIs(t, s.Shutdown(context.Background()), sse.ErrProviderClosed, "shut down twice")
+}
+
+func BenchmarkJoe_dispatch(b *testing.B) {
+	for _, interruptible := range []bool{false, true} {
+		interruptible := interruptible
+		b.Run("InterruptibleWrites="+strconv.FormatBool(interruptible), func(b *testing.B) {
+			const subscribers = 100
+
+			j := &sse.Joe{InterruptibleWrites: interruptible}
+			defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			client := mockClient(func(*sse.Message) error { return nil })
+			for i := 0; i < subscribers; i++ {
+				go j.Subscribe(ctx, sse.Subscription{Client: client, Topics: []string{sse.DefaultTopic}}) //nolint:errcheck // irrelevant
+			}
+			for j.Stats().Subscribers < subscribers {
+				time.Sleep(time.Millisecond)
+			}
+
+			m := msg(b, "hello", "")
+
+			b.ReportAllocs()
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				_ = j.Publish(m, []string{sse.DefaultTopic})
+			}
+			_ = j.Ping(context.Background())
+		})
+	}
+}
+
+func TestJoe_ReleaseMessages(t *testing.T) {
+	t.Parallel()
+
+	providers := map[string]sse.ReplayProvider{
+		"No replay": nil,
+		"Replay":    &sse.FiniteReplayProvider{Count: 10, AutoIDs: true},
+	}
+
+	for name, rp := range providers {
+		rp := rp
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			j := &sse.Joe{ReleaseMessages: true, ReplayProvider: rp}
+			defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var received []string
+			go j.Subscribe(ctx, sse.Subscription{ //nolint:errcheck // irrelevant
+				Client: mockClient(func(m *sse.Message) error {
+					if m != nil {
+						received = append(received, m.String())
+					}
+					return nil
+				}),
+				Topics: []string{sse.DefaultTopic},
+			})
+			require.Eventually(t, func() bool { return j.Stats().Subscribers == 1 }, time.Second, time.Millisecond, "subscriber not added")
+
+			published := sse.AcquireMessage()
+			published.AppendData("hello")
+			require.NoError(t, j.Publish(published, []string{sse.DefaultTopic}))
+			require.NoError(t, j.Ping(context.Background()))
+
+			require.Empty(t, published.String(), "message not released")
+
+			// The released message is reused, which must not affect what Joe holds.
+			reused := sse.AcquireMessage()
+			reused.AppendData("other")
+
+			if rp == nil {
+				require.Equal(t, []string{"data: hello\n\n"}, received, "invalid message received")
+				return
+			}
+			require.Equal(t, []string{"id: 0\ndata: hello\n\n"}, received, "invalid message received")
+			require.Equal(t, []string{"id: 0\ndata: hello\n\n"}, msgStrings(replay(t, rp, sse.ID("-1"))), "replayed message corrupted by release")
+		})
+	}
+}
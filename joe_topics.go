@@ -0,0 +1,274 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// topicLevelSeparator separates the levels of hierarchical topics. See Joe's HierarchicalTopics field.
+const topicLevelSeparator = "/"
+
+// topicTrie holds the subscribers of the topics. Each node is a level of a topic: if topics are hierarchical,
+// they are split into their levels, so the subscribers of a topic's ancestors are found on the way to the topic.
+// Otherwise, each topic has a single level and the trie is a map of the topics to their subscribers.
+type topicTrie struct {
+	subscribers subscribers
+	children    map[string]*topicTrie
+}
+
+// add returns the subscribers of the topic with the given levels, creating the topic's nodes if needed.
+func (t *topicTrie) add(levels []string) subscribers {
+	n := t
+	for _, level := range levels {
+		child := n.children[level]
+		if child == nil {
+			if n.children == nil {
+				n.children = map[string]*topicTrie{}
+			}
+			child = &topicTrie{}
+			n.children[level] = child
+		}
+		n = child
+	}
+
+	if n.subscribers == nil {
+		n.subscribers = subscribers{}
+	}
+	return n.subscribers
+}
+
+// remove removes the subscriber from the topic with the given levels, together with the nodes left
+// without subscribers and children. It returns true if the topic has no subscribers anymore.
+func (t *topicTrie) remove(levels []string, sub subscriber) bool {
+	if len(levels) == 0 {
+		delete(t.subscribers, sub)
+		return len(t.subscribers) == 0
+	}
+
+	child := t.children[levels[0]]
+	if child == nil {
+		return true
+	}
+
+	empty := child.remove(levels[1:], sub)
+	if len(child.subscribers) == 0 && len(child.children) == 0 {
+		delete(t.children, levels[0])
+	}
+
+	return empty
+}
+
+// get returns the subscribers of the topic with the given levels, if it has any.
+func (t *topicTrie) get(levels []string) subscribers {
+	n := t
+	for _, level := range levels {
+		if n = n.children[level]; n == nil {
+			return nil
+		}
+	}
+
+	return n.subscribers
+}
+
+// walk calls fn with the subscribers of the topic with the given levels and of its ancestors,
+// from the topmost ancestor to the topic. Nodes without subscribers are skipped.
+func (t *topicTrie) walk(levels []string, fn func(subs subscribers)) {
+	n := t
+	for _, level := range levels {
+		if n = n.children[level]; n == nil {
+			return
+		}
+		if len(n.subscribers) > 0 {
+			fn(n.subscribers)
+		}
+	}
+}
+
+// each calls fn with the levels of each topic which has subscribers.
+func (t *topicTrie) each(levels []string, fn func(levels []string)) {
+	if len(t.subscribers) > 0 {
+		fn(levels)
+	}
+	for level, child := range t.children {
+		child.each(append(levels, level), fn)
+	}
+}
+
+// Topics returns, sorted, the topics which currently have subscribers. The patterns subscribed to
+// with WildcardTopics are not included, as messages are published to topics, not patterns.
+// The topics are read on Joe's event loop, so they may change right after Topics returns.
+func (j *Joe) Topics(ctx context.Context) ([]string, error) {
+	var topics []string
+	if err := j.run(ctx, func() {
+		j.topics.each(nil, func(levels []string) {
+			topics = append(topics, strings.Join(levels, topicLevelSeparator))
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(topics)
+	return topics, nil
+}
+
+// SubscriberCount returns the number of subscribers which receive the messages published to the topic:
+// those subscribed to the topic itself, to its ancestors and to the patterns it matches. A subscriber
+// which receives the topic's messages through multiple of these is counted once.
+func (j *Joe) SubscriberCount(ctx context.Context, topic string) (int, error) {
+	var count int
+	if err := j.run(ctx, func() {
+		counted := map[subscriber]struct{}{}
+		j.forEachSubscribers(topic, func(subs subscribers) {
+			for sub := range subs {
+				counted[sub] = struct{}{}
+			}
+		})
+		count = len(counted)
+	}); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// topicLevels returns the levels of the topic in Joe's topic trie.
+func (j *Joe) topicLevels(topic string) []string {
+	if j.HierarchicalTopics {
+		return strings.Split(topic, topicLevelSeparator)
+	}
+	return []string{topic}
+}
+
+// isPattern tells whether the subscribed topic is a pattern. See the WildcardTopics field.
+func (j *Joe) isPattern(topic string) bool {
+	return j.WildcardTopics && isTopicPattern(topic)
+}
+
+// topic returns the subscribers of the subscribed topic, registering the topic if needed.
+func (j *Joe) topic(identifier string) subscribers {
+	if !j.isPattern(identifier) {
+		return j.topics.add(j.topicLevels(identifier))
+	}
+
+	if _, ok := j.patterns[identifier]; !ok {
+		j.patterns[identifier] = subscribers{}
+	}
+	return j.patterns[identifier]
+}
+
+// removeFromTopic removes the subscriber from the subscribed topic and marks the topic as idle
+// if it has no subscribers anymore.
+func (j *Joe) removeFromTopic(topic string, sub subscriber) {
+	if !j.isPattern(topic) {
+		if j.topics.remove(j.topicLevels(topic), sub) {
+			j.markIdle(topic)
+		}
+		return
+	}
+
+	subs := j.patterns[topic]
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(j.patterns, topic)
+	}
+}
+
+// ErrTopicFull is wrapped by the *TopicFullError returned when a topic has reached its subscriber limit.
+var ErrTopicFull = errors.New("go-sse.server: topic has too many subscribers")
+
+// TopicFullError is returned by Joe when a subscription to a topic would exceed the topic's subscriber limit.
+// See Joe's TopicSubscriberLimit field. The Server responds to the sessions whose subscription fails with it
+// like to those rejected because of its own limits – see Server.OnReject.
+type TopicFullError struct {
+	Topic string
+	Limit int
+}
+
+func (e *TopicFullError) Error() string {
+	return fmt.Sprintf("%v: topic %q is limited to %d", ErrTopicFull, e.Topic, e.Limit)
+}
+
+func (e *TopicFullError) Unwrap() error {
+	return ErrTopicFull
+}
+
+// checkTopicLimits returns a *TopicFullError if subscribing the subscriber to any of the topics
+// would exceed the topic's subscriber limit. The topics the subscriber already has are not checked.
+func (j *Joe) checkTopicLimits(sub subscriber, topics []string) error {
+	if j.TopicSubscriberLimit == nil {
+		return nil
+	}
+
+	for _, topic := range topics {
+		limit := j.TopicSubscriberLimit(topic)
+		if limit <= 0 {
+			continue
+		}
+
+		var subs subscribers
+		if j.isPattern(topic) {
+			subs = j.patterns[topic]
+		} else {
+			subs = j.topics.get(j.topicLevels(topic))
+		}
+
+		if _, ok := subs[sub]; !ok && len(subs) >= limit {
+			return &TopicFullError{Topic: topic, Limit: limit}
+		}
+	}
+
+	return nil
+}
+
+// forEachSubscribers calls fn with the subscribers which receive the messages published to the topic:
+// those of the topic itself, of its ancestors and of the patterns it matches. It returns false if fn
+// wasn't called, because the topic has no subscribers.
+func (j *Joe) forEachSubscribers(topic string, fn func(subs subscribers)) bool {
+	subscribed := false
+	j.topics.walk(j.topicLevels(topic), func(subs subscribers) {
+		subscribed = true
+		fn(subs)
+	})
+
+	for pattern, subs := range j.patterns {
+		if matchTopicPattern(pattern, topic) {
+			subscribed = true
+			fn(subs)
+		}
+	}
+
+	return subscribed
+}
+
+// subscribedIndirectly tells whether the topic has subscribers through its ancestors or the patterns it matches.
+func (j *Joe) subscribedIndirectly(topic string) bool {
+	if j.HierarchicalTopics {
+		levels := j.topicLevels(topic)
+		subscribed := false
+		j.topics.walk(levels[:len(levels)-1], func(subscribers) { subscribed = true })
+		if subscribed {
+			return true
+		}
+	}
+
+	for pattern := range j.patterns {
+		if matchTopicPattern(pattern, topic) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchTopic tells whether the messages published to a topic are sent to the subscribers of another topic,
+// other than itself – a pattern or an ancestor.
+func (j *Joe) matchTopic(subscribed, published string) bool {
+	if j.isPattern(subscribed) {
+		return matchTopicPattern(subscribed, published)
+	}
+
+	return j.HierarchicalTopics && strings.HasPrefix(published, subscribed+topicLevelSeparator)
+}
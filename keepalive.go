@@ -0,0 +1,97 @@
+package sse
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// newKeepAliveMessage creates the comment the Server sends to keep idle connections alive.
+// The comment announces the interval, so clients using ParseKeepAliveHint adjust their idle timeout.
+func newKeepAliveMessage(interval time.Duration) *Message {
+	value := interval.String()
+	if interval%time.Second == 0 {
+		value = strconv.FormatInt(int64(interval/time.Second), 10)
+	}
+
+	m := &Message{}
+	m.AppendComment("ka-interval=" + value)
+	return m
+}
+
+// keepAliveWriter sends a keep-alive comment to the client it wraps when nothing was sent
+// to it for the keep-alive interval. The writes of the provider and the keep-alives are serialized,
+// so the provider's guarantee of not writing concurrently to the client is kept.
+type keepAliveWriter struct {
+	MessageWriter
+	mu sync.Mutex
+	// lastWrite is the time of the last message or keep-alive sent to the client.
+	lastWrite time.Time
+}
+
+func (w *keepAliveWriter) Send(m *Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.lastWrite = time.Now()
+	return w.MessageWriter.Send(m)
+}
+
+func (w *keepAliveWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.MessageWriter.Flush()
+}
+
+// startKeepAlive wraps the client so keep-alives are sent to it at the given interval. The returned
+// function stops the keep-alives and returns after the client isn't used for them anymore.
+func startKeepAlive(client MessageWriter, interval time.Duration) (MessageWriter, func()) {
+	w := &keepAliveWriter{MessageWriter: client, lastWrite: time.Now()}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		w.run(interval, stop)
+	}()
+
+	return w, func() {
+		close(stop)
+		<-stopped
+	}
+}
+
+// run sends the keep-alives until stopped or until a keep-alive fails to be sent.
+func (w *keepAliveWriter) run(interval time.Duration, stop <-chan struct{}) {
+	keepAlive := newKeepAliveMessage(interval)
+
+	t := time.NewTimer(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+
+		w.mu.Lock()
+		wait := interval - time.Since(w.lastWrite)
+		if wait <= 0 {
+			err := w.MessageWriter.Send(keepAlive)
+			if err == nil {
+				err = w.MessageWriter.Flush()
+			}
+			if err != nil {
+				// The provider will see the failure on its next write and end the subscription.
+				w.mu.Unlock()
+				return
+			}
+
+			w.lastWrite, wait = time.Now(), interval
+		}
+		w.mu.Unlock()
+
+		t.Reset(wait)
+	}
+}
@@ -0,0 +1,186 @@
+package sse
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LongPollLastEventIDQueryParam is the name of the query parameter in which long-polling clients can send
+// the ID of the last event they received, instead of the Last-Event-ID header.
+const LongPollLastEventIDQueryParam = "lastEventId"
+
+// LongPollEvent is the JSON representation of an event in the responses of a LongPollHandler.
+type LongPollEvent struct {
+	// ID is the event's ID. It is omitted if the event has no ID.
+	ID string `json:"id,omitempty"`
+	// Type is the event's type. It is DefaultEventType if the event has no type.
+	Type string `json:"event"`
+	// Data is the event's data, with multiple data fields joined using LF, as EventSource does.
+	Data string `json:"data"`
+	// Retry is the event's reconnection delay, in milliseconds. It is omitted if the event has none.
+	Retry int64 `json:"retry,omitempty"`
+}
+
+// LongPollHandler serves the events of a Server using long-polling, as a fallback for the clients behind
+// proxies which buffer or break event streams. Each request is subscribed to the Server's provider like
+// a session of ServeHTTP – OnSession and ValidateTopic are used – and it receives the events replayed for
+// its last event ID or, if there are none, it waits for the next published events. The first batch of events
+// the provider flushes is then sent in the response, as a JSON array of LongPollEvent values, and the client
+// makes the next request with the ID of the last event it received.
+//
+// The last event ID is read from the Last-Event-ID header or from the LongPollLastEventIDQueryParam query
+// parameter. The events published between two requests are received only if they are replayed, so use
+// a replay provider which sets the events' IDs, such as Joe with a FiniteReplayProvider with AutoIDs.
+//
+// If no events are published before the Timeout, the response is an empty array. If the provider was shut
+// down, the response has a 503 Service Unavailable status code. Events without data are not sent, as
+// EventSource wouldn't dispatch them either. The fields must not be modified after the LongPollHandler
+// is used.
+type LongPollHandler struct {
+	// Server is the server whose provider and subscription callbacks are used. It must not be nil.
+	Server *Server
+	// Timeout is for how long a request waits for events. Defaults to 30 seconds, which is less than
+	// the idle timeout of most proxies.
+	Timeout time.Duration
+}
+
+const defaultLongPollTimeout = 30 * time.Second
+
+// ServeHTTP implements the long-polling endpoint. See LongPollHandler for more information.
+func (h *LongPollHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := h.Server
+	s.init()
+
+	l := s.logger(r)
+
+	sess, err := Upgrade(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !sess.LastEventID.IsSet() {
+		if id, err := NewID(r.URL.Query().Get(LongPollLastEventIDQueryParam)); err == nil {
+			sess.LastEventID = id
+		}
+	}
+
+	sub, ok := s.getSubscription(sess)
+	if !ok {
+		return
+	}
+	if err = s.validateTopics(sub.Topics); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sub.TopicAttribution == NoTopicAttribution {
+		sub.TopicAttribution = s.TopicAttribution
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = defaultLongPollTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+
+	collector := &longPollWriter{ready: make(chan struct{})}
+	sub.Client = collector
+
+	subscribed := make(chan error, 1)
+	go func() { subscribed <- s.provider.Subscribe(ctx, sub) }()
+
+	select {
+	case <-collector.ready:
+		cancel()
+		err = <-subscribed
+	case err = <-subscribed:
+	}
+
+	events := collector.events()
+	if len(events) == 0 && err != nil {
+		if l != nil {
+			l.ErrorContext(r.Context(), "sse: long-polling subscribe error", "err", err)
+		}
+
+		code := http.StatusInternalServerError
+		if errors.Is(err, ErrProviderClosed) {
+			code = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err = json.NewEncoder(w).Encode(events); err != nil && l != nil {
+		l.ErrorContext(r.Context(), "sse: failed to send long-polling events", "err", err)
+	}
+}
+
+// longPollWriter collects the events sent to a long-polling request, until the first flush
+// after which it has any.
+type longPollWriter struct {
+	mu       sync.Mutex
+	messages []*Message
+	ready    chan struct{}
+	isReady  bool
+}
+
+func (w *longPollWriter) Send(m *Message) error {
+	if !m.hasData() {
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.messages = append(w.messages, m)
+	return nil
+}
+
+func (w *longPollWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.messages) > 0 && !w.isReady {
+		w.isReady = true
+		close(w.ready)
+	}
+	return nil
+}
+
+// events returns the collected events.
+func (w *longPollWriter) events() []LongPollEvent {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	events := make([]LongPollEvent, 0, len(w.messages))
+	for _, m := range w.messages {
+		typ := DefaultEventType
+		if m.Type.IsSet() {
+			typ = m.Type.String()
+		}
+
+		var data []string
+		for _, c := range m.chunks {
+			if !c.isComment {
+				data = append(data, c.content)
+			}
+		}
+
+		events = append(events, LongPollEvent{
+			ID:    m.ID.String(),
+			Type:  typ,
+			Data:  strings.Join(data, "\n"),
+			Retry: m.Retry.Milliseconds(),
+		})
+	}
+
+	return events
+}
@@ -2,8 +2,10 @@ package sse
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
@@ -56,10 +58,19 @@ func (c *chunk) WriteTo(w io.Writer) (int64, error) {
 // Message is the representation of an event sent from the server to its clients.
 type Message struct {
 	chunks []chunk
+	// raw is the original encoding of a message created with NewRawMessage.
+	raw *rawEncoding
 
-	ID    EventID
+	ID EventID
+	// Type is the type of the message's event. The DefaultEventType and the empty type
+	// are canonicalized: messages of these types are encoded without the "event" field.
 	Type  EventType
 	Retry time.Duration
+	// TTL optionally overrides for how long replay providers which expire messages, such as ValidReplayProvider,
+	// hold the message. A positive value is used instead of the provider's TTL, so the message can be replayed
+	// for a longer or shorter time than the others. It is not part of the event sent to clients, so it is
+	// not encoded and providers which transmit the messages in their encoded form don't preserve it.
+	TTL time.Duration
 }
 
 func (e *Message) appendText(isComment bool, chunks ...string) {
@@ -145,9 +156,23 @@ func (e *Message) writeID(w io.Writer) (int64, error) {
 }
 
 func (e *Message) writeType(w io.Writer) (int64, error) {
+	if isDefaultEventType(e.Type) {
+		return 0, nil
+	}
 	return e.writeMessageField(w, e.Type.messageField, fieldBytesEvent)
 }
 
+// DefaultEventType is the type of the events without an "event" field, or with an empty one.
+// Clients treat the three forms the same, so the canonical form is the shortest one:
+// messages of this type are encoded without the "event" field, and the client delivers
+// events of this type with an empty type. See Message.Type and Connection.SubscribeEvent.
+const DefaultEventType = "message"
+
+// isDefaultEventType returns whether the type is the default one, which is encoded without an "event" field.
+func isDefaultEventType(t EventType) bool {
+	return !t.IsSet() || t.value == "" || t.value == DefaultEventType
+}
+
 func (e *Message) writeRetry(w io.Writer) (int64, error) {
 	millis := e.Retry.Milliseconds()
 	if millis <= 0 {
@@ -180,6 +205,11 @@ func (e *Message) writeRetry(w io.Writer) (int64, error) {
 // WriteTo writes the standard textual representation of the message's event to an io.Writer.
 // This operation is heavily optimized, so it is strongly preferred over MarshalText or String.
 func (e *Message) WriteTo(w io.Writer) (int64, error) {
+	if e.raw.matches(e) {
+		n, err := writeString(w, e.raw.text)
+		return int64(n), err
+	}
+
 	n, err := e.writeID(w)
 	if err != nil {
 		return n, err
@@ -208,6 +238,43 @@ func (e *Message) WriteTo(w io.Writer) (int64, error) {
 	return int64(o) + n, err
 }
 
+// EncodedLen returns the number of bytes WriteTo writes for the message, without encoding it.
+// Use it to enforce size limits before a message is published.
+//
+// Replay providers that automatically assign IDs set the ID after the message is published,
+// so the size of the message that is sent to clients is bigger than what EncodedLen
+// reports if the ID is not already set.
+func (e *Message) EncodedLen() int {
+	if e.raw.matches(e) {
+		return len(e.raw.text)
+	}
+
+	n := 0
+	if e.ID.IsSet() {
+		n += len(fieldBytesID) + len(e.ID.String()) + len(newline)
+	}
+	if !isDefaultEventType(e.Type) {
+		n += len(fieldBytesEvent) + len(e.Type.String()) + len(newline)
+	}
+	if millis := e.Retry.Milliseconds(); millis > 0 {
+		n += len(fieldBytesRetry) + len(newline)
+		for ; millis != 0; millis /= 10 {
+			n++
+		}
+	}
+	for i := range e.chunks {
+		name := fieldBytesData
+		if e.chunks[i].isComment {
+			name = fieldBytesComment
+		}
+		n += len(name) + len(e.chunks[i].content) + len(newline)
+	}
+	if n == 0 {
+		return 0
+	}
+	return n + len(newline)
+}
+
 // MarshalText writes the standard textual representation of the message's event. Marshalling and unmarshalling will
 // result in a message with an event that has the same fields; topic will be lost.
 //
@@ -262,9 +329,11 @@ var ErrUnexpectedEOF = parser.ErrUnexpectedEOF
 
 func (e *Message) reset() {
 	e.chunks = nil
+	e.raw = nil
 	e.Type = EventType{}
 	e.ID = EventID{}
 	e.Retry = 0
+	e.TTL = 0
 }
 
 // UnmarshalText extracts the first event found in the given byte slice into the
@@ -309,7 +378,12 @@ loop:
 				}
 			}
 
-			e.Retry = time.Duration(milli) * time.Millisecond
+			if milli > math.MaxInt64/int64(time.Millisecond) {
+				// Clamp the values that overflow the duration, like the client does.
+				e.Retry = math.MaxInt64
+			} else {
+				e.Retry = time.Duration(milli) * time.Millisecond
+			}
 		case parser.FieldNameData, parser.FieldNameComment:
 			e.chunks = append(e.chunks, chunk{content: f.Value, isComment: f.Name == parser.FieldNameComment})
 		case parser.FieldNameEvent:
@@ -334,15 +408,110 @@ loop:
 	return nil
 }
 
+// hasData returns whether the message has data fields.
+func (e *Message) hasData() bool {
+	for i := range e.chunks {
+		if !e.chunks[i].isComment {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmpty returns whether the message has no fields, so it is encoded to nothing.
+func (e *Message) isEmpty() bool {
+	return len(e.chunks) == 0 && !e.ID.IsSet() && isDefaultEventType(e.Type) && e.Retry == 0
+}
+
+// ErrInvalidRawMessage is returned by NewRawMessage if the given bytes are not a single complete event.
+var ErrInvalidRawMessage = errors.New("go-sse: invalid raw message")
+
+// rawEncoding is the original encoding of a message, along with the fields it was parsed into.
+type rawEncoding struct {
+	text   string
+	id     EventID
+	typ    EventType
+	retry  time.Duration
+	chunks int
+}
+
+// matches returns whether the raw encoding still represents the message – that is, if the message
+// wasn't modified after it was created. It returns false if there is no raw encoding.
+func (r *rawEncoding) matches(e *Message) bool {
+	return r != nil && r.id == e.ID && r.typ == e.Type && r.retry == e.Retry && r.chunks == len(e.chunks)
+}
+
+// NewRawMessage creates a message from an already encoded event, such as one received from an upstream
+// server, which is sent to clients exactly as given, without being encoded again. The input must be
+// a single complete event in the event stream format: it must end with a blank line and it must not contain
+// any other blank lines, as they would end the event early and corrupt the stream. Otherwise, an error
+// wrapping ErrInvalidRawMessage is returned.
+//
+// The event's fields are parsed, so the message's ID and type are available to replay providers,
+// for example. If the message is modified after it is created – if a replay provider sets its ID,
+// for example –, it is encoded again, as usual.
+func NewRawMessage(b []byte) (*Message, error) {
+	text := string(b)
+	if !utf8.ValidString(text) {
+		return nil, fmt.Errorf("%w: not valid UTF-8", ErrInvalidRawMessage)
+	}
+
+	for remaining, lines := text, 0; ; lines++ {
+		line, rest, hasNewline := parser.NextChunk(remaining)
+		if !hasNewline {
+			return nil, fmt.Errorf("%w: the event doesn't end with a blank line", ErrInvalidRawMessage)
+		}
+		if line == "" {
+			if lines == 0 {
+				return nil, fmt.Errorf("%w: the event starts with a blank line", ErrInvalidRawMessage)
+			}
+			if rest != "" {
+				return nil, fmt.Errorf("%w: there is data after the blank line which ends the event", ErrInvalidRawMessage)
+			}
+			break
+		}
+		remaining = rest
+	}
+
+	m := &Message{}
+	if err := m.UnmarshalText(b); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRawMessage, err)
+	}
+	m.raw = &rawEncoding{text: text, id: m.ID, typ: m.Type, retry: m.Retry, chunks: len(m.chunks)}
+
+	return m, nil
+}
+
+// EncodedMessage is a message whose event is encoded once, when the message is created with Message.Encode.
+// The encoding is written as is to every client the message is sent to, instead of the message being encoded
+// again for each of them, which saves work when a message is published to many subscribers.
+//
+// Publish or send the embedded Message as usual. If the message is modified – if a replay provider sets
+// its ID, for example, or if it is annotated with its topic –, it is encoded again, as usual.
+type EncodedMessage struct {
+	*Message
+}
+
+// Encode returns a copy of the message which holds the message's encoding. The message itself is not modified.
+// See EncodedMessage for more information.
+func (e *Message) Encode() EncodedMessage {
+	m := e.Clone()
+	m.raw = &rawEncoding{text: e.String(), id: m.ID, typ: m.Type, retry: m.Retry, chunks: len(m.chunks)}
+
+	return EncodedMessage{Message: m}
+}
+
 // Clone returns a copy of the message.
 func (e *Message) Clone() *Message {
 	return &Message{
 		// The first AppendData will trigger a reallocation.
 		// Already appended chunks cannot be modified/removed, so this is safe.
 		chunks: e.chunks[:len(e.chunks):len(e.chunks)],
+		raw:    e.raw,
 		Retry:  e.Retry,
 		Type:   e.Type,
 		ID:     e.ID,
+		TTL:    e.TTL,
 	}
 }
 
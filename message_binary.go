@@ -0,0 +1,38 @@
+package sse
+
+import "encoding/base64"
+
+// BinaryDataComment is the comment which precedes the data of the events whose data is binary. Event streams
+// carry only UTF-8 text, so such data is sent encoded using standard Base64 and this marker tells the clients
+// to decode it. See Message.SetBinaryData.
+const BinaryDataComment = "data-encoding=base64"
+
+// SetBinaryData sets the message's data to arbitrary bytes – a protobuf message or a compressed blob, for example.
+// The bytes are encoded using standard Base64, with padding, and sent as a single data field, preceded by
+// a comment with the BinaryDataComment marker:
+//
+//	: data-encoding=base64
+//	data: AAEC
+//
+// The previous data is replaced and the other comments are kept. Setting binary data again replaces it,
+// without repeating the marker.
+//
+// This package's Client and Parser recognize the marker: they decode the data and set the event's Binary field.
+// If the data is not valid Base64, the event is received as sent, with Binary unset. Other clients receive
+// the Base64 text, as EventSource doesn't expose comments: browsers can decode it using atob, for example.
+func (e *Message) SetBinaryData(b []byte) {
+	// The chunks may be shared with clones, so a new slice is created.
+	chunks := make([]chunk, 0, len(e.chunks)+2)
+	for _, c := range e.chunks {
+		if c.isComment && c.content != BinaryDataComment {
+			chunks = append(chunks, c)
+		}
+	}
+
+	e.chunks = append(chunks,
+		chunk{content: BinaryDataComment, isComment: true},
+		chunk{content: base64.StdEncoding.EncodeToString(b)},
+	)
+	// Dropping the data may leave the number of chunks unchanged, so the raw encoding is cleared explicitly.
+	e.raw = nil
+}
@@ -1,6 +1,7 @@
 package sse
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -161,3 +162,51 @@ func TestFieldConstructors(t *testing.T) {
 	require.Panics(t, func() { ID("a\nb") })
 	require.Panics(t, func() { Type("a\nb") })
 }
+
+func TestCompositeID(t *testing.T) {
+	t.Parallel()
+
+	c := CompositeID{"orders": "1700000000000-0", "users": "42", "": "7"}
+
+	id, err := c.EventID()
+	require.NoError(t, err, "unexpected encoding error")
+	require.True(t, strings.HasPrefix(id.String(), compositeIDPrefix), "encoding is not versioned")
+
+	again, _ := CompositeID{"users": "42", "": "7", "orders": "1700000000000-0"}.EventID()
+	require.Equal(t, id, again, "encoding is not deterministic")
+
+	parsed, err := ParseCompositeID(id)
+	require.NoError(t, err, "unexpected parsing error")
+	require.Equal(t, c, parsed, "invalid round trip")
+
+	updated := parsed.With("users", "43")
+	require.Equal(t, "42", parsed["users"], "With modified the composite ID")
+	require.Equal(t, CompositeID{"users": "43", "archived": ""}.Only([]string{"users"}), updated.Only([]string{"users", "deleted"}), "invalid subset")
+
+	empty, err := CompositeID{}.EventID()
+	require.NoError(t, err, "unexpected encoding error")
+	parsed, err = ParseCompositeID(empty)
+	require.NoError(t, err, "unexpected parsing error")
+	require.Empty(t, parsed, "invalid round trip")
+
+	_, err = CompositeID{"topic": strings.Repeat("a", MaxCompositeIDLength)}.EventID()
+	require.ErrorIs(t, err, ErrCompositeIDTooLong, "too long ID encoded")
+	_, err = ParseCompositeID(ID(compositeIDPrefix + strings.Repeat("a", MaxCompositeIDLength)))
+	require.ErrorIs(t, err, ErrCompositeIDTooLong, "too long ID parsed")
+
+	invalid := []string{
+		"12",
+		"c2.AQE",
+		compositeIDPrefix + "!!",
+		// The position is missing.
+		compositeIDPrefix + compositeIDEncoding.EncodeToString([]byte{1, 'a'}),
+		// The position is truncated.
+		compositeIDPrefix + compositeIDEncoding.EncodeToString([]byte{1, 'a', 3, 'b'}),
+		// The topic is duplicated.
+		compositeIDPrefix + compositeIDEncoding.EncodeToString([]byte{1, 'a', 1, 'b', 1, 'a', 1, 'c'}),
+	}
+	for _, s := range invalid {
+		_, err = ParseCompositeID(ID(s))
+		require.ErrorIs(t, err, ErrInvalidCompositeID, "invalid ID %q parsed", s)
+	}
+}
@@ -0,0 +1,59 @@
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// NewJSONMessage creates a message of the given event type whose data is the JSON encoding of v.
+// The type is optional: if it is empty, the message has no type. An error is returned if the type
+// is invalid or if v can't be encoded. See SetJSONData for more information.
+func NewJSONMessage(eventType string, v any) (*Message, error) {
+	m := &Message{}
+	if eventType != "" {
+		typ, err := NewType(eventType)
+		if err != nil {
+			return nil, err
+		}
+		m.Type = typ
+	}
+
+	if err := m.SetJSONData(v); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// SetJSONData replaces the message's data with the JSON encoding of v. The comments are kept.
+// The encoding is compact – the newlines inside strings are escaped –, so it is sent as a single
+// data field and clients receive it exactly as it was encoded. Use Event.DecodeJSON to decode it.
+func (e *Message) SetJSONData(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("go-sse: failed to encode JSON data: %w", err)
+	}
+
+	// The chunks may be shared with clones, so a new slice is created.
+	chunks := make([]chunk, 0, len(e.chunks)+1)
+	for _, c := range e.chunks {
+		if c.isComment {
+			chunks = append(chunks, c)
+		}
+	}
+
+	e.chunks = append(chunks, chunk{content: string(data)})
+	// The number of chunks may be unchanged, so the raw encoding is dropped explicitly.
+	e.raw = nil
+
+	return nil
+}
+
+// DecodeJSON decodes the event's data, which must be JSON, into v, like json.Unmarshal.
+func (e Event) DecodeJSON(v any) error {
+	if err := json.Unmarshal([]byte(e.Data), v); err != nil {
+		return fmt.Errorf("go-sse.client: failed to decode JSON data: %w", err)
+	}
+
+	return nil
+}
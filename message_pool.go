@@ -0,0 +1,48 @@
+package sse
+
+import "sync"
+
+var messagePool = sync.Pool{New: func() any { return &Message{} }}
+
+// AcquireMessage returns an empty message from a pool, which may reuse the memory of a message
+// released with ReleaseMessage. Use it together with ReleaseMessage to reduce the garbage produced
+// when messages are created at a high rate.
+func AcquireMessage() *Message {
+	return messagePool.Get().(*Message) //nolint:forcetypeassert // The pool only holds messages.
+}
+
+// ReleaseMessage empties the message and puts it in the pool of AcquireMessage. The message must
+// not be used anymore after it is released – neither directly, nor through the clones made of it,
+// as their data may be overwritten when the message is reused.
+//
+// Release a message only when nothing holds a reference to it anymore: after it is sent directly
+// to a Session, for example, or after it is published to a provider which encodes the messages before
+// Publish returns, such as those which send them over the network. Joe holds the published messages
+// after Publish returns, so don't release them yourself: set Joe's ReleaseMessages field instead,
+// which makes Joe release them once it's done with them.
+func ReleaseMessage(m *Message) {
+	if m == nil {
+		return
+	}
+
+	// The chunks' contents are cleared so the pooled message doesn't keep them from being
+	// garbage collected, while the capacity is kept for reuse.
+	for i := range m.chunks {
+		m.chunks[i] = chunk{}
+	}
+	chunks := m.chunks[:0]
+
+	m.reset()
+	m.chunks = chunks
+
+	messagePool.Put(m)
+}
+
+// detach returns a copy of the message which doesn't share its chunks, so it stays valid
+// after the message is released.
+func (e *Message) detach() *Message {
+	m := e.Clone()
+	m.chunks = append([]chunk(nil), e.chunks...)
+
+	return m
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	"github.com/tmaxmax/go-sse/internal/parser"
+	"github.com/tmaxmax/go-sse/internal/tests"
 )
 
 func TestNew(t *testing.T) {
@@ -49,6 +50,7 @@ func TestEvent_WriteTo(t *testing.T) {
 		n, _ := e.WriteTo(w)
 		require.Zero(t, n, "bytes were written")
 		require.Empty(t, w.String())
+		require.Zero(t, e.EncodedLen(), "invalid encoded length")
 	})
 
 	t.Run("Valid", func(t *testing.T) {
@@ -66,6 +68,7 @@ func TestEvent_WriteTo(t *testing.T) {
 
 		require.Equal(t, output, w.String(), "event written incorrectly")
 		require.Equal(t, expectedWritten, written, "written byte count wrong")
+		require.Equal(t, len(output), e.EncodedLen(), "invalid encoded length")
 	})
 
 	type retryTest struct {
@@ -83,6 +86,7 @@ func TestEvent_WriteTo(t *testing.T) {
 		t.Run(fmt.Sprintf("Retry/%s", v.value), func(t *testing.T) {
 			e := &Message{Retry: v.value}
 			require.Equal(t, v.expected, e.String(), "incorrect output")
+			require.Equal(t, len(v.expected), e.EncodedLen(), "invalid encoded length")
 		})
 	}
 }
@@ -244,3 +248,174 @@ func BenchmarkEvent_WriteTo_text(b *testing.B) {
 		_, _ = ev.WriteTo(io.Discard)
 	}
 }
+
+func TestMessage_defaultEventType(t *testing.T) {
+	t.Parallel()
+
+	for _, typ := range []EventType{{}, Type(""), Type(DefaultEventType)} {
+		e := &Message{Type: typ}
+		e.AppendData("hello")
+
+		require.Equal(t, "data: hello\n\n", e.String(), "default event type encoded for %q", typ.String())
+		require.Equal(t, len("data: hello\n\n"), e.EncodedLen(), "invalid encoded length for %q", typ.String())
+	}
+
+	e := &Message{Type: Type("messages")}
+	require.Equal(t, "event: messages\n\n", e.String(), "custom event type not encoded")
+}
+
+func TestMessage_Encode(t *testing.T) {
+	t.Parallel()
+
+	m := &Message{ID: ID("1"), Type: Type("update")}
+	m.AppendData("hello")
+
+	e := m.Encode()
+	require.Nil(t, m.raw, "original message modified")
+	require.NotNil(t, e.raw, "encoding not kept")
+	require.Equal(t, m.String(), e.String(), "invalid encoding")
+	require.Equal(t, m.EncodedLen(), e.EncodedLen(), "invalid encoded length")
+
+	e.raw.text = "data: cached\n\n"
+	require.Equal(t, "data: cached\n\n", e.String(), "encoding not reused")
+
+	e.ID = ID("2")
+	require.Equal(t, "id: 2\nevent: update\ndata: hello\n\n", e.String(), "modified message not encoded again")
+}
+
+func TestNewJSONMessage(t *testing.T) {
+	t.Parallel()
+
+	type payload struct {
+		Text string `json:"text"`
+	}
+
+	m, err := NewJSONMessage("update", payload{Text: "multi\nline"})
+	require.NoError(t, err, "failed to create message")
+	require.Equal(t, "event: update\ndata: {\"text\":\"multi\\nline\"}\n\n", m.String(), "invalid message")
+
+	var decoded payload
+	require.NoError(t, Event{Data: m.chunks[0].content}.DecodeJSON(&decoded), "failed to decode data")
+	require.Equal(t, payload{Text: "multi\nline"}, decoded, "invalid decoded data")
+	require.Error(t, Event{Data: "not json"}.DecodeJSON(&decoded), "invalid data decoded")
+
+	_, err = NewJSONMessage("in\nvalid", nil)
+	require.Error(t, err, "invalid type accepted")
+	_, err = NewJSONMessage("", func() {})
+	require.Error(t, err, "unencodable value accepted")
+
+	e := m.Encode()
+	e.AppendComment("kept")
+	e.AppendData("replaced")
+	clone := e.Clone()
+	require.NoError(t, e.SetJSONData(1), "failed to set data")
+	require.Equal(t, "event: update\n: kept\ndata: 1\n\n", e.String(), "invalid message after setting data")
+	require.Equal(t, "event: update\ndata: {\"text\":\"multi\\nline\"}\n: kept\ndata: replaced\n\n", clone.String(), "clone modified")
+
+	encoded := m.Encode()
+	require.NoError(t, encoded.SetJSONData(2), "failed to set data")
+	require.Equal(t, "event: update\ndata: 2\n\n", encoded.String(), "encoding not dropped")
+}
+
+func TestMessage_SetBinaryData(t *testing.T) {
+	t.Parallel()
+
+	m := &Message{ID: ID("1")}
+	m.AppendComment("kept")
+	m.AppendData("replaced")
+	clone := m.Clone()
+
+	m.SetBinaryData([]byte{0, '\n', 0xff})
+	require.Equal(t, "id: 1\n: kept\n: data-encoding=base64\ndata: AAr/\n\n", m.String(), "invalid message")
+	require.Equal(t, "id: 1\n: kept\ndata: replaced\n\n", clone.String(), "clone modified")
+
+	m.SetBinaryData([]byte("a"))
+	require.Equal(t, "id: 1\n: kept\n: data-encoding=base64\ndata: YQ==\n\n", m.String(), "marker not replaced")
+}
+
+func TestMessagePool(t *testing.T) {
+	m := AcquireMessage()
+	require.Empty(t, m.chunks, "acquired message has chunks")
+	require.Equal(t, "", m.String(), "acquired message is not empty")
+
+	m.ID = ID("1")
+	m.Type = Type("update")
+	m.Retry = time.Second
+	m.AppendData("hello", "world")
+	m.AppendComment("comment")
+	chunks := cap(m.chunks)
+
+	ReleaseMessage(m)
+	require.Empty(t, m.chunks, "chunks not removed")
+	require.Equal(t, chunks, cap(m.chunks), "chunks capacity not kept")
+	require.Equal(t, chunk{}, m.chunks[:1][0], "chunk contents not cleared")
+	require.Equal(t, EventID{}, m.ID, "ID not cleared")
+	require.Equal(t, EventType{}, m.Type, "type not cleared")
+	require.Zero(t, m.Retry, "retry not cleared")
+
+	require.NotPanics(t, func() { ReleaseMessage(nil) }, "releasing nil message panicked")
+}
+
+func TestNewRawMessage(t *testing.T) {
+	t.Parallel()
+
+	raw := "id: 5\r\nevent: update\r\n: upstream\r\ndata: hello\r\ndata: world\r\nretry: 1000\r\n\r\n"
+	m, err := NewRawMessage([]byte(raw))
+	require.NoError(t, err, "valid raw message rejected")
+	require.Equal(t, "5", m.ID.String(), "invalid ID")
+	require.Equal(t, "update", m.Type.String(), "invalid type")
+	require.Equal(t, time.Second, m.Retry, "invalid retry")
+	require.Equal(t, raw, m.String(), "raw message was encoded again")
+	require.Equal(t, len(raw), m.EncodedLen(), "invalid encoded length")
+	require.Equal(t, raw, m.Clone().String(), "clone was encoded again")
+
+	m.ID = ID("6")
+	require.Equal(t, "id: 6\nevent: update\nretry: 1000\n: upstream\ndata: hello\ndata: world\n\n", m.String(), "modified message not encoded again")
+
+	m, _ = NewRawMessage([]byte(raw))
+	m.AppendData("again")
+	require.Contains(t, m.String(), "data: again\n", "appended data not encoded")
+
+	invalid := []string{
+		"",
+		"data: hello\n",
+		"data: hello",
+		"\ndata: hello\n\n",
+		"data: hello\n\ndata: world\n\n",
+		"data: hello\n\n\n",
+		"retry: soon\n\n",
+		"data: \xff\n\n",
+	}
+	for _, input := range invalid {
+		_, err := NewRawMessage([]byte(input))
+		require.ErrorIs(t, err, ErrInvalidRawMessage, "invalid raw message %q accepted", input)
+	}
+}
+
+func FuzzMessage_UnmarshalText(f *testing.F) {
+	for _, c := range tests.Conformance {
+		f.Add([]byte(c.Stream))
+	}
+	f.Add([]byte("id: 1\nevent: x\nretry: 1000\n: comment\ndata: a\ndata: b\n\n"))
+	f.Add([]byte("retry: 99999999999999999999\ndata: a\n\n"))
+	f.Add([]byte("retry: 9223372036855\n\n"))
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		var m Message
+		if err := m.UnmarshalText(input); err != nil {
+			return
+		}
+
+		// The encoded message must be parsed back as the same message.
+		encoded, err := m.MarshalText()
+		require.NoError(t, err, "unexpected marshal error")
+
+		var parsed Message
+		if len(encoded) == 0 {
+			require.True(t, m.isEmpty(), "non-empty message encoded to nothing")
+			return
+		}
+		require.NoError(t, parsed.UnmarshalText(encoded), "unexpected unmarshal error for %q", encoded)
+		require.Equal(t, string(encoded), parsed.String(), "message changed after round trip")
+	})
+}
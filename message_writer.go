@@ -0,0 +1,70 @@
+package sse
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// MessageWriterFunc is an adapter that allows the usage of ordinary functions as MessageWriters.
+// The function is called for each sent message. Flush is a no-op, so use this adapter only
+// for writers that do not buffer messages.
+type MessageWriterFunc func(m *Message) error
+
+// Send calls the function with the given message.
+func (f MessageWriterFunc) Send(m *Message) error { return f(m) }
+
+// Flush does nothing.
+func (f MessageWriterFunc) Flush() error { return nil }
+
+// WriterWrapper is the type of the functions that decorate a MessageWriter. Use these to implement
+// cross-cutting concerns such as instrumentation or transformation of the messages sent to a single client.
+// See CountingWriter for an example and the Server's WrapWriter field for more information.
+type WriterWrapper func(MessageWriter, *http.Request) MessageWriter
+
+// CountingWriter is a MessageWriter that counts the messages sent and the flushes
+// done through the MessageWriter it wraps. The counts can be read concurrently with
+// the writer being used.
+//
+// It serves as an example on how to decorate MessageWriters, for usage with the Server's
+// WrapWriter field:
+//
+//	s := &sse.Server{
+//		WrapWriter: func(w sse.MessageWriter, _ *http.Request) sse.MessageWriter {
+//			return &sse.CountingWriter{MessageWriter: w}
+//		},
+//	}
+type CountingWriter struct {
+	MessageWriter
+
+	messages int64
+	flushes  int64
+}
+
+// Send sends the message using the wrapped writer and increases the message count on success.
+func (c *CountingWriter) Send(m *Message) error {
+	if err := c.MessageWriter.Send(m); err != nil {
+		return err
+	}
+	atomic.AddInt64(&c.messages, 1)
+	return nil
+}
+
+// Flush flushes the wrapped writer and increases the flush count on success.
+func (c *CountingWriter) Flush() error {
+	if err := c.MessageWriter.Flush(); err != nil {
+		return err
+	}
+	atomic.AddInt64(&c.flushes, 1)
+	return nil
+}
+
+// Messages returns the number of messages successfully sent.
+func (c *CountingWriter) Messages() int64 { return atomic.LoadInt64(&c.messages) }
+
+// Flushes returns the number of successful flushes.
+func (c *CountingWriter) Flushes() int64 { return atomic.LoadInt64(&c.flushes) }
+
+var (
+	_ MessageWriter = MessageWriterFunc(nil)
+	_ MessageWriter = (*CountingWriter)(nil)
+)
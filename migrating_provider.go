@@ -0,0 +1,148 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// MigratingProvider moves the subscribers of a provider to another without dropping any connection.
+// Use it as the Server's provider in place of the old provider and call Migrate when the new provider
+// should take over. Until then, it behaves exactly like the old provider.
+//
+// After Migrate is called new subscriptions go to the new provider, while existing subscriptions stay
+// on the old one until their clients go away. Messages are published to both providers, so each
+// subscriber receives every message exactly once, from the provider it is subscribed to. When the old
+// provider has no subscribers left, Cutover returns and messages are published only to the new provider.
+// The old provider can then be shut down.
+//
+// Subscribers of the new provider don't receive messages published before Migrate was called, even if
+// the new provider replays messages, as they were never published to it. Use a replay provider on the
+// new provider only if this gap is acceptable.
+//
+// Only the subscriptions created through the MigratingProvider are tracked, so it must be used from
+// the moment the old provider is created. The MigratingProvider must not be copied after it is used.
+type MigratingProvider struct {
+	// Old is the provider the subscribers are migrated from. It must not be nil.
+	Old Provider
+	// New is the provider the subscribers are migrated to. It must not be nil.
+	New Provider
+
+	drained   chan struct{}
+	mu        sync.RWMutex
+	oldSubs   int
+	migrating bool
+	retired   bool
+}
+
+// Subscribe subscribes to the old provider, if Migrate wasn't called, or to the new provider otherwise.
+func (m *MigratingProvider) Subscribe(ctx context.Context, sub Subscription) error {
+	m.mu.Lock()
+	if m.migrating {
+		m.mu.Unlock()
+		return m.New.Subscribe(ctx, sub)
+	}
+	m.oldSubs++
+	m.mu.Unlock()
+
+	defer func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.oldSubs--
+		if m.migrating && m.oldSubs == 0 {
+			close(m.drained)
+		}
+	}()
+
+	return m.Old.Subscribe(ctx, sub)
+}
+
+// Publish publishes the message to the old provider, if Migrate wasn't called, to both providers
+// while the subscribers are migrated, and to the new provider after Cutover returns successfully.
+// If publishing to both providers, the message is published to both even if the first publish fails
+// and the first error is returned.
+func (m *MigratingProvider) Publish(msg *Message, topics []string) error {
+	// The read lock ensures that no subscriber changes providers while the message is published,
+	// so every subscriber either receives it from the old provider or from the new one.
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.migrating {
+		return m.Old.Publish(msg, topics)
+	}
+	if m.retired {
+		return m.New.Publish(msg, topics)
+	}
+
+	oldErr := m.Old.Publish(msg, topics)
+	newErr := m.New.Publish(msg, topics)
+	if oldErr != nil {
+		return oldErr
+	}
+	return newErr
+}
+
+// Migrate starts the migration: all subscriptions created after it is called go to the new provider.
+// Calling Migrate multiple times has no effect.
+func (m *MigratingProvider) Migrate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.migrating {
+		return
+	}
+
+	m.migrating = true
+	m.drained = make(chan struct{})
+	if m.oldSubs == 0 {
+		close(m.drained)
+	}
+}
+
+// Cutover starts the migration, if it wasn't started already, and waits until the old provider
+// has no subscribers left. After it returns successfully messages are published only to the new
+// provider, so the old provider can be shut down.
+//
+// If the context is done before all the subscribers leave the old provider, the context error is returned
+// and the migration continues. Cutover can be called again to wait for the remaining subscribers.
+func (m *MigratingProvider) Cutover(ctx context.Context) error {
+	m.Migrate()
+
+	select {
+	case <-m.drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	m.mu.Lock()
+	m.retired = true
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Shutdown shuts down the old provider, if Cutover didn't return successfully, and then the new provider.
+// Both providers are shut down even if shutting down the first one fails and the first error is returned.
+// ErrProviderClosed is ignored for the old provider, which may have been shut down separately after
+// the subscribers left it.
+func (m *MigratingProvider) Shutdown(ctx context.Context) error {
+	m.mu.RLock()
+	retired := m.retired
+	m.mu.RUnlock()
+
+	var oldErr error
+	if !retired {
+		if oldErr = m.Old.Shutdown(ctx); errors.Is(oldErr, ErrProviderClosed) {
+			oldErr = nil
+		}
+	}
+	newErr := m.New.Shutdown(ctx)
+	if oldErr != nil {
+		return oldErr
+	}
+
+	return newErr
+}
+
+var _ Provider = (*MigratingProvider)(nil)
@@ -0,0 +1,79 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// MultiProvider is a provider which publishes the messages to multiple providers and subscribes the clients
+// to one of them. Use it for hybrid topologies – for example, to publish both to a local Joe, to which the
+// clients of this instance subscribe, and to a distributed provider, like Redis, which delivers the messages
+// to the clients of other instances or to other consumers.
+//
+// The clients receive only the messages their provider delivers, so make sure that a message doesn't
+// reach the subscribed provider both directly and through another one – by publishing to a distributed
+// provider which forwards to the local one, for example –, or the clients receive it twice.
+// The fields must not be modified after the MultiProvider is used.
+type MultiProvider struct {
+	// Providers are the providers the messages are published to. At least one is required.
+	Providers []Provider
+	// SubscribeTo is the index in Providers of the provider the clients are subscribed to. Defaults to 0,
+	// the first provider.
+	SubscribeTo int
+}
+
+// ErrNoProviders is returned by MultiProvider if it has no providers.
+var ErrNoProviders = errors.New("go-sse.server: no providers")
+
+// Subscribe subscribes the client to the provider at the SubscribeTo index. It fails if the index
+// is out of range.
+func (m *MultiProvider) Subscribe(ctx context.Context, sub Subscription) error {
+	if len(m.Providers) == 0 {
+		return ErrNoProviders
+	}
+	if m.SubscribeTo < 0 || m.SubscribeTo >= len(m.Providers) {
+		return fmt.Errorf("go-sse.server: SubscribeTo index %d out of range for %d providers", m.SubscribeTo, len(m.Providers))
+	}
+
+	return m.Providers[m.SubscribeTo].Subscribe(ctx, sub)
+}
+
+// Publish publishes the message to all the providers, in order. The message is published to all
+// the providers even if publishing to one of them fails and the first error is returned.
+func (m *MultiProvider) Publish(msg *Message, topics []string) error {
+	if len(m.Providers) == 0 {
+		return ErrNoProviders
+	}
+
+	var err error
+	for _, p := range m.Providers {
+		pubErr := p.Publish(msg, topics)
+		if err == nil {
+			err = pubErr
+		}
+	}
+
+	return err
+}
+
+// Shutdown shuts down all the providers concurrently and waits for them. All the providers are shut down
+// even if shutting down one of them fails and the first error is returned.
+func (m *MultiProvider) Shutdown(ctx context.Context) error {
+	errs := make(chan error, len(m.Providers))
+	for _, p := range m.Providers {
+		go func(p Provider) { errs <- p.Shutdown(ctx) }(p)
+	}
+
+	var err error
+	for range m.Providers {
+		shutdownErr := <-errs
+		if err == nil {
+			err = shutdownErr
+		}
+	}
+
+	return err
+}
+
+var _ Provider = (*MultiProvider)(nil)
@@ -0,0 +1,76 @@
+package sse
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+
+	"github.com/tmaxmax/go-sse/internal/parser"
+)
+
+// DefaultMaxEventSize is the maximum size of the events read by the Client and the Parser,
+// if no other maximum is configured.
+const DefaultMaxEventSize = bufio.MaxScanTokenSize
+
+// LimitError is returned when a received event exceeds the configured maximum event size or
+// maximum field length, so a misbehaving server can't make the client allocate unbounded memory.
+// The Client's connections fail permanently with a ConnectionError which wraps it.
+type LimitError struct {
+	// Field is the name of the field which exceeded the maximum field length – "comment" for comments and
+	// "unknown" for lines which are not valid fields. It is empty if the event exceeded the maximum size.
+	Field string
+	// Limit is the exceeded limit, in bytes.
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("go-sse.client: event exceeds the maximum size of %d bytes", e.Limit)
+	}
+	return fmt.Sprintf("go-sse.client: %s field exceeds the maximum length of %d bytes", e.Field, e.Limit)
+}
+
+// parseLimits are the limits of the events read by a parser. A zero value means the default limit.
+type parseLimits struct {
+	maxEventSize   int
+	maxFieldLength int
+}
+
+// apply configures the parser to fail when events exceed the maximum size.
+// It must be called before parsing starts.
+func (l parseLimits) apply(p *parser.Parser) {
+	if l.maxEventSize > 0 {
+		p.Buffer(nil, l.maxEventSize)
+	}
+}
+
+// check returns a LimitError if the field exceeds the maximum length.
+func (l parseLimits) check(f parser.Field) error {
+	if l.maxFieldLength <= 0 || len(f.Value) <= l.maxFieldLength {
+		return nil
+	}
+
+	name := string(f.Name)
+	switch f.Name { //nolint:exhaustive // The other fields are named as they are sent.
+	case parser.FieldNameComment:
+		name = "comment"
+	case parser.FieldNameUnknown:
+		name = "unknown"
+	}
+
+	return &LimitError{Field: name, Limit: l.maxFieldLength}
+}
+
+// err returns a LimitError if the parser failed because an event exceeded the maximum size.
+func (l parseLimits) err(err error) error {
+	if !errors.Is(err, bufio.ErrTooLong) {
+		return err
+	}
+
+	limit := l.maxEventSize
+	if limit <= 0 {
+		limit = DefaultMaxEventSize
+	}
+
+	return &LimitError{Limit: limit}
+}
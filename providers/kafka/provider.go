@@ -0,0 +1,316 @@
+// Package kafka implements a go-sse provider which publishes the messages to an Apache Kafka topic,
+// so that multiple server instances send the same events to their clients and clients can resume
+// their streams from any instance, using the topic's history.
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/kadm"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/tmaxmax/go-sse"
+)
+
+// DefaultTopic is the Kafka topic to which the messages are published, by default.
+const DefaultTopic = "sse"
+
+// TopicHeader is the header of the records which holds a topic the message was published to.
+// A record has this header once for each of its topics.
+const TopicHeader = "sse-topic"
+
+// Provider is a go-sse provider which publishes each message as a record to a Kafka topic, with the message's
+// topics in the record's headers, and sends to each subscriber the messages of its topics by consuming
+// the partitions of the Kafka topic directly, without a consumer group.
+//
+// The IDs of the sent events are composite IDs (see sse.CompositeID) which hold, for each partition, the offset
+// of the next record the subscriber would receive. When a client reconnects with a last event ID, its subscription
+// starts consuming each partition from there: the events published in the meantime are replayed for as long as
+// Kafka retains them, regardless of which instance the client reconnects to. The IDs the messages are published
+// with are replaced. If the last event ID is not a composite ID, only new messages are sent. The subscriptions'
+// InclusiveReplay and MaxReplayed fields are not honored. Since the IDs have a position for each partition, topics
+// with many partitions may make the IDs too long – sse.MaxCompositeIDLength bytes fit about 50 partitions.
+//
+// Messages are ordered only within a partition: use a Kafka topic with a single partition or a client with
+// a partitioner which keeps related messages together if the order of the events matters.
+//
+// Each subscription uses its own Kafka client, created with the options of the Client field. The zero value
+// is not usable, as the Client field is required. The fields must not be modified after the provider is used.
+type Provider struct {
+	// Client is used to publish the messages. The clients of the subscriptions are created with its options,
+	// so it must not be configured to consume topics.
+	Client *kgo.Client
+	// Topic is the Kafka topic the messages are published to. It must exist, unless the Kafka cluster
+	// creates topics automatically. Defaults to DefaultTopic.
+	Topic string
+
+	// topic is the Topic used, with the default applied.
+	topic string
+	// subscriptions holds the cancel functions of the active subscriptions, so they are ended on shutdown.
+	subscriptions map[*context.CancelFunc]struct{}
+	mu            sync.Mutex
+	wg            sync.WaitGroup
+	done          chan struct{}
+	initDone      sync.Once
+}
+
+func (p *Provider) init() {
+	p.initDone.Do(func() {
+		p.topic = p.Topic
+		if p.topic == "" {
+			p.topic = DefaultTopic
+		}
+
+		p.subscriptions = map[*context.CancelFunc]struct{}{}
+		p.done = make(chan struct{})
+	})
+}
+
+// Publish produces a record with the message to the Kafka topic and waits for it to be acknowledged.
+func (p *Provider) Publish(msg *sse.Message, topics []string) error {
+	p.init()
+
+	if msg == nil {
+		return sse.ErrNilMessage
+	}
+	if len(topics) == 0 {
+		return sse.ErrNoTopic
+	}
+	if p.closed() {
+		return sse.ErrProviderClosed
+	}
+
+	data, err := msg.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	r := &kgo.Record{Topic: p.topic, Value: data, Headers: make([]kgo.RecordHeader, 0, len(topics))}
+	for _, topic := range topics {
+		r.Headers = append(r.Headers, kgo.RecordHeader{Key: TopicHeader, Value: []byte(topic)})
+	}
+
+	if err := p.Client.ProduceSync(context.Background(), r).FirstErr(); err != nil {
+		return fmt.Errorf("go-sse.kafka: publish: %w", err)
+	}
+
+	return nil
+}
+
+// Subscribe consumes the partitions of the Kafka topic and sends the messages of the subscription's topics
+// to its client, until the subscription's context is done.
+func (p *Provider) Subscribe(ctx context.Context, sub sse.Subscription) error {
+	p.init()
+
+	if len(sub.Topics) == 0 {
+		return sse.ErrNoTopic
+	}
+
+	ctx, cancel, ok := p.register(ctx)
+	if !ok {
+		return sse.ErrProviderClosed
+	}
+	defer p.unregister(cancel)
+
+	positions, err := p.startPositions(ctx, sub.LastEventID)
+	if err != nil {
+		return p.subscriptionError(fmt.Errorf("go-sse.kafka: list offsets: %w", err))
+	}
+
+	offsets := make(map[int32]kgo.Offset, len(positions))
+	for partition, offset := range positions {
+		offsets[partition] = kgo.NewOffset().At(offset)
+	}
+
+	opts := append([]kgo.Opt(nil), p.Client.Opts()...)
+	opts = append(opts,
+		kgo.ConsumePartitions(map[string]map[int32]kgo.Offset{p.topic: offsets}),
+		// Offsets which aren't retained anymore are replayed from the earliest retained record.
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+	)
+
+	consumer, err := kgo.NewClient(opts...)
+	if err != nil {
+		return fmt.Errorf("go-sse.kafka: create consumer: %w", err)
+	}
+	defer consumer.Close()
+
+	return p.subscriptionError(p.consume(ctx, consumer, sub, positions))
+}
+
+// startPositions returns the offsets from which the subscription consumes each partition:
+// the positions of the last event ID, if it has any, or the end of the partitions.
+func (p *Provider) startPositions(ctx context.Context, lastEventID sse.EventID) (map[int32]int64, error) {
+	ends, err := kadm.NewClient(p.Client).ListEndOffsets(ctx, p.topic)
+	if err == nil {
+		err = ends.Error()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	last, _ := sse.ParseCompositeID(lastEventID)
+
+	positions := map[int32]int64{}
+	ends.Each(func(o kadm.ListedOffset) {
+		positions[o.Partition] = o.Offset
+
+		offset, err := strconv.ParseInt(last[strconv.Itoa(int(o.Partition))], 10, 64)
+		if err == nil && offset >= 0 && offset < o.Offset {
+			positions[o.Partition] = offset
+		}
+	})
+
+	return positions, nil
+}
+
+// consume sends the messages of the subscription's topics to its client, until the context is done.
+func (p *Provider) consume(ctx context.Context, consumer *kgo.Client, sub sse.Subscription, positions map[int32]int64) error {
+	id := sse.CompositeID{}
+	for partition, offset := range positions {
+		id[strconv.Itoa(int(partition))] = strconv.FormatInt(offset, 10)
+	}
+
+	for {
+		fetches := consumer.PollFetches(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err := fetches.Err(); err != nil {
+			return fmt.Errorf("go-sse.kafka: fetch: %w", err)
+		}
+
+		sent := false
+		for it := fetches.RecordIter(); !it.Done(); {
+			r := it.Next()
+			id[strconv.Itoa(int(r.Partition))] = strconv.FormatInt(r.Offset+1, 10)
+
+			if !subscribed(r, sub.Topics) {
+				continue
+			}
+
+			msg := &sse.Message{}
+			if err := msg.UnmarshalText(r.Value); err != nil {
+				return fmt.Errorf("go-sse.kafka: invalid message at partition %d, offset %d: %w", r.Partition, r.Offset, err)
+			}
+
+			eventID, err := id.EventID()
+			if err != nil {
+				return fmt.Errorf("go-sse.kafka: event ID: %w", err)
+			}
+			msg.ID = eventID
+
+			if sub.Filter != nil && !sub.Filter(msg) {
+				continue
+			}
+
+			if err := sub.Client.Send(msg); err != nil {
+				return err
+			}
+			sent = true
+		}
+
+		if sent {
+			if err := sub.Client.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// subscribed tells whether the record was published to any of the given topics.
+func subscribed(r *kgo.Record, topics []string) bool {
+	for _, h := range r.Headers {
+		if h.Key != TopicHeader {
+			continue
+		}
+		for _, topic := range topics {
+			if string(h.Value) == topic {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// subscriptionError returns ErrProviderClosed instead of the given error if the subscription
+// ended because the provider was shut down.
+func (p *Provider) subscriptionError(err error) error {
+	if p.closed() {
+		return sse.ErrProviderClosed
+	}
+
+	return err
+}
+
+// register records a new subscription, so Shutdown ends it and waits for it.
+func (p *Provider) register(ctx context.Context) (context.Context, *context.CancelFunc, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed() {
+		return nil, nil, false
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	p.subscriptions[&cancel] = struct{}{}
+	p.wg.Add(1)
+
+	return ctx, &cancel, true
+}
+
+func (p *Provider) unregister(cancel *context.CancelFunc) {
+	(*cancel)()
+
+	p.mu.Lock()
+	delete(p.subscriptions, cancel)
+	p.mu.Unlock()
+
+	p.wg.Done()
+}
+
+// Shutdown ends all the subscriptions, which return ErrProviderClosed, and waits for them to end.
+// If the context is done before they end, its error is returned. The Kafka client is not closed.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.init()
+
+	p.mu.Lock()
+	if p.closed() {
+		p.mu.Unlock()
+		return sse.ErrProviderClosed
+	}
+
+	close(p.done)
+	for cancel := range p.subscriptions {
+		(*cancel)()
+	}
+	p.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Provider) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+var _ sse.Provider = (*Provider)(nil)
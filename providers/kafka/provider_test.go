@@ -0,0 +1,128 @@
+package kafka_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/twmb/franz-go/pkg/kfake"
+	"github.com/twmb/franz-go/pkg/kgo"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/providers/kafka"
+)
+
+type client struct {
+	msgs []*sse.Message
+	mu   sync.Mutex
+}
+
+func (c *client) Send(m *sse.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.msgs = append(c.msgs, m.Clone())
+	return nil
+}
+
+func (c *client) Flush() error { return nil }
+
+func (c *client) Messages() []*sse.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]*sse.Message(nil), c.msgs...)
+}
+
+func msg(tb testing.TB, data string) *sse.Message {
+	tb.Helper()
+
+	m := &sse.Message{}
+	m.AppendData(data)
+	return m
+}
+
+func newProvider(tb testing.TB, partitions int32) *kafka.Provider {
+	tb.Helper()
+
+	cluster, err := kfake.NewCluster(kfake.SeedTopics(partitions, kafka.DefaultTopic))
+	require.NoError(tb, err)
+	tb.Cleanup(cluster.Close)
+
+	cl, err := kgo.NewClient(kgo.SeedBrokers(cluster.ListenAddrs()...))
+	require.NoError(tb, err)
+	tb.Cleanup(cl.Close)
+
+	return &kafka.Provider{Client: cl}
+}
+
+func subscribe(ctx context.Context, tb testing.TB, p *kafka.Provider, sub sse.Subscription) <-chan error {
+	tb.Helper()
+
+	done := make(chan error, 1)
+	go func() { done <- p.Subscribe(ctx, sub) }()
+
+	return done
+}
+
+func data(msgs []*sse.Message) []string {
+	ret := make([]string, 0, len(msgs))
+	for _, m := range msgs {
+		ret = append(ret, m.String()[len("id: "+m.ID.String()+"\n"):])
+	}
+	return ret
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+
+	p := newProvider(t, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &client{}
+	done := subscribe(ctx, t, p, sse.Subscription{Client: c, Topics: []string{"a", "b"}})
+
+	// The subscription receives only new messages, so publish until it is ready.
+	require.Eventually(t, func() bool {
+		require.NoError(t, p.Publish(msg(t, "ready"), []string{"a"}))
+		return len(c.Messages()) > 0
+	}, 5*time.Second, 10*time.Millisecond, "subscription not ready")
+
+	require.NoError(t, p.Publish(msg(t, "both"), []string{"a", "b"}))
+	require.NoError(t, p.Publish(msg(t, "other"), []string{"c"}))
+	require.NoError(t, p.Publish(msg(t, "b"), []string{"c", "b"}))
+
+	require.Eventually(t, func() bool {
+		msgs := data(c.Messages())
+		return msgs[len(msgs)-1] == "data: b\n\n"
+	}, 5*time.Second, 10*time.Millisecond, "messages not received")
+
+	msgs := c.Messages()
+	require.Equal(t, "data: both\n\n", data(msgs)[len(msgs)-2], "invalid message published to both topics")
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// Resuming after the message published to both topics replays only the messages after it.
+	resumed := &client{}
+	replayCtx, replayCancel := context.WithCancel(context.Background())
+	defer replayCancel()
+
+	done = subscribe(replayCtx, t, p, sse.Subscription{Client: resumed, Topics: []string{"a", "b"}, LastEventID: msgs[len(msgs)-2].ID})
+	require.Eventually(t, func() bool { return len(resumed.Messages()) == 1 }, 5*time.Second, 10*time.Millisecond, "messages not replayed")
+	require.Equal(t, msgs[len(msgs)-1:], resumed.Messages(), "invalid replayed message")
+
+	id, err := sse.ParseCompositeID(resumed.Messages()[0].ID)
+	require.NoError(t, err, "event ID is not a composite ID")
+	require.Len(t, id, 3, "event ID doesn't have the positions of all partitions")
+
+	require.NoError(t, p.Shutdown(context.Background()))
+	require.ErrorIs(t, <-done, sse.ErrProviderClosed, "subscription not ended on shutdown")
+	require.ErrorIs(t, p.Shutdown(context.Background()), sse.ErrProviderClosed, "shut down twice")
+	require.ErrorIs(t, p.Publish(msg(t, "a"), []string{"a"}), sse.ErrProviderClosed, "published after shutdown")
+	require.ErrorIs(t, p.Subscribe(context.Background(), sse.Subscription{Client: c, Topics: []string{"a"}}), sse.ErrProviderClosed, "subscribed after shutdown")
+}
@@ -0,0 +1,342 @@
+// Package nats implements a go-sse provider which publishes the messages to a NATS JetStream stream,
+// so that multiple server instances send the same events to their clients and clients can resume
+// their streams from any instance, using the stream's history.
+package nats
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/tmaxmax/go-sse"
+)
+
+// Defaults for the Provider's configuration.
+const (
+	DefaultStream        = "SSE"
+	DefaultSubjectPrefix = "sse"
+)
+
+// TopicsHeader is the header of the stream's messages which holds the subject tokens of all the topics
+// the message was published to.
+const TopicsHeader = "Sse-Topics"
+
+// Provider is a go-sse provider which publishes the messages to a JetStream stream, on a subject for each topic,
+// and sends to each subscriber the messages of its topics using an ordered consumer. The stream must capture
+// the subjects of the provider – see the StreamConfig method.
+//
+// The IDs of the sent events are the sequences of the messages in the stream, so when a client reconnects with
+// a last event ID, its consumer starts right after that message: the events published in the meantime are replayed
+// for as long as the stream retains them, regardless of which instance the client reconnects to. The IDs the
+// messages are published with are replaced. If the last event ID is not a stream sequence, only new messages are sent.
+// The subscriptions' InclusiveReplay flag is honored, while MaxReplayed is not.
+//
+// A message published to multiple topics is stored once for each topic, but it is sent only once to a subscriber
+// of several of them: the copy of the first of its topics the subscriber is subscribed to is sent. For this reason,
+// a client which resumes its stream with other topics than before may receive again the messages it was sent
+// for one of its previous topics.
+//
+// Connection losses are handled by the NATS client and the ordered consumers, which resume from the last message
+// they received once the connection is reestablished. The zero value is not usable, as the JetStream field is
+// required. The fields must not be modified after the provider is used.
+type Provider struct {
+	// JetStream is used to publish and consume the messages.
+	JetStream jetstream.JetStream
+	// Stream is the name of the stream which holds the messages. Defaults to DefaultStream.
+	Stream string
+	// SubjectPrefix is the prefix of the subjects of the topics. The subject of a topic is the prefix followed
+	// by a token with the encoded topic, as topics can contain characters which are not allowed in subjects.
+	// Defaults to DefaultSubjectPrefix.
+	SubjectPrefix string
+
+	// stream and subjectPrefix are the Stream and the SubjectPrefix used, with the defaults applied.
+	stream        string
+	subjectPrefix string
+	// subscriptions holds the active subscriptions, so they are stopped on shutdown.
+	subscriptions map[*subscriptionState]struct{}
+	mu            sync.Mutex
+	wg            sync.WaitGroup
+	done          chan struct{}
+	initDone      sync.Once
+}
+
+// subscriptionState is the state of an active subscription.
+type subscriptionState struct {
+	// stop stops the subscription's consumer. It is nil until the consumer is created.
+	stop func()
+}
+
+func (p *Provider) init() {
+	p.initDone.Do(func() {
+		p.stream = p.Stream
+		if p.stream == "" {
+			p.stream = DefaultStream
+		}
+		p.subjectPrefix = p.SubjectPrefix
+		if p.subjectPrefix == "" {
+			p.subjectPrefix = DefaultSubjectPrefix
+		}
+
+		p.subscriptions = map[*subscriptionState]struct{}{}
+		p.done = make(chan struct{})
+	})
+}
+
+// StreamConfig returns the configuration of a stream which captures the provider's subjects. Use it to create
+// the stream, after setting the desired limits and retention policies – for example, the maximum age of the messages:
+//
+//	cfg := p.StreamConfig()
+//	cfg.MaxAge = time.Hour
+//	_, err := js.CreateOrUpdateStream(ctx, cfg)
+func (p *Provider) StreamConfig() jetstream.StreamConfig {
+	p.init()
+
+	return jetstream.StreamConfig{Name: p.stream, Subjects: []string{p.subjectPrefix + ".>"}}
+}
+
+// token encodes the topic as a subject token.
+func token(topic string) string {
+	return "t" + base64.RawURLEncoding.EncodeToString([]byte(topic))
+}
+
+func (p *Provider) subject(topic string) string {
+	return p.subjectPrefix + "." + token(topic)
+}
+
+// Publish stores the message in the stream once for each of the given topics.
+func (p *Provider) Publish(msg *sse.Message, topics []string) error {
+	p.init()
+
+	if msg == nil {
+		return sse.ErrNilMessage
+	}
+	if len(topics) == 0 {
+		return sse.ErrNoTopic
+	}
+	if p.closed() {
+		return sse.ErrProviderClosed
+	}
+
+	data, err := msg.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	header := nats.Header{}
+	for _, topic := range topics {
+		header.Add(TopicsHeader, token(topic))
+	}
+
+	for _, topic := range topics {
+		m := &nats.Msg{Subject: p.subject(topic), Header: header, Data: data}
+		if _, err := p.JetStream.PublishMsg(context.Background(), m); err != nil {
+			return fmt.Errorf("go-sse.nats: publish to topic %q: %w", topic, err)
+		}
+	}
+
+	return nil
+}
+
+// Subscribe creates an ordered consumer for the subscription's topics and sends the messages it receives
+// to the subscription's client, until the subscription's context is done.
+func (p *Provider) Subscribe(ctx context.Context, sub sse.Subscription) error {
+	p.init()
+
+	if len(sub.Topics) == 0 {
+		return sse.ErrNoTopic
+	}
+
+	state, ok := p.register()
+	if !ok {
+		return sse.ErrProviderClosed
+	}
+	defer p.unregister(state)
+
+	cfg := jetstream.OrderedConsumerConfig{DeliverPolicy: jetstream.DeliverNewPolicy}
+	if seq, err := strconv.ParseUint(sub.LastEventID.String(), 10, 64); err == nil && sub.LastEventID.IsSet() {
+		cfg.DeliverPolicy = jetstream.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = seq + 1
+		if sub.InclusiveReplay && seq > 0 {
+			cfg.OptStartSeq = seq
+		}
+	}
+
+	tokens := make([]string, 0, len(sub.Topics))
+	for _, topic := range sub.Topics {
+		tokens = append(tokens, token(topic))
+		cfg.FilterSubjects = append(cfg.FilterSubjects, p.subject(topic))
+	}
+
+	consumer, err := p.JetStream.OrderedConsumer(ctx, p.stream, cfg)
+	if err != nil {
+		return fmt.Errorf("go-sse.nats: create consumer: %w", err)
+	}
+
+	it, err := consumer.Messages()
+	if err != nil {
+		return fmt.Errorf("go-sse.nats: consume: %w", err)
+	}
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stopped:
+		}
+		it.Stop()
+	}()
+
+	if !p.setStop(state, it.Stop) {
+		return sse.ErrProviderClosed
+	}
+
+	return p.consume(it, sub, tokens)
+}
+
+// consume sends the messages of the iterator to the subscription's client until the iterator is stopped.
+func (p *Provider) consume(it jetstream.MessagesContext, sub sse.Subscription, tokens []string) error {
+	unflushed := false
+
+	for {
+		m, err := it.Next()
+		if err != nil {
+			if !errors.Is(err, jetstream.ErrMsgIteratorClosed) {
+				return fmt.Errorf("go-sse.nats: receive: %w", err)
+			}
+			if p.closed() {
+				return sse.ErrProviderClosed
+			}
+			return nil
+		}
+
+		meta, err := m.Metadata()
+		if err != nil {
+			return fmt.Errorf("go-sse.nats: receive: %w", err)
+		}
+
+		if firstSubscribed(m.Headers().Values(TopicsHeader), tokens) == strings.TrimPrefix(m.Subject(), p.subjectPrefix+".") {
+			msg := &sse.Message{}
+			if err := msg.UnmarshalText(m.Data()); err != nil {
+				return fmt.Errorf("go-sse.nats: invalid message %d: %w", meta.Sequence.Stream, err)
+			}
+			msg.ID = sse.ID(strconv.FormatUint(meta.Sequence.Stream, 10))
+
+			if sub.Filter == nil || sub.Filter(msg) {
+				if err := sub.Client.Send(msg); err != nil {
+					return err
+				}
+				unflushed = true
+			}
+		}
+
+		if unflushed && meta.NumPending == 0 {
+			if err := sub.Client.Flush(); err != nil {
+				return err
+			}
+			unflushed = false
+		}
+	}
+}
+
+// firstSubscribed returns the first of the message's topic tokens which the subscription has.
+func firstSubscribed(messageTokens, subscriptionTokens []string) string {
+	for _, mt := range messageTokens {
+		for _, st := range subscriptionTokens {
+			if mt == st {
+				return mt
+			}
+		}
+	}
+
+	return ""
+}
+
+// register records a new subscription, so Shutdown waits for it.
+func (p *Provider) register() (*subscriptionState, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed() {
+		return nil, false
+	}
+
+	state := &subscriptionState{}
+	p.subscriptions[state] = struct{}{}
+	p.wg.Add(1)
+
+	return state, true
+}
+
+// setStop sets the function which stops the subscription on shutdown. It returns false
+// if the provider was shut down meanwhile, in which case the subscription must end.
+func (p *Provider) setStop(state *subscriptionState, stop func()) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed() {
+		return false
+	}
+
+	state.stop = stop
+	return true
+}
+
+func (p *Provider) unregister(state *subscriptionState) {
+	p.mu.Lock()
+	delete(p.subscriptions, state)
+	p.mu.Unlock()
+
+	p.wg.Done()
+}
+
+// Shutdown stops all the subscriptions, which return ErrProviderClosed, and waits for them to end.
+// If the context is done before they end, its error is returned. The JetStream connection is not closed.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.init()
+
+	p.mu.Lock()
+	if p.closed() {
+		p.mu.Unlock()
+		return sse.ErrProviderClosed
+	}
+
+	close(p.done)
+	for state := range p.subscriptions {
+		if state.stop != nil {
+			state.stop()
+		}
+	}
+	p.mu.Unlock()
+
+	stopped := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *Provider) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+var _ sse.Provider = (*Provider)(nil)
@@ -0,0 +1,139 @@
+package nats_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natsgo "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/providers/nats"
+)
+
+type client struct {
+	msgs []string
+	mu   sync.Mutex
+}
+
+func (c *client) Send(m *sse.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.msgs = append(c.msgs, m.String())
+	return nil
+}
+
+func (c *client) Flush() error { return nil }
+
+func (c *client) Messages() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.msgs...)
+}
+
+func msg(tb testing.TB, data string) *sse.Message {
+	tb.Helper()
+
+	m := &sse.Message{}
+	m.AppendData(data)
+	return m
+}
+
+func newProvider(tb testing.TB) *nats.Provider {
+	tb.Helper()
+
+	s, err := server.NewServer(&server.Options{Port: -1, JetStream: true, StoreDir: tb.TempDir()})
+	require.NoError(tb, err)
+	s.Start()
+	tb.Cleanup(s.Shutdown)
+	require.True(tb, s.ReadyForConnections(5*time.Second), "server not ready")
+
+	nc, err := natsgo.Connect(s.ClientURL())
+	require.NoError(tb, err)
+	tb.Cleanup(nc.Close)
+
+	js, err := jetstream.New(nc)
+	require.NoError(tb, err)
+
+	p := &nats.Provider{JetStream: js}
+	_, err = js.CreateOrUpdateStream(context.Background(), p.StreamConfig())
+	require.NoError(tb, err)
+
+	return p
+}
+
+func subscribe(ctx context.Context, tb testing.TB, p *nats.Provider, sub sse.Subscription) <-chan error {
+	tb.Helper()
+
+	done := make(chan error, 1)
+	go func() { done <- p.Subscribe(ctx, sub) }()
+
+	return done
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+
+	p := newProvider(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &client{}
+	done := subscribe(ctx, t, p, sse.Subscription{Client: c, Topics: []string{"a", "b"}})
+
+	// The consumer delivers only new messages, so publish until the subscription is ready.
+	require.Eventually(t, func() bool {
+		require.NoError(t, p.Publish(msg(t, "ready"), []string{"a"}))
+		return len(c.Messages()) > 0
+	}, 5*time.Second, 10*time.Millisecond, "subscription not ready")
+
+	require.NoError(t, p.Publish(msg(t, "both"), []string{"a", "b"}))
+	require.NoError(t, p.Publish(msg(t, "other"), []string{"c"}))
+	require.NoError(t, p.Publish(msg(t, "b"), []string{"c", "b"}))
+
+	require.Eventually(t, func() bool {
+		msgs := c.Messages()
+		return stripID(msgs[len(msgs)-1]) == "data: b\n\n"
+	}, 5*time.Second, 10*time.Millisecond, "messages not received")
+
+	msgs := c.Messages()
+	require.Equal(t, "data: both\n\n", stripID(msgs[len(msgs)-2]), "invalid message published to both topics")
+	require.Equal(t, "data: b\n\n", stripID(msgs[len(msgs)-1]), "invalid message published to other topics")
+
+	cancel()
+	require.NoError(t, <-done)
+
+	// Resuming from the message published to both topics skips its other copy and the messages of other topics.
+	resumed := &client{}
+	lastID := idOf(msgs[len(msgs)-2])
+	replayCtx, replayCancel := context.WithCancel(context.Background())
+	defer replayCancel()
+
+	done = subscribe(replayCtx, t, p, sse.Subscription{Client: resumed, Topics: []string{"a", "b"}, LastEventID: sse.ID(lastID)})
+	require.Eventually(t, func() bool { return len(resumed.Messages()) == 1 }, 5*time.Second, 10*time.Millisecond, "messages not replayed")
+	require.Equal(t, msgs[len(msgs)-1], resumed.Messages()[0], "invalid replayed message")
+
+	require.NoError(t, p.Shutdown(context.Background()))
+	require.ErrorIs(t, <-done, sse.ErrProviderClosed, "subscription not ended on shutdown")
+	require.ErrorIs(t, p.Shutdown(context.Background()), sse.ErrProviderClosed, "shut down twice")
+	require.ErrorIs(t, p.Publish(msg(t, "a"), []string{"a"}), sse.ErrProviderClosed, "published after shutdown")
+	require.ErrorIs(t, p.Subscribe(context.Background(), sse.Subscription{Client: c, Topics: []string{"a"}}), sse.ErrProviderClosed, "subscribed after shutdown")
+}
+
+func idOf(m string) string {
+	idField, _, _ := strings.Cut(m, "\n")
+	return strings.TrimPrefix(idField, "id: ")
+}
+
+func stripID(m string) string {
+	_, rest, _ := strings.Cut(m, "\n")
+	return rest
+}
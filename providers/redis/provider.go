@@ -0,0 +1,342 @@
+// Package redis implements a go-sse provider which distributes the published messages through
+// Redis Pub/Sub, so that multiple server instances behind a load balancer send the same events
+// to their clients.
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/tmaxmax/go-sse"
+)
+
+// DefaultChannelPrefix is the prefix of the Redis channels to which the messages are published, by default.
+const DefaultChannelPrefix = "sse:"
+
+// Provider is a go-sse provider which publishes the messages to Redis channels, one for each topic,
+// and sends the messages received from the channels to the local subscribers, using a Joe.
+// Every instance of the server subscribes to the channels of the topics its clients are subscribed to,
+// so a message published by any instance reaches the clients of all instances.
+//
+// A message published to multiple topics is published to the channel of each topic, in a single transaction,
+// and delivered once to each local subscriber. Messages are delivered at most once: Redis Pub/Sub doesn't store messages,
+// so those published while an instance's connection to Redis is lost are not delivered to its clients.
+// The connection is reestablished automatically and the channels are subscribed to again – connection
+// errors are reported to the OnError callback. For the same reason, events are replayed only from the
+// instance's own history, if its Joe has a replay provider: message IDs should be set when publishing,
+// so that they are the same on all instances.
+//
+// The zero value is not usable, as the Client field is required. The fields must not be modified after
+// the provider is used.
+type Provider struct {
+	// Client is the Redis client used to publish the messages and to subscribe to the channels.
+	Client redis.UniversalClient
+	// ChannelPrefix is the prefix of the Redis channel of each topic. Defaults to DefaultChannelPrefix.
+	ChannelPrefix string
+	// Joe delivers the messages received from Redis to the local subscribers. The provider shuts it down
+	// when it is shut down. Defaults to a Joe without a replay provider.
+	Joe *sse.Joe
+	// OnError is an optional callback that's called when the connection to Redis fails while receiving
+	// messages, or when a received message can't be decoded. It is called on the goroutine which receives
+	// the messages, so it must return quickly.
+	OnError func(err error)
+
+	// channelPrefix and joe are the ChannelPrefix and the Joe used, with the defaults applied.
+	channelPrefix string
+	joe           *sse.Joe
+
+	pubsub *redis.PubSub
+	// id prefixes the IDs of the published messages, so they are unique across instances.
+	id  string
+	seq atomic.Uint64
+	// lastID is the ID of the last message dispatched by the receiving goroutine.
+	lastID string
+	// channels counts the local subscriptions to each topic, by channel.
+	channels map[string]int
+	mu       sync.Mutex
+	// done is closed when the provider is shut down, stopped when the receiving goroutine returns.
+	done     chan struct{}
+	stopped  chan struct{}
+	initDone sync.Once
+}
+
+// ErrInvalidPayload is reported to the OnError callback for messages received from Redis which
+// weren't published by a Provider.
+var ErrInvalidPayload = errors.New("go-sse.redis: invalid message payload")
+
+// payload is the representation of a published message in Redis.
+type payload struct {
+	// ID identifies the published message, so its copies received from the channels of
+	// its other topics are ignored.
+	ID      string   `json:"id"`
+	Topics  []string `json:"topics"`
+	Message string   `json:"message"`
+}
+
+func (p *Provider) init() {
+	p.initDone.Do(func() {
+		p.channelPrefix = p.ChannelPrefix
+		if p.channelPrefix == "" {
+			p.channelPrefix = DefaultChannelPrefix
+		}
+		p.joe = p.Joe
+		if p.joe == nil {
+			p.joe = &sse.Joe{}
+		}
+
+		var id [8]byte
+		_, _ = rand.Read(id[:])
+		p.id = hex.EncodeToString(id[:])
+
+		p.channels = map[string]int{}
+		p.done = make(chan struct{})
+		p.stopped = make(chan struct{})
+		// No channels are subscribed to until there are subscribers.
+		p.pubsub = p.Client.Subscribe(context.Background())
+
+		go p.receive()
+	})
+}
+
+// Subscribe subscribes the instance to the channels of the subscription's topics, if it isn't already,
+// and sends the messages received from them to the subscription's client until its context is done.
+// The instance unsubscribes from the channels which have no local subscribers anymore afterwards.
+func (p *Provider) Subscribe(ctx context.Context, sub sse.Subscription) error {
+	p.init()
+
+	if len(sub.Topics) == 0 {
+		return sse.ErrNoTopic
+	}
+	if p.closed() {
+		return sse.ErrProviderClosed
+	}
+
+	if err := p.addChannels(ctx, sub.Topics); err != nil {
+		return err
+	}
+	defer p.removeChannels(sub.Topics)
+
+	return p.joe.Subscribe(ctx, sub)
+}
+
+// Publish publishes the message to the channel of each of the given topics. The message is published
+// to all the channels in a single transaction, so either all or none of them receive it, unless Redis
+// fails to execute a command of the transaction – Redis doesn't roll transactions back.
+func (p *Provider) Publish(msg *sse.Message, topics []string) error {
+	p.init()
+
+	if msg == nil {
+		return sse.ErrNilMessage
+	}
+	if len(topics) == 0 {
+		return sse.ErrNoTopic
+	}
+	if p.closed() {
+		return sse.ErrProviderClosed
+	}
+
+	text, err := msg.MarshalText()
+	if err != nil {
+		return err
+	}
+	id := p.id + "-" + strconv.FormatUint(p.seq.Add(1), 10)
+	data, err := json.Marshal(payload{ID: id, Topics: topics, Message: string(text)})
+	if err != nil {
+		return err
+	}
+
+	_, err = p.Client.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		for _, topic := range topics {
+			pipe.Publish(context.Background(), p.channel(topic), data)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("go-sse.redis: publish: %w", err)
+	}
+
+	return nil
+}
+
+// Shutdown stops receiving messages from Redis and shuts down the provider's Joe, which ends
+// all the subscriptions. The Redis client is not closed. The same semantics as Joe's Shutdown apply.
+func (p *Provider) Shutdown(ctx context.Context) error {
+	p.init()
+
+	p.mu.Lock()
+	select {
+	case <-p.done:
+		p.mu.Unlock()
+		return sse.ErrProviderClosed
+	default:
+		close(p.done)
+	}
+	err := p.pubsub.Close()
+	p.mu.Unlock()
+
+	select {
+	case <-p.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if joeErr := p.joe.Shutdown(ctx); joeErr != nil && !errors.Is(joeErr, sse.ErrProviderClosed) {
+		return joeErr
+	}
+
+	return err
+}
+
+func (p *Provider) closed() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *Provider) channel(topic string) string {
+	return p.channelPrefix + topic
+}
+
+// addChannels subscribes to the channels of the given topics which have no local subscribers yet.
+func (p *Provider) addChannels(ctx context.Context, topics []string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed() {
+		return sse.ErrProviderClosed
+	}
+
+	var added []string
+	for _, topic := range topics {
+		c := p.channel(topic)
+		if p.channels[c] == 0 {
+			added = append(added, c)
+		}
+		p.channels[c]++
+	}
+
+	if len(added) == 0 {
+		return nil
+	}
+	if err := p.pubsub.Subscribe(ctx, added...); err != nil {
+		for _, topic := range topics {
+			p.release(p.channel(topic))
+		}
+		return fmt.Errorf("go-sse.redis: subscribe: %w", err)
+	}
+
+	return nil
+}
+
+// removeChannels unsubscribes from the channels of the given topics which have no local subscribers anymore.
+func (p *Provider) removeChannels(topics []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var removed []string
+	for _, topic := range topics {
+		if c := p.channel(topic); p.release(c) {
+			removed = append(removed, c)
+		}
+	}
+
+	if len(removed) > 0 && !p.closed() {
+		// If unsubscribing fails, the messages of the channels are ignored until they have subscribers again.
+		_ = p.pubsub.Unsubscribe(context.Background(), removed...)
+	}
+}
+
+// release decrements the subscriptions to the channel and tells whether it has no subscriptions anymore.
+func (p *Provider) release(channel string) bool {
+	p.channels[channel]--
+	if p.channels[channel] > 0 {
+		return false
+	}
+
+	delete(p.channels, channel)
+	return true
+}
+
+// receive sends the messages received from Redis to the local subscribers until the provider is shut down.
+func (p *Provider) receive() {
+	defer close(p.stopped)
+
+	const maxRetryDelay = 5 * time.Second
+	retryDelay := 50 * time.Millisecond
+
+	for {
+		received, err := p.pubsub.Receive(context.Background())
+		if p.closed() {
+			return
+		}
+		if err != nil {
+			// The connection is reestablished, and the channels subscribed to again, on the next receive.
+			p.reportError(fmt.Errorf("go-sse.redis: receive: %w", err))
+
+			select {
+			case <-time.After(retryDelay):
+			case <-p.done:
+				return
+			}
+
+			if retryDelay *= 2; retryDelay > maxRetryDelay {
+				retryDelay = maxRetryDelay
+			}
+			continue
+		}
+
+		retryDelay = 50 * time.Millisecond
+
+		if m, ok := received.(*redis.Message); ok {
+			p.dispatch(m)
+		}
+	}
+}
+
+// dispatch publishes the received message to the local subscribers. A message published to multiple topics
+// is received once from each of their channels the instance is subscribed to, so it is published only when
+// its first copy is received – Joe sends it to the subscribers of all the topics. The copies are published
+// in a transaction, so they are received one after the other.
+func (p *Provider) dispatch(m *redis.Message) {
+	var pl payload
+	if err := json.Unmarshal([]byte(m.Payload), &pl); err != nil || pl.ID == "" || len(pl.Topics) == 0 {
+		p.reportError(ErrInvalidPayload)
+		return
+	}
+
+	if pl.ID == p.lastID {
+		return
+	}
+	p.lastID = pl.ID
+
+	msg := &sse.Message{}
+	if err := msg.UnmarshalText([]byte(pl.Message)); err != nil {
+		p.reportError(fmt.Errorf("%w: %v", ErrInvalidPayload, err))
+		return
+	}
+
+	if err := p.joe.Publish(msg, pl.Topics); err != nil && !errors.Is(err, sse.ErrProviderClosed) {
+		p.reportError(err)
+	}
+}
+
+func (p *Provider) reportError(err error) {
+	if p.OnError != nil {
+		p.OnError(err)
+	}
+}
+
+var _ sse.Provider = (*Provider)(nil)
@@ -0,0 +1,172 @@
+package redis_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/providers/redis"
+)
+
+type client struct {
+	msgs []string
+	mu   sync.Mutex
+}
+
+func (c *client) Send(m *sse.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.msgs = append(c.msgs, m.String())
+	return nil
+}
+
+func (c *client) Flush() error { return nil }
+
+func (c *client) Messages() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]string(nil), c.msgs...)
+}
+
+func msg(tb testing.TB, data, id string) *sse.Message {
+	tb.Helper()
+
+	m := &sse.Message{}
+	m.AppendData(data)
+	if id != "" {
+		m.ID = sse.ID(id)
+	}
+	return m
+}
+
+func newProvider(tb testing.TB, addr string) *redis.Provider {
+	tb.Helper()
+
+	rdb := goredis.NewClient(&goredis.Options{Addr: addr})
+	tb.Cleanup(func() { _ = rdb.Close() })
+
+	return &redis.Provider{Client: rdb}
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+
+	publisher, subscriber := newProvider(t, mr.Addr()), newProvider(t, mr.Addr())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &client{}
+	done := make(chan error, 1)
+	go func() { done <- subscriber.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{"a", "b"}}) }()
+
+	require.Eventually(t, func() bool {
+		return mr.PubSubNumSub("sse:a")["sse:a"] == 1 && mr.PubSubNumSub("sse:b")["sse:b"] == 1
+	}, time.Second, time.Millisecond, "channels not subscribed")
+
+	require.NoError(t, publisher.Publish(msg(t, "both", "1"), []string{"a", "b"}))
+	require.NoError(t, publisher.Publish(msg(t, "other", "2"), []string{"c"}))
+	require.NoError(t, publisher.Publish(msg(t, "b", "3"), []string{"c", "b"}))
+
+	expected := []string{"id: 1\ndata: both\n\n", "id: 3\ndata: b\n\n"}
+	require.Eventually(t, func() bool { return len(c.Messages()) == len(expected) }, time.Second, time.Millisecond, "messages not received")
+	require.Equal(t, expected, c.Messages(), "invalid messages received")
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Eventually(t, func() bool { return len(mr.PubSubChannels("")) == 0 }, time.Second, time.Millisecond, "channels not unsubscribed")
+
+	require.NoError(t, subscriber.Shutdown(context.Background()))
+	require.ErrorIs(t, subscriber.Shutdown(context.Background()), sse.ErrProviderClosed, "shut down twice")
+	require.ErrorIs(t, subscriber.Publish(msg(t, "a", ""), []string{"a"}), sse.ErrProviderClosed, "published after shutdown")
+	require.ErrorIs(t, subscriber.Subscribe(context.Background(), sse.Subscription{Client: c, Topics: []string{"a"}}), sse.ErrProviderClosed, "subscribed after shutdown")
+	require.NoError(t, publisher.Shutdown(context.Background()))
+}
+
+func TestProvider_multipleTopics(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+
+	publisher, subscriber := newProvider(t, mr.Addr()), newProvider(t, mr.Addr())
+	defer publisher.Shutdown(context.Background())  //nolint:errcheck // irrelevant
+	defer subscriber.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, b := &client{}, &client{}
+	go subscriber.Subscribe(ctx, sse.Subscription{Client: a, Topics: []string{"a"}}) //nolint:errcheck // irrelevant
+	go subscriber.Subscribe(ctx, sse.Subscription{Client: b, Topics: []string{"b"}}) //nolint:errcheck // irrelevant
+
+	require.Eventually(t, func() bool {
+		return mr.PubSubNumSub("sse:a")["sse:a"] == 1 && mr.PubSubNumSub("sse:b")["sse:b"] == 1
+	}, time.Second, time.Millisecond, "channels not subscribed")
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, publisher.Publish(msg(t, "both", ""), []string{"a", "b"}))
+	}
+	require.NoError(t, publisher.Publish(msg(t, "last", ""), []string{"b"}))
+
+	require.Eventually(t, func() bool { return len(b.Messages()) == 11 }, time.Second, time.Millisecond, "messages not received")
+	require.Len(t, a.Messages(), 10, "messages not delivered once")
+}
+
+func TestProvider_Shutdown(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+	p := newProvider(t, mr.Addr())
+	p.Joe = &sse.Joe{}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Subscribe(context.Background(), sse.Subscription{Client: &client{}, Topics: []string{sse.DefaultTopic}})
+	}()
+
+	require.Eventually(t, func() bool { return p.Joe.Stats().Subscribers == 1 }, time.Second, time.Millisecond, "not subscribed")
+	require.NoError(t, p.Shutdown(context.Background()))
+	require.ErrorIs(t, <-done, sse.ErrProviderClosed, "subscription not ended")
+}
+
+func TestProvider_connectionLoss(t *testing.T) {
+	t.Parallel()
+
+	mr := miniredis.RunT(t)
+
+	errs := make(chan error, 16)
+	p := newProvider(t, mr.Addr())
+	p.OnError = func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	defer p.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c := &client{}
+	go p.Subscribe(ctx, sse.Subscription{Client: c, Topics: []string{"a"}}) //nolint:errcheck // irrelevant
+
+	require.Eventually(t, func() bool { return mr.PubSubNumSub("sse:a")["sse:a"] == 1 }, time.Second, time.Millisecond, "channel not subscribed")
+
+	mr.Close()
+	require.Error(t, <-errs, "connection loss not reported")
+	require.NoError(t, mr.Restart())
+
+	require.Eventually(t, func() bool { return mr.PubSubNumSub("sse:a")["sse:a"] == 1 }, 5*time.Second, 10*time.Millisecond, "channel not subscribed again")
+	require.NoError(t, p.Publish(msg(t, "after", ""), []string{"a"}))
+	require.Eventually(t, func() bool { return len(c.Messages()) == 1 }, time.Second, time.Millisecond, "message not received after reconnection")
+}
@@ -0,0 +1,33 @@
+package sse
+
+// PublishMiddleware processes the messages published by the Server before they reach the provider.
+// It returns the message and topics to publish – the given ones or others, to enrich or re-route the message –,
+// or an error, which Publish returns without publishing the message. If it returns a nil message and no error,
+// the message is dropped and Publish returns nil.
+//
+// The given message may be used by the caller of Publish after it returns, so it must not be modified:
+// return a modified clone instead. See Message.Clone.
+type PublishMiddleware func(msg *Message, topics []string) (*Message, []string, error)
+
+// Use adds middlewares which process the messages published by the Server, in the order they are added,
+// each receiving the result of the previous one. The middlewares see the message as it is given to Publish
+// and the topics after the DefaultTopic is added, if no topics were given. Their result is validated as
+// a published message would be, so a middleware can't publish invalid messages or topics.
+//
+// Use must not be called after the Server is used.
+func (s *Server) Use(middlewares ...PublishMiddleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// applyMiddlewares passes the message through the middlewares added with Use.
+// It returns a nil message if a middleware dropped it.
+func (s *Server) applyMiddlewares(m *Message, topics []string) (*Message, []string, error) {
+	for _, middleware := range s.middlewares {
+		var err error
+		if m, topics, err = middleware(m, topics); err != nil || m == nil {
+			return nil, nil, err
+		}
+	}
+
+	return m, topics, nil
+}
@@ -0,0 +1,213 @@
+package sse
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmaxmax/go-sse/internal/parser"
+)
+
+// recordedAtComment is the prefix of the comments with which the RecordingWriter
+// marks the time each event was received.
+const recordedAtComment = "recorded-at "
+
+// RecordingWriter records the events received by the connections of a Client, so the streams
+// can be inspected later or served using ReplayFileHandler, in order to reproduce issues without
+// access to the original server. Set it using the Client's Recorder field.
+//
+// By default each event is recorded as it was parsed, with a comment holding the time it was received,
+// which ReplayFileHandler uses to reproduce the stream's timing. Retry fields and comments are not recorded.
+// In raw mode the response bodies are recorded byte for byte instead, but without any timing information.
+//
+// Recording never fails a connection: the first write error is kept and is available through the Err method,
+// and nothing is recorded after it. The records of concurrent connections are not interleaved in the default
+// mode, but they may be in raw mode, so use a separate Client for each connection that is recorded raw.
+type RecordingWriter struct {
+	// W is where the events are recorded. It must not be nil.
+	W io.Writer
+	// The function used to retrieve the current time. Defaults to time.Now.
+	Now func() time.Time
+	// Raw configures the RecordingWriter to record the response bodies as they are received.
+	Raw bool
+
+	err error
+	mu  sync.Mutex
+}
+
+// Err returns the first error that occurred while recording, if any.
+func (r *RecordingWriter) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// Write records the given bytes, as received. It never returns an error, so the connection
+// reading the stream is not affected by recording failures – see Err.
+func (r *RecordingWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		_, r.err = r.W.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// record records the event, with the time it was received. The ID is recorded if it
+// is different from the previous one, given by recordedID, which is then updated.
+func (r *RecordingWriter) record(ev Event, recordedID *string) {
+	m := &Message{}
+	if ev.LastEventID != *recordedID {
+		// The received IDs are always valid.
+		m.ID, _ = NewID(ev.LastEventID)
+		*recordedID = ev.LastEventID
+	}
+	if ev.Type != "" {
+		m.Type, _ = NewType(ev.Type)
+	}
+	m.AppendComment(recordedAtComment + r.now().Format(time.RFC3339Nano))
+	if ev.Binary {
+		m.SetBinaryData([]byte(ev.Data))
+	} else {
+		m.AppendData(ev.Data)
+	}
+
+	var buf bytes.Buffer
+	_, _ = m.WriteTo(&buf)
+	_, _ = r.Write(buf.Bytes())
+}
+
+func (r *RecordingWriter) now() time.Time {
+	if r.Now == nil {
+		return time.Now()
+	}
+	return r.Now()
+}
+
+// recordedEvent is an event read from a recording.
+type recordedEvent struct {
+	receivedAt time.Time
+	// raw is the event's text, as recorded, without the recorded time.
+	raw string
+	// lastEventID is the last event ID after the event is received.
+	lastEventID string
+}
+
+// parseRecording splits a recording made by a RecordingWriter into events.
+func parseRecording(recording string) []recordedEvent {
+	var events []recordedEvent
+	var ev recordedEvent
+	var raw strings.Builder
+	lastEventID := ""
+	hasFields := false
+
+	for recording != "" {
+		rawLine := recording
+		line, remaining, _ := parser.NextChunk(recording)
+		rawLine, recording = rawLine[:len(rawLine)-len(remaining)], remaining
+
+		if line == "" {
+			if hasFields {
+				raw.WriteString(rawLine)
+				ev.raw, ev.lastEventID = raw.String(), lastEventID
+				events = append(events, ev)
+			}
+			ev, hasFields = recordedEvent{}, false
+			raw.Reset()
+			continue
+		}
+
+		if comment := strings.TrimPrefix(line, ": "+recordedAtComment); comment != line {
+			ev.receivedAt, _ = time.Parse(time.RFC3339Nano, comment)
+			continue
+		}
+
+		name, value, _ := strings.Cut(line, ":")
+		if parser.FieldName(name) == parser.FieldNameID && strings.IndexByte(value, 0) == -1 {
+			lastEventID = strings.TrimPrefix(value, " ")
+		}
+
+		raw.WriteString(rawLine)
+		hasFields = true
+	}
+
+	// If the recording ends with an incomplete event, it is discarded, as clients do.
+	return events
+}
+
+// ReplayFileHandler returns a handler which serves the recording from the file at the given path,
+// made by a RecordingWriter, as an event stream. The recorded time between events is honored,
+// scaled by the given speed: a speed of 2 sends the events twice as fast as they were received.
+// If speed is not positive or the recording has no timing information, as raw recordings don't,
+// the events are sent without any delay.
+//
+// If the request has a Last-Event-ID header, the events are sent starting with the first one
+// after the event with that ID. The file is read for each request, so it can be replaced
+// while the handler is used.
+func ReplayFileHandler(path string, speed float64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recording, err := os.ReadFile(path)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if os.IsNotExist(err) {
+				code = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), code)
+			return
+		}
+
+		sess, err := Upgrade(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		events := parseRecording(string(recording))
+		if sess.LastEventID.IsSet() {
+			for i := range events {
+				if events[i].lastEventID == sess.LastEventID.String() {
+					events = events[i+1:]
+					break
+				}
+			}
+		}
+
+		sess.Res.Header()[headerContentType] = headerContentTypeValue
+		if err := sess.Res.Flush(); err != nil {
+			return
+		}
+
+		var timer *time.Timer
+		for i := range events {
+			if i > 0 && speed > 0 && !events[i].receivedAt.IsZero() && !events[i-1].receivedAt.IsZero() {
+				delay := time.Duration(float64(events[i].receivedAt.Sub(events[i-1].receivedAt)) / speed)
+				if timer == nil {
+					timer = time.NewTimer(delay)
+					defer timer.Stop()
+				} else {
+					timer.Reset(delay)
+				}
+
+				select {
+				case <-timer.C:
+				case <-r.Context().Done():
+					return
+				}
+			}
+
+			if _, err := io.WriteString(sess.Res, events[i].raw); err != nil {
+				return
+			}
+			if err := sess.Res.Flush(); err != nil {
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,144 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RelayProvider is a provider which consumes an upstream event stream, using this package's Client,
+// and republishes its events to the local subscribers – this way, a server instance becomes a fan-out relay
+// for a third-party stream, holding a single upstream connection regardless of the number of its clients.
+// Use a Joe with a replay provider as the Local provider to also cache the recent events at the edge,
+// for the clients which reconnect.
+//
+// The upstream connection is opened when the RelayProvider is first used and it is kept until Shutdown
+// is called. If it fails, OnError is called and the connection is opened again after the connection's
+// reconnection time, resuming from the last relayed event ID. Relaying stops if the upstream stream completes
+// – see Client.IsTerminalEvent.
+//
+// The events are published with the ID, type and data they were received with – binary data is encoded
+// again, see Message.SetBinaryData. An event without an ID of its own is published without an ID.
+//
+// The fields must not be modified after the RelayProvider is used.
+type RelayProvider struct {
+	// Request is the request used to connect to the upstream stream. It must not be nil.
+	// Its context is not used: the connection is closed when the RelayProvider is shut down.
+	Request *http.Request
+	// Client is the client used to connect to the upstream stream. Defaults to DefaultClient.
+	Client *Client
+	// Local is the provider to which the upstream events are published and the clients are subscribed.
+	// Defaults to a zero value Joe.
+	Local Provider
+	// Topics optionally returns the topics an upstream event is published to. By default,
+	// the events are published to the DefaultTopic.
+	Topics func(Event) []string
+	// OnError is an optional callback that is called when the upstream connection fails.
+	// It is called on the relay's goroutine, so it must not block.
+	OnError func(error)
+
+	cancel   context.CancelFunc
+	relayed  chan struct{}
+	initDone sync.Once
+}
+
+func (r *RelayProvider) init() {
+	r.initDone.Do(func() {
+		if r.Client == nil {
+			r.Client = DefaultClient
+		}
+		if r.Local == nil {
+			r.Local = &Joe{}
+		}
+
+		var ctx context.Context
+		ctx, r.cancel = context.WithCancel(context.Background())
+		r.relayed = make(chan struct{})
+
+		go r.relay(ctx)
+	})
+}
+
+// relay relays the upstream events until the context is done or the upstream stream completes.
+func (r *RelayProvider) relay(ctx context.Context) {
+	defer close(r.relayed)
+
+	lastEventID := ""
+	for {
+		req := r.Request.Clone(ctx)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+
+		conn := r.Client.NewConnection(req)
+		// The connection resumes from the last relayed event, so its events' IDs are compared to it.
+		conn.lastEventID = lastEventID
+		conn.onEvent = func(ev Event) {
+			m := &Message{Type: Type(ev.Type)}
+			if ev.Binary {
+				m.SetBinaryData([]byte(ev.Data))
+			} else {
+				m.AppendData(ev.Data)
+			}
+			if ev.LastEventID != lastEventID {
+				lastEventID = ev.LastEventID
+				m.ID = ID(lastEventID)
+			}
+
+			topics := []string{DefaultTopic}
+			if r.Topics != nil {
+				topics = r.Topics(ev)
+			}
+
+			if err := r.Local.Publish(m, topics); err != nil && r.OnError != nil {
+				r.OnError(err)
+			}
+		}
+
+		err := conn.Connect()
+		if ctx.Err() != nil || errors.Is(err, ErrStreamCompleted) {
+			return
+		}
+		if err != nil && r.OnError != nil {
+			r.OnError(err)
+		}
+
+		select {
+		case <-time.After(conn.ReconnectionTime()):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Subscribe subscribes the client to the Local provider.
+func (r *RelayProvider) Subscribe(ctx context.Context, sub Subscription) error {
+	r.init()
+
+	return r.Local.Subscribe(ctx, sub)
+}
+
+// Publish publishes the message to the Local provider, alongside the relayed events.
+func (r *RelayProvider) Publish(msg *Message, topics []string) error {
+	r.init()
+
+	return r.Local.Publish(msg, topics)
+}
+
+// Shutdown closes the upstream connection and shuts down the Local provider.
+func (r *RelayProvider) Shutdown(ctx context.Context) error {
+	r.init()
+
+	r.cancel()
+	select {
+	case <-r.relayed:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return r.Local.Shutdown(ctx)
+}
+
+var _ Provider = (*RelayProvider)(nil)
@@ -2,6 +2,8 @@ package sse
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -12,9 +14,16 @@ type buffer interface {
 	queue(message *Message, topics []string) *Message
 	dequeue()
 	front() *messageWithTopics
+	// get returns the message at the given index. Modifying its topics is allowed.
+	get(i int) *messageWithTopics
 	len() int
-	cap() int
-	slice(EventID) []messageWithTopics
+	slice(atID EventID, inclusive bool) []messageWithTopics
+	// all returns all the messages in the buffer, oldest first.
+	all() []messageWithTopics
+	// filter removes the messages for which keep returns false, preserving the order of the rest.
+	// The function receives the index of each message, in order. Removing the oldest messages
+	// is equivalent to dequeuing them.
+	filter(keep func(i int) bool)
 }
 
 type bufferBase struct {
@@ -25,10 +34,6 @@ func (b *bufferBase) len() int {
 	return len(b.buf)
 }
 
-func (b *bufferBase) cap() int {
-	return cap(b.buf)
-}
-
 func (b *bufferBase) front() *messageWithTopics {
 	if b.len() == 0 {
 		return nil
@@ -36,6 +41,14 @@ func (b *bufferBase) front() *messageWithTopics {
 	return &b.buf[0]
 }
 
+func (b *bufferBase) all() []messageWithTopics {
+	return b.buf
+}
+
+func (b *bufferBase) get(i int) *messageWithTopics {
+	return &b.buf[i]
+}
+
 func (b *bufferBase) queue(message *Message, topics []string) *Message {
 	if len(topics) == 0 {
 		panic(errors.New("go-sse: no topics provided for Message.\n" + formatMessagePanicString(message)))
@@ -46,9 +59,36 @@ func (b *bufferBase) queue(message *Message, topics []string) *Message {
 	return message
 }
 
+// compact moves the messages for which keep returns true to the front of the buffer and truncates it.
+// It calls removed for each removed message, reporting whether it is older than all the kept ones.
+func (b *bufferBase) compact(keep func(i int) bool, removed func(m *messageWithTopics, front bool)) {
+	n := 0
+	for i := range b.buf {
+		if keep(i) {
+			b.buf[n] = b.buf[i]
+			n++
+		} else {
+			removed(&b.buf[i], n == 0)
+		}
+	}
+
+	// Release the references to the removed messages, so they can be garbage collected.
+	for i := n; i < len(b.buf); i++ {
+		b.buf[i] = messageWithTopics{}
+	}
+
+	b.buf = b.buf[:n]
+}
+
 type bufferNoID struct {
 	lastRemovedID EventID
 	bufferBase
+	// removed records the order of the messages removed from the middle of the buffer – by TTLs,
+	// per-topic limits or dropped topics –, so the clients whose last event was one of them still
+	// receive the newer messages. The entries older than the front of the buffer are pruned.
+	removed map[EventID]int64
+	// seq is the order of the next message put in the buffer.
+	seq int64
 }
 
 func (b *bufferNoID) queue(message *Message, topics []string) *Message {
@@ -67,15 +107,43 @@ func (b *bufferNoID) queue(message *Message, topics []string) *Message {
 		panic(errors.New(panicString))
 	}
 
-	return b.bufferBase.queue(message, topics)
+	message = b.bufferBase.queue(message, topics)
+	b.buf[len(b.buf)-1].seq = b.seq
+	b.seq++
+
+	return message
 }
 
 func (b *bufferNoID) dequeue() {
-	b.lastRemovedID = b.buf[0].message.ID
+	b.removedFront(&b.buf[0])
 	b.buf = b.buf[1:]
 }
 
-func (b *bufferNoID) slice(atID EventID) []messageWithTopics {
+func (b *bufferNoID) filter(keep func(i int) bool) {
+	b.compact(keep, func(m *messageWithTopics, front bool) {
+		if front {
+			b.removedFront(m)
+			return
+		}
+
+		if b.removed == nil {
+			b.removed = map[EventID]int64{}
+		}
+		b.removed[m.message.ID] = m.seq
+	})
+}
+
+// removedFront records the removal of a message older than all the others in the buffer.
+func (b *bufferNoID) removedFront(m *messageWithTopics) {
+	b.lastRemovedID = m.message.ID
+	for id, seq := range b.removed {
+		if seq < m.seq {
+			delete(b.removed, id)
+		}
+	}
+}
+
+func (b *bufferNoID) slice(atID EventID, inclusive bool) []messageWithTopics {
 	if !atID.IsSet() {
 		return nil
 	}
@@ -90,7 +158,15 @@ func (b *bufferNoID) slice(atID EventID) []messageWithTopics {
 		}
 	}
 	if index == -1 {
-		return nil
+		seq, ok := b.removed[atID]
+		if !ok {
+			return nil
+		}
+		// The message was removed, so the messages put after it are replayed.
+		return b.buf[sort.Search(len(b.buf), func(i int) bool { return b.buf[i].seq > seq }):]
+	}
+	if inclusive {
+		return b.buf[index:]
 	}
 
 	return b.buf[index+1:]
@@ -98,13 +174,28 @@ func (b *bufferNoID) slice(atID EventID) []messageWithTopics {
 
 type bufferAutoID struct {
 	bufferBase
+	// firstID is the ID of the oldest message which wasn't dequeued.
+	// Messages between firstID and the front of the buffer may have been filtered out.
 	firstID    int64
 	upcomingID int64
+	// checkpoint optionally stores the IDs reserved by the buffer, up to reservedID.
+	checkpoint IDCheckpoint
+	reservedID int64
+	// startID is the first ID issued by the buffer. Lower IDs were issued before a restart.
+	startID int64
 }
 
 const autoIDBase = 10
 
 func (b *bufferAutoID) queue(message *Message, topics []string) *Message {
+	if b.checkpoint != nil && b.upcomingID >= b.reservedID {
+		reserved := b.upcomingID + IDCheckpointInterval
+		if err := b.checkpoint.Store(reserved); err != nil {
+			panic(fmt.Errorf("go-sse: failed to store ID checkpoint: %w", err))
+		}
+		b.reservedID = reserved
+	}
+
 	message = message.Clone()
 	message.ID = ID(strconv.FormatInt(b.upcomingID, autoIDBase))
 	b.upcomingID++
@@ -113,26 +204,78 @@ func (b *bufferAutoID) queue(message *Message, topics []string) *Message {
 }
 
 func (b *bufferAutoID) dequeue() {
-	b.firstID++
+	b.firstID = b.id(0) + 1
 	b.buf = b.buf[1:]
 }
 
-func (b *bufferAutoID) slice(atID EventID) []messageWithTopics {
+func (b *bufferAutoID) filter(keep func(i int) bool) {
+	b.compact(keep, func(m *messageWithTopics, front bool) {
+		if front {
+			b.firstID = parseAutoID(m.message.ID) + 1
+		}
+	})
+}
+
+func (b *bufferAutoID) id(i int) int64 {
+	return parseAutoID(b.buf[i].message.ID)
+}
+
+func (b *bufferAutoID) slice(atID EventID, inclusive bool) []messageWithTopics {
 	id, err := strconv.ParseInt(atID.String(), autoIDBase, 64)
 	if err != nil {
 		return nil
 	}
-	index := id - b.firstID
-	if index < -1 || index >= int64(len(b.buf)) {
+	if id < b.startID && b.firstID == b.startID {
+		// The ID was issued before a restart, so all the messages in the buffer are newer.
+		return b.buf
+	}
+	if id < b.firstID-1 || id >= b.upcomingID {
 		return nil
 	}
-	return b.buf[index+1:]
+	// IDs are increasing, but not necessarily contiguous, as messages may have been filtered out.
+	index := sort.Search(len(b.buf), func(i int) bool {
+		if inclusive {
+			return b.id(i) >= id
+		}
+		return b.id(i) > id
+	})
+	return b.buf[index:]
+}
+
+// parseAutoID parses an ID set by bufferAutoID. The ID is always valid.
+func parseAutoID(id EventID) int64 {
+	v, _ := strconv.ParseInt(id.String(), autoIDBase, 64)
+	return v
+}
+
+// bufferGeneratedID is a buffer which sets the IDs of the messages using an IDGenerator.
+type bufferGeneratedID struct {
+	bufferNoID
+	generator IDGenerator
 }
 
-func getBuffer(autoIDs bool, capacity int) buffer {
+func (b *bufferGeneratedID) queue(message *Message, topics []string) *Message {
+	message = message.Clone()
+	message.ID = b.generator.NextID()
+
+	return b.bufferNoID.queue(message, topics)
+}
+
+func getBuffer(autoIDs bool, capacity int, checkpoint IDCheckpoint, generator IDGenerator) buffer {
 	base := bufferBase{buf: make([]messageWithTopics, 0, capacity)}
+	if generator != nil {
+		return &bufferGeneratedID{bufferNoID: bufferNoID{bufferBase: base}, generator: generator}
+	}
 	if autoIDs {
-		return &bufferAutoID{bufferBase: base}
+		b := &bufferAutoID{bufferBase: base, checkpoint: checkpoint}
+		if checkpoint != nil {
+			id, err := checkpoint.Load()
+			if err != nil {
+				panic(fmt.Errorf("go-sse: failed to load ID checkpoint: %w", err))
+			}
+			b.firstID, b.upcomingID, b.reservedID, b.startID = id, id, id, id
+		}
+		return b
 	}
 	return &bufferNoID{bufferBase: base}
 }
@@ -0,0 +1,131 @@
+package sse
+
+import "context"
+
+// CompactingReplayProvider is a replay provider which keeps only the last message published to each topic
+// for each key, like a compacted log: the key of a message is its event type, by default, or the result of
+// the Key function. The retained messages of the subscription's topics are sent to every new subscriber,
+// before the live messages, regardless of the subscription's LastEventID – this way, clients of state-sync
+// streams, like tickers or dashboards, receive a compact snapshot of the current state instead of the full
+// history of updates.
+//
+// Publishing a message replaces the messages with the same key retained by the topics it is published to.
+// Publishing a message without data clears them instead, like with RetainedReplayProvider. The messages must
+// not be modified after they are published.
+//
+// The retained messages are replayed in the order they were published. A message retained by multiple topics
+// of a subscription is replayed once. The IDs of the messages are not changed. The zero value is ready to use.
+type CompactingReplayProvider struct {
+	// Key optionally returns the key of a message, such as the ID of the entity whose state it holds.
+	// It must return the same key for the same message each time it is called. By default, the key
+	// is the message's event type.
+	Key func(msg *Message) string
+
+	retained map[string]map[string]retainedMessage
+	// published is the number of messages published, used to replay the messages in publish order.
+	published uint64
+}
+
+func (c *CompactingReplayProvider) key(msg *Message) string {
+	if c.Key != nil {
+		return c.Key(msg)
+	}
+	if !msg.Type.IsSet() {
+		return DefaultEventType
+	}
+
+	return msg.Type.String()
+}
+
+// Put retains the message for its key in the given topics, or clears the messages retained for its key
+// if it has no data.
+func (c *CompactingReplayProvider) Put(message *Message, topics []string) *Message {
+	if c.retained == nil {
+		c.retained = map[string]map[string]retainedMessage{}
+	}
+
+	key := c.key(message)
+
+	if !message.hasData() {
+		for _, topic := range topics {
+			delete(c.retained[topic], key)
+			if len(c.retained[topic]) == 0 {
+				delete(c.retained, topic)
+			}
+		}
+		return message
+	}
+
+	c.published++
+	for _, topic := range topics {
+		byKey := c.retained[topic]
+		if byKey == nil {
+			byKey = map[string]retainedMessage{}
+			c.retained[topic] = byKey
+		}
+		byKey[key] = retainedMessage{message: message, topics: topics, seq: c.published}
+	}
+
+	return message
+}
+
+// Replay sends to the subscriber the messages retained by its topics.
+func (c *CompactingReplayProvider) Replay(subscription Subscription) error {
+	return c.ReplayContext(context.Background(), subscription)
+}
+
+// ReplayContext sends to the subscriber the messages retained by its topics, until the context is done.
+// See ReplayProviderWithContext for more information.
+func (c *CompactingReplayProvider) ReplayContext(ctx context.Context, subscription Subscription) error {
+	allTopics := func() []string {
+		topics := make([]string, 0, len(c.retained))
+		for topic := range c.retained {
+			topics = append(topics, topic)
+		}
+		return topics
+	}
+
+	return replayRetained(ctx, subscription, allTopics, func(topic string) []retainedMessage {
+		byKey := c.retained[topic]
+		messages := make([]retainedMessage, 0, len(byKey))
+		for _, m := range byKey {
+			messages = append(messages, m)
+		}
+		return messages
+	})
+}
+
+// DropTopic clears the messages retained by the given topic.
+func (c *CompactingReplayProvider) DropTopic(topic string) error {
+	delete(c.retained, topic)
+	return nil
+}
+
+// ReplayStats returns the statistics of the retained messages: each topic has at most one retained message
+// for each key. See ReplayInspector for more information.
+func (c *CompactingReplayProvider) ReplayStats() (ReplayStats, error) {
+	stats := ReplayStats{Topics: make(map[string]ReplayTopicStats, len(c.retained))}
+	seen := map[uint64]struct{}{}
+
+	for topic, byKey := range c.retained {
+		var last retainedMessage
+		for _, m := range byKey {
+			if _, ok := seen[m.seq]; !ok {
+				seen[m.seq] = struct{}{}
+				stats.Events++
+			}
+			if m.seq > last.seq {
+				last = m
+			}
+		}
+		stats.Topics[topic] = ReplayTopicStats{Events: len(byKey), LastEventID: last.message.ID}
+	}
+
+	return stats, nil
+}
+
+var (
+	_ ReplayProviderWithTopicCleanup = (*CompactingReplayProvider)(nil)
+	_ ReplayProviderWithContext      = (*CompactingReplayProvider)(nil)
+	_ ReplayInspector                = (*CompactingReplayProvider)(nil)
+)
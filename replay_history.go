@@ -0,0 +1,124 @@
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// A HistoryWriter writes the retained history of a topic in the event stream format,
+// exactly as it would be replayed to a client. The built-in replay providers and Joe
+// implement this interface.
+type HistoryWriter interface {
+	// WriteHistory writes to w all the retained events published to the given topic, in replay order.
+	// If maxBytes is positive, only the events that fit in maxBytes are written – events are never
+	// written partially. It returns the number of bytes written.
+	WriteHistory(w io.Writer, topic string, maxBytes int) (int64, error)
+}
+
+// ErrHistoryUnsupported is returned by Joe's WriteHistory method if its replay provider
+// does not implement the HistoryWriter interface.
+var ErrHistoryUnsupported = errors.New("go-sse.server: replay provider does not support writing history")
+
+// HistoryHandler returns a handler which responds with the retained history of the topic given in the
+// "topic" query parameter, in the event stream format, so it can be inspected or replayed into a client.
+// If the parameter is missing, the history of the DefaultTopic is written. The response has at most
+// maxBytes bytes, if maxBytes is positive.
+//
+// The built-in replay providers are not thread-safe, so if they are used by a provider, such as Joe,
+// pass the provider to this function instead of the replay provider.
+//
+// The handler exposes all the retained events, so make sure to protect it, as it is intended for debugging.
+func HistoryHandler(h HistoryWriter, maxBytes int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var buf bytes.Buffer
+		if _, err := h.WriteHistory(&buf, r.URL.Query().Get("topic"), maxBytes); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+		_, _ = buf.WriteTo(w)
+	})
+}
+
+// writeHistory writes the events for which isValid returns true and which have the given topic,
+// stopping at the first event which doesn't fit in maxBytes.
+func writeHistory(w io.Writer, events []messageWithTopics, topic string, maxBytes int, isValid func(i int) bool) (int64, error) {
+	var n int64
+	var encoded bytes.Buffer
+	topics := []string{topic}
+
+	for i := range events {
+		if !topicsIntersect(topics, events[i].topics) || (isValid != nil && !isValid(i)) {
+			continue
+		}
+
+		encoded.Reset()
+		_, _ = events[i].message.WriteTo(&encoded)
+
+		if maxBytes > 0 && n+int64(encoded.Len()) > int64(maxBytes) {
+			break
+		}
+
+		m, err := encoded.WriteTo(w)
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// A ReplayInspector reports the events retained by a replay provider, so the replay history can be monitored
+// or debugged. The built-in replay providers and Joe implement this interface.
+type ReplayInspector interface {
+	// ReplayStats returns the statistics of the retained events. Only the events which would be replayed
+	// are taken into account – for example, expired events which weren't removed yet are not.
+	ReplayStats() (ReplayStats, error)
+}
+
+// ReplayStats are the statistics of the events retained by a replay provider.
+type ReplayStats struct {
+	// Events is the number of retained events. An event published to multiple topics is counted once.
+	Events int
+	// Topics holds the statistics of each topic which has retained events.
+	Topics map[string]ReplayTopicStats
+}
+
+// ReplayTopicStats are the statistics of the events retained for a topic.
+type ReplayTopicStats struct {
+	// Events is the number of events retained for the topic.
+	Events int
+	// LastEventID is the ID of the last event retained for the topic. It is unset if the event has no ID.
+	LastEventID EventID
+}
+
+// ErrReplayStatsUnsupported is returned by Joe's ReplayStats method if its replay provider
+// does not implement the ReplayInspector interface.
+var ErrReplayStatsUnsupported = errors.New("go-sse.server: replay provider does not support reporting statistics")
+
+// replayStats returns the statistics of the events for which isValid returns true.
+func replayStats(events []messageWithTopics, isValid func(i int) bool) ReplayStats {
+	stats := ReplayStats{Topics: map[string]ReplayTopicStats{}}
+
+	for i := range events {
+		if isValid != nil && !isValid(i) {
+			continue
+		}
+
+		stats.Events++
+		for _, topic := range events[i].topics {
+			t := stats.Topics[topic]
+			t.Events++
+			t.LastEventID = events[i].message.ID
+			stats.Topics[topic] = t
+		}
+	}
+
+	return stats
+}
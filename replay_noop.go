@@ -6,5 +6,11 @@ type noopReplayProvider struct{}
 
 func (n noopReplayProvider) Put(m *Message, _ []string) *Message { return m }
 func (n noopReplayProvider) Replay(_ Subscription) error         { return nil }
+func (n noopReplayProvider) ReplayStats() (ReplayStats, error) {
+	return ReplayStats{Topics: map[string]ReplayTopicStats{}}, nil
+}
 
-var _ ReplayProvider = (*noopReplayProvider)(nil)
+var (
+	_ ReplayProvider  = (*noopReplayProvider)(nil)
+	_ ReplayInspector = (*noopReplayProvider)(nil)
+)
@@ -1,65 +1,168 @@
 package sse
 
 import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
 	"time"
 )
 
 // FiniteReplayProvider is a replay provider that replays at maximum a certain number of events.
 // GC is a no-op for this provider, as when the maximum number of values is reached
 // and a new value has to be appended, old values are removed from the buffer.
-// The events must have an ID unless the AutoIDs flag is toggled.
+// The events must have an ID unless the AutoIDs flag is toggled or an IDGenerator is set.
 type FiniteReplayProvider struct {
 	b buffer
 
 	// Count is the maximum number of events FiniteReplayProvider should hold as valid.
 	// It must be a positive integer, or the code will panic.
 	Count int
+	// TopicCount optionally limits the number of events held for specific topics, in addition to Count.
+	// It is called for each topic of a message when the message is put in the buffer. If a positive value
+	// is returned and the topic has more events than that, the topic is removed from its oldest event,
+	// which is removed from the buffer if it has no topics left. Otherwise, only Count limits the topic's events.
+	//
+	// Use it to hold more events for some topics than for others – for example, 1000 events for "prices"
+	// and 50 for "alerts", with a Count large enough for all of them.
+	TopicCount func(topic string) int
 	// AutoIDs configures FiniteReplayProvider to automatically set the IDs of events.
 	AutoIDs bool
+	// IDCheckpoint is an optional store for the automatically set IDs, which keeps them increasing
+	// across restarts. It is used only if AutoIDs is set. See IDCheckpoint for more information.
+	IDCheckpoint IDCheckpoint
+	// IDGenerator optionally sets the IDs of the events, instead of the numeric IDs set by AutoIDs.
+	// If it is set, AutoIDs and IDCheckpoint are ignored. See IDGenerator for more information.
+	IDGenerator IDGenerator
+
+	// topicCounts is the number of events of each topic in the buffer. It is kept only if TopicCount is set.
+	topicCounts map[string]int
 }
 
 // Put puts a message into the provider's buffer. If there are more messages than the maximum
-// number, the oldest message is removed.
+// number, the oldest message is removed. The same applies to the topics limited by TopicCount.
 func (f *FiniteReplayProvider) Put(message *Message, topics []string) *Message {
 	if f.b == nil {
-		f.b = getBuffer(f.AutoIDs, f.Count)
+		f.b = getBuffer(f.AutoIDs, f.Count, f.IDCheckpoint, f.IDGenerator)
+		if f.TopicCount != nil {
+			f.topicCounts = map[string]int{}
+		}
+	}
+
+	if f.b.len() >= f.Count {
+		f.dequeue()
+	}
+
+	message = f.b.queue(message, topics)
+	f.b.get(f.b.len() - 1).putAt = time.Now()
+
+	if f.topicCounts != nil {
+		for _, topic := range topics {
+			f.topicCounts[topic]++
+			if limit := f.TopicCount(topic); limit > 0 && f.topicCounts[topic] > limit {
+				f.dropOldest(topic)
+			}
+		}
+	}
+
+	return message
+}
+
+func (f *FiniteReplayProvider) dequeue() {
+	if f.topicCounts != nil {
+		for _, topic := range f.b.front().topics {
+			f.uncount(topic)
+		}
+	}
+
+	f.b.dequeue()
+}
+
+// dropOldest removes the topic from the oldest message published to it.
+func (f *FiniteReplayProvider) dropOldest(topic string) {
+	f.uncount(topic)
+
+	oldest := 0
+	for !topicsIntersect(f.b.get(oldest).topics, []string{topic}) {
+		oldest++
 	}
 
-	if f.b.len() == f.b.cap() {
-		f.b.dequeue()
+	if !dropTopic(f.b.get(oldest), topic) {
+		f.b.filter(func(i int) bool { return i != oldest })
 	}
+}
 
-	return f.b.queue(message, topics)
+func (f *FiniteReplayProvider) uncount(topic string) {
+	if f.topicCounts[topic]--; f.topicCounts[topic] == 0 {
+		delete(f.topicCounts, topic)
+	}
 }
 
 // Replay replays the messages in the buffer to the listener.
 // It doesn't take into account the messages' expiry times.
 func (f *FiniteReplayProvider) Replay(subscription Subscription) error {
+	return f.ReplayContext(context.Background(), subscription)
+}
+
+// ReplayContext replays the messages in the buffer to the listener, until the context is done.
+// See ReplayProviderWithContext for more information.
+func (f *FiniteReplayProvider) ReplayContext(ctx context.Context, subscription Subscription) error {
 	if f.b == nil {
 		return nil
 	}
 
-	events := f.b.slice(subscription.LastEventID)
-	if len(events) == 0 {
+	events := sliceReplayed(f.b, subscription)
+
+	return replayEvents(ctx, subscription, events, func(i int) bool {
+		_, ok := subscription.receivedTopic(events[i].topics)
+		return ok
+	})
+}
+
+// OldestEventTime returns the time the oldest message in the buffer was put at.
+// See ReplayProviderWithTime for more information.
+func (f *FiniteReplayProvider) OldestEventTime() time.Time {
+	return oldestEventTime(f.b)
+}
+
+// DropTopic removes the given topic from all the messages in the buffer.
+// The messages which were published only to the given topic are removed.
+func (f *FiniteReplayProvider) DropTopic(topic string) error {
+	if f.b == nil {
 		return nil
 	}
 
-	for _, e := range events {
-		if topicsIntersect(subscription.Topics, e.topics) {
-			if err := subscription.Client.Send(e.message); err != nil {
-				return err
-			}
-		}
+	f.b.filter(func(i int) bool { return dropTopic(f.b.get(i), topic) })
+	delete(f.topicCounts, topic)
+
+	return nil
+}
+
+// WriteHistory writes all the messages in the buffer published to the given topic, in replay order.
+// See HistoryWriter for more information. It must not be called concurrently with the other methods.
+func (f *FiniteReplayProvider) WriteHistory(w io.Writer, topic string, maxBytes int) (int64, error) {
+	if f.b == nil {
+		return 0, nil
 	}
 
-	return subscription.Client.Flush()
+	return writeHistory(w, f.b.all(), topic, maxBytes, nil)
+}
+
+// ReplayStats returns the statistics of the messages in the buffer. See ReplayInspector for more information.
+// It must not be called concurrently with the other methods.
+func (f *FiniteReplayProvider) ReplayStats() (ReplayStats, error) {
+	if f.b == nil {
+		return replayStats(nil, nil), nil
+	}
+
+	return replayStats(f.b.all(), nil), nil
 }
 
 // ValidReplayProvider is a ReplayProvider that replays all the buffered non-expired events.
 // Call its GC method periodically to remove expired events from the buffer and release resources.
 // You can use this provider for replaying an infinite number of events, if the events never
 // expire.
-// The events must have an ID unless the AutoIDs flag is toggled.
+// The events must have an ID unless the AutoIDs flag is toggled or an IDGenerator is set.
 type ValidReplayProvider struct {
 	// The function used to retrieve the current time. Defaults to time.Now.
 	// Useful when testing.
@@ -69,49 +172,120 @@ type ValidReplayProvider struct {
 	expiries []time.Time
 
 	// TTL is for how long a message is valid, since it was added.
+	// The TTL of a message can be overridden by its TTL field.
 	TTL time.Duration
+	// TopicTTL optionally overrides the TTL for the messages published to specific topics.
+	// It is called for each topic of a message when the message is put in the buffer.
+	// A positive value returned is used as the topic's TTL, otherwise the TTL field's value is used.
+	// A message published to multiple topics is valid for the longest of its topics' TTLs.
+	// It is not called for the messages which have a TTL of their own.
+	//
+	// The expiry time of a message is computed only once, when it is put, so changing
+	// the TTL of a topic at runtime affects only the messages published after the change.
+	TopicTTL func(topic string) time.Duration
 	// AutoIDs configures ValidReplayProvider to automatically set the IDs of events.
 	AutoIDs bool
+	// IDCheckpoint is an optional store for the automatically set IDs, which keeps them increasing
+	// across restarts. It is used only if AutoIDs is set. See IDCheckpoint for more information.
+	IDCheckpoint IDCheckpoint
+	// IDGenerator optionally sets the IDs of the events, instead of the numeric IDs set by AutoIDs.
+	// If it is set, AutoIDs and IDCheckpoint are ignored. See IDGenerator for more information.
+	IDGenerator IDGenerator
 }
 
 // Put puts the message into the provider's buffer.
 func (v *ValidReplayProvider) Put(message *Message, topics []string) *Message {
 	if v.b == nil {
-		v.b = getBuffer(v.AutoIDs, 0)
+		v.b = getBuffer(v.AutoIDs, 0, v.IDCheckpoint, v.IDGenerator)
+	}
+
+	now := v.now()
+	message = v.b.queue(message, topics)
+	v.b.get(v.b.len() - 1).putAt = now
+	v.expiries = append(v.expiries, now.Add(v.ttl(message, topics)))
+
+	return message
+}
+
+func (v *ValidReplayProvider) ttl(message *Message, topics []string) time.Duration {
+	if message.TTL > 0 {
+		return message.TTL
+	}
+	if v.TopicTTL == nil {
+		return v.TTL
+	}
+
+	var ttl time.Duration
+	for _, topic := range topics {
+		t := v.TopicTTL(topic)
+		if t <= 0 {
+			t = v.TTL
+		}
+		if t > ttl {
+			ttl = t
+		}
 	}
 
-	v.expiries = append(v.expiries, v.now().Add(v.TTL))
-	return v.b.queue(message, topics)
+	return ttl
 }
 
 // GC removes all the expired messages from the provider's buffer.
+// As messages can have different TTLs, expired messages are removed
+// even if older messages are still valid.
 func (v *ValidReplayProvider) GC() error {
 	if v.b == nil {
 		return nil
 	}
 
 	now := v.now()
+	v.filter(func(i int) bool { return v.expiries[i].After(now) })
 
-	for {
-		e := v.b.front()
-		if e == nil || v.expiries[0].After(now) {
-			break
-		}
+	return nil
+}
 
-		v.b.dequeue()
-		v.expiries = v.expiries[1:]
+// DropTopic removes the given topic from all the messages in the buffer.
+// The messages which were published only to the given topic are removed.
+func (v *ValidReplayProvider) DropTopic(topic string) error {
+	if v.b == nil {
+		return nil
 	}
 
+	v.filter(func(i int) bool { return dropTopic(v.b.get(i), topic) })
+
 	return nil
 }
 
+// filter removes the messages for which keep returns false, together with their expiry times.
+func (v *ValidReplayProvider) filter(keep func(i int) bool) {
+	n := 0
+
+	v.b.filter(func(i int) bool {
+		if !keep(i) {
+			return false
+		}
+
+		v.expiries[n] = v.expiries[i]
+		n++
+
+		return true
+	})
+
+	v.expiries = v.expiries[:n]
+}
+
 // Replay replays all the valid messages to the listener.
 func (v *ValidReplayProvider) Replay(subscription Subscription) error {
+	return v.ReplayContext(context.Background(), subscription)
+}
+
+// ReplayContext replays all the valid messages to the listener, until the context is done.
+// See ReplayProviderWithContext for more information.
+func (v *ValidReplayProvider) ReplayContext(ctx context.Context, subscription Subscription) error {
 	if v.b == nil {
 		return nil
 	}
 
-	events := v.b.slice(subscription.LastEventID)
+	events := sliceReplayed(v.b, subscription)
 	if len(events) == 0 {
 		return nil
 	}
@@ -119,15 +293,47 @@ func (v *ValidReplayProvider) Replay(subscription Subscription) error {
 	now := v.now()
 	expiriesOffset := v.b.len() - len(events)
 
-	for i, e := range events {
-		if v.expiries[i+expiriesOffset].After(now) && topicsIntersect(subscription.Topics, e.topics) {
-			if err := subscription.Client.Send(e.message); err != nil {
-				return err
-			}
+	return replayEvents(ctx, subscription, events, func(i int) bool {
+		if !v.expiries[i+expiriesOffset].After(now) {
+			return false
 		}
+		_, ok := subscription.receivedTopic(events[i].topics)
+		return ok
+	})
+}
+
+// WriteHistory writes all the non-expired messages in the buffer published to the given topic, in replay order.
+// See HistoryWriter for more information. It must not be called concurrently with the other methods.
+func (v *ValidReplayProvider) WriteHistory(w io.Writer, topic string, maxBytes int) (int64, error) {
+	if v.b == nil {
+		return 0, nil
+	}
+
+	now := v.now()
+
+	return writeHistory(w, v.b.all(), topic, maxBytes, func(i int) bool {
+		return v.expiries[i].After(now)
+	})
+}
+
+// ReplayStats returns the statistics of the non-expired messages in the buffer. See ReplayInspector
+// for more information. It must not be called concurrently with the other methods.
+func (v *ValidReplayProvider) ReplayStats() (ReplayStats, error) {
+	if v.b == nil {
+		return replayStats(nil, nil), nil
 	}
 
-	return subscription.Client.Flush()
+	now := v.now()
+
+	return replayStats(v.b.all(), func(i int) bool {
+		return v.expiries[i].After(now)
+	}), nil
+}
+
+// OldestEventTime returns the time the oldest message in the buffer was put at, expired or not.
+// See ReplayProviderWithTime for more information.
+func (v *ValidReplayProvider) OldestEventTime() time.Time {
+	return oldestEventTime(v.b)
 }
 
 func (v *ValidReplayProvider) now() time.Time {
@@ -138,6 +344,183 @@ func (v *ValidReplayProvider) now() time.Time {
 	return v.Now()
 }
 
+var (
+	_ ReplayProviderWithTime         = (*FiniteReplayProvider)(nil)
+	_ ReplayProviderWithTime         = (*ValidReplayProvider)(nil)
+	_ ReplayProviderWithTopicCleanup = (*FiniteReplayProvider)(nil)
+	_ ReplayProviderWithTopicCleanup = (*ValidReplayProvider)(nil)
+	_ HistoryWriter                  = (*FiniteReplayProvider)(nil)
+	_ HistoryWriter                  = (*ValidReplayProvider)(nil)
+	_ ReplayInspector                = (*FiniteReplayProvider)(nil)
+	_ ReplayInspector                = (*ValidReplayProvider)(nil)
+	_ ReplayProviderWithContext      = (*FiniteReplayProvider)(nil)
+	_ ReplayProviderWithContext      = (*ValidReplayProvider)(nil)
+)
+
+// IDCheckpoint persists the progress of the automatically set IDs, so that a replay provider
+// continues issuing IDs where the previous process left off, instead of starting again from zero.
+// Without it, after a restart the IDs of new events collide with the IDs clients have already seen,
+// so reconnecting clients would miss events or receive them twice.
+//
+// The replay provider doesn't store every ID it issues. Instead, it reserves blocks of IDCheckpointInterval IDs:
+// before issuing the first ID of a block, it stores the ID following the block, so after a restart
+// the IDs continue from there. This guarantees that no ID is issued twice and that the IDs keep increasing
+// across restarts, as long as the checkpoint is shared only by one replay provider at a time. Up to
+// IDCheckpointInterval IDs are skipped at each restart.
+//
+// The events published by the previous process are not replayed after a restart, as they are not
+// in the new replay provider's buffer. Clients which reconnect with an ID issued before the restart
+// are replayed all the events published after it, if none of them were removed from the buffer yet.
+//
+// The methods are called on the replay provider's goroutine, which for Joe is its event loop,
+// so they should return quickly. If a method returns an error, the replay provider panics,
+// as continuing could issue duplicate IDs – see Joe's OnReplayError field for handling such failures.
+type IDCheckpoint interface {
+	// Load returns the ID stored last, or 0 if no ID was stored.
+	Load() (int64, error)
+	// Store stores the given ID. Only IDs lower than the stored one were issued.
+	Store(id int64) error
+}
+
+// IDCheckpointInterval is the number of IDs replay providers issue between the calls to IDCheckpoint.Store.
+const IDCheckpointInterval = 1000
+
+// ReplayGapEventType is the type of the event the built-in replay providers send
+// before replaying events to a subscriber, if some of the events that should have been
+// replayed were skipped – because of the subscription's MaxReplayed limit, for example.
+// It is also sent after the replayed events if the replay took too long and was stopped.
+// The event's data is the number of skipped events and it has no ID, so the client's
+// last event ID is not changed.
+//
+// Clients that receive this event should resynchronize their state.
+const ReplayGapEventType = "sse-replay-gap"
+
+func newReplayGapMessage(skipped int) *Message {
+	m := &Message{Type: Type(ReplayGapEventType)}
+	m.AppendData(strconv.Itoa(skipped))
+	return m
+}
+
+// replayEvents sends to the subscriber the events for which isValid returns true and which pass its filter,
+// respecting the subscription's MaxReplayed limit. It stops when the context is done,
+// signaling the gap to the subscriber if the context's deadline was exceeded.
+func replayEvents(ctx context.Context, sub Subscription, events []messageWithTopics, isValid func(i int) bool) error {
+	if len(events) == 0 {
+		return nil
+	}
+	if sub.Filter != nil {
+		isValidEvent := isValid
+		isValid = func(i int) bool { return isValidEvent(i) && sub.Filter(events[i].message) }
+	}
+
+	skip := 0
+	if sub.MaxReplayed > 0 {
+		count := 0
+		for i := range events {
+			if isValid(i) {
+				count++
+			}
+		}
+
+		skip = count - sub.MaxReplayed
+		if skip > 0 {
+			if err := sub.Client.Send(newReplayGapMessage(skip)); err != nil {
+				return err
+			}
+		}
+	}
+
+	replayed := 0
+	for i := range events {
+		if !isValid(i) {
+			continue
+		}
+		if skip > 0 {
+			skip--
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return stopReplay(sub, err, replayed, events, i, isValid)
+		}
+		m := sub.TopicAttribution.annotate(events[i].message, sub.attributedTopic(events[i].topics))
+		if err := sub.Client.Send(m); err != nil {
+			return err
+		}
+		replayed++
+	}
+
+	return sub.Client.Flush()
+}
+
+// stopReplay ends a replay stopped because of the given context error before sending the event at the given index.
+func stopReplay(sub Subscription, ctxErr error, replayed int, events []messageWithTopics, next int, isValid func(i int) bool) error {
+	count := 0
+	for i := next; i < len(events); i++ {
+		if isValid(i) {
+			count++
+		}
+	}
+
+	err := &PartialReplayError{Err: ctxErr, Replayed: replayed, Remaining: count}
+	if !errors.Is(ctxErr, context.DeadlineExceeded) {
+		// The subscriber left, so there's no one to notify.
+		return err
+	}
+
+	if sendErr := sub.Client.Send(newReplayGapMessage(count)); sendErr != nil {
+		return sendErr
+	}
+	if flushErr := sub.Client.Flush(); flushErr != nil {
+		return flushErr
+	}
+
+	return err
+}
+
+// sliceReplayed returns the messages in the buffer which are replayed to the subscription: those after
+// its LastEventID or, if it has none, those put at or after its Since time.
+func sliceReplayed(b buffer, sub Subscription) []messageWithTopics {
+	if sub.LastEventID.IsSet() || sub.Since.IsZero() {
+		return b.slice(sub.LastEventID, sub.InclusiveReplay)
+	}
+
+	events := b.all()
+	for i := range events {
+		if !events[i].putAt.Before(sub.Since) {
+			return events[i:]
+		}
+	}
+
+	return nil
+}
+
+func oldestEventTime(b buffer) time.Time {
+	if b == nil || b.len() == 0 {
+		return time.Time{}
+	}
+
+	return b.front().putAt
+}
+
+// dropTopic removes the topic from the message's topics. It returns false if the message has no topics left.
+// The topics slice is not modified in place, as it may be shared with other messages.
+func dropTopic(m *messageWithTopics, topic string) bool {
+	if !topicsIntersect(m.topics, []string{topic}) {
+		return true
+	}
+
+	topics := make([]string, 0, len(m.topics)-1)
+	for _, t := range m.topics {
+		if t != topic {
+			topics = append(topics, t)
+		}
+	}
+
+	m.topics = topics
+
+	return len(topics) > 0
+}
+
 // topicsIntersect returns true if the given topic slices have at least one topic in common.
 func topicsIntersect(a, b []string) bool {
 	for _, at := range a {
@@ -0,0 +1,152 @@
+package sse
+
+import (
+	"context"
+	"sort"
+)
+
+// RetainedReplayProvider is a replay provider which keeps only the last message published to each topic,
+// like the retained messages of MQTT. The retained messages of the subscription's topics are sent to every
+// new subscriber, before the live messages, regardless of the subscription's LastEventID – this is useful for
+// streams where new clients only need the current state, like dashboards, and it is much lighter than
+// keeping the full history of events.
+//
+// Publishing a message replaces the retained messages of all the topics it is published to. Publishing
+// a message without data clears the retained messages of its topics instead – such a message is still sent
+// to the current subscribers, which can use it to reset their state, but note that browsers don't dispatch
+// events without data. The messages must not be modified after they are published.
+//
+// The retained messages are replayed in the order they were published. A message retained by multiple topics
+// of a subscription is replayed once. The IDs of the messages are not changed, so messages without IDs don't
+// change the clients' last event ID. The zero value is ready to use.
+type RetainedReplayProvider struct {
+	retained map[string]retainedMessage
+	// published is the number of messages published, used to replay the messages in publish order.
+	published uint64
+}
+
+type retainedMessage struct {
+	message *Message
+	topics  []string
+	seq     uint64
+}
+
+// Put retains the message for the given topics, or clears their retained messages if it has no data.
+func (r *RetainedReplayProvider) Put(message *Message, topics []string) *Message {
+	if r.retained == nil {
+		r.retained = map[string]retainedMessage{}
+	}
+
+	if !message.hasData() {
+		for _, topic := range topics {
+			delete(r.retained, topic)
+		}
+		return message
+	}
+
+	r.published++
+	for _, topic := range topics {
+		r.retained[topic] = retainedMessage{message: message, topics: topics, seq: r.published}
+	}
+
+	return message
+}
+
+// Replay sends to the subscriber the messages retained by its topics.
+func (r *RetainedReplayProvider) Replay(subscription Subscription) error {
+	return r.ReplayContext(context.Background(), subscription)
+}
+
+// ReplayContext sends to the subscriber the messages retained by its topics, until the context is done.
+// See ReplayProviderWithContext for more information.
+func (r *RetainedReplayProvider) ReplayContext(ctx context.Context, subscription Subscription) error {
+	allTopics := func() []string {
+		topics := make([]string, 0, len(r.retained))
+		for topic := range r.retained {
+			topics = append(topics, topic)
+		}
+		return topics
+	}
+
+	return replayRetained(ctx, subscription, allTopics, func(topic string) []retainedMessage {
+		if m, ok := r.retained[topic]; ok {
+			return []retainedMessage{m}
+		}
+		return nil
+	})
+}
+
+// replayRetained sends to the subscriber the messages retained by its topics, in the order they were published.
+// A message retained by multiple topics is sent once, attributed to the subscription's topics which retain it.
+// The topics which retain messages are listed only if the subscription's topics may be patterns.
+func replayRetained(ctx context.Context, subscription Subscription, allTopics func() []string, retainedBy func(topic string) []retainedMessage) error {
+	var retained []retainedMessage
+	// The messages are attributed to the subscription's topics which still retain them.
+	retainedTopics := map[uint64][]string{}
+
+	retain := func(topic string) {
+		for _, m := range retainedBy(topic) {
+			if _, seen := retainedTopics[m.seq]; !seen {
+				retained = append(retained, m)
+			}
+			retainedTopics[m.seq] = append(retainedTopics[m.seq], topic)
+		}
+	}
+
+	if subscription.matchTopic != nil {
+		// The subscription's topics may be patterns, so all the retained topics are matched against them.
+		for _, topic := range allTopics() {
+			if _, ok := subscription.receivedTopic([]string{topic}); ok {
+				retain(topic)
+			}
+		}
+	} else {
+		for _, topic := range subscription.Topics {
+			retain(topic)
+		}
+	}
+
+	sort.Slice(retained, func(i, j int) bool { return retained[i].seq < retained[j].seq })
+
+	events := make([]messageWithTopics, 0, len(retained))
+	for _, m := range retained {
+		topics := make([]string, 0, len(retainedTopics[m.seq]))
+		for _, topic := range m.topics {
+			if topicsIntersect(retainedTopics[m.seq], []string{topic}) {
+				topics = append(topics, topic)
+			}
+		}
+		events = append(events, messageWithTopics{message: m.message, topics: topics})
+	}
+
+	return replayEvents(ctx, subscription, events, func(int) bool { return true })
+}
+
+// DropTopic clears the message retained by the given topic.
+func (r *RetainedReplayProvider) DropTopic(topic string) error {
+	delete(r.retained, topic)
+	return nil
+}
+
+// ReplayStats returns the statistics of the retained messages: each topic has at most one retained message.
+// See ReplayInspector for more information.
+func (r *RetainedReplayProvider) ReplayStats() (ReplayStats, error) {
+	stats := ReplayStats{Topics: make(map[string]ReplayTopicStats, len(r.retained))}
+	seen := map[uint64]struct{}{}
+
+	for topic, m := range r.retained {
+		if _, ok := seen[m.seq]; !ok {
+			seen[m.seq] = struct{}{}
+			stats.Events++
+		}
+		stats.Topics[topic] = ReplayTopicStats{Events: 1, LastEventID: m.message.ID}
+	}
+
+	return stats, nil
+}
+
+var (
+	_ ReplayProviderWithTopicCleanup = (*RetainedReplayProvider)(nil)
+	_ ReplayProviderWithContext      = (*RetainedReplayProvider)(nil)
+	_ ReplayInspector                = (*RetainedReplayProvider)(nil)
+)
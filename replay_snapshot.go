@@ -0,0 +1,107 @@
+package sse
+
+import (
+	"context"
+	"errors"
+)
+
+// SnapshotReplayProvider is a replay provider for stateful streams, where the events are deltas which update
+// the clients' state. New subscribers without a last event ID are sent a snapshot of the current state,
+// followed by the deltas published after it, instead of all the deltas since the stream started. Subscribers
+// with a last event ID are sent the deltas published after it, as usual.
+//
+// The deltas are stored by the Deltas replay provider. The snapshot must have the ID of the last delta it
+// reflects, so the deltas published after it are replayed – Deltas must retain them. The zero value is
+// not ready to use, as the Deltas and Snapshot fields must be set.
+type SnapshotReplayProvider struct {
+	// Deltas is the replay provider which stores the published deltas. It must not be nil.
+	Deltas ReplayProvider
+	// Snapshot returns the event with the current state of the subscription's topics, which is sent to
+	// subscribers without a last event ID. Its ID must be the ID of the last delta it reflects. If it returns
+	// a nil message, the deltas are replayed as if there was no snapshot. If it returns an error, the replay
+	// fails with the error. It is called where Replay is called – on Joe's event loop, for example –, so it
+	// must return quickly: compute the snapshot from state held in memory.
+	Snapshot func(sub Subscription) (*Message, error)
+}
+
+// ErrSnapshotNoID is returned by SnapshotReplayProvider if the snapshot has no ID, as the deltas
+// published after it can't be determined.
+var ErrSnapshotNoID = errors.New("go-sse.server: snapshot has no ID")
+
+// Put puts the delta in the Deltas replay provider.
+func (s *SnapshotReplayProvider) Put(message *Message, topics []string) *Message {
+	return s.Deltas.Put(message, topics)
+}
+
+// Replay sends to the subscriber the snapshot and the deltas published after it, or only the deltas
+// published after its last event ID, if it has one.
+func (s *SnapshotReplayProvider) Replay(subscription Subscription) error {
+	return s.ReplayContext(context.Background(), subscription)
+}
+
+// ReplayContext replays the events like Replay, until the context is done. The snapshot is always sent,
+// while the deltas are replayed until the context is done only if Deltas implements ReplayProviderWithContext.
+// See ReplayProviderWithContext for more information.
+func (s *SnapshotReplayProvider) ReplayContext(ctx context.Context, subscription Subscription) error {
+	if !subscription.LastEventID.IsSet() {
+		snapshot, err := s.Snapshot(subscription)
+		if err != nil {
+			return err
+		}
+		if snapshot != nil {
+			if !snapshot.ID.IsSet() {
+				return ErrSnapshotNoID
+			}
+			if err = subscription.Client.Send(snapshot); err != nil {
+				return err
+			}
+			if err = subscription.Client.Flush(); err != nil {
+				return err
+			}
+
+			subscription.LastEventID = snapshot.ID
+			subscription.InclusiveReplay = false
+		}
+	}
+
+	if r, ok := s.Deltas.(ReplayProviderWithContext); ok {
+		return r.ReplayContext(ctx, subscription)
+	}
+
+	return s.Deltas.Replay(subscription)
+}
+
+// GC runs the Deltas replay provider's GC, if it implements ReplayProviderWithGC.
+func (s *SnapshotReplayProvider) GC() error {
+	if r, ok := s.Deltas.(ReplayProviderWithGC); ok {
+		return r.GC()
+	}
+
+	return nil
+}
+
+// DropTopic drops the topic from the Deltas replay provider, if it implements ReplayProviderWithTopicCleanup.
+func (s *SnapshotReplayProvider) DropTopic(topic string) error {
+	if r, ok := s.Deltas.(ReplayProviderWithTopicCleanup); ok {
+		return r.DropTopic(topic)
+	}
+
+	return nil
+}
+
+// ReplayStats returns the statistics of the deltas, if the Deltas replay provider implements ReplayInspector.
+// Otherwise, ErrReplayStatsUnsupported is returned.
+func (s *SnapshotReplayProvider) ReplayStats() (ReplayStats, error) {
+	if r, ok := s.Deltas.(ReplayInspector); ok {
+		return r.ReplayStats()
+	}
+
+	return ReplayStats{}, ErrReplayStatsUnsupported
+}
+
+var (
+	_ ReplayProviderWithGC           = (*SnapshotReplayProvider)(nil)
+	_ ReplayProviderWithTopicCleanup = (*SnapshotReplayProvider)(nil)
+	_ ReplayProviderWithContext      = (*SnapshotReplayProvider)(nil)
+	_ ReplayInspector                = (*SnapshotReplayProvider)(nil)
+)
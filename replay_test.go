@@ -1,6 +1,12 @@
 package sse_test
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -145,3 +151,911 @@ The message is the following:
 
 	testReplayError(t, &sse.FiniteReplayProvider{Count: 10}, nil)
 }
+
+func TestFiniteReplayProvider_TopicCount(t *testing.T) {
+	t.Parallel()
+
+	p := &sse.FiniteReplayProvider{
+		Count:   5,
+		AutoIDs: true,
+		TopicCount: func(topic string) int {
+			if topic == "alerts" {
+				return 1
+			}
+			return 0
+		},
+	}
+
+	p.Put(msg(t, "a", ""), []string{"alerts"})
+	p.Put(msg(t, "b", ""), []string{"prices"})
+	p.Put(msg(t, "c", ""), []string{"alerts", "prices"})
+	p.Put(msg(t, "d", ""), []string{"prices"})
+	p.Put(msg(t, "e", ""), []string{"alerts"})
+
+	replayed := replay(t, p, sse.ID("0"), "alerts")
+	require.Len(t, replayed, 1, "topic limit not applied")
+	require.Equal(t, "id: 4\ndata: e\n\n", replayed[0].String())
+
+	replayed = replay(t, p, sse.ID("0"), "prices")
+	require.Len(t, replayed, 3, "events of other topics removed")
+	require.Equal(t, "id: 2\ndata: c\n\n", replayed[1].String(), "event removed from its other topics")
+
+	for i := 0; i < 10; i++ {
+		p.Put(msg(t, "f", ""), []string{"prices"})
+	}
+
+	var ids []string
+	require.NoError(t, p.Replay(sse.Subscription{
+		Client: mockClient(func(m *sse.Message) error {
+			if m != nil {
+				ids = append(ids, m.ID.String())
+			}
+			return nil
+		}),
+		LastEventID: sse.ID("8"),
+		Topics:      []string{"prices", "alerts"},
+	}))
+	require.Empty(t, ids, "count limit not applied")
+}
+
+func TestReplayProvider_inclusive(t *testing.T) {
+	t.Parallel()
+
+	replayInclusive := func(tb testing.TB, p sse.ReplayProvider, lastEventID string) string {
+		tb.Helper()
+
+		s := ""
+		_ = p.Replay(sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					s += m.String()
+				}
+				return nil
+			}),
+			LastEventID:     sse.ID(lastEventID),
+			Topics:          []string{sse.DefaultTopic},
+			InclusiveReplay: true,
+		})
+		return s
+	}
+
+	p := &sse.FiniteReplayProvider{Count: 2}
+	p.Put(msg(t, "a", "1"), []string{sse.DefaultTopic})
+	p.Put(msg(t, "b", "2"), []string{sse.DefaultTopic})
+	p.Put(msg(t, "c", "3"), []string{sse.DefaultTopic})
+
+	require.Equal(t, "id: 2\ndata: b\n\nid: 3\ndata: c\n\n", replayInclusive(t, p, "2"), "event with last ID not replayed")
+	require.Equal(t, "id: 2\ndata: b\n\nid: 3\ndata: c\n\n", replayInclusive(t, p, "1"), "removed event should be skipped")
+	require.Equal(t, "", replayInclusive(t, p, "unknown"), "invalid ID should not replay")
+
+	a := &sse.FiniteReplayProvider{Count: 2, AutoIDs: true}
+	a.Put(msg(t, "a", ""), []string{sse.DefaultTopic})
+	a.Put(msg(t, "b", ""), []string{sse.DefaultTopic})
+	a.Put(msg(t, "c", ""), []string{sse.DefaultTopic})
+
+	require.Equal(t, "id: 2\ndata: c\n\n", replayInclusive(t, a, "2"), "event with last ID not replayed")
+	require.Equal(t, "id: 1\ndata: b\n\nid: 2\ndata: c\n\n", replayInclusive(t, a, "0"), "removed event should be skipped")
+	require.Equal(t, "", replayInclusive(t, a, "3"), "future ID should not replay")
+}
+
+func TestValidReplayProvider_TopicTTL(t *testing.T) {
+	t.Parallel()
+
+	tm := &tests.Time{}
+	tm.Set(time.Now())
+
+	longTTL := time.Millisecond * 50
+	p := &sse.ValidReplayProvider{
+		TTL:     time.Millisecond * 5,
+		AutoIDs: true,
+		Now:     tm.Now,
+		TopicTTL: func(topic string) time.Duration {
+			if topic == "long" {
+				return longTTL
+			}
+			return 0
+		},
+	}
+
+	p.Put(msg(t, "a", ""), []string{sse.DefaultTopic})
+	p.Put(msg(t, "b", ""), []string{"long"})
+	p.Put(msg(t, "c", ""), []string{sse.DefaultTopic})
+	p.Put(msg(t, "d", ""), []string{sse.DefaultTopic, "long"})
+
+	// Changing the TTL must not affect already put messages.
+	longTTL = time.Millisecond
+
+	tm.Add(time.Millisecond * 10)
+	require.NoError(t, p.GC(), "unexpected GC error")
+
+	replayed := replay(t, p, sse.ID("0"), sse.DefaultTopic, "long")
+	require.Len(t, replayed, 2, "invalid replayed message count")
+	require.Equal(t, "id: 1\ndata: b\n\n", replayed[0].String())
+	require.Equal(t, "id: 3\ndata: d\n\n", replayed[1].String())
+
+	replayed = replay(t, p, sse.ID("2"), sse.DefaultTopic)
+	require.Len(t, replayed, 1, "replay from expired message failed")
+	require.Equal(t, "id: 3\ndata: d\n\n", replayed[0].String())
+
+	tm.Add(time.Millisecond * 50)
+	require.NoError(t, p.GC(), "unexpected GC error")
+	require.Empty(t, replay(t, p, sse.ID("0"), sse.DefaultTopic, "long"), "expired messages replayed")
+}
+
+func TestValidReplayProvider_messageTTL(t *testing.T) {
+	t.Parallel()
+
+	tm := &tests.Time{}
+	tm.Set(time.Now())
+
+	p := &sse.ValidReplayProvider{
+		TTL:      time.Millisecond * 5,
+		AutoIDs:  true,
+		Now:      tm.Now,
+		TopicTTL: func(string) time.Duration { return time.Millisecond * 20 },
+	}
+
+	short, long := msg(t, "short", ""), msg(t, "long", "")
+	short.TTL, long.TTL = time.Millisecond, time.Millisecond*50
+
+	p.Put(msg(t, "a", ""), []string{sse.DefaultTopic})
+	p.Put(short, []string{sse.DefaultTopic})
+	p.Put(long, []string{sse.DefaultTopic})
+
+	tm.Add(time.Millisecond * 10)
+	require.NoError(t, p.GC(), "unexpected GC error")
+
+	replayed := replay(t, p, sse.ID("0"))
+	require.Len(t, replayed, 1, "message with shorter TTL replayed")
+	require.Equal(t, "id: 2\ndata: long\n\n", replayed[0].String())
+
+	tm.Add(time.Millisecond * 20)
+	require.NoError(t, p.GC(), "unexpected GC error")
+
+	replayed = replay(t, p, sse.ID("0"))
+	require.Len(t, replayed, 1, "message with longer TTL not replayed")
+
+	tm.Add(time.Millisecond * 30)
+	require.NoError(t, p.GC(), "unexpected GC error")
+	require.Empty(t, replay(t, p, sse.ID("0")), "expired message replayed")
+}
+
+func TestReplayProvider_MaxReplayed(t *testing.T) {
+	t.Parallel()
+
+	replayMax := func(tb testing.TB, p sse.ReplayProvider, maxReplayed int) string {
+		tb.Helper()
+
+		s := ""
+		err := p.Replay(sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					s += m.String()
+				}
+				return nil
+			}),
+			LastEventID: sse.ID("0"),
+			Topics:      []string{sse.DefaultTopic},
+			MaxReplayed: maxReplayed,
+		})
+		require.NoError(tb, err, "unexpected replay error")
+		return s
+	}
+
+	providers := map[string]sse.ReplayProvider{
+		"Finite": &sse.FiniteReplayProvider{Count: 10, AutoIDs: true},
+		"Valid":  &sse.ValidReplayProvider{TTL: time.Hour, AutoIDs: true},
+	}
+
+	for name, p := range providers {
+		p := p
+
+		t.Run(name, func(t *testing.T) {
+			p.Put(msg(t, "a", ""), []string{sse.DefaultTopic})
+			p.Put(msg(t, "b", ""), []string{sse.DefaultTopic})
+			p.Put(msg(t, "c", ""), []string{"other"})
+			p.Put(msg(t, "d", ""), []string{sse.DefaultTopic})
+			p.Put(msg(t, "e", ""), []string{sse.DefaultTopic})
+
+			require.Equal(t, "event: sse-replay-gap\ndata: 1\n\nid: 3\ndata: d\n\nid: 4\ndata: e\n\n", replayMax(t, p, 2), "invalid truncated replay")
+			require.Equal(t, "id: 1\ndata: b\n\nid: 3\ndata: d\n\nid: 4\ndata: e\n\n", replayMax(t, p, 3), "replay truncated unnecessarily")
+			require.Equal(t, "id: 1\ndata: b\n\nid: 3\ndata: d\n\nid: 4\ndata: e\n\n", replayMax(t, p, 0), "replay should be unlimited")
+		})
+	}
+}
+
+func TestReplayProvider_ReplayContext(t *testing.T) {
+	t.Parallel()
+
+	providers := map[string]sse.ReplayProviderWithContext{
+		"Finite": &sse.FiniteReplayProvider{Count: 10, AutoIDs: true},
+		"Valid":  &sse.ValidReplayProvider{TTL: time.Hour, AutoIDs: true},
+	}
+
+	for name, p := range providers {
+		p := p
+
+		t.Run(name, func(t *testing.T) {
+			p.Put(msg(t, "a", ""), []string{sse.DefaultTopic})
+			p.Put(msg(t, "b", ""), []string{sse.DefaultTopic})
+			p.Put(msg(t, "c", ""), []string{"other"})
+			p.Put(msg(t, "d", ""), []string{sse.DefaultTopic})
+
+			s := ""
+			ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+			defer cancel()
+
+			err := p.ReplayContext(ctx, sse.Subscription{
+				Client: mockClient(func(m *sse.Message) error {
+					if m != nil {
+						s += m.String()
+					}
+					return nil
+				}),
+				LastEventID: sse.ID("0"),
+				Topics:      []string{sse.DefaultTopic},
+			})
+
+			var partial *sse.PartialReplayError
+			require.ErrorAs(t, err, &partial, "expected partial replay")
+			require.ErrorIs(t, err, context.DeadlineExceeded, "invalid partial replay cause")
+			require.Equal(t, sse.PartialReplayError{Err: context.DeadlineExceeded, Replayed: 0, Remaining: 2}, *partial, "invalid partial replay")
+			require.Equal(t, "event: sse-replay-gap\ndata: 2\n\n", s, "gap should be signaled")
+
+			s = ""
+			ctx, cancel = context.WithCancel(context.Background())
+			defer cancel()
+
+			err = p.ReplayContext(ctx, sse.Subscription{
+				Client: mockClient(func(m *sse.Message) error {
+					if m != nil {
+						s += m.String()
+						cancel()
+					}
+					return nil
+				}),
+				LastEventID: sse.ID("0"),
+				Topics:      []string{sse.DefaultTopic},
+			})
+
+			require.ErrorAs(t, err, &partial, "expected partial replay")
+			require.Equal(t, sse.PartialReplayError{Err: context.Canceled, Replayed: 1, Remaining: 1}, *partial, "invalid partial replay")
+			require.Equal(t, "id: 1\ndata: b\n\n", s, "gap shouldn't be signaled to subscribers which left")
+		})
+	}
+}
+
+func TestReplayProvider_DropTopic(t *testing.T) {
+	t.Parallel()
+
+	providers := map[string]sse.ReplayProviderWithTopicCleanup{
+		"Finite": &sse.FiniteReplayProvider{Count: 10, AutoIDs: true},
+		"Valid":  &sse.ValidReplayProvider{TTL: time.Hour, AutoIDs: true},
+	}
+
+	for name, p := range providers {
+		p := p
+
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, p.DropTopic("x"), "dropping from an empty provider failed")
+
+			topics := []string{"x", "y"}
+
+			p.Put(msg(t, "a", ""), []string{"x"})
+			p.Put(msg(t, "b", ""), topics)
+			p.Put(msg(t, "c", ""), []string{"y"})
+			p.Put(msg(t, "d", ""), []string{"x"})
+
+			require.NoError(t, p.DropTopic("x"), "unexpected DropTopic error")
+			require.Equal(t, []string{"x", "y"}, topics, "topics slice was modified")
+
+			require.Empty(t, replay(t, p, sse.ID("0"), "x"), "dropped topic was replayed")
+			require.Equal(t, []string{"id: 1\ndata: b\n\n", "id: 2\ndata: c\n\n"}, msgStrings(replay(t, p, sse.ID("0"), "y")), "invalid replay of other topic")
+		})
+	}
+}
+
+func TestReplayProvider_removedFromMiddle(t *testing.T) {
+	t.Parallel()
+
+	tm := &tests.Time{}
+	tm.Set(time.Now())
+
+	cases := map[string]struct {
+		provider sse.ReplayProvider
+		put      func(p sse.ReplayProvider)
+		topics   []string
+	}{
+		"Topic TTL": {
+			provider: &sse.ValidReplayProvider{
+				TTL: time.Hour,
+				Now: tm.Now,
+				TopicTTL: func(topic string) time.Duration {
+					if topic == "b" {
+						return time.Millisecond
+					}
+					return 0
+				},
+			},
+			put: func(p sse.ReplayProvider) {
+				tm.Add(time.Second)
+				require.NoError(t, p.(*sse.ValidReplayProvider).GC(), "unexpected GC error")
+			},
+			topics: []string{"a"},
+		},
+		"Message TTL": {
+			provider: &sse.ValidReplayProvider{TTL: time.Hour, Now: tm.Now},
+			put: func(p sse.ReplayProvider) {
+				tm.Add(time.Second)
+				require.NoError(t, p.(*sse.ValidReplayProvider).GC(), "unexpected GC error")
+			},
+			topics: []string{"a"},
+		},
+		"Topic count": {
+			provider: &sse.FiniteReplayProvider{
+				Count: 10,
+				TopicCount: func(topic string) int {
+					if topic == "b" {
+						return 1
+					}
+					return 0
+				},
+			},
+			put: func(p sse.ReplayProvider) {
+				p.Put(msg(t, "d", "4"), []string{"b"})
+			},
+			topics: []string{"a", "b"},
+		},
+		"Dropped topic": {
+			provider: &sse.FiniteReplayProvider{Count: 10},
+			put: func(p sse.ReplayProvider) {
+				require.NoError(t, p.(sse.ReplayProviderWithTopicCleanup).DropTopic("b"), "unexpected DropTopic error")
+			},
+			topics: []string{"a"},
+		},
+	}
+
+	for name, test := range cases {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			p := test.provider
+
+			short := msg(t, "b", "2")
+			if name == "Message TTL" {
+				short.TTL = time.Millisecond
+			}
+
+			p.Put(msg(t, "a", "1"), []string{"a"})
+			p.Put(short, []string{"b"})
+			p.Put(msg(t, "c", "3"), []string{"a"})
+			test.put(p)
+
+			expected := []string{"id: 3\ndata: c\n\n"}
+			if name == "Topic count" {
+				expected = append(expected, "id: 4\ndata: d\n\n")
+			}
+
+			require.Equal(t, expected, msgStrings(replay(t, p, sse.ID("2"), test.topics...)), "messages after the removed one not replayed")
+			require.Equal(t, expected, msgStrings(replay(t, p, sse.ID("1"), test.topics...)), "invalid replay before the removed message")
+			require.Empty(t, replay(t, p, sse.ID("4"), "x"), "unexpected replay for unknown ID")
+		})
+	}
+
+	t.Run("Pruned", func(t *testing.T) {
+		p := &sse.FiniteReplayProvider{Count: 2}
+		p.Put(msg(t, "a", "1"), []string{"a"})
+		p.Put(msg(t, "b", "2"), []string{"b"})
+		p.Put(msg(t, "c", "3"), []string{"a"})
+		require.NoError(t, p.DropTopic("b"), "unexpected DropTopic error")
+
+		require.Equal(t, []string{"id: 3\ndata: c\n\n"}, msgStrings(replay(t, p, sse.ID("2"), "a")), "invalid replay from removed message")
+
+		p.Put(msg(t, "d", "4"), []string{"a"})
+		p.Put(msg(t, "e", "5"), []string{"a"})
+
+		require.Empty(t, replay(t, p, sse.ID("2"), "a"), "removed message older than the buffer not pruned")
+		require.Equal(t, []string{"id: 4\ndata: d\n\n", "id: 5\ndata: e\n\n"}, msgStrings(replay(t, p, sse.ID("3"), "a")), "invalid replay from last removed message")
+	})
+}
+
+func TestReplayProvider_WriteHistory(t *testing.T) {
+	t.Parallel()
+
+	providers := map[string]sse.ReplayProvider{
+		"Finite": &sse.FiniteReplayProvider{Count: 10, AutoIDs: true},
+		"Valid":  &sse.ValidReplayProvider{TTL: time.Hour, AutoIDs: true},
+	}
+
+	for name, p := range providers {
+		p := p
+
+		t.Run(name, func(t *testing.T) {
+			p.Put(msg(t, "a", ""), []string{"x"})
+			p.Put(msg(t, "b", ""), []string{"y"})
+			p.Put(msg(t, "c", ""), []string{"x", "y"})
+			p.Put(msg(t, "d", ""), []string{"x"})
+
+			h := p.(sse.HistoryWriter) //nolint:forcetypeassert // The built-in providers implement it.
+
+			sb := &strings.Builder{}
+			n, err := h.WriteHistory(sb, "x", 0)
+			require.NoError(t, err, "unexpected WriteHistory error")
+			require.Equal(t, "id: 0\ndata: a\n\nid: 2\ndata: c\n\nid: 3\ndata: d\n\n", sb.String(), "invalid history")
+			require.Equal(t, int64(sb.Len()), n, "invalid written byte count")
+
+			sb.Reset()
+			_, err = h.WriteHistory(sb, "x", 30)
+			require.NoError(t, err, "unexpected WriteHistory error")
+			require.Equal(t, "id: 0\ndata: a\n\nid: 2\ndata: c\n\n", sb.String(), "history not limited")
+		})
+	}
+
+	t.Run("Handler", func(t *testing.T) {
+		t.Parallel()
+
+		j := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 10, AutoIDs: true}}
+		defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		require.NoError(t, j.Publish(msg(t, "a", ""), []string{"x"}))
+		require.NoError(t, j.Publish(msg(t, "b", ""), []string{sse.DefaultTopic}))
+
+		rec := httptest.NewRecorder()
+		sse.HistoryHandler(j, 0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?topic=x", http.NoBody))
+
+		require.Equal(t, http.StatusOK, rec.Code, "invalid response code")
+		require.Equal(t, "text/event-stream", rec.Header().Get("Content-Type"), "invalid content type")
+		require.Equal(t, "id: 0\ndata: a\n\n", rec.Body.String(), "invalid response body")
+
+		unsupported := &sse.Joe{}
+		defer unsupported.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		rec = httptest.NewRecorder()
+		sse.HistoryHandler(unsupported, 0).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+		require.Equal(t, http.StatusInternalServerError, rec.Code, "invalid response code")
+		require.Equal(t, sse.ErrHistoryUnsupported.Error()+"\n", rec.Body.String(), "invalid response body")
+	})
+}
+
+func TestReplayProvider_ReplayStats(t *testing.T) {
+	t.Parallel()
+
+	providers := map[string]sse.ReplayProvider{
+		"Finite":   &sse.FiniteReplayProvider{Count: 10, AutoIDs: true},
+		"Valid":    &sse.ValidReplayProvider{TTL: time.Hour, AutoIDs: true},
+		"Retained": &sse.RetainedReplayProvider{},
+	}
+	expected := map[string]sse.ReplayStats{
+		"Finite": {Events: 3, Topics: map[string]sse.ReplayTopicStats{
+			"x": {Events: 2, LastEventID: sse.ID("2")},
+			"y": {Events: 2, LastEventID: sse.ID("2")},
+		}},
+		"Retained": {Events: 1, Topics: map[string]sse.ReplayTopicStats{
+			"x": {Events: 1, LastEventID: sse.ID("c")},
+			"y": {Events: 1, LastEventID: sse.ID("c")},
+		}},
+	}
+	expected["Valid"] = expected["Finite"]
+
+	for name, p := range providers {
+		p := p
+		name := name
+
+		t.Run(name, func(t *testing.T) {
+			p.Put(msg(t, "a", "a"), []string{"x"})
+			p.Put(msg(t, "b", "b"), []string{"y"})
+			p.Put(msg(t, "c", "c"), []string{"x", "y"})
+
+			stats, err := p.(sse.ReplayInspector).ReplayStats() //nolint:forcetypeassert // The built-in providers implement it.
+			require.NoError(t, err, "unexpected ReplayStats error")
+			require.Equal(t, expected[name], stats, "invalid stats")
+		})
+	}
+
+	t.Run("Handler", func(t *testing.T) {
+		t.Parallel()
+
+		j := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 10, AutoIDs: true}}
+		defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		ctx, cancel := newMockContext(t)
+		defer cancel()
+
+		sub := subscribe(t, j, ctx, "y")
+		<-ctx.waitingOnDone
+
+		require.NoError(t, j.Publish(msg(t, "a", ""), []string{"x"}))
+		require.NoError(t, j.Publish(msg(t, "b", ""), []string{"x"}))
+
+		rec := httptest.NewRecorder()
+		sse.DebugHandler(j).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+
+		expected := `{"subscribers":1,"topicRegistrations":1,"retainedEvents":2,"topics":{` +
+			`"x":{"subscribers":0,"retainedEvents":2,"lastEventID":"1"},` +
+			`"y":{"subscribers":1,"retainedEvents":0}}}`
+
+		require.Equal(t, http.StatusOK, rec.Code, "invalid response code")
+		require.Equal(t, "application/json", rec.Header().Get("Content-Type"), "invalid content type")
+		require.JSONEq(t, expected, rec.Body.String(), "invalid response body")
+
+		cancel()
+		<-sub
+	})
+}
+
+type memoryIDCheckpoint struct {
+	id     int64
+	stores int
+}
+
+func (m *memoryIDCheckpoint) Load() (int64, error) { return m.id, nil }
+func (m *memoryIDCheckpoint) Store(id int64) error {
+	m.id = id
+	m.stores++
+	return nil
+}
+
+func TestReplayProvider_IDCheckpoint(t *testing.T) {
+	t.Parallel()
+
+	checkpoint := &memoryIDCheckpoint{}
+	subscribeClient := func(j *sse.Joe, lastEventID sse.EventID) (<-chan []*sse.Message, context.CancelFunc) {
+		ctx, cancel := newMockContext(t)
+		ch := make(chan []*sse.Message, 1)
+		go func() {
+			var msgs []*sse.Message
+			_ = j.Subscribe(ctx, sse.Subscription{
+				Client: mockClient(func(m *sse.Message) error {
+					if m != nil {
+						msgs = append(msgs, m)
+					}
+					return nil
+				}),
+				LastEventID: lastEventID,
+				Topics:      []string{sse.DefaultTopic},
+			})
+			ch <- msgs
+		}()
+		<-ctx.waitingOnDone
+		return ch, cancel
+	}
+
+	first := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 10, AutoIDs: true, IDCheckpoint: checkpoint}}
+	sub, cancel := subscribeClient(first, sse.EventID{})
+	defer cancel()
+
+	require.NoError(t, first.Publish(msg(t, "a", ""), []string{sse.DefaultTopic}))
+	require.NoError(t, first.Publish(msg(t, "b", ""), []string{sse.DefaultTopic}))
+	// The process restarts mid-stream: the client is disconnected and reconnects to the new process.
+	require.NoError(t, first.Shutdown(context.Background()))
+
+	received := <-sub
+	require.Equal(t, []string{"id: 0\ndata: a\n\n", "id: 1\ndata: b\n\n"}, msgStrings(received), "invalid messages before restart")
+	require.Equal(t, int64(sse.IDCheckpointInterval), checkpoint.id, "reserved IDs not stored")
+	require.Equal(t, 1, checkpoint.stores, "IDs stored more than once for a block")
+
+	second := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 10, AutoIDs: true, IDCheckpoint: checkpoint}}
+	defer second.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	// Published before the client reconnects, so it must be replayed.
+	require.NoError(t, second.Publish(msg(t, "c", ""), []string{sse.DefaultTopic}))
+
+	sub, cancel = subscribeClient(second, received[len(received)-1].ID)
+	require.NoError(t, second.Publish(msg(t, "d", ""), []string{sse.DefaultTopic}))
+	require.NoError(t, second.Ping(context.Background()))
+	cancel()
+
+	expected := []string{"id: 1000\ndata: c\n\n", "id: 1001\ndata: d\n\n"}
+	require.Equal(t, expected, msgStrings(<-sub), "invalid messages after restart")
+	require.Equal(t, int64(2*sse.IDCheckpointInterval), checkpoint.id, "reserved IDs not stored after restart")
+}
+
+func TestReplayProvider_Since(t *testing.T) {
+	t.Parallel()
+
+	tm := &tests.Time{}
+	start := time.Now()
+	tm.Set(start)
+
+	p := &sse.ValidReplayProvider{TTL: time.Hour, AutoIDs: true, Now: tm.Now}
+	require.True(t, p.OldestEventTime().IsZero(), "oldest time set without events")
+
+	p.Put(msg(t, "a", ""), []string{sse.DefaultTopic})
+	tm.Add(time.Minute)
+	p.Put(msg(t, "b", ""), []string{sse.DefaultTopic})
+	tm.Add(time.Minute)
+	p.Put(msg(t, "c", ""), []string{sse.DefaultTopic})
+
+	require.True(t, start.Equal(p.OldestEventTime()), "invalid oldest time")
+
+	replaySince := func(p sse.ReplayProvider, sub sse.Subscription) []string {
+		t.Helper()
+
+		var replayed []*sse.Message
+		sub.Client = mockClient(func(m *sse.Message) error {
+			if m != nil {
+				replayed = append(replayed, m)
+			}
+			return nil
+		})
+		sub.Topics = []string{sse.DefaultTopic}
+		require.NoError(t, p.Replay(sub), "replay failed")
+
+		return msgStrings(replayed)
+	}
+
+	since := start.Add(time.Minute)
+	require.Equal(t, []string{"id: 1\ndata: b\n\n", "id: 2\ndata: c\n\n"}, replaySince(p, sse.Subscription{Since: since}), "invalid replay since time")
+	require.Equal(t, []string{"id: 2\ndata: c\n\n"}, replaySince(p, sse.Subscription{Since: since, LastEventID: sse.ID("1")}), "last event ID not preferred")
+	require.Empty(t, replaySince(p, sse.Subscription{Since: start.Add(time.Hour)}), "events after since time replayed")
+	require.Empty(t, replaySince(p, sse.Subscription{}), "events replayed without ID or time")
+
+	f := &sse.FiniteReplayProvider{Count: 5, AutoIDs: true}
+	before := time.Now()
+	f.Put(msg(t, "a", ""), []string{sse.DefaultTopic})
+	require.False(t, f.OldestEventTime().Before(before), "invalid oldest time")
+	require.Equal(t, []string{"id: 0\ndata: a\n\n"}, replaySince(f, sse.Subscription{Since: before}), "invalid finite replay since time")
+
+	t.Run("Session", func(t *testing.T) {
+		t.Parallel()
+
+		for query, expected := range map[string]time.Time{
+			"":                              {},
+			"?since=1700000000":             time.Unix(1700000000, 0),
+			"?since=2024-05-01T12:00:00Z":   time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC),
+			"?since=yesterday":              {},
+			"?topic=a&since=1700000000&x=1": time.Unix(1700000000, 0),
+		} {
+			sess, err := sse.Upgrade(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/"+query, http.NoBody))
+			require.NoError(t, err, "upgrade failed")
+			require.True(t, expected.Equal(sess.Since), "invalid since time for %q: %v", query, sess.Since)
+		}
+	})
+}
+
+func TestIDGenerators(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	generators := []struct {
+		name    string
+		gen     sse.IDGenerator
+		format  *regexp.Regexp
+		compare func(a, b string) bool
+	}{
+		{"counter", &sse.CounterIDGenerator{}, regexp.MustCompile(`^\d+$`), lessNumeric},
+		{"ULID", &sse.ULIDGenerator{Now: clock}, regexp.MustCompile(`^01HWT0D7G0[0-9A-HJKMNP-TV-Z]{16}$`), lessString},
+		{"UUIDv7", &sse.UUIDv7Generator{Now: clock}, regexp.MustCompile(`^018f3406-9e00-7[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), lessString},
+		{"snowflake", &sse.SnowflakeGenerator{Now: clock, Node: 5}, regexp.MustCompile(`^\d+$`), lessNumeric},
+	}
+
+	for _, g := range generators {
+		g := g
+		t.Run(g.name, func(t *testing.T) {
+			t.Parallel()
+
+			// More IDs than the UUIDv7 and snowflake generators fit in a millisecond,
+			// so the overflow into the next one is exercised.
+			prev := g.gen.NextID().String()
+			require.Regexp(t, g.format, prev, "invalid ID format")
+			for i := 0; i < 5000; i++ {
+				id := g.gen.NextID().String()
+				require.True(t, g.compare(prev, id), "IDs not increasing: %q, %q", prev, id)
+				prev = id
+			}
+		})
+	}
+
+	t.Run("snowflake layout", func(t *testing.T) {
+		t.Parallel()
+
+		g := &sse.SnowflakeGenerator{Now: clock, Epoch: now.Add(-time.Millisecond), Node: 3}
+		require.Equal(t, strconv.Itoa(1<<22|3<<12), g.NextID().String(), "invalid snowflake ID")
+		require.Equal(t, strconv.Itoa(1<<22|3<<12|1), g.NextID().String(), "invalid sequence")
+	})
+
+	t.Run("replay provider", func(t *testing.T) {
+		t.Parallel()
+
+		for _, p := range []sse.ReplayProvider{
+			&sse.FiniteReplayProvider{Count: 5, AutoIDs: true, IDGenerator: &sse.CounterIDGenerator{}},
+			&sse.ValidReplayProvider{TTL: time.Hour, IDGenerator: &sse.CounterIDGenerator{}},
+		} {
+			m := msg(t, "hello", "")
+			require.Equal(t, "id: 0\ndata: hello\n\n", p.Put(m, []string{sse.DefaultTopic}).String(), "invalid generated ID")
+			require.Equal(t, "data: hello\n\n", m.String(), "put message was modified")
+
+			p.Put(msg(t, "world", ""), []string{sse.DefaultTopic})
+			require.Equal(t, []string{"id: 1\ndata: world\n\n"}, msgStrings(replay(t, p, sse.ID("0"))[:1]), "invalid replay")
+		}
+	})
+}
+
+func lessString(a, b string) bool { return a < b }
+
+func lessNumeric(a, b string) bool {
+	x, _ := strconv.ParseInt(a, 10, 64)
+	y, _ := strconv.ParseInt(b, 10, 64)
+	return x < y
+}
+
+func TestRetainedReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	p := &sse.RetainedReplayProvider{}
+
+	replayTopics := func(tb testing.TB, topics ...string) []string {
+		tb.Helper()
+
+		var replayed []string
+		err := p.Replay(sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					replayed = append(replayed, m.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID("100"),
+			Topics:      topics,
+		})
+		require.NoError(tb, err, "unexpected replay error")
+
+		return replayed
+	}
+
+	require.Empty(t, replayTopics(t, "a"), "nothing should be replayed")
+
+	p.Put(msg(t, "first", "1"), []string{"a", "b"})
+	p.Put(msg(t, "second", ""), []string{"a"})
+	p.Put(msg(t, "third", "3"), []string{"c"})
+
+	expected := []string{"id: 1\ndata: first\n\n", "data: second\n\n", "id: 3\ndata: third\n\n"}
+	require.Equal(t, expected, replayTopics(t, "c", "b", "a"), "retained messages not replayed in publish order")
+	require.Equal(t, []string{"data: second\n\n"}, replayTopics(t, "a"), "message not replaced")
+	require.Equal(t, []string{"id: 1\ndata: first\n\n"}, replayTopics(t, "b", "d"), "invalid retained message")
+
+	clearing := &sse.Message{Type: sse.Type("cleared")}
+	require.Equal(t, clearing, p.Put(clearing, []string{"a", "c"}), "clearing message should be dispatched")
+	require.Empty(t, replayTopics(t, "a", "c"), "retained messages not cleared")
+
+	require.NoError(t, p.DropTopic("b"))
+	require.Empty(t, replayTopics(t, "b"), "retained message not dropped")
+
+	j := &sse.Joe{ReplayProvider: &sse.RetainedReplayProvider{}}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	require.NoError(t, j.Publish(msg(t, "stale", ""), []string{sse.DefaultTopic}))
+	require.NoError(t, j.Publish(msg(t, "current", ""), []string{sse.DefaultTopic}))
+
+	ctx, cancel := newMockContext(t)
+	sub := subscribe(t, j, ctx, sse.DefaultTopic)
+	<-ctx.waitingOnDone
+
+	require.NoError(t, j.Publish(msg(t, "live", ""), []string{sse.DefaultTopic}))
+	cancel()
+
+	require.Equal(t, []string{"data: current\n\n", "data: live\n\n"}, msgStrings(<-sub), "retained message not sent before live ones")
+}
+
+func TestCompactingReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	typed := func(data, typ, id string) *sse.Message {
+		m := msg(t, data, id)
+		m.Type = sse.Type(typ)
+		return m
+	}
+
+	replayTopics := func(tb testing.TB, p sse.ReplayProvider, topics ...string) []string {
+		tb.Helper()
+
+		var replayed []string
+		err := p.Replay(sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					replayed = append(replayed, m.String())
+				}
+				return nil
+			}),
+			LastEventID: sse.ID("100"),
+			Topics:      topics,
+		})
+		require.NoError(tb, err, "unexpected replay error")
+
+		return replayed
+	}
+
+	p := &sse.CompactingReplayProvider{}
+	require.Empty(t, replayTopics(t, p, "a"), "nothing should be replayed")
+
+	p.Put(typed("1", "btc", "1"), []string{"a"})
+	p.Put(typed("10", "eth", "2"), []string{"a", "b"})
+	p.Put(typed("2", "btc", "3"), []string{"a"})
+	p.Put(msg(t, "untyped", "4"), []string{"b"})
+
+	expected := []string{"id: 2\nevent: eth\ndata: 10\n\n", "id: 3\nevent: btc\ndata: 2\n\n"}
+	require.Equal(t, expected, replayTopics(t, p, "a"), "messages not compacted")
+	expected = []string{"id: 2\nevent: eth\ndata: 10\n\n", "id: 3\nevent: btc\ndata: 2\n\n", "id: 4\ndata: untyped\n\n"}
+	require.Equal(t, expected, replayTopics(t, p, "b", "a"), "messages not replayed once, in publish order")
+
+	stats, err := p.ReplayStats()
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Events, "invalid event count")
+	require.Equal(t, sse.ReplayTopicStats{Events: 2, LastEventID: sse.ID("3")}, stats.Topics["a"], "invalid topic stats")
+
+	p.Put(&sse.Message{Type: sse.Type("eth")}, []string{"a"})
+	require.Equal(t, []string{"id: 3\nevent: btc\ndata: 2\n\n"}, replayTopics(t, p, "a"), "key not cleared")
+	require.Equal(t, []string{"id: 2\nevent: eth\ndata: 10\n\n", "id: 4\ndata: untyped\n\n"}, replayTopics(t, p, "b"), "key cleared in other topic")
+
+	require.NoError(t, p.DropTopic("b"))
+	require.Empty(t, replayTopics(t, p, "b"), "topic not dropped")
+
+	keyed := &sse.CompactingReplayProvider{Key: func(m *sse.Message) string { return m.ID.String()[:1] }}
+	keyed.Put(msg(t, "first", "x1"), []string{sse.DefaultTopic})
+	keyed.Put(msg(t, "second", "y1"), []string{sse.DefaultTopic})
+	keyed.Put(msg(t, "third", "x2"), []string{sse.DefaultTopic})
+	require.Equal(t, []string{"id: y1\ndata: second\n\n", "id: x2\ndata: third\n\n"}, replayTopics(t, keyed, sse.DefaultTopic), "custom key not used")
+}
+
+func TestSnapshotReplayProvider(t *testing.T) {
+	t.Parallel()
+
+	// The state is the sum of the deltas, which the snapshot reflects up to the delta with ID 3.
+	p := &sse.SnapshotReplayProvider{
+		Deltas: &sse.FiniteReplayProvider{Count: 10},
+		Snapshot: func(sub sse.Subscription) (*sse.Message, error) {
+			if sub.Topics[0] == "none" {
+				return nil, nil
+			}
+			m := msg(t, "sum=6", "3")
+			m.Type = sse.Type("snapshot")
+			return m, nil
+		},
+	}
+
+	j := &sse.Joe{ReplayProvider: p}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, j.Publish(msg(t, "+"+strconv.Itoa(i), strconv.Itoa(i)), []string{"sum", "none"}))
+	}
+	// The deltas are put on Joe's event loop, so wait for them before replaying directly.
+	require.NoError(t, j.Ping(context.Background()))
+
+	replayed := func(lastEventID sse.EventID, topic string) []string {
+		t.Helper()
+
+		var msgs []*sse.Message
+		err := p.Replay(sse.Subscription{
+			Client: mockClient(func(m *sse.Message) error {
+				if m != nil {
+					msgs = append(msgs, m)
+				}
+				return nil
+			}),
+			LastEventID: lastEventID,
+			Topics:      []string{topic},
+		})
+		require.NoError(t, err, "replay failed")
+
+		return msgStrings(msgs)
+	}
+
+	expected := []string{"id: 3\nevent: snapshot\ndata: sum=6\n\n", "id: 4\ndata: +4\n\n", "id: 5\ndata: +5\n\n"}
+	require.Equal(t, expected, replayed(sse.EventID{}, "sum"), "invalid snapshot replay")
+	require.Equal(t, []string{"id: 5\ndata: +5\n\n"}, replayed(sse.ID("4"), "sum"), "invalid replay with last event ID")
+	require.Empty(t, replayed(sse.EventID{}, "none"), "deltas replayed without snapshot or ID")
+
+	ctx, cancel := newMockContext(t)
+	sub := subscribe(t, j, ctx, "sum")
+	<-ctx.waitingOnDone
+	require.NoError(t, j.Publish(msg(t, "+6", "6"), []string{"sum"}))
+	cancel()
+	require.Equal(t, append(expected, "id: 6\ndata: +6\n\n"), msgStrings(<-sub), "invalid subscription messages")
+
+	p.Snapshot = func(sse.Subscription) (*sse.Message, error) { return msg(t, "no id", ""), nil }
+	err := p.Replay(sse.Subscription{Client: mockClient(func(*sse.Message) error { return nil }), Topics: []string{"sum"}})
+	require.ErrorIs(t, err, sse.ErrSnapshotNoID, "snapshot without ID accepted")
+}
@@ -0,0 +1,149 @@
+package sse
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// The names used to transport resumption tokens. See ResumptionTokens for more information.
+const (
+	// ResumptionTokenHeader is the name of the header in which the Server sends the resumption token
+	// and in which the clients send it back on reconnection.
+	ResumptionTokenHeader = "Sse-Resumption-Token"
+	// ResumptionTokenQueryParam is the name of the query parameter in which clients that can't set
+	// headers, such as browsers using EventSource, can send the resumption token on reconnection.
+	ResumptionTokenQueryParam = "resumption_token"
+	// ResumptionTokenEventType is the type of the event in which the Server sends the resumption token,
+	// before any other event. The event's data is the token.
+	ResumptionTokenEventType = "sse-resumption-token"
+)
+
+// ResumptionTokens issues and validates the opaque tokens that allow clients to resume their sessions,
+// so applications can reattach the per-session state they hold – a cursor into an expensive computation,
+// for example. The state itself is owned by the application: tokens only carry a session ID, which is
+// signed using HMAC-SHA256 and expires after a while. See the Server's Resumption field for usage.
+type ResumptionTokens struct {
+	// The function used to retrieve the current time. Defaults to time.Now.
+	// Useful when testing.
+	Now func() time.Time
+	// Key is the secret key used to sign the tokens. It must not be empty.
+	Key []byte
+	// TTL is for how long a token is valid after it is issued. Defaults to 1 hour.
+	TTL time.Duration
+}
+
+// ErrInvalidResumptionToken is returned when a resumption token is malformed,
+// its signature is invalid or it is expired.
+var ErrInvalidResumptionToken = errors.New("go-sse.server: invalid resumption token")
+
+const (
+	defaultResumptionTTL = time.Hour
+	resumptionIDLength   = 16
+	resumptionExpiryLen  = 8
+)
+
+var resumptionEncoding = base64.RawURLEncoding
+
+// NewResumptionID returns a new random session ID, to be used with the Issue method.
+func NewResumptionID() string {
+	var b [resumptionIDLength]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(errors.New("go-sse.server: failed to generate resumption ID: " + err.Error()))
+	}
+
+	return hex.EncodeToString(b[:])
+}
+
+// Issue returns a token for the session with the given ID.
+func (r *ResumptionTokens) Issue(id string) string {
+	payload := make([]byte, resumptionExpiryLen, resumptionExpiryLen+len(id))
+	binary.BigEndian.PutUint64(payload, uint64(r.now().Add(r.ttl()).Unix()))
+	payload = append(payload, id...)
+
+	return resumptionEncoding.EncodeToString(payload) + "." + resumptionEncoding.EncodeToString(r.sign(payload))
+}
+
+// Parse validates the given token and returns the ID of the session it was issued for.
+// The returned error is ErrInvalidResumptionToken if the token is invalid or expired.
+func (r *ResumptionTokens) Parse(token string) (id string, err error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidResumptionToken
+	}
+
+	payload, err := resumptionEncoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) < resumptionExpiryLen {
+		return "", ErrInvalidResumptionToken
+	}
+	signature, err := resumptionEncoding.DecodeString(encodedSignature)
+	if err != nil || !hmac.Equal(signature, r.sign(payload)) {
+		return "", ErrInvalidResumptionToken
+	}
+
+	expiry := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if !r.now().Before(expiry) {
+		return "", ErrInvalidResumptionToken
+	}
+
+	return string(payload[resumptionExpiryLen:]), nil
+}
+
+func (r *ResumptionTokens) sign(payload []byte) []byte {
+	if len(r.Key) == 0 {
+		panic(errors.New("go-sse.server: ResumptionTokens.Key must not be empty"))
+	}
+
+	mac := hmac.New(sha256.New, r.Key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (r *ResumptionTokens) ttl() time.Duration {
+	if r.TTL <= 0 {
+		return defaultResumptionTTL
+	}
+	return r.TTL
+}
+
+func (r *ResumptionTokens) now() time.Time {
+	if r.Now == nil {
+		return time.Now()
+	}
+	return r.Now()
+}
+
+// resume sets the resumption ID of the session, reusing the one from the token sent by the client,
+// if it is valid, and sets the response header with the token for the session.
+func (r *ResumptionTokens) resume(sess *Session) (token string) {
+	received := sess.Req.Header.Get(ResumptionTokenHeader)
+	if received == "" {
+		received = sess.Req.URL.Query().Get(ResumptionTokenQueryParam)
+	}
+
+	if received != "" {
+		if id, err := r.Parse(received); err == nil {
+			sess.ResumptionID, sess.Resumed = id, true
+		}
+	}
+	if !sess.Resumed {
+		sess.ResumptionID = NewResumptionID()
+	}
+
+	token = r.Issue(sess.ResumptionID)
+	sess.Res.Header().Set(ResumptionTokenHeader, token)
+
+	return token
+}
+
+func newResumptionTokenMessage(token string) *Message {
+	m := &Message{Type: Type(ResumptionTokenEventType)}
+	m.AppendData(token)
+	return m
+}
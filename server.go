@@ -19,8 +19,15 @@ package sse
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/exp/slog"
 )
@@ -34,12 +41,66 @@ type Subscription struct {
 	// The events will replay starting from the first valid event sent after the one with the given ID.
 	// If the ID is invalid replaying events will be omitted and new events will be sent as normal.
 	LastEventID EventID
+	// InclusiveReplay makes the replay start with the event that has the LastEventID, instead of the
+	// first event sent after it. This is useful for clients that persist the ID of the last event they
+	// started processing, instead of the last one they finished processing. If the event with the given ID
+	// is not available anymore, the replay starts with the first available event after it.
+	//
+	// Defaults to false, in which case the replay is exclusive. The built-in replay providers honor this flag.
+	InclusiveReplay bool
+	// Since optionally requests the replay of the events published at or after the given time, for clients
+	// which don't have a last event ID – because they connect for the first time, for example – but want
+	// the recent history. It is used only if LastEventID is not set. Replay providers which honor it implement
+	// ReplayProviderWithTime, as the built-in FiniteReplayProvider and ValidReplayProvider do.
+	// The Server sets it to the Session's Since if OnSession is not set.
+	Since time.Time
+	// MaxReplayed limits the number of events replayed to the client. If more events are available
+	// for replay, only the most recent MaxReplayed events are sent, preceded by an event of type
+	// ReplayGapEventType which signals that events were skipped and the client should resynchronize.
+	// This prevents replays from dominating bandwidth after many clients reconnect at once.
+	//
+	// Zero means no limit. The built-in replay providers honor this limit.
+	MaxReplayed int
 	// The topics to receive message from. If no topic is specified, a default topic is implied.
 	// Topics are orthogonal to event types. They are used to filter what the server sends to each client.
 	//
 	// If using a Provider directly, without a Server instance, you must specify at least one topic.
 	// The Server automatically adds the default topic if no topic is specified.
 	Topics []string
+	// ResumptionID identifies the session's state, if the Server issues resumption tokens.
+	// The Server sets it to the Session's ResumptionID if OnSession doesn't set it.
+	// See ResumptionTokens for more information.
+	ResumptionID string
+	// TopicAttribution configures how the events sent to the client are annotated with the topic
+	// they were delivered for. The Server sets it to its TopicAttribution if OnSession doesn't set it.
+	// See TopicAttribution for more information.
+	TopicAttribution TopicAttribution
+	// OnOverflow configures what the provider does when the client can't keep up with the published
+	// messages. If it is not set, the provider's policy is used – Joe uses it only if its
+	// SubscriberBufferSize is set. See OverflowPolicy for more information.
+	OnOverflow OverflowPolicy
+	// Filter is an optional function which tells whether a message is sent to the client, so the client
+	// receives only the messages of its topics which match its own criteria – of a user or a severity,
+	// for example – without a topic for each client. It is called with the message as it was published,
+	// before the topic attribution, and it must not modify it. It is called on the provider's goroutines,
+	// so it should be fast. The built-in providers and replay providers honor it.
+	Filter func(*Message) bool
+
+	// onReplayDone is called by Joe after the events are replayed to the client.
+	onReplayDone func(d time.Duration, events int)
+	// onPartialReplay is called by Joe if the replay was stopped before all the events were replayed.
+	onPartialReplay func(err error)
+	// flushOnAccept is set by the Server if the client must be flushed once the subscription is accepted,
+	// so Joe sends the session's preamble before any event. Other providers send it with the first event.
+	flushOnAccept bool
+	// matchTopic is set by Joe if the subscription's topics can match other topics than themselves,
+	// so the built-in replay providers replay the events of the matched topics. See Joe's WildcardTopics field.
+	matchTopic func(subscribed, published string) bool
+}
+
+// accepts tells whether the message passes the subscription's filter, if it has one.
+func (s *Subscription) accepts(m *Message) bool {
+	return s.Filter == nil || s.Filter(m)
 }
 
 // A Provider is a publish-subscribe system that can be used to implement a HTML5 server-sent events
@@ -53,12 +114,17 @@ type Subscription struct {
 type Provider interface {
 	// Subscribe to the provider. The context is used to remove the subscriber automatically
 	// when it is done. Errors returned by the subscription's callback function must be returned
-	// by Subscribe.
+	// by Subscribe. If the subscription ends because the provider is shut down, Subscribe must
+	// return ErrProviderClosed, so callers can distinguish it from the client going away.
 	//
 	// Providers can assume that the topics list for a subscription has at least one topic.
 	Subscribe(ctx context.Context, subscription Subscription) error
 	// Publish a message to all the subscribers that are subscribed to the given topics.
-	// The topics slice must be non-empty, or ErrNoTopic will be raised.
+	// The topics slice must be non-empty, or ErrNoTopic will be raised. Nil messages must be
+	// rejected with ErrNilMessage, synchronously, so the error is returned to the caller.
+	//
+	// Providers should deliver the messages to each subscriber in the order they were published,
+	// even if they were published to different topics, and document if they don't.
 	Publish(message *Message, topics []string) error
 	// Shutdown stops the provider. Calling Shutdown will clean up all the provider's resources
 	// and make Subscribe and Publish fail with an error. All the listener channels will be
@@ -72,9 +138,115 @@ type Provider interface {
 	Shutdown(ctx context.Context) error
 }
 
+// A TopicReplacer is a Provider which can atomically replace the topics of its subscriptions.
+// Joe implements this interface. See Server.ReplaceTopics for more information.
+type TopicReplacer interface {
+	// ReplaceTopics replaces the topics of the given client's subscription and returns the previous ones.
+	// No message may be delivered based on a mix of the previous and the new topics. If the client
+	// is not subscribed, ErrNotSubscribed must be returned.
+	ReplaceTopics(ctx context.Context, client MessageWriter, topics []string) (previous []string, err error)
+}
+
+// A TopicUpdater is a Provider which can atomically add topics to its subscriptions or remove topics from them.
+// Joe implements this interface. See Server.AddTopics and Server.RemoveTopics for more information.
+type TopicUpdater interface {
+	// AddTopics adds the topics to the given client's subscription. The topics it already has are ignored.
+	// If the client is not subscribed, ErrNotSubscribed must be returned.
+	AddTopics(ctx context.Context, client MessageWriter, topics []string) error
+	// RemoveTopics removes the topics from the given client's subscription. The topics it doesn't have
+	// are ignored. If no topics would be left, the subscription must not be changed and ErrNoTopic
+	// must be returned. If the client is not subscribed, ErrNotSubscribed must be returned.
+	RemoveTopics(ctx context.Context, client MessageWriter, topics []string) error
+}
+
+// A PublishReporter is a Provider which can report to how many subscribers a message was delivered.
+// Joe implements this interface. See Server.PublishWithResult for more information.
+type PublishReporter interface {
+	// PublishWithResult publishes the message like Publish and waits for it to be delivered to the subscribers
+	// of the given topics, which are then reported. It must return when the given context is done, at the latest.
+	PublishWithResult(ctx context.Context, msg *Message, topics []string) (PublishResult, error)
+}
+
+// PublishResult reports the delivery of a published message to the subscribers of this server instance.
+type PublishResult struct {
+	// Topics holds, for each topic the message was published to, the number of subscribers which received
+	// the message for that topic. A subscriber of multiple of the topics receives the message once, so it is
+	// counted only for the first of them. Subscribers of other topics which match the message's topics,
+	// such as patterns, are counted for the matched topics.
+	Topics map[string]int
+	// Subscribers is the total number of subscribers which received the message.
+	Subscribers int
+}
+
+// A TopicLister is a Provider which can list the topics its subscribers are subscribed to.
+// Joe implements this interface. See Server.Broadcast for more information.
+type TopicLister interface {
+	// Topics returns the topics which currently have subscribers.
+	// It must return when the given context is done, at the latest.
+	Topics(ctx context.Context) ([]string, error)
+}
+
+// A ProviderStats is a Provider which can report statistics about its subscribers, so they can be exposed
+// as metrics. Joe implements this interface.
+type ProviderStats interface {
+	TopicLister
+	// SubscriberCount returns the number of subscribers which receive the messages published to the given topic.
+	// It must return when the given context is done, at the latest.
+	SubscriberCount(ctx context.Context, topic string) (int, error)
+	// Totals returns the current number of subscribers and of their topic subscriptions.
+	Totals() ProviderTotals
+}
+
+// ProviderTotals are the totals reported by a ProviderStats.
+type ProviderTotals struct {
+	// Subscribers is the number of subscribers.
+	Subscribers int
+	// TopicRegistrations is the number of topic subscriptions. A subscriber which is subscribed
+	// to multiple topics is counted once for each of them.
+	TopicRegistrations int
+}
+
+// A UnicastSender is a Provider which can send a message to a single one of its subscribers.
+// Joe implements this interface. See Server.SendTo for more information.
+type UnicastSender interface {
+	// SendTo sends the message only to the given client. The message must be sent in order with
+	// the messages published to the client, without being interleaved with them. If the client
+	// is not subscribed, ErrNotSubscribed must be returned.
+	SendTo(ctx context.Context, client MessageWriter, msg *Message) error
+}
+
+// A Pinger is a Provider which can check its own health. Joe implements this interface.
+// See Server.Ping for more information.
+type Pinger interface {
+	// Ping returns an error if the provider can't currently deliver messages.
+	// It must return when the given context is done, at the latest.
+	Ping(ctx context.Context) error
+}
+
+// ErrReplaceTopicsUnsupported is returned by Server.ReplaceTopics if the provider doesn't implement TopicReplacer.
+var ErrReplaceTopicsUnsupported = errors.New("go-sse.server: provider does not support replacing topics")
+
+// ErrUpdateTopicsUnsupported is returned by Server.AddTopics and Server.RemoveTopics if the provider
+// doesn't implement TopicUpdater.
+var ErrUpdateTopicsUnsupported = errors.New("go-sse.server: provider does not support updating topics")
+
+// ErrPublishResultUnsupported is returned by Server.PublishWithResult if the provider doesn't implement
+// PublishReporter.
+var ErrPublishResultUnsupported = errors.New("go-sse.server: provider does not support publish results")
+
+// ErrBroadcastUnsupported is returned by Server.Broadcast if the provider doesn't implement TopicLister.
+var ErrBroadcastUnsupported = errors.New("go-sse.server: provider does not support listing topics")
+
+// ErrSendToUnsupported is returned by Server.SendTo if the provider doesn't implement UnicastSender.
+var ErrSendToUnsupported = errors.New("go-sse.server: provider does not support sending to a single client")
+
 // ErrProviderClosed is a sentinel error returned by providers when any operation is attempted after the provider is closed.
 var ErrProviderClosed = errors.New("go-sse.server: provider is closed")
 
+// ErrTooManyConnections is the reason for which ServeHTTP rejects the requests once
+// the Server's MaxConnections are reached.
+var ErrTooManyConnections = errors.New("go-sse.server: too many connections")
+
 // ErrNoTopic is a sentinel error returned by providers when a Message is published without any topics.
 // It is not an issue to call Server.Publish without topics, because the Server will add the DefaultTopic;
 // it is an error to call Provider.Publish without any topics, though.
@@ -99,7 +271,8 @@ type Server struct {
 	// You can use this to authorize the session, set the topics
 	// the client should be subscribed to and so on. Using the
 	// Res field of the Session you can write an error response
-	// to the client.
+	// to the client, as long as the session is not committed – see
+	// Session.Committed.
 	//
 	// The boolean returned indicates whether the returned subscription
 	// is valid or not. If it is valid, the Provider will receive it
@@ -107,7 +280,8 @@ type Server struct {
 	// will be ended.
 	//
 	// If this is not set, the client will be subscribed to the provider
-	// using the DefaultTopic.
+	// using the topics set on the request's context with ContextWithTopics,
+	// if any, or the DefaultTopic otherwise.
 	OnSession func(*Session) (Subscription, bool)
 	// Logger can be used to get a custom logger from the request context,
 	// which could have been set beforehand through a middleware, for example.
@@ -116,8 +290,151 @@ type Server struct {
 	// the data you want to be logged together with what the library adds,
 	// for example identification info like request IP, origin etc.
 	Logger func(*http.Request) *slog.Logger
+	// EventLogLevel is the level at which each event sent to a session is logged, with the logger returned
+	// by Logger: the event's ID and type, the topics of the session's subscription, the number of bytes written
+	// and the time spent writing the event are logged. The events are logged only if the logger is enabled
+	// for the level, so use a *slog.LevelVar to turn the event logs on and off at runtime. By default, events
+	// are not logged – only the sessions' lifecycle is.
+	EventLogLevel slog.Leveler
+	// WrapWriter is an optional function used to decorate the MessageWriter of each subscription.
+	// It is called with the subscription's client, as returned by OnSession, and the session's request,
+	// and its result replaces the client before the subscription is handed to the Provider.
+	//
+	// The returned writer receives everything that is sent to the client, including the events replayed
+	// by the provider when the subscription is created, as replay happens through the subscription's client.
+	// Wrapping multiple times can be done by chaining the wrappers inside this function – the writer
+	// returned by the outermost call is the first that sees each message.
+	WrapWriter WriterWrapper
+	// OnSessionEnd is an optional callback that's called after the session's subscription ends.
+	// The reason is nil if the client went away, ErrProviderClosed if the provider was shut down
+	// or any other error returned by the provider otherwise. Use it to log the outcome of the session
+	// or to set response trailers, for example.
+	OnSessionEnd func(sess *Session, reason error)
+	// OnSubscribe is an optional callback that's called right before a session is subscribed to the provider,
+	// with the subscription returned by OnSession, after the Server's defaults are applied. OnUnsubscribe
+	// is an optional callback that's called after the subscription ends, with the same subscription and
+	// the reason it ended – see OnSessionEnd. They are always called in pairs, so they can be used to track
+	// the presence of clients or to audit connections. The subscriptions' topics are the initial ones:
+	// changes made with ReplaceTopics, AddTopics or RemoveTopics are not reflected. Their clients must
+	// not be used.
+	OnSubscribe   func(sess *Session, sub Subscription)
+	OnUnsubscribe func(sess *Session, sub Subscription, reason error)
+	// ValidateTopic is an optional function used to validate the topics of the subscriptions
+	// and of the published messages. Subscriptions with invalid topics are rejected with
+	// a 400 Bad Request response, and Publish returns the validation error.
+	//
+	// Use the ValidateTopic function from this package to reject topics which could be used to
+	// forge log lines or terminal output, or provide your own function for stricter validation.
+	ValidateTopic func(topic string) error
+	// EncodeHook is an optional function used to transform each message right before it is sent
+	// to a client, both for live and replayed messages, so clients see a consistent format – for example,
+	// to wrap the message's data in an envelope containing the server timestamp and the topic.
+	// The given message must not be modified; return a new message instead, or nil to skip sending it.
+	//
+	// The hook sees the messages after the writers returned by WrapWriter, if any, so those receive
+	// the transformed messages.
+	EncodeHook func(sub Subscription, msg *Message) *Message
+	// EncodeHookPerMessage signals that the result of EncodeHook doesn't depend on the subscription.
+	// The hook is then called once for each message and its result is reused for all the clients
+	// which receive that message, instead of being called for each client.
+	EncodeHookPerMessage bool
+	// Resumption enables the resumption tokens, which allow applications to reattach the state
+	// they hold for a session when the client reconnects. If it is set, each session is issued
+	// a token, which is sent in the ResumptionTokenHeader response header and in an event of type
+	// ResumptionTokenEventType, before any other event. Clients send the token back on reconnection,
+	// either in the same header or in the ResumptionTokenQueryParam query parameter, and the Session
+	// received by OnSession has its ResumptionID and Resumed fields set accordingly.
+	Resumption *ResumptionTokens
+	// OnFlush is an optional callback that's called after each batch of messages is flushed to a client,
+	// with the time spent writing and flushing the batch and the number of bytes it has. The context is
+	// the request's context, so spans or other tracing data can be attached to it – for example, by
+	// retrieving the request's span and recording an event. It is called on the goroutine that writes
+	// to the client, so it must return quickly.
+	OnFlush func(ctx context.Context, d time.Duration, bytes int)
+	// OnReplayDone is an optional callback that's called after the events are replayed to a new client,
+	// with the duration of the replay and the number of events replayed. The context is the request's context.
+	// Only Joe reports replays – other providers don't call it.
+	OnReplayDone func(ctx context.Context, d time.Duration, events int)
+	// TopicPolicies optionally configures, by topic, the default event type of the messages published
+	// to each topic and whether they must have IDs. Publish applies the policies of all the topics
+	// a message is published to, before the provider receives the message: the default type of the first
+	// topic which has one is used and the ID requirements of all the topics must be satisfied, otherwise
+	// a *TopicPolicyError is returned. The published message is not modified – a copy is published instead,
+	// if needed. The map must not be modified after the Server is used.
+	TopicPolicies map[string]TopicPolicy
+	// TopicAttribution configures how the events sent to clients are annotated with the topic they were
+	// delivered for, for the subscriptions which don't configure it themselves. See TopicAttribution
+	// for more information. Defaults to NoTopicAttribution.
+	TopicAttribution TopicAttribution
+	// RejectEmptyMessages configures Publish to reject the messages which have no fields at all – no data,
+	// comments, ID, type or retry –, as they are not sent to clients and publishing them is most likely a bug.
+	// ErrEmptyMessage is returned for such messages. Defaults to false, so empty messages are published.
+	RejectEmptyMessages bool
+	// IDGenerator optionally sets the IDs of the published events which don't have one, before the topic
+	// policies are applied and the provider receives them. The published message is not modified – a copy
+	// with the ID is published instead. Prefer setting the replay provider's IDGenerator when using Joe,
+	// as that sets the IDs of all the events in the order they are replayed.
+	IDGenerator IDGenerator
+	// KeepAliveInterval configures the Server to send a keep-alive comment to each session which wasn't
+	// sent anything for this long, so proxies and load balancers don't close idle connections. The comment
+	// is of the form ": ka-interval=15", announcing the interval to clients using ParseKeepAliveHint, so they
+	// detect dead connections sooner. Keep-alives are sent directly to the session, so they are not given
+	// to WrapWriter, EncodeHook or OnFlush. Defaults to 0, which means that no keep-alives are sent.
+	KeepAliveInterval time.Duration
+	// DefaultRetry is the reconnection delay announced to each session once its subscription is accepted,
+	// before any event is sent, so the clients' reconnection behavior is tuned without setting the Retry
+	// field of the published messages – which still override it. The delay is sent in milliseconds.
+	// Defaults to 0, which means that no delay is announced and clients use their own default.
+	DefaultRetry time.Duration
+	// Padding is the number of spaces of a comment sent to each session once its subscription is accepted,
+	// before any event. Some proxies buffer responses until they receive enough bytes, and some EventSource
+	// polyfills, such as those for Internet Explorer's XDomainRequest, require 2KB of padding before they
+	// dispatch the first event. Defaults to 0, which means that no padding is sent.
+	Padding int
+	// WriteTimeout is the maximum duration of sending a batch of events to a session, from the first event
+	// to the flush. Once it is exceeded, the write fails and the session ends, so a client which stopped reading
+	// doesn't block the provider – Joe's event loop, for example – until the operating system's timeouts fire.
+	// The deadlines are set using Session.SetWriteDeadline; if the response writer doesn't support them,
+	// they aren't set. Keep-alives are subject to the timeout, too. Defaults to 0, which means no timeout.
+	WriteTimeout time.Duration
+	// FlushInterval enables coalesced flushing: the events the provider sends to a session are flushed
+	// together at most this long after the provider requests their flush, instead of right away. This reduces
+	// the number of writes to the connections of high-frequency streams, at the cost of a small delay.
+	// Defaults to 0, which means that the events are flushed as soon as the provider requests it.
+	FlushInterval time.Duration
+	// FlushBatchSize makes the coalesced flushing flush the events right away once this many are waiting.
+	// It is used only if FlushInterval is set. Defaults to 0, which means that only the interval is used.
+	FlushBatchSize int
+	// MaxConnections limits the number of requests ServeHTTP serves concurrently. Once it is reached,
+	// new requests are rejected with a 503 Service Unavailable response, before OnSession is called.
+	// Defaults to 0, which means no limit.
+	MaxConnections int
+	// RetryAfter is sent, rounded up to seconds, in the Retry-After header of the requests rejected because
+	// of the Server's limits, so clients which honor it retry later. EventSource doesn't honor it, as it
+	// doesn't reconnect after error responses. Defaults to 0, which means the header is not sent.
+	RetryAfter time.Duration
+	// OnReject is an optional callback that's called when ServeHTTP rejects a request because of the Server's
+	// limits or because a topic is full, with the reason – ErrTooManyConnections or a *TopicFullError.
+	OnReject func(r *http.Request, reason error)
+	// CORS optionally configures the cross-origin resource sharing headers sent by ServeHTTP, which also
+	// responds to the preflight requests and rejects the requests from origins which are not allowed,
+	// before any other handling. See CORS for more information.
+	CORS *CORS
+	// Compression optionally enables the compression of the event streams, negotiated with each client
+	// using the Accept-Encoding header. See Compression for more information.
+	Compression *Compression
 
-	provider Provider
+	provider    Provider
+	middlewares []PublishMiddleware
+	encodeCache encodeCache
+	// clients holds the clients subscribed by ServeHTTP, by session, if the provider is a TopicReplacer,
+	// a TopicUpdater or a UnicastSender.
+	clients   map[*Session]MessageWriter
+	clientsMu sync.Mutex
+	// connections is the number of requests ServeHTTP currently serves, if MaxConnections is set.
+	connections atomic.Int64
+	// goodbye is the message sent to the sessions ended by ShutdownWithMessage.
+	goodbye  atomic.Pointer[Message]
 	initDone sync.Once
 }
 
@@ -130,7 +447,13 @@ type Server struct {
 // If the request isn't upgradeable, it writes a message to the client along with
 // an 500 Internal Server ConnectionError response code. If on subscribe the provider returns
 // an error, it writes the error message to the client and a 500 Internal Server ConnectionError
-// response code.
+// response code. If the session was already committed when the error occurred, the error message
+// is sent as a comment in the event stream instead. If the provider was shut down, the stream is ended
+// without any error, so clients reconnect – to another instance of the server, for example.
+// Requests over the Server's limits, such as MaxConnections, and those whose subscription fails because
+// a topic is full (see TopicFullError) are rejected with a 503 Service Unavailable response code.
+// If CORS is set, preflight requests are answered with a 204 No Content response code and requests
+// from origins which are not allowed are rejected with a 403 Forbidden response code.
 //
 // To customize behavior, use the OnSession callback or create your custom handler.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -142,6 +465,44 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		l.InfoContext(r.Context(), "sse: starting new session")
 	}
 
+	if s.CORS != nil {
+		preflight, err := s.CORS.apply(w, r)
+		if err != nil {
+			if l != nil {
+				l.WarnContext(r.Context(), "sse: origin not allowed", "origin", r.Header.Get("Origin"))
+			}
+
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if preflight {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	if s.MaxConnections > 0 {
+		defer s.connections.Add(-1)
+		if s.connections.Add(1) > int64(s.MaxConnections) {
+			if l != nil {
+				l.WarnContext(r.Context(), "sse: too many connections")
+			}
+
+			s.reject(w, r, ErrTooManyConnections)
+			return
+		}
+	}
+
+	if s.Compression != nil {
+		var finish func() error
+		w, finish = s.Compression.wrap(w, r)
+		defer func() {
+			if err := finish(); err != nil && l != nil && r.Context().Err() == nil {
+				l.ErrorContext(r.Context(), "sse: failed to finish compressed stream", "err", err)
+			}
+		}()
+	}
+
 	sess, err := Upgrade(w, r)
 	if err != nil {
 		if l != nil {
@@ -152,6 +513,11 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var resumptionToken string
+	if s.Resumption != nil {
+		resumptionToken = s.Resumption.resume(sess)
+	}
+
 	sub, ok := s.getSubscription(sess)
 	if !ok {
 		if l != nil {
@@ -161,29 +527,376 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err = s.validateTopics(sub.Topics); err != nil {
+		if l != nil {
+			l.WarnContext(r.Context(), "sse: invalid subscription topics", "err", err)
+		}
+
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if sub.ResumptionID == "" {
+		sub.ResumptionID = sess.ResumptionID
+	}
+	if sub.TopicAttribution == NoTopicAttribution {
+		sub.TopicAttribution = s.TopicAttribution
+	}
+
+	// The subscription is given to the callbacks without the writers added below.
+	subscribed := sub
+
+	if preamble := s.preamble(resumptionToken); len(preamble) > 0 {
+		// The preamble commits the session, so it is sent only once the subscription is accepted:
+		// a rejected session is still answered with an error status.
+		sub.Client = &preambleWriter{MessageWriter: sub.Client, preamble: preamble}
+		sub.flushOnAccept = true
+	}
+
+	if s.WriteTimeout > 0 {
+		sub.Client = &writeTimeoutWriter{MessageWriter: sub.Client, sess: sess, timeout: s.WriteTimeout}
+	}
+
+	var stopKeepAlive func()
+	if s.KeepAliveInterval > 0 {
+		sub.Client, stopKeepAlive = startKeepAlive(sub.Client, s.KeepAliveInterval)
+	}
+	if s.OnFlush != nil {
+		sub.Client = &instrumentedWriter{MessageWriter: sub.Client, ctx: r.Context(), onFlush: s.OnFlush}
+	}
+	if l != nil && s.EventLogLevel != nil {
+		sub.Client = &eventLogWriter{MessageWriter: sub.Client, ctx: r.Context(), l: l, level: s.EventLogLevel, topics: getTopicsLog(sub.Topics)}
+	}
+	var stopCoalescing func()
+	if s.FlushInterval > 0 {
+		sub.Client, stopCoalescing = startCoalescing(sub.Client, s.FlushInterval, s.FlushBatchSize)
+	}
+	if s.OnReplayDone != nil {
+		sub.onReplayDone = func(d time.Duration, events int) { s.OnReplayDone(r.Context(), d, events) }
+	}
+	if l != nil {
+		sub.onPartialReplay = func(err error) { l.WarnContext(r.Context(), "sse: partial replay", "err", err) }
+	}
+	if s.WrapWriter != nil {
+		sub.Client = s.WrapWriter(sub.Client, r)
+	}
+	if s.EncodeHook != nil {
+		sub.Client = &encodingWriter{MessageWriter: sub.Client, s: s, sub: sub}
+	}
+
 	if l != nil {
 		l.InfoContext(r.Context(), "sse: subscribing session", "topics", getTopicsLog(sub.Topics), "lastEventID", sub.LastEventID)
 	}
 
-	if err = s.provider.Subscribe(r.Context(), sub); err != nil {
+	if s.tracksClients() {
+		s.setClient(sess, sub.Client)
+		defer s.setClient(sess, nil)
+	}
+
+	if s.OnSubscribe != nil {
+		s.OnSubscribe(sess, subscribed)
+	}
+
+	err = s.provider.Subscribe(r.Context(), sub)
+	if stopCoalescing != nil {
+		stopCoalescing()
+	}
+	if stopKeepAlive != nil {
+		stopKeepAlive()
+	}
+
+	switch {
+	case errors.Is(err, ErrProviderClosed):
+		if l != nil {
+			l.InfoContext(r.Context(), "sse: session ended due to provider shutdown")
+		}
+
+		if goodbye := s.goodbye.Load(); goodbye != nil && sess.Send(goodbye) == nil {
+			_ = sess.Flush()
+		}
+	case errors.Is(err, ErrTopicFull) && !sess.Committed():
+		if l != nil {
+			l.WarnContext(r.Context(), "sse: topic full", "err", err)
+		}
+
+		s.reject(w, r, err)
+	case err != nil:
 		if l != nil {
 			l.ErrorContext(r.Context(), "sse: subscribe error", "err", err)
 		}
 
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		writeError(sess, err)
+	default:
+		if l != nil {
+			l.InfoContext(r.Context(), "sse: session ended")
+		}
 	}
 
-	if l != nil {
-		l.InfoContext(r.Context(), "sse: session ended")
+	if s.OnUnsubscribe != nil {
+		s.OnUnsubscribe(sess, subscribed, err)
+	}
+	if s.OnSessionEnd != nil {
+		s.OnSessionEnd(sess, err)
 	}
 }
 
 // Publish sends the event to all subscribes that are subscribed to the topic the event is published to.
 // The topics are optional - if none are specified, the event is published to the DefaultTopic.
+//
+// The message is first passed through the middlewares added with Use, if any. If the ValidateTopic function
+// is set, the message is not published if any of the topics is invalid. The message is also not published
+// if it violates the TopicPolicies. Nil or invalid messages are rejected with ErrNilMessage or
+// ErrInvalidMessage – see also the RejectEmptyMessages field.
 func (s *Server) Publish(e *Message, topics ...string) error {
 	s.init()
-	return s.provider.Publish(e, getTopics(topics))
+
+	e, topics, err := s.preparePublish(e, topics)
+	if err != nil || e == nil {
+		return err
+	}
+
+	return s.provider.Publish(e, topics)
+}
+
+// PublishWithResult publishes the event like Publish and reports to how many of this server's sessions
+// it was delivered, for each topic, if the provider implements the PublishReporter interface – otherwise,
+// ErrPublishResultUnsupported is returned. Use it to detect that nobody is listening to a topic
+// and skip the expensive work of producing its next events, for example.
+//
+// Unlike Publish, PublishWithResult waits for the event to be delivered, so it returns when the context is
+// done, at the latest. If a middleware drops the event, an empty result and no error are returned.
+func (s *Server) PublishWithResult(ctx context.Context, e *Message, topics ...string) (PublishResult, error) {
+	s.init()
+
+	reporter, ok := s.provider.(PublishReporter)
+	if !ok {
+		return PublishResult{}, ErrPublishResultUnsupported
+	}
+
+	e, topics, err := s.preparePublish(e, topics)
+	if err != nil || e == nil {
+		return PublishResult{}, err
+	}
+
+	return reporter.PublishWithResult(ctx, e, topics)
+}
+
+// preparePublish applies the middlewares and the topic policies to the published message, after validating it.
+// It returns a nil message and no error if a middleware dropped the message.
+func (s *Server) preparePublish(e *Message, topics []string) (*Message, []string, error) {
+	topics = getTopics(topics)
+	if len(s.middlewares) > 0 {
+		if e == nil {
+			return nil, nil, ErrNilMessage
+		}
+
+		var err error
+		if e, topics, err = s.applyMiddlewares(e, topics); err != nil || e == nil {
+			return nil, nil, err
+		}
+	}
+	if len(topics) == 0 {
+		return nil, nil, ErrNoTopic
+	}
+	if err := s.validateTopics(topics); err != nil {
+		return nil, nil, err
+	}
+	if err := validateMessage(e, !s.RejectEmptyMessages); err != nil {
+		return nil, nil, err
+	}
+
+	if s.IDGenerator != nil && !e.ID.IsSet() {
+		e = e.Clone()
+		e.ID = s.IDGenerator.NextID()
+	}
+
+	e, err := s.applyTopicPolicies(e, topics)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e, topics, nil
+}
+
+// Broadcast publishes the event to all the topics which currently have subscribers, if the provider
+// implements the TopicLister interface – otherwise, ErrBroadcastUnsupported is returned. Use it for
+// announcements every connected client must receive, such as maintenance notices.
+//
+// The topics are listed before the event is published, so clients which subscribe to new topics meanwhile
+// don't receive it. With Joe's WildcardTopics, the subscribers of a pattern receive the event only if any of
+// the listed topics matches the pattern. The event is published like with Publish otherwise. If no topics
+// have subscribers, the event is not published and nil is returned.
+func (s *Server) Broadcast(ctx context.Context, e *Message) error {
+	s.init()
+
+	lister, ok := s.provider.(TopicLister)
+	if !ok {
+		return ErrBroadcastUnsupported
+	}
+	if e == nil {
+		return ErrNilMessage
+	}
+
+	topics, err := lister.Topics(ctx)
+	if err != nil || len(topics) == 0 {
+		return err
+	}
+
+	return s.Publish(e, topics...)
+}
+
+// ReplaceTopics atomically replaces the topics of the subscription of a session served by ServeHTTP
+// and returns the previous topics, if the provider implements the TopicReplacer interface – otherwise,
+// ErrReplaceTopicsUnsupported is returned. Use it to change the topics of a session when the client's
+// permissions change, for example, without transiently exposing or hiding any topic.
+//
+// The topics are optional - if none are specified, the session is subscribed to the DefaultTopic.
+// If the ValidateTopic function is set, the topics are not replaced if any of them is invalid.
+// If the session's subscription has ended, ErrNotSubscribed is returned. The subscription given to
+// the EncodeHook keeps its initial topics.
+func (s *Server) ReplaceTopics(ctx context.Context, sess *Session, topics ...string) (previous []string, err error) {
+	s.init()
+
+	replacer, ok := s.provider.(TopicReplacer)
+	if !ok {
+		return nil, ErrReplaceTopicsUnsupported
+	}
+
+	topics = getTopics(topics)
+	if err := s.validateTopics(topics); err != nil {
+		return nil, err
+	}
+
+	client, ok := s.client(sess)
+	if !ok {
+		return nil, ErrNotSubscribed
+	}
+
+	return replacer.ReplaceTopics(ctx, client, topics)
+}
+
+// AddTopics atomically adds topics to the subscription of a session served by ServeHTTP, if the provider
+// implements the TopicUpdater interface – otherwise, ErrUpdateTopicsUnsupported is returned. Unlike
+// ReplaceTopics, concurrent calls of AddTopics and RemoveTopics for the same session don't overwrite
+// each other's changes. Messages published to the new topics before the call are not replayed.
+//
+// If no topics are given, ErrNoTopic is returned. If the ValidateTopic function is set, no topics are added
+// if any of them is invalid. If the session's subscription has ended, ErrNotSubscribed is returned.
+func (s *Server) AddTopics(ctx context.Context, sess *Session, topics ...string) error {
+	s.init()
+
+	updater, ok := s.provider.(TopicUpdater)
+	if !ok {
+		return ErrUpdateTopicsUnsupported
+	}
+
+	if len(topics) == 0 {
+		return ErrNoTopic
+	}
+	if err := s.validateTopics(topics); err != nil {
+		return err
+	}
+
+	client, ok := s.client(sess)
+	if !ok {
+		return ErrNotSubscribed
+	}
+
+	return updater.AddTopics(ctx, client, topics)
+}
+
+// RemoveTopics atomically removes topics from the subscription of a session served by ServeHTTP, if the provider
+// implements the TopicUpdater interface – otherwise, ErrUpdateTopicsUnsupported is returned. The session keeps
+// its connection. If no topics are given, or if the session would be left without topics, ErrNoTopic is returned
+// and the subscription is not changed. If the session's subscription has ended, ErrNotSubscribed is returned.
+func (s *Server) RemoveTopics(ctx context.Context, sess *Session, topics ...string) error {
+	s.init()
+
+	updater, ok := s.provider.(TopicUpdater)
+	if !ok {
+		return ErrUpdateTopicsUnsupported
+	}
+
+	if len(topics) == 0 {
+		return ErrNoTopic
+	}
+
+	client, ok := s.client(sess)
+	if !ok {
+		return ErrNotSubscribed
+	}
+
+	return updater.RemoveTopics(ctx, client, topics)
+}
+
+// SendTo sends the message only to the given session served by ServeHTTP, if the provider implements
+// the UnicastSender interface – otherwise, ErrSendToUnsupported is returned. Use it to notify a single
+// client, of an expiring token for example, without publishing the message to a topic.
+//
+// Unlike the session's Send method, SendTo is safe to call concurrently with the messages the provider
+// sends to the session: with Joe, the message is sent on its event loop, in order with the published
+// messages – see Joe.SendTo for the ordering guarantees. The message is given to the EncodeHook, if set,
+// and it is not replayed. If the session's subscription has ended, ErrNotSubscribed is returned.
+func (s *Server) SendTo(ctx context.Context, sess *Session, msg *Message) error {
+	s.init()
+
+	sender, ok := s.provider.(UnicastSender)
+	if !ok {
+		return ErrSendToUnsupported
+	}
+
+	client, ok := s.client(sess)
+	if !ok {
+		return ErrNotSubscribed
+	}
+
+	return sender.SendTo(ctx, client, msg)
+}
+
+// Ping checks the health of the provider, if it implements the Pinger interface, so the server's
+// readiness can be reported by a health check handler. Providers which don't implement Pinger are
+// assumed to be healthy, so Ping returns nil for them.
+func (s *Server) Ping(ctx context.Context) error {
+	s.init()
+
+	if p, ok := s.provider.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// tracksClients tells whether the clients subscribed by ServeHTTP must be recorded,
+// so ReplaceTopics, AddTopics, RemoveTopics and SendTo can find them.
+func (s *Server) tracksClients() bool {
+	_, replacer := s.provider.(TopicReplacer)
+	_, updater := s.provider.(TopicUpdater)
+	_, sender := s.provider.(UnicastSender)
+
+	return replacer || updater || sender
+}
+
+// client returns the client subscribed by ServeHTTP for the session, if any.
+func (s *Server) client(sess *Session) (MessageWriter, bool) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	client, ok := s.clients[sess]
+	return client, ok
+}
+
+// setClient records the client subscribed for the session or, if it is nil, removes it.
+func (s *Server) setClient(sess *Session, client MessageWriter) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+
+	if client == nil {
+		delete(s.clients, sess)
+		return
+	}
+	if s.clients == nil {
+		s.clients = map[*Session]MessageWriter{}
+	}
+	s.clients[sess] = client
 }
 
 // Shutdown closes all the connections and stops the server. Publish operations will fail
@@ -199,6 +912,259 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	return s.provider.Shutdown(ctx)
 }
 
+// reject responds to a request rejected because of the Server's limits.
+func (s *Server) reject(w http.ResponseWriter, r *http.Request, reason error) {
+	if s.OnReject != nil {
+		s.OnReject(r, reason)
+	}
+
+	if s.RetryAfter > 0 {
+		seconds := (s.RetryAfter + time.Second - 1) / time.Second
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(seconds), 10))
+	}
+	http.Error(w, reason.Error(), http.StatusServiceUnavailable)
+}
+
+// preamble returns the messages sent to a session once its subscription is accepted, before any event: the padding,
+// the session's resumption token and the default reconnection delay, if any.
+func (s *Server) preamble(resumptionToken string) []*Message {
+	var preamble []*Message
+	if s.Padding > 0 {
+		padding := &Message{}
+		padding.AppendComment(strings.Repeat(" ", s.Padding))
+		preamble = append(preamble, padding)
+	}
+
+	var m *Message
+	if resumptionToken != "" {
+		m = newResumptionTokenMessage(resumptionToken)
+	}
+	if s.DefaultRetry > 0 {
+		if m == nil {
+			m = &Message{}
+		}
+		m.Retry = s.DefaultRetry
+	}
+	if m != nil {
+		preamble = append(preamble, m)
+	}
+
+	return preamble
+}
+
+// ShutdownWithMessage shuts the server down like Shutdown, but the sessions served by ServeHTTP are sent
+// the given message before they end. Set a long retry delay on the message, so the clients reconnect
+// to another instance of the server instead of to this one while it shuts down, and a type or data
+// so they can tell why they were disconnected:
+//
+//	goodbye := &sse.Message{Type: sse.Type("shutdown"), Retry: time.Minute}
+//	goodbye.AppendData("server restarting")
+//	err := s.ShutdownWithMessage(ctx, goodbye)
+//
+// The message is sent directly to the sessions once the provider ends their subscriptions, like keep-alives,
+// so it is not given to WrapWriter, EncodeHook or OnFlush. As it is sent by the handlers, it may be sent
+// after ShutdownWithMessage returns – http.Server's Shutdown waits for the handlers to return.
+// The sessions whose writes are blocked don't receive it. Invalid messages are rejected with
+// ErrNilMessage or ErrInvalidMessage and the server is not shut down.
+func (s *Server) ShutdownWithMessage(ctx context.Context, msg *Message) error {
+	if err := validateMessage(msg, false); err != nil {
+		return err
+	}
+
+	s.init()
+	s.goodbye.CompareAndSwap(nil, msg)
+
+	return s.provider.Shutdown(ctx)
+}
+
+// writeError signals the error to the client: using a HTTP error response,
+// if possible, or otherwise by sending it as a comment in the event stream.
+func writeError(sess *Session, err error) {
+	if !sess.Committed() {
+		http.Error(sess.Res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e := &Message{}
+	e.AppendComment(err.Error())
+
+	// The error may be caused by the connection, so sending the comment may fail, too.
+	if sess.Send(e) == nil {
+		_ = sess.Flush()
+	}
+}
+
+// encodeCache holds the result of the last EncodeHook call, when the hook is called per message.
+// As providers send the same message to all subscribers before sending the next one, caching
+// a single result suffices.
+type encodeCache struct {
+	in, out *Message
+	mu      sync.Mutex
+}
+
+func (s *Server) encode(sub Subscription, m *Message) *Message {
+	if !s.EncodeHookPerMessage {
+		return s.EncodeHook(sub, m)
+	}
+
+	s.encodeCache.mu.Lock()
+	defer s.encodeCache.mu.Unlock()
+
+	if s.encodeCache.in != m {
+		s.encodeCache.in, s.encodeCache.out = m, s.EncodeHook(sub, m)
+	}
+
+	return s.encodeCache.out
+}
+
+// encodingWriter applies the Server's EncodeHook to the messages sent to a subscription.
+type encodingWriter struct {
+	MessageWriter
+	s   *Server
+	sub Subscription
+}
+
+func (e *encodingWriter) Send(m *Message) error {
+	if m = e.s.encode(e.sub, m); m == nil {
+		return nil
+	}
+
+	return e.MessageWriter.Send(m)
+}
+
+// eventLogWriter logs the events sent to a session. See Server.EventLogLevel.
+type eventLogWriter struct {
+	MessageWriter
+	ctx    context.Context
+	l      *slog.Logger
+	level  slog.Leveler
+	topics string
+}
+
+func (e *eventLogWriter) Send(m *Message) error {
+	level := e.level.Level()
+	if !e.l.Enabled(e.ctx, level) {
+		return e.MessageWriter.Send(m)
+	}
+
+	start := time.Now()
+	err := e.MessageWriter.Send(m)
+	duration := time.Since(start)
+
+	bytes := 0
+	if err == nil {
+		bytes = m.EncodedLen()
+	}
+
+	e.l.Log(e.ctx, level, "sse: event sent", "id", m.ID, "type", m.Type, "topics", e.topics, "bytes", bytes, "duration", duration)
+
+	return err
+}
+
+// preambleWriter sends the preamble before the first message or flush. See Server.preamble.
+type preambleWriter struct {
+	MessageWriter
+	preamble []*Message
+}
+
+func (w *preambleWriter) Send(m *Message) error {
+	if err := w.sendPreamble(); err != nil {
+		return err
+	}
+	return w.MessageWriter.Send(m)
+}
+
+func (w *preambleWriter) Flush() error {
+	if err := w.sendPreamble(); err != nil {
+		return err
+	}
+	return w.MessageWriter.Flush()
+}
+
+func (w *preambleWriter) sendPreamble() error {
+	for len(w.preamble) > 0 {
+		if err := w.MessageWriter.Send(w.preamble[0]); err != nil {
+			return err
+		}
+		w.preamble = w.preamble[1:]
+	}
+	return nil
+}
+
+// writeTimeoutWriter sets a write deadline on the session for each batch of messages sent to it,
+// which is cleared after the batch is flushed. See Server.WriteTimeout.
+type writeTimeoutWriter struct {
+	MessageWriter
+	sess    *Session
+	timeout time.Duration
+	// hasDeadline is true while a deadline is set for the batch being sent.
+	hasDeadline bool
+	unsupported bool
+}
+
+func (w *writeTimeoutWriter) Send(m *Message) error {
+	if !w.hasDeadline {
+		w.setDeadline(time.Now().Add(w.timeout))
+	}
+
+	return w.MessageWriter.Send(m)
+}
+
+func (w *writeTimeoutWriter) Flush() error {
+	if !w.hasDeadline {
+		w.setDeadline(time.Now().Add(w.timeout))
+	}
+
+	err := w.MessageWriter.Flush()
+	// The deadline is cleared, so writing the next batch after an idle period doesn't fail.
+	w.setDeadline(time.Time{})
+
+	return err
+}
+
+func (w *writeTimeoutWriter) setDeadline(deadline time.Time) {
+	if w.unsupported {
+		return
+	}
+
+	// Other errors mean that the connection is closed, which the writes report.
+	if err := w.sess.SetWriteDeadline(deadline); errors.Is(err, http.ErrNotSupported) {
+		w.unsupported = true
+	}
+	w.hasDeadline = !deadline.IsZero()
+}
+
+// instrumentedWriter reports to the Server's OnFlush callback each flushed batch of messages.
+type instrumentedWriter struct {
+	MessageWriter
+	ctx     context.Context
+	onFlush func(ctx context.Context, d time.Duration, bytes int)
+	elapsed time.Duration
+	bytes   int
+}
+
+func (i *instrumentedWriter) Send(m *Message) error {
+	start := time.Now()
+	err := i.MessageWriter.Send(m)
+	i.elapsed += time.Since(start)
+	if err == nil {
+		i.bytes += m.EncodedLen()
+	}
+
+	return err
+}
+
+func (i *instrumentedWriter) Flush() error {
+	start := time.Now()
+	err := i.MessageWriter.Flush()
+	if err == nil {
+		i.onFlush(i.ctx, i.elapsed+time.Since(start), i.bytes)
+	}
+	i.elapsed, i.bytes = 0, 0
+
+	return err
+}
+
 func (s *Server) init() {
 	s.initDone.Do(func() {
 		s.provider = s.Provider
@@ -213,13 +1179,58 @@ func (s *Server) getSubscription(sess *Session) (Subscription, bool) {
 		return s.OnSession(sess)
 	}
 
+	topics := TopicsFromContext(sess.Req.Context())
+	if len(topics) == 0 {
+		topics = defaultTopicSlice
+	}
+
 	return Subscription{
-		Client:      sess,
-		LastEventID: sess.LastEventID,
-		Topics:      defaultTopicSlice,
+		Client:       sess,
+		LastEventID:  sess.LastEventID,
+		Since:        sess.Since,
+		Topics:       topics,
+		ResumptionID: sess.ResumptionID,
 	}, true
 }
 
+// Errors returned when publishing invalid messages.
+var (
+	ErrNilMessage     = errors.New("go-sse.server: nil message")
+	ErrEmptyMessage   = errors.New("go-sse.server: message has no fields")
+	ErrInvalidMessage = errors.New("go-sse.server: invalid message")
+)
+
+// validateMessage checks that the message can be published. The message's fields can't have invalid
+// characters, as their constructors validate them, except for the null byte in IDs, which makes
+// clients ignore the ID.
+func validateMessage(m *Message, allowEmpty bool) error {
+	if m == nil {
+		return ErrNilMessage
+	}
+	if strings.IndexByte(m.ID.String(), 0) != -1 {
+		return fmt.Errorf("%w: ID %q contains the null byte, so clients would ignore it", ErrInvalidMessage, m.ID.String())
+	}
+	if !allowEmpty && m.isEmpty() {
+		return ErrEmptyMessage
+	}
+
+	return nil
+}
+
+func (s *Server) validateTopics(topics []string) error {
+	if s.ValidateTopic == nil {
+		return nil
+	}
+
+	for _, t := range topics {
+		if err := s.ValidateTopic(t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (s *Server) logger(r *http.Request) *slog.Logger {
 	if s.Logger != nil {
 		return s.Logger(r)
@@ -238,27 +1249,84 @@ func getTopics(initial []string) []string {
 	return initial
 }
 
+// maxTopicsLogLength is the maximum length of the topics representation, after which it is truncated.
+const maxTopicsLogLength = 256
+
+// getTopicsLog renders the given topics for logging. Duplicate topics are skipped,
+// topics which contain non-printable characters are quoted and the result is truncated,
+// as topics often come from user input.
 func getTopicsLog(topics []string) string {
 	seen := map[string]struct{}{}
-	ret := ""
+	var b strings.Builder
 
-	for i, t := range topics {
+	for _, t := range topics {
 		if _, ok := seen[t]; ok {
 			continue
 		}
 
+		if len(seen) > 0 {
+			b.WriteByte(',')
+		}
+
 		seen[t] = struct{}{}
 
-		if i > 0 {
-			ret += ","
+		switch {
+		case t == DefaultTopic:
+			b.WriteString("<sse:default>")
+		case isPrintable(t):
+			b.WriteString(t)
+		default:
+			b.WriteString(strconv.Quote(t))
 		}
 
-		if t == DefaultTopic {
-			ret += "<sse:default>"
-		} else {
-			ret += t
+		if b.Len() > maxTopicsLogLength {
+			break
 		}
 	}
 
-	return ret
+	ret := b.String()
+	if len(ret) <= maxTopicsLogLength {
+		return ret
+	}
+
+	end := maxTopicsLogLength
+	for end > 0 && !utf8.RuneStart(ret[end]) {
+		end--
+	}
+
+	return ret[:end] + "..."
+}
+
+func isPrintable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+
+	for _, r := range s {
+		if !unicode.IsPrint(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ErrInvalidTopic is returned by ValidateTopic for invalid topics.
+var ErrInvalidTopic = errors.New("go-sse.server: invalid topic")
+
+// ValidateTopic checks that the topic is valid UTF-8 and that it doesn't contain
+// control characters, such as newlines or terminal escape sequences. The returned
+// error wraps ErrInvalidTopic. Use it as the Server's ValidateTopic function.
+func ValidateTopic(topic string) error {
+	if !utf8.ValidString(topic) {
+		return fmt.Errorf("%w: invalid UTF-8", ErrInvalidTopic)
+	}
+
+	for i, r := range topic {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("%w: control character %U at byte %d", ErrInvalidTopic, r, i)
+		}
+	}
+
+	return nil
 }
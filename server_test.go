@@ -1,16 +1,22 @@
 package sse_test
 
 import (
+	"compress/gzip"
 	"context"
 	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"github.com/tmaxmax/go-sse"
@@ -137,6 +143,41 @@ func TestServer_ServeHTTP(t *testing.T) {
 	require.Equal(t, "level=INFO msg=\"sse: starting new session\"\nlevel=INFO msg=\"sse: subscribing session\" topics=<sse:default> lastEventID=5\nlevel=INFO msg=\"sse: session ended\"\n", sb.String(), "invalid log output")
 }
 
+func TestServer_EventLogLevel(t *testing.T) {
+	t.Parallel()
+
+	sb := &strings.Builder{}
+	l := slog.New(slog.NewTextHandler(sb, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey || a.Key == "duration" {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+
+	level := &slog.LevelVar{}
+	level.Set(slog.LevelDebug)
+
+	serve := func() {
+		req, cancel := request(t, "", "/", http.NoBody)
+		go cancel()
+		(&sse.Server{
+			Provider:      newMockProvider(t, nil),
+			Logger:        func(*http.Request) *slog.Logger { return l },
+			EventLogLevel: level,
+		}).ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	serve()
+	require.NotContains(t, sb.String(), "event sent", "event logged below the logger's level")
+
+	sb.Reset()
+	level.Set(slog.LevelInfo)
+	serve()
+	require.Contains(t, sb.String(), "level=INFO msg=\"sse: event sent\" id=\"\" type=\"\" topics=<sse:default> bytes=13\n", "event not logged")
+}
+
 type noFlusher struct {
 	http.ResponseWriter
 }
@@ -172,6 +213,31 @@ func TestServer_ServeHTTP_subscribeError(t *testing.T) {
 	require.Equal(t, "level=INFO msg=\"sse: starting new session\"\nlevel=INFO msg=\"sse: subscribing session\" topics=<sse:default> lastEventID=\"\"\nlevel=ERROR msg=\"sse: subscribe error\" err=\"can't subscribe\"\n", sb.String(), "invalid log output")
 }
 
+type errAfterSendProvider struct {
+	mockProvider
+}
+
+func (e *errAfterSendProvider) Subscribe(_ context.Context, sub sse.Subscription) error {
+	m := &sse.Message{}
+	m.AppendData("hello")
+	_ = sub.Client.Send(m)
+	_ = sub.Client.Flush()
+
+	return errors.New("can't continue")
+}
+
+func TestServer_ServeHTTP_subscribeErrorCommitted(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest("", "http://localhost", http.NoBody)
+
+	(&sse.Server{Provider: &errAfterSendProvider{}}).ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code, "invalid response code")
+	require.Equal(t, "data: hello\n\n: can't continue\n\n", rec.Body.String(), "error should be sent in the stream")
+}
+
 func TestServer_OnSession(t *testing.T) {
 	t.Parallel()
 
@@ -196,6 +262,123 @@ func TestServer_OnSession(t *testing.T) {
 	})
 }
 
+func TestServer_ContextWithTopics(t *testing.T) {
+	t.Parallel()
+
+	req, cancel := request(t, "", "/", http.NoBody)
+	cancel()
+	req = req.WithContext(sse.ContextWithTopics(req.Context(), []string{"a", "b"}))
+	require.Equal(t, []string{"a", "b"}, sse.TopicsFromContext(req.Context()), "invalid topics")
+
+	p := newMockProvider(t, nil)
+	(&sse.Server{Provider: p}).ServeHTTP(httptest.NewRecorder(), req)
+	require.Equal(t, []string{"a", "b"}, p.Sub.Topics, "context topics not used")
+
+	rec := httptest.NewRecorder()
+	(&sse.Server{Provider: newMockProvider(t, nil), ValidateTopic: sse.ValidateTopic}).ServeHTTP(rec, req.WithContext(sse.ContextWithTopics(req.Context(), []string{"a\nb"})))
+	require.Equal(t, http.StatusBadRequest, rec.Code, "invalid context topic accepted")
+}
+
+func TestServer_ValidateTopic(t *testing.T) {
+	t.Parallel()
+
+	t.Run("Subscribe", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("", "/", http.NoBody)
+		p := newMockProvider(t, nil)
+
+		(&sse.Server{
+			Provider: p,
+			OnSession: func(s *sse.Session) (sse.Subscription, bool) {
+				return sse.Subscription{Client: s, Topics: []string{"a", "b\x1b[31m"}}, true
+			},
+			ValidateTopic: sse.ValidateTopic,
+		}).ServeHTTP(rec, req)
+
+		require.False(t, p.Subscribed, "Subscribe was called")
+		require.Equal(t, http.StatusBadRequest, rec.Code, "invalid response code")
+		require.Equal(t, "go-sse.server: invalid topic: control character U+001B at byte 1\n", rec.Body.String(), "invalid response body")
+	})
+
+	t.Run("Publish", func(t *testing.T) {
+		p := newMockProvider(t, nil)
+		s := &sse.Server{Provider: p, ValidateTopic: sse.ValidateTopic}
+
+		require.ErrorIs(t, s.Publish(&sse.Message{}, "a\nb"), sse.ErrInvalidTopic, "invalid topic accepted")
+		require.ErrorIs(t, s.Publish(&sse.Message{}, "\xff"), sse.ErrInvalidTopic, "invalid topic accepted")
+		require.False(t, p.Published, "Publish was called")
+
+		require.NoError(t, s.Publish(&sse.Message{}, "ünïcode topic", sse.DefaultTopic))
+		require.True(t, p.Published, "Publish wasn't called")
+	})
+}
+
+func TestServer_ServeHTTP_topicsLog(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("é", 200)
+
+	tests := []struct {
+		name     string
+		topics   []string
+		expected string
+	}{
+		{name: "Duplicates", topics: []string{"a", "a", sse.DefaultTopic, "b", "a"}, expected: "a,<sse:default>,b"},
+		{name: "Control characters", topics: []string{"a\nlevel=ERROR", "\x1b[2J"}, expected: `"\"a\\nlevel=ERROR\",\"\\x1b[2J\""`},
+		{name: "Truncated", topics: []string{long, "b"}, expected: strings.Repeat("é", 128) + "..."},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			rec := httptest.NewRecorder()
+			req, cancel := request(t, "", "/", http.NoBody)
+			cancel()
+			sb := &strings.Builder{}
+
+			(&sse.Server{
+				Provider: newMockProvider(t, nil),
+				Logger:   newMockLogger(sb),
+				OnSession: func(s *sse.Session) (sse.Subscription, bool) {
+					return sse.Subscription{Client: s, Topics: test.topics}, true
+				},
+			}).ServeHTTP(rec, req)
+
+			require.Contains(t, sb.String(), "topics="+test.expected+" ", "invalid topics log")
+		})
+	}
+}
+
+func TestServer_WrapWriter(t *testing.T) {
+	t.Parallel()
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "http://localhost", nil)
+	defer cancel()
+	p := newMockProvider(t, nil)
+
+	var cw *sse.CountingWriter
+	var wrappedReq *http.Request
+
+	go cancel()
+	(&sse.Server{
+		Provider: p,
+		WrapWriter: func(w sse.MessageWriter, r *http.Request) sse.MessageWriter {
+			wrappedReq = r
+			cw = &sse.CountingWriter{MessageWriter: w}
+			return cw
+		},
+	}).ServeHTTP(rec, req)
+
+	require.Same(t, req, wrappedReq, "wrapper received wrong request")
+	require.Same(t, cw, p.Sub.Client, "subscription client wasn't wrapped")
+	require.Equal(t, int64(1), cw.Messages(), "invalid message count")
+	require.Equal(t, int64(1), cw.Flushes(), "invalid flush count")
+	require.Equal(t, "data: hello\n\n", rec.Body.String(), "invalid response body")
+}
+
 type flushResponseWriter interface {
 	http.Flusher
 	http.ResponseWriter
@@ -210,6 +393,115 @@ func (r *responseWriterErr) Write(p []byte) (int, error) {
 	return n, errors.New("")
 }
 
+func TestServer_OnSessionEnd(t *testing.T) {
+	t.Parallel()
+
+	subscribed := make(chan struct{})
+	j := &sse.Joe{
+		Backfill: func(context.Context, sse.Subscription) (sse.EventID, error) {
+			close(subscribed)
+			return sse.EventID{}, nil
+		},
+	}
+
+	reasons := make(chan error, 1)
+	sb := &strings.Builder{}
+	s := &sse.Server{
+		Provider:     j,
+		Logger:       newMockLogger(sb),
+		OnSessionEnd: func(_ *sse.Session, reason error) { reasons <- reason },
+	}
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "http://localhost", nil)
+	defer cancel()
+
+	go s.ServeHTTP(rec, req)
+	<-subscribed
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	require.ErrorIs(t, <-reasons, sse.ErrProviderClosed, "invalid session end reason")
+	require.Equal(t, http.StatusOK, rec.Code, "invalid response code")
+	require.Empty(t, rec.Body.String(), "unexpected response body")
+	require.Contains(t, sb.String(), "level=INFO msg=\"sse: session ended due to provider shutdown\"\n", "invalid log output")
+}
+
+func TestServer_EncodeHook(t *testing.T) {
+	t.Parallel()
+
+	newHook := func(calls *atomic.Int64) func(sse.Subscription, *sse.Message) *sse.Message {
+		return func(sub sse.Subscription, m *sse.Message) *sse.Message {
+			calls.Add(1)
+
+			if m.Type.String() == "skip" {
+				return nil
+			}
+
+			e := m.Clone()
+			e.AppendData(sub.Topics[0])
+			return e
+		}
+	}
+
+	serve := func(t *testing.T, s *sse.Server, topic string, lastEventID sse.EventID) (*ptrClient, context.CancelFunc) {
+		t.Helper()
+
+		c := &ptrClient{}
+		req, cancel := request(t, "", "/", http.NoBody)
+		s.OnSession = func(*sse.Session) (sse.Subscription, bool) {
+			return sse.Subscription{Client: c, LastEventID: lastEventID, InclusiveReplay: true, Topics: []string{topic}}, true
+		}
+
+		go s.ServeHTTP(httptest.NewRecorder(), req)
+
+		return c, cancel
+	}
+
+	t.Run("Subscription", func(t *testing.T) {
+		t.Parallel()
+
+		j := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 5, AutoIDs: true}}
+		s := &sse.Server{Provider: j, EncodeHook: newHook(&atomic.Int64{})}
+		defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		require.NoError(t, s.Publish(msg(t, "replayed", ""), "a"))
+
+		c, cancel := serve(t, s, "a", sse.ID("0"))
+		defer cancel()
+		require.Eventually(t, func() bool { return j.Stats().Subscribers == 1 }, time.Second, time.Millisecond)
+
+		skipped := msg(t, "skipped", "")
+		skipped.Type = sse.Type("skip")
+		require.NoError(t, s.Publish(skipped, "a"))
+		require.NoError(t, s.Publish(msg(t, "live", ""), "a"))
+		require.Eventually(t, func() bool { return len(c.Messages()) == 2 }, time.Second, time.Millisecond)
+
+		require.Equal(t, []string{"id: 0\ndata: replayed\ndata: a\n\n", "id: 2\ndata: live\ndata: a\n\n"}, msgStrings(c.Messages()), "invalid messages")
+	})
+
+	t.Run("PerMessage", func(t *testing.T) {
+		t.Parallel()
+
+		j := &sse.Joe{}
+		var calls atomic.Int64
+		s := &sse.Server{Provider: j, EncodeHook: newHook(&calls), EncodeHookPerMessage: true}
+		defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		c1, cancel1 := serve(t, s, "a", sse.EventID{})
+		defer cancel1()
+		require.Eventually(t, func() bool { return j.Stats().Subscribers == 1 }, time.Second, time.Millisecond)
+		c2, cancel2 := serve(t, s, "a", sse.EventID{})
+		defer cancel2()
+		require.Eventually(t, func() bool { return j.Stats().Subscribers == 2 }, time.Second, time.Millisecond)
+
+		require.NoError(t, s.Publish(msg(t, "hello", ""), "a"))
+		require.Eventually(t, func() bool { return len(c1.Messages()) == 1 && len(c2.Messages()) == 1 }, time.Second, time.Millisecond)
+
+		require.Equal(t, int64(1), calls.Load(), "hook not called once per message")
+		require.Equal(t, c1.Messages()[0], c2.Messages()[0], "encoded message not reused")
+	})
+}
+
 func TestServer_ServeHTTP_connectionError(t *testing.T) {
 	t.Parallel()
 
@@ -284,3 +576,1096 @@ func BenchmarkServer(b *testing.B) {
 		})
 	}
 }
+
+func TestResumptionTokens(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1000, 0)
+	r := &sse.ResumptionTokens{Key: []byte("secret"), TTL: time.Minute, Now: func() time.Time { return now }}
+
+	token := r.Issue("session")
+	id, err := r.Parse(token)
+	require.NoError(t, err, "valid token rejected")
+	require.Equal(t, "session", id, "invalid session ID")
+
+	other := &sse.ResumptionTokens{Key: []byte("other"), Now: r.Now}
+	_, err = other.Parse(token)
+	require.ErrorIs(t, err, sse.ErrInvalidResumptionToken, "token with invalid signature accepted")
+
+	for _, invalid := range []string{"", "abc", "abc.def", token[1:], token + "a"} {
+		_, err = r.Parse(invalid)
+		require.ErrorIs(t, err, sse.ErrInvalidResumptionToken, "invalid token %q accepted", invalid)
+	}
+
+	now = now.Add(time.Minute)
+	_, err = r.Parse(token)
+	require.ErrorIs(t, err, sse.ErrInvalidResumptionToken, "expired token accepted")
+
+	require.NotEqual(t, sse.NewResumptionID(), sse.NewResumptionID(), "resumption IDs are not random")
+}
+
+func TestServer_Resumption(t *testing.T) {
+	t.Parallel()
+
+	tokens := &sse.ResumptionTokens{Key: []byte("secret")}
+
+	type session struct {
+		id      string
+		resumed bool
+	}
+
+	sessions := make(chan session, 1)
+	p := newMockProvider(t, nil)
+	s := &sse.Server{
+		Provider:   p,
+		Resumption: tokens,
+		OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+			sessions <- session{id: sess.ResumptionID, resumed: sess.Resumed}
+			return sse.Subscription{Client: sess, Topics: []string{sse.DefaultTopic}}, true
+		},
+	}
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "/", http.NoBody)
+	cancel()
+	s.ServeHTTP(rec, req)
+
+	first := <-sessions
+	require.False(t, first.resumed, "new session marked as resumed")
+	require.NotEmpty(t, first.id, "no resumption ID set")
+	require.Equal(t, first.id, p.Sub.ResumptionID, "resumption ID not set on subscription")
+
+	token := rec.Header().Get(sse.ResumptionTokenHeader)
+	id, err := tokens.Parse(token)
+	require.NoError(t, err, "invalid token issued")
+	require.Equal(t, first.id, id, "token issued for another session")
+	require.Equal(t, "event: sse-resumption-token\ndata: "+token+"\n\ndata: hello\n\n", rec.Body.String(), "invalid response body")
+
+	s = &sse.Server{Provider: newMockProvider(t, nil), Resumption: tokens, OnSession: s.OnSession}
+	req, cancel = request(t, "", "/?"+sse.ResumptionTokenQueryParam+"="+token, http.NoBody)
+	cancel()
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	require.Equal(t, session{id: first.id, resumed: true}, <-sessions, "session not resumed")
+}
+
+func TestMigratingProvider(t *testing.T) {
+	t.Parallel()
+
+	oldJoe, newJoe := &sse.Joe{}, &sse.Joe{}
+	p := &sse.MigratingProvider{Old: oldJoe, New: newJoe}
+	defer p.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ctxOld, cancelOld := newMockContext(t)
+	defer cancelOld()
+	subOld := subscribe(t, p, ctxOld, sse.DefaultTopic)
+	<-ctxOld.waitingOnDone
+
+	require.NoError(t, p.Publish(msg(t, "before", ""), []string{sse.DefaultTopic}))
+
+	p.Migrate()
+
+	ctxNew, cancelNew := newMockContext(t)
+	defer cancelNew()
+	subNew := subscribe(t, p, ctxNew, sse.DefaultTopic)
+	<-ctxNew.waitingOnDone
+
+	require.Equal(t, 1, oldJoe.Stats().Subscribers, "subscriber not kept on old provider")
+	require.Equal(t, 1, newJoe.Stats().Subscribers, "subscriber not added to new provider")
+
+	require.NoError(t, p.Publish(msg(t, "during", ""), []string{sse.DefaultTopic}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, p.Cutover(ctx), context.DeadlineExceeded, "cutover with remaining subscribers")
+
+	cancelOld()
+	require.Equal(t, []string{"data: before\n\n", "data: during\n\n"}, msgStrings(<-subOld), "invalid messages for old subscriber")
+
+	require.NoError(t, p.Cutover(context.Background()), "cutover failed")
+	require.NoError(t, oldJoe.Shutdown(context.Background()), "old provider shutdown failed")
+
+	require.NoError(t, p.Publish(msg(t, "after", ""), []string{sse.DefaultTopic}), "publish after cutover failed")
+
+	cancelNew()
+	require.Equal(t, []string{"data: during\n\n", "data: after\n\n"}, msgStrings(<-subNew), "invalid messages for new subscriber")
+
+	errOld, errNew := errors.New("old"), errors.New("new")
+	failing := &sse.MigratingProvider{
+		Old: shutdownErrorProvider{Provider: newMockProvider(t, nil), err: errOld},
+		New: shutdownErrorProvider{Provider: newMockProvider(t, nil), err: errNew},
+	}
+	require.ErrorIs(t, failing.Shutdown(context.Background()), errOld, "old provider's error not returned first")
+
+	failing.Old = shutdownErrorProvider{Provider: newMockProvider(t, nil), err: sse.ErrProviderClosed}
+	require.ErrorIs(t, failing.Shutdown(context.Background()), errNew, "new provider's error not returned")
+}
+
+// shutdownErrorProvider is a provider whose Shutdown returns the given error.
+type shutdownErrorProvider struct {
+	sse.Provider
+	err error
+}
+
+func (s shutdownErrorProvider) Shutdown(context.Context) error { return s.err }
+
+func TestMultiProvider(t *testing.T) {
+	t.Parallel()
+
+	require.ErrorIs(t, (&sse.MultiProvider{}).Publish(msg(t, "a", ""), []string{sse.DefaultTopic}), sse.ErrNoProviders, "published without providers")
+	require.ErrorIs(t, (&sse.MultiProvider{}).Subscribe(context.Background(), sse.Subscription{}), sse.ErrNoProviders, "subscribed without providers")
+	outOfRange := &sse.MultiProvider{Providers: []sse.Provider{newMockProvider(t, nil)}, SubscribeTo: 1}
+	require.EqualError(t, outOfRange.Subscribe(context.Background(), sse.Subscription{}), "go-sse.server: SubscribeTo index 1 out of range for 1 providers", "subscribed to missing provider")
+
+	local, remote := &sse.Joe{}, newMockProvider(t, nil)
+	p := &sse.MultiProvider{Providers: []sse.Provider{local, remote}}
+
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+	sub := subscribe(t, p, ctx, sse.DefaultTopic)
+	<-ctx.waitingOnDone
+
+	require.NoError(t, p.Publish(msg(t, "hello", ""), []string{sse.DefaultTopic}))
+	require.NoError(t, local.Ping(context.Background()), "ping failed")
+	require.Equal(t, "data: hello\n\n", remote.Pub.String(), "message not published to all providers")
+	require.False(t, remote.Subscribed, "subscribed to other provider")
+
+	require.NoError(t, p.Shutdown(context.Background()))
+	require.True(t, remote.Stopped, "provider not shut down")
+	require.Equal(t, []string{"data: hello\n\n"}, msgStrings(<-sub), "invalid messages")
+	require.ErrorIs(t, p.Publish(msg(t, "a", ""), []string{sse.DefaultTopic}), sse.ErrProviderClosed, "first error not returned")
+}
+
+func TestRelayProvider(t *testing.T) {
+	t.Parallel()
+
+	// The first connection sends an event and ends, the second one resumes from it.
+	lastEventIDs := make(chan string, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastEventIDs <- r.Header.Get("Last-Event-ID")
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		if r.Header.Get("Last-Event-ID") == "" {
+			_, _ = io.WriteString(w, "id: 1\nevent: update\ndata: hello\n\n")
+			return
+		}
+
+		_, _ = io.WriteString(w, "data: no id\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, http.NoBody)
+	require.NoError(t, err)
+
+	local := &sse.Joe{}
+	relay := &sse.RelayProvider{
+		Request: req,
+		Client:  &sse.Client{DefaultReconnectionTime: time.Millisecond},
+		Local:   local,
+		Topics:  func(ev sse.Event) []string { return []string{"relayed/" + ev.Type} },
+	}
+	defer relay.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	// The client is subscribed before the relay starts, so it receives all the relayed events.
+	client := &ptrClient{}
+	ctx, cancel := newMockContext(t)
+	defer cancel()
+	go func() {
+		_ = local.Subscribe(ctx, sse.Subscription{Client: client, Topics: []string{"relayed/update", "relayed/", "local"}})
+	}()
+	<-ctx.waitingOnDone
+
+	require.NoError(t, relay.Publish(msg(t, "local", ""), []string{"local"}))
+
+	require.Equal(t, "", <-lastEventIDs, "invalid initial last event ID")
+	require.Equal(t, "1", <-lastEventIDs, "connection not resumed from last relayed event")
+
+	expected := []string{"data: local\n\n", "id: 1\nevent: update\ndata: hello\n\n", "data: no id\n\n"}
+	require.Eventually(t, func() bool { return len(client.Messages()) == len(expected) }, time.Second, time.Millisecond, "events not relayed")
+	require.Equal(t, expected, msgStrings(client.Messages()), "invalid relayed events")
+}
+
+func TestServer_ReplaceTopics(t *testing.T) {
+	t.Parallel()
+
+	_, err := (&sse.Server{Provider: newMockProvider(t, nil)}).ReplaceTopics(context.Background(), &sse.Session{}, "a")
+	require.ErrorIs(t, err, sse.ErrReplaceTopicsUnsupported, "replaced topics with unsupported provider")
+
+	sessions := make(chan *sse.Session, 1)
+	s := &sse.Server{
+		OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+			sessions <- sess
+			return sse.Subscription{Client: sess, Topics: []string{"a"}}, true
+		},
+		ValidateTopic: sse.ValidateTopic,
+	}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "/", http.NoBody)
+	defer cancel()
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.ServeHTTP(rec, req)
+	}()
+
+	sess := <-sessions
+
+	_, err = s.ReplaceTopics(context.Background(), sess, "\n")
+	require.ErrorIs(t, err, sse.ErrInvalidTopic, "replaced topics with invalid topic")
+
+	require.Eventually(t, func() bool {
+		previous, err := s.ReplaceTopics(context.Background(), sess, "b")
+		return err == nil && reflect.DeepEqual(previous, []string{"a"})
+	}, time.Second, time.Millisecond, "topics not replaced")
+
+	require.NoError(t, s.Publish(msg(t, "a", ""), "a"))
+	require.NoError(t, s.Publish(msg(t, "b", ""), "b"))
+
+	cancel()
+	<-served
+
+	require.Equal(t, "data: b\n\n", rec.Body.String(), "invalid messages after replace")
+
+	_, err = s.ReplaceTopics(context.Background(), sess, "a")
+	require.ErrorIs(t, err, sse.ErrNotSubscribed, "replaced topics of ended session")
+}
+
+func TestServer_AddRemoveTopics(t *testing.T) {
+	t.Parallel()
+
+	unsupported := &sse.Server{Provider: newMockProvider(t, nil)}
+	require.ErrorIs(t, unsupported.AddTopics(context.Background(), &sse.Session{}, "a"), sse.ErrUpdateTopicsUnsupported, "added topics with unsupported provider")
+	require.ErrorIs(t, unsupported.RemoveTopics(context.Background(), &sse.Session{}, "a"), sse.ErrUpdateTopicsUnsupported, "removed topics with unsupported provider")
+
+	sessions := make(chan *sse.Session, 1)
+	s := &sse.Server{
+		OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+			sessions <- sess
+			return sse.Subscription{Client: sess, Topics: []string{"a"}}, true
+		},
+		ValidateTopic: sse.ValidateTopic,
+	}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "/", http.NoBody)
+	defer cancel()
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.ServeHTTP(rec, req)
+	}()
+
+	sess := <-sessions
+
+	require.ErrorIs(t, s.AddTopics(context.Background(), sess, "\n"), sse.ErrInvalidTopic, "added invalid topic")
+	require.ErrorIs(t, s.AddTopics(context.Background(), sess), sse.ErrNoTopic, "added no topics")
+
+	require.Eventually(t, func() bool {
+		return s.AddTopics(context.Background(), sess, "b") == nil
+	}, time.Second, time.Millisecond, "topics not added")
+	require.NoError(t, s.RemoveTopics(context.Background(), sess, "a"), "remove failed")
+
+	require.NoError(t, s.Publish(msg(t, "a", ""), "a"))
+	require.NoError(t, s.Publish(msg(t, "b", ""), "b"))
+
+	cancel()
+	<-served
+
+	require.Equal(t, "data: b\n\n", rec.Body.String(), "invalid messages after update")
+	require.ErrorIs(t, s.RemoveTopics(context.Background(), sess, "b"), sse.ErrNotSubscribed, "removed topics of ended session")
+}
+
+func TestServer_SendTo(t *testing.T) {
+	t.Parallel()
+
+	err := (&sse.Server{Provider: newMockProvider(t, nil)}).SendTo(context.Background(), &sse.Session{}, msg(t, "a", ""))
+	require.ErrorIs(t, err, sse.ErrSendToUnsupported, "sent with unsupported provider")
+
+	sessions := make(chan *sse.Session, 1)
+	s := &sse.Server{
+		OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+			sessions <- sess
+			return sse.Subscription{Client: sess, Topics: []string{"a"}}, true
+		},
+	}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "/", http.NoBody)
+	defer cancel()
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.ServeHTTP(rec, req)
+	}()
+
+	sess := <-sessions
+
+	require.Eventually(t, func() bool {
+		return s.SendTo(context.Background(), sess, msg(t, "unicast", "")) == nil
+	}, time.Second, time.Millisecond, "message not sent")
+	require.NoError(t, s.Publish(msg(t, "a", ""), "a"))
+
+	cancel()
+	<-served
+
+	require.Equal(t, "data: unicast\n\ndata: a\n\n", rec.Body.String(), "invalid messages")
+
+	err = s.SendTo(context.Background(), sess, msg(t, "unicast", ""))
+	require.ErrorIs(t, err, sse.ErrNotSubscribed, "sent to ended session")
+}
+
+func TestServer_KeepAliveInterval(t *testing.T) {
+	t.Parallel()
+
+	const interval = 10 * time.Millisecond
+
+	s := &sse.Server{KeepAliveInterval: interval}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "/", http.NoBody)
+	defer cancel()
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.ServeHTTP(rec, req)
+	}()
+
+	time.Sleep(interval * 3)
+	require.NoError(t, s.Publish(msg(t, "hello", "")))
+	time.Sleep(interval * 2)
+
+	cancel()
+	<-served
+
+	body := rec.Body.String()
+	require.True(t, strings.HasPrefix(body, ": ka-interval=10ms\n\n"), "no keep-alive sent: %q", body)
+	require.Contains(t, body, "data: hello\n\n", "message not sent")
+}
+
+func TestServer_DefaultRetry(t *testing.T) {
+	t.Parallel()
+
+	s := &sse.Server{Provider: newMockProvider(t, nil), DefaultRetry: 3 * time.Second}
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "/", http.NoBody)
+	cancel()
+
+	s.ServeHTTP(rec, req)
+
+	require.Equal(t, "retry: 3000\n\ndata: hello\n\n", rec.Body.String(), "retry not sent before the events")
+}
+
+func TestServer_Padding(t *testing.T) {
+	t.Parallel()
+
+	s := &sse.Server{Provider: newMockProvider(t, nil), Padding: 2048, DefaultRetry: time.Second}
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "/", http.NoBody)
+	cancel()
+
+	s.ServeHTTP(rec, req)
+
+	expected := ": " + strings.Repeat(" ", 2048) + "\n\nretry: 1000\n\ndata: hello\n\n"
+	require.Equal(t, expected, rec.Body.String(), "padding not sent first")
+}
+
+func TestServer_WriteTimeout(t *testing.T) {
+	t.Parallel()
+
+	ended := make(chan error, 1)
+	s := &sse.Server{
+		WriteTimeout: 50 * time.Millisecond,
+		OnSessionEnd: func(_ *sse.Session, reason error) { ended <- reason },
+	}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	// The client never reads the response, so the connection's buffers fill up.
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	require.NoError(t, err, "dial failed")
+	defer conn.Close()
+
+	_, err = fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\n\r\n", ts.Listener.Addr())
+	require.NoError(t, err, "request failed")
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		large := msg(t, strings.Repeat("a", 1<<20), "")
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = s.Publish(large)
+			}
+		}
+	}()
+
+	select {
+	case <-ended:
+	case <-time.After(5 * time.Second):
+		t.Fatal("session not ended by write timeout")
+	}
+}
+
+func TestServer_FlushInterval(t *testing.T) {
+	t.Parallel()
+
+	var (
+		flushes []int
+		mu      sync.Mutex
+	)
+	s := &sse.Server{
+		FlushInterval:  200 * time.Millisecond,
+		FlushBatchSize: 3,
+		OnFlush: func(_ context.Context, _ time.Duration, bytes int) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			if bytes > 0 {
+				flushes = append(flushes, bytes)
+			}
+		},
+	}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "/", http.NoBody)
+	defer cancel()
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.ServeHTTP(rec, req)
+	}()
+
+	require.Eventually(t, func() bool {
+		res, err := s.PublishWithResult(context.Background(), msg(t, "m0", ""))
+		return err == nil && res.Subscribers == 1
+	}, time.Second, time.Millisecond, "session not subscribed")
+
+	for i := 1; i < 7; i++ {
+		_, err := s.PublishWithResult(context.Background(), msg(t, "m"+strconv.Itoa(i), ""))
+		require.NoError(t, err, "publish failed")
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(flushes) == 3
+	}, time.Second, time.Millisecond, "messages not flushed")
+
+	cancel()
+	<-served
+
+	const size = len("data: m0\n\n")
+	require.Equal(t, []int{3 * size, 3 * size, size}, flushes, "flushes not coalesced")
+}
+
+func TestServer_MaxConnections(t *testing.T) {
+	t.Parallel()
+
+	rejected := make(chan error, 1)
+	s := &sse.Server{
+		MaxConnections: 1,
+		RetryAfter:     1500 * time.Millisecond,
+		OnReject:       func(_ *http.Request, reason error) { rejected <- reason },
+	}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	req, cancel := request(t, "", "/", http.NoBody)
+	defer cancel()
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	require.Eventually(t, func() bool {
+		res, err := s.PublishWithResult(context.Background(), msg(t, "ready", ""))
+		return err == nil && res.Subscribers == 1
+	}, time.Second, time.Millisecond, "session not subscribed")
+
+	rec := httptest.NewRecorder()
+	other, cancelOther := request(t, "", "/", http.NoBody)
+	defer cancelOther()
+
+	s.ServeHTTP(rec, other)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code, "request over limit not rejected")
+	require.Equal(t, "2", rec.Header().Get("Retry-After"), "invalid Retry-After header")
+	require.ErrorIs(t, <-rejected, sse.ErrTooManyConnections, "invalid rejection reason")
+
+	cancel()
+	<-served
+
+	rec = httptest.NewRecorder()
+	cancelOther()
+	s.ServeHTTP(rec, other)
+	require.Equal(t, http.StatusOK, rec.Code, "request rejected after connection ended")
+}
+
+func TestServer_topicFull(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]func(*sse.Server){
+		"Default": func(*sse.Server) {},
+		// The preamble must not commit the session before the subscription is accepted.
+		"Padding":      func(s *sse.Server) { s.Padding = 16 },
+		"DefaultRetry": func(s *sse.Server) { s.DefaultRetry = time.Second },
+	}
+
+	for name, configure := range tests {
+		configure := configure
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			rejected := make(chan error, 1)
+			s := &sse.Server{
+				Provider: &sse.Joe{TopicSubscriberLimit: func(string) int { return 1 }},
+				OnReject: func(_ *http.Request, reason error) { rejected <- reason },
+			}
+			configure(s)
+			defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+			req, cancel := request(t, "", "/", http.NoBody)
+			defer cancel()
+
+			served := make(chan struct{})
+			go func() {
+				defer close(served)
+				s.ServeHTTP(httptest.NewRecorder(), req)
+			}()
+
+			require.Eventually(t, func() bool {
+				res, err := s.PublishWithResult(context.Background(), msg(t, "ready", ""))
+				return err == nil && res.Subscribers == 1
+			}, time.Second, time.Millisecond, "session not subscribed")
+
+			rec := httptest.NewRecorder()
+			other, cancelOther := request(t, "", "/", http.NoBody)
+			defer cancelOther()
+
+			s.ServeHTTP(rec, other)
+			require.Equal(t, http.StatusServiceUnavailable, rec.Code, "session subscribed to full topic")
+			require.ErrorIs(t, <-rejected, sse.ErrTopicFull, "invalid rejection reason")
+
+			cancel()
+			<-served
+		})
+	}
+}
+
+func TestServer_CORS(t *testing.T) {
+	t.Parallel()
+
+	s := &sse.Server{CORS: &sse.CORS{
+		AllowedOrigins:   []string{"https://example.com"},
+		AllowOrigin:      func(origin string) bool { return strings.HasSuffix(origin, ".example.com") },
+		AllowCredentials: true,
+		AllowedHeaders:   []string{"Authorization"},
+		MaxAge:           time.Hour,
+	}}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	serve := func(method, origin string, preflight bool) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req, cancel := request(t, method, "/", http.NoBody)
+		cancel()
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+		}
+
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodOptions, "https://EXAMPLE.com", true)
+	require.Equal(t, http.StatusNoContent, rec.Code, "preflight not answered")
+	require.Equal(t, "https://EXAMPLE.com", rec.Header().Get("Access-Control-Allow-Origin"), "invalid allowed origin")
+	require.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"), "credentials not allowed")
+	require.Equal(t, "GET", rec.Header().Get("Access-Control-Allow-Methods"), "invalid allowed methods")
+	require.Equal(t, "Last-Event-ID, Sse-Resumption-Token, Authorization", rec.Header().Get("Access-Control-Allow-Headers"), "invalid allowed headers")
+	require.Equal(t, "3600", rec.Header().Get("Access-Control-Max-Age"), "invalid max age")
+	require.Empty(t, rec.Body.String(), "preflight response has body")
+
+	rec = serve(http.MethodGet, "https://api.example.com", false)
+	require.Equal(t, http.StatusOK, rec.Code, "allowed origin rejected")
+	require.Equal(t, "https://api.example.com", rec.Header().Get("Access-Control-Allow-Origin"), "invalid allowed origin")
+	require.Equal(t, "Sse-Resumption-Token", rec.Header().Get("Access-Control-Expose-Headers"), "invalid exposed headers")
+	require.Equal(t, []string{"Origin"}, rec.Header().Values("Vary"), "invalid Vary header")
+
+	rec = serve(http.MethodGet, "https://evil.com", false)
+	require.Equal(t, http.StatusForbidden, rec.Code, "disallowed origin not rejected")
+	require.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"), "disallowed origin allowed")
+
+	rec = serve(http.MethodGet, "", false)
+	require.Equal(t, http.StatusOK, rec.Code, "request without origin rejected")
+	require.Empty(t, rec.Header().Get("Access-Control-Allow-Origin"), "CORS headers sent without origin")
+
+	s.CORS.AllowedOrigins, s.CORS.AllowCredentials = []string{"*"}, false
+	rec = serve(http.MethodGet, "https://evil.com", false)
+	require.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"), "wildcard origin not sent")
+	require.Empty(t, rec.Header().Get("Access-Control-Allow-Credentials"), "credentials allowed")
+}
+
+func TestServer_Compression(t *testing.T) {
+	t.Parallel()
+
+	s := &sse.Server{Compression: &sse.Compression{Level: gzip.BestCompression}}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Accept-Encoding", "br, gzip;q=0.8")
+
+	// The response is sent once the first event is.
+	responses := make(chan *http.Response, 1)
+	go func() {
+		res, err := ts.Client().Do(req) //nolint:bodyclose // closed below
+		if err != nil {
+			close(responses)
+			return
+		}
+		responses <- res
+	}()
+
+	require.Eventually(t, func() bool {
+		res, err := s.PublishWithResult(context.Background(), msg(t, "hello", ""))
+		return err == nil && res.Subscribers == 1
+	}, time.Second, time.Millisecond, "session not subscribed")
+
+	res, ok := <-responses
+	require.True(t, ok, "request failed")
+	defer res.Body.Close()
+
+	require.Equal(t, "gzip", res.Header.Get("Content-Encoding"), "stream not compressed")
+	require.Equal(t, "Accept-Encoding", res.Header.Get("Vary"), "invalid Vary header")
+
+	// The stream stays open, so the event is readable only if the compressor was flushed.
+	zr, err := gzip.NewReader(res.Body)
+	require.NoError(t, err, "invalid compressed stream")
+	event := make([]byte, len("data: hello\n\n"))
+	_, err = io.ReadFull(zr, event)
+	require.NoError(t, err, "event not received")
+	require.Equal(t, "data: hello\n\n", string(event), "invalid event")
+
+	negotiate := func(c *sse.Compression, acceptEncoding string) string {
+		t.Helper()
+
+		// The padding commits the response, so the encoding is set.
+		s := &sse.Server{Compression: c, Padding: 1}
+		defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+		req, cancel := request(t, "", "/", http.NoBody)
+		cancel()
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		return rec.Header().Get("Content-Encoding")
+	}
+
+	brotli := &sse.Compression{Brotli: func(w io.Writer) sse.Compressor { return gzip.NewWriter(w) }}
+	require.Equal(t, "br", negotiate(brotli, "gzip, br"), "brotli not preferred")
+	require.Equal(t, "gzip", negotiate(brotli, "gzip, br;q=0"), "refused encoding used")
+	require.Equal(t, "br", negotiate(brotli, "*"), "wildcard not accepted")
+	require.Equal(t, "gzip", negotiate(&sse.Compression{}, "*"), "wildcard not accepted")
+	require.Equal(t, "", negotiate(&sse.Compression{}, "br, gzip;q=0"), "unsupported encoding used")
+	require.Equal(t, "", negotiate(&sse.Compression{}, ""), "stream compressed without Accept-Encoding")
+}
+
+func TestLongPollHandler(t *testing.T) {
+	t.Parallel()
+
+	j := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 5, AutoIDs: true}}
+	s := &sse.Server{Provider: j}
+	h := &sse.LongPollHandler{Server: s}
+
+	poll := func(h *sse.LongPollHandler, target string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, http.NoBody))
+		return rec
+	}
+
+	// The client waits for the next events.
+	polled := make(chan *httptest.ResponseRecorder, 1)
+	go func() { polled <- poll(h, "/") }()
+
+	require.Eventually(t, func() bool { return j.Stats().Subscribers == 1 }, time.Second, time.Millisecond, "request not subscribed")
+	m := msg(t, "a\nb", "")
+	m.Type = sse.Type("update")
+	m.Retry = time.Second
+	require.NoError(t, s.Publish(m))
+
+	rec := <-polled
+	require.Equal(t, http.StatusOK, rec.Code, "invalid status code")
+	require.Equal(t, "application/json", rec.Header().Get("Content-Type"), "invalid content type")
+	require.Equal(t, `[{"id":"0","event":"update","data":"a\nb","retry":1000}]`+"\n", rec.Body.String(), "invalid events")
+
+	// The events published between the requests are replayed.
+	require.NoError(t, s.Publish(msg(t, "c", "")))
+	require.NoError(t, j.Ping(context.Background()))
+	rec = poll(h, "/?"+sse.LongPollLastEventIDQueryParam+"=0")
+	require.Equal(t, `[{"id":"1","event":"message","data":"c"}]`+"\n", rec.Body.String(), "invalid replayed events")
+
+	rec = poll(&sse.LongPollHandler{Server: s, Timeout: time.Millisecond}, "/?"+sse.LongPollLastEventIDQueryParam+"=1")
+	require.Equal(t, http.StatusOK, rec.Code, "invalid status code")
+	require.Equal(t, "[]\n", rec.Body.String(), "events received after timeout")
+
+	require.NoError(t, s.Shutdown(context.Background()))
+	require.Equal(t, http.StatusServiceUnavailable, poll(h, "/").Code, "invalid status code after shutdown")
+}
+
+func TestServer_OnSubscribe(t *testing.T) {
+	t.Parallel()
+
+	serve := func(subErr error) []string {
+		var events []string
+		s := &sse.Server{
+			Provider: newMockProvider(t, subErr),
+			OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+				return sse.Subscription{Client: sess, Topics: []string{"a", "b"}}, true
+			},
+			OnSubscribe: func(_ *sse.Session, sub sse.Subscription) {
+				events = append(events, fmt.Sprintf("subscribe %v", sub.Topics))
+			},
+			OnUnsubscribe: func(_ *sse.Session, sub sse.Subscription, reason error) {
+				events = append(events, fmt.Sprintf("unsubscribe %v %v", sub.Topics, reason))
+			},
+			OnSessionEnd: func(*sse.Session, error) { events = append(events, "end") },
+		}
+
+		req, cancel := request(t, "", "/", http.NoBody)
+		cancel()
+		s.ServeHTTP(httptest.NewRecorder(), req)
+
+		return events
+	}
+
+	require.Equal(t, []string{"subscribe [a b]", "unsubscribe [a b] <nil>", "end"}, serve(nil), "invalid callbacks")
+	require.Equal(t, []string{"subscribe [a b]", "unsubscribe [a b] failed", "end"}, serve(errors.New("failed")), "invalid callbacks on error")
+}
+
+func TestServer_Ping(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, (&sse.Server{Provider: newMockProvider(t, nil)}).Ping(context.Background()), "ping failed without pinger")
+
+	s := &sse.Server{}
+	require.NoError(t, s.Ping(context.Background()), "ping failed")
+	require.NoError(t, s.Shutdown(context.Background()))
+	require.ErrorIs(t, s.Ping(context.Background()), sse.ErrProviderClosed, "ping succeeded after shutdown")
+}
+
+func TestServer_instrumentation(t *testing.T) {
+	t.Parallel()
+
+	type ctxKey struct{}
+
+	var flushed, replayed []int
+	var contexts []context.Context
+
+	j := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 5, AutoIDs: true}}
+	defer j.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	s := &sse.Server{
+		Provider: j,
+		OnFlush: func(ctx context.Context, d time.Duration, bytes int) {
+			require.GreaterOrEqual(t, d, time.Duration(0), "negative flush duration")
+			flushed = append(flushed, bytes)
+			contexts = append(contexts, ctx)
+		},
+		OnReplayDone: func(ctx context.Context, d time.Duration, events int) {
+			replayed = append(replayed, events)
+			contexts = append(contexts, ctx)
+		},
+	}
+
+	for _, data := range []string{"a", "b", "c"} {
+		require.NoError(t, s.Publish(msg(t, data, "")))
+	}
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "/", http.NoBody)
+	req = req.WithContext(context.WithValue(req.Context(), ctxKey{}, "request"))
+	req.Header.Set("Last-Event-ID", "0")
+	cancel()
+	s.ServeHTTP(rec, req)
+
+	require.Equal(t, "id: 1\ndata: b\n\nid: 2\ndata: c\n\n", rec.Body.String(), "invalid response body")
+	require.Equal(t, []int{2}, replayed, "invalid replayed event counts")
+
+	total := 0
+	for _, n := range flushed {
+		total += n
+	}
+	require.Equal(t, rec.Body.Len(), total, "flushed bytes don't match the response body")
+
+	for _, ctx := range contexts {
+		require.Equal(t, "request", ctx.Value(ctxKey{}), "callback didn't receive the request context")
+	}
+}
+
+func TestServer_TopicPolicies(t *testing.T) {
+	t.Parallel()
+
+	p := newMockProvider(t, nil)
+	s := &sse.Server{
+		Provider: p,
+		TopicPolicies: map[string]sse.TopicPolicy{
+			"audit":  {DefaultType: sse.Type("audit"), IDs: sse.IDRequired},
+			"ticker": {IDs: sse.IDForbidden},
+			"feed":   {DefaultType: sse.Type("feed"), IDs: sse.IDAuto},
+		},
+	}
+
+	var policyErr *sse.TopicPolicyError
+
+	err := s.Publish(msg(t, "no id", ""), "audit")
+	require.ErrorIs(t, err, sse.ErrIDRequired, "message without ID accepted")
+	require.ErrorAs(t, err, &policyErr, "invalid error type")
+	require.Equal(t, "audit", policyErr.Topic, "invalid error topic")
+	require.ErrorIs(t, s.Publish(msg(t, "id", "1"), "ticker"), sse.ErrIDForbidden, "message with ID accepted")
+	require.ErrorIs(t, s.Publish(msg(t, "auto", ""), "feed", "ticker"), sse.ErrIDForbidden, "auto ID allowed on topic forbidding IDs")
+	require.False(t, p.Published, "Publish was called for invalid messages")
+
+	m := msg(t, "audited", "1")
+	require.NoError(t, s.Publish(m, "audit"))
+	require.Equal(t, "id: 1\nevent: audit\ndata: audited\n\n", p.Pub.String(), "default type not set")
+	require.Equal(t, "id: 1\ndata: audited\n\n", m.String(), "published message modified")
+
+	m = msg(t, "typed", "2")
+	m.Type = sse.Type("custom")
+	require.NoError(t, s.Publish(m, "audit"))
+	require.Same(t, m, p.Pub, "message copied needlessly")
+
+	require.NoError(t, s.Publish(msg(t, "tick", ""), "ticker"))
+	require.Equal(t, "data: tick\n\n", p.Pub.String(), "message without ID changed")
+
+	require.NoError(t, s.Publish(msg(t, "first", ""), "feed"))
+	first := p.Pub
+	require.NoError(t, s.Publish(msg(t, "second", ""), "feed"))
+	second := p.Pub
+	require.Equal(t, "feed", first.Type.String(), "default type not set")
+
+	firstID, err := strconv.ParseInt(first.ID.String(), 10, 64)
+	require.NoError(t, err, "invalid auto ID")
+	secondID, err := strconv.ParseInt(second.ID.String(), 10, 64)
+	require.NoError(t, err, "invalid auto ID")
+	require.Greater(t, secondID, firstID, "auto IDs not increasing")
+}
+
+func TestServer_Publish_validation(t *testing.T) {
+	t.Parallel()
+
+	p := newMockProvider(t, nil)
+	s := &sse.Server{Provider: p}
+
+	require.ErrorIs(t, s.Publish(nil), sse.ErrNilMessage, "nil message accepted")
+	require.ErrorIs(t, s.Publish(&sse.Message{ID: sse.ID("a\x00b")}), sse.ErrInvalidMessage, "ID with null byte accepted")
+	require.False(t, p.Published, "Publish was called for invalid messages")
+
+	require.NoError(t, s.Publish(&sse.Message{}), "empty message rejected by default")
+	require.True(t, p.Published, "Publish wasn't called")
+
+	p = newMockProvider(t, nil)
+	s = &sse.Server{Provider: p, RejectEmptyMessages: true}
+
+	require.ErrorIs(t, s.Publish(&sse.Message{}), sse.ErrEmptyMessage, "empty message accepted")
+	require.ErrorIs(t, s.Publish(&sse.Message{Type: sse.Type(sse.DefaultEventType)}), sse.ErrEmptyMessage, "message encoded to nothing accepted")
+	require.False(t, p.Published, "Publish was called for empty messages")
+
+	for _, m := range []*sse.Message{msg(t, "", "1"), {Type: sse.Type("ping")}, {Retry: time.Second}} {
+		require.NoError(t, s.Publish(m), "non-empty message %q rejected", m.String())
+	}
+}
+
+func TestServer_IDGenerator(t *testing.T) {
+	t.Parallel()
+
+	p := newMockProvider(t, nil)
+	s := &sse.Server{Provider: p, IDGenerator: &sse.CounterIDGenerator{}}
+
+	m := msg(t, "hello", "")
+	require.NoError(t, s.Publish(m))
+	require.Equal(t, "id: 0\ndata: hello\n\n", p.Pub.String(), "ID not generated")
+	require.Equal(t, "data: hello\n\n", m.String(), "published message modified")
+
+	require.NoError(t, s.Publish(msg(t, "hello", "custom")))
+	require.Equal(t, "id: custom\ndata: hello\n\n", p.Pub.String(), "existing ID replaced")
+
+	require.NoError(t, s.Publish(msg(t, "hello", "")))
+	require.Equal(t, "id: 1\ndata: hello\n\n", p.Pub.String(), "IDs not consecutive")
+}
+
+func TestServer_PublishWithResult(t *testing.T) {
+	t.Parallel()
+
+	_, err := (&sse.Server{Provider: newMockProvider(t, nil)}).PublishWithResult(context.Background(), msg(t, "a", ""))
+	require.ErrorIs(t, err, sse.ErrPublishResultUnsupported, "published with unsupported provider")
+
+	s := &sse.Server{ValidateTopic: sse.ValidateTopic}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	_, err = s.PublishWithResult(context.Background(), msg(t, "a", ""), "\n")
+	require.ErrorIs(t, err, sse.ErrInvalidTopic, "published to invalid topic")
+
+	res, err := s.PublishWithResult(context.Background(), msg(t, "a", ""))
+	require.NoError(t, err, "publish failed")
+	require.Equal(t, sse.PublishResult{Topics: map[string]int{sse.DefaultTopic: 0}}, res, "invalid result")
+}
+
+func TestServer_Broadcast(t *testing.T) {
+	t.Parallel()
+
+	err := (&sse.Server{Provider: newMockProvider(t, nil)}).Broadcast(context.Background(), msg(t, "a", ""))
+	require.ErrorIs(t, err, sse.ErrBroadcastUnsupported, "broadcast with unsupported provider")
+
+	j := &sse.Joe{}
+	sessions := make(chan *sse.Session, 2)
+	s := &sse.Server{
+		Provider: j,
+		OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+			sessions <- sess
+			return sse.Subscription{Client: sess, Topics: []string{sess.Req.URL.Query().Get("topic")}}, true
+		},
+	}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	require.NoError(t, s.Broadcast(context.Background(), msg(t, "nobody", "")), "broadcast without subscribers failed")
+	require.ErrorIs(t, s.Broadcast(context.Background(), nil), sse.ErrNilMessage, "nil message broadcast")
+
+	recs := []*httptest.ResponseRecorder{httptest.NewRecorder(), httptest.NewRecorder()}
+	cancels := make([]context.CancelFunc, 0, len(recs))
+	var served sync.WaitGroup
+	for i, topic := range []string{"a", "b"} {
+		req, cancel := request(t, "", "/?topic="+topic, http.NoBody)
+		defer cancel()
+		cancels = append(cancels, cancel)
+
+		rec := recs[i]
+		served.Add(1)
+		go func() {
+			defer served.Done()
+			s.ServeHTTP(rec, req)
+		}()
+		<-sessions
+	}
+
+	require.Eventually(t, func() bool {
+		topics, err := j.Topics(context.Background())
+		return err == nil && len(topics) == 2
+	}, time.Second, time.Millisecond, "sessions not subscribed")
+
+	require.NoError(t, s.Broadcast(context.Background(), msg(t, "all", "")), "broadcast failed")
+
+	for _, cancel := range cancels {
+		cancel()
+	}
+	served.Wait()
+
+	for _, rec := range recs {
+		require.Equal(t, "data: all\n\n", rec.Body.String(), "broadcast not received")
+	}
+}
+
+func TestServer_ShutdownWithMessage(t *testing.T) {
+	t.Parallel()
+
+	s := &sse.Server{}
+
+	require.ErrorIs(t, s.ShutdownWithMessage(context.Background(), nil), sse.ErrNilMessage, "shut down with nil message")
+
+	rec := httptest.NewRecorder()
+	req, cancel := request(t, "", "/", http.NoBody)
+	defer cancel()
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.ServeHTTP(rec, req)
+	}()
+
+	require.Eventually(t, func() bool {
+		res, err := s.PublishWithResult(context.Background(), msg(t, "hello", ""))
+		return err == nil && res.Subscribers == 1
+	}, time.Second, time.Millisecond, "session not subscribed")
+
+	goodbye := &sse.Message{Type: sse.Type("shutdown"), Retry: time.Minute}
+	goodbye.AppendData("bye")
+	require.NoError(t, s.ShutdownWithMessage(context.Background(), goodbye), "shutdown failed")
+	<-served
+
+	require.Equal(t, "data: hello\n\nevent: shutdown\nretry: 60000\ndata: bye\n\n", rec.Body.String(), "goodbye message not sent")
+	require.ErrorIs(t, s.ShutdownWithMessage(context.Background(), goodbye), sse.ErrProviderClosed, "shut down twice")
+}
+
+func TestServer_Use(t *testing.T) {
+	t.Parallel()
+
+	p := newMockProvider(t, nil)
+	s := &sse.Server{Provider: p, ValidateTopic: sse.ValidateTopic}
+
+	errRejected := errors.New("rejected")
+	s.Use(
+		func(m *sse.Message, topics []string) (*sse.Message, []string, error) {
+			if m.String() == "data: secret\n\n" {
+				return nil, nil, errRejected
+			}
+			if m.String() == "data: noise\n\n" {
+				return nil, nil, nil
+			}
+
+			m = m.Clone()
+			m.AppendComment("stamped")
+			return m, topics, nil
+		},
+		func(m *sse.Message, topics []string) (*sse.Message, []string, error) {
+			return m, append([]string{"audit"}, topics...), nil
+		},
+	)
+
+	m := msg(t, "hello", "")
+	require.NoError(t, s.Publish(m))
+	require.Equal(t, "data: hello\n: stamped\n\n", p.Pub.String(), "message not processed")
+	require.Equal(t, []string{"audit", sse.DefaultTopic}, p.PubTopics, "message not re-routed")
+	require.Equal(t, "data: hello\n\n", m.String(), "published message modified")
+
+	p.Published = false
+	require.ErrorIs(t, s.Publish(msg(t, "secret", "")), errRejected, "rejected message published")
+	require.NoError(t, s.Publish(msg(t, "noise", "")), "dropped message returned error")
+	require.ErrorIs(t, s.Publish(nil), sse.ErrNilMessage, "nil message passed to middlewares")
+	require.False(t, p.Published, "Publish was called for rejected messages")
+
+	s.Use(func(m *sse.Message, _ []string) (*sse.Message, []string, error) { return m, []string{"\n"}, nil })
+	require.ErrorIs(t, s.Publish(msg(t, "invalid", "")), sse.ErrInvalidTopic, "invalid topic from middleware accepted")
+	require.False(t, p.Published, "Publish was called for invalid topic")
+}
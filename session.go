@@ -1,8 +1,11 @@
 package sse
 
 import (
+	"context"
 	"errors"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 // ResponseWriter is a http.ResponseWriter augmented with a Flush method.
@@ -28,7 +31,8 @@ type MessageWriter interface {
 // get the last event ID, or write data to the client.
 type Session struct {
 	// The response writer for the request. Can be used to write an error response
-	// back to the client. Must not be used after the Session was subscribed!
+	// back to the client, if the session is not committed. Must not be used after
+	// the Session was subscribed!
 	Res ResponseWriter
 	// The initial HTTP request. Can be used to retrieve authentication data,
 	// topics, or data from context – a logger, for example.
@@ -36,11 +40,34 @@ type Session struct {
 	// Last evend ID of the client. It is unset if no ID was provided in the Last-Event-Id
 	// request header.
 	LastEventID EventID
+	// Since is the time from which the client requests the replay of events, if it has no last event ID.
+	// It is the zero time if the request has no valid "since" query parameter – see Subscription.Since.
+	Since time.Time
+	// ResumptionID identifies the session's state, owned by the application, if the Server issues
+	// resumption tokens – see Server.Resumption. It is either a new random ID or, if Resumed is true,
+	// the ID of the session the client resumes, taken from the valid resumption token it sent.
+	ResumptionID string
+	// Resumed reports whether the client sent a valid resumption token.
+	Resumed bool
 
+	res        *committingWriter
+	w          http.ResponseWriter
 	didUpgrade bool
 }
 
+// Committed returns whether the response was committed – that is, the status code and headers
+// were sent or some part of the response body was written. Writes made directly through Res
+// are taken into account, as long as Res is not replaced.
+//
+// Once the session is committed, errors can't be signaled using HTTP status codes anymore:
+// only stream-level signaling is possible, such as sending an event or a comment.
+func (s *Session) Committed() bool {
+	return s.didUpgrade || (s.res != nil && s.res.committed)
+}
+
 // Send sends the given event to the client. It returns any errors that occurred while writing the event.
+// It is not safe to call Send concurrently with the provider the session is subscribed to, which sends
+// the published events – use Server.SendTo to send an event only to this session while it is subscribed.
 func (s *Session) Send(e *Message) error {
 	if err := s.doUpgrade(); err != nil {
 		return err
@@ -63,6 +90,14 @@ func (s *Session) Flush() error {
 	return nil
 }
 
+// SetWriteDeadline sets the deadline for writing the response, so writes to a client which stopped reading
+// fail instead of blocking until the operating system's timeouts fire. A zero value means no deadline.
+// Like http.ResponseController, it uses the SetWriteDeadline method of the response writer the session
+// was upgraded with, unwrapping it if needed. If no writer supports deadlines, http.ErrNotSupported is returned.
+func (s *Session) SetWriteDeadline(deadline time.Time) error {
+	return setWriteDeadline(s.w, deadline)
+}
+
 func (s *Session) doUpgrade() error {
 	if !s.didUpgrade {
 		s.Res.Header()[headerContentType] = headerContentTypeValue
@@ -96,7 +131,47 @@ func Upgrade(w http.ResponseWriter, r *http.Request) (*Session, error) {
 		id, _ = NewID(h[0])
 	}
 
-	return &Session{Req: r, Res: rw, LastEventID: id}, nil
+	res := &committingWriter{ResponseWriter: rw}
+
+	return &Session{Req: r, Res: res, LastEventID: id, Since: parseSince(r), res: res, w: w}, nil
+}
+
+// parseSince parses the request's "since" query parameter, which is either a RFC 3339 timestamp or
+// a Unix timestamp, in seconds. The zero time is returned if the parameter is missing or invalid.
+func parseSince(r *http.Request) time.Time {
+	if r.URL == nil || r.URL.RawQuery == "" {
+		return time.Time{}
+	}
+
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		return time.Time{}
+	}
+	if seconds, err := strconv.ParseInt(since, 10, 64); err == nil {
+		return time.Unix(seconds, 0)
+	}
+	if t, err := time.Parse(time.RFC3339Nano, since); err == nil {
+		return t
+	}
+
+	return time.Time{}
+}
+
+type topicsContextKey struct{}
+
+// ContextWithTopics returns a copy of the context which carries the topics the Server subscribes
+// the session of a request with this context to, if OnSession is not set. Router adapters use it
+// to subscribe the sessions to the topics given by the requests' route parameters, for example.
+// The topics are validated like any other subscription's topics – see Server.ValidateTopic.
+func ContextWithTopics(ctx context.Context, topics []string) context.Context {
+	return context.WithValue(ctx, topicsContextKey{}, topics)
+}
+
+// TopicsFromContext returns the topics set using ContextWithTopics, if any. Use it in OnSession
+// to subscribe the sessions to these topics, too.
+func TopicsFromContext(ctx context.Context) []string {
+	topics, _ := ctx.Value(topicsContextKey{}).([]string)
+	return topics
 }
 
 // ErrUpgradeUnsupported is returned when a request can't be upgraded to support server-sent events.
@@ -144,6 +219,23 @@ func getResponseWriter(w http.ResponseWriter) ResponseWriter {
 	}
 }
 
+type writeDeadliner interface {
+	SetWriteDeadline(time.Time) error
+}
+
+func setWriteDeadline(w http.ResponseWriter, deadline time.Time) error {
+	for {
+		switch v := w.(type) {
+		case writeDeadliner:
+			return v.SetWriteDeadline(deadline)
+		case rwUnwrapper:
+			w = v.Unwrap()
+		default:
+			return http.ErrNotSupported
+		}
+	}
+}
+
 type flusherWrapper struct {
 	writeFlusher
 }
@@ -153,6 +245,27 @@ func (f flusherWrapper) Flush() error {
 	return nil
 }
 
+// committingWriter records whether anything was written to the response.
+type committingWriter struct {
+	ResponseWriter
+	committed bool
+}
+
+func (c *committingWriter) WriteHeader(code int) {
+	c.committed = true
+	c.ResponseWriter.WriteHeader(code)
+}
+
+func (c *committingWriter) Write(p []byte) (int, error) {
+	c.committed = true
+	return c.ResponseWriter.Write(p)
+}
+
+func (c *committingWriter) Flush() error {
+	c.committed = true
+	return c.ResponseWriter.Flush()
+}
+
 type flusherErrorWrapper struct {
 	writeFlusherError
 }
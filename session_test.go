@@ -85,3 +85,18 @@ func TestUpgradedRequest_Send_error(t *testing.T) {
 	require.ErrorIs(t, conn.Send(&sse.Message{ID: sse.ID("")}), errWriteFailed, "invalid Send error")
 	require.True(t, rec.Flushed, "writer wasn't flushed")
 }
+
+func TestSession_Committed(t *testing.T) {
+	t.Parallel()
+
+	sess, err := sse.Upgrade(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	require.NoError(t, err, "unexpected Upgrade error")
+	require.False(t, sess.Committed(), "new session should not be committed")
+	require.NoError(t, sess.Send(&sse.Message{ID: sse.ID("1")}), "unexpected Send error")
+	require.True(t, sess.Committed(), "session should be committed after Send")
+
+	sess, err = sse.Upgrade(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", http.NoBody))
+	require.NoError(t, err, "unexpected Upgrade error")
+	sess.Res.WriteHeader(http.StatusBadRequest)
+	require.True(t, sess.Committed(), "session should be committed after writing to the response")
+}
@@ -0,0 +1,39 @@
+// Package ssechi serves go-sse event streams on chi routers, subscribing the sessions to the topics
+// given by the requests' route parameters.
+package ssechi
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+
+	"github.com/tmaxmax/go-sse"
+)
+
+// Handler returns a handler which serves the requests using the Server, subscribing the sessions
+// to the values of the given route parameters, as topics:
+//
+//	r.Get("/rooms/{room}/events", ssechi.Handler(s, "room"))
+//
+// Empty parameters are ignored and the sessions are subscribed to the DefaultTopic if all the parameters
+// are empty or none is given. The topics are set on the request's context using sse.ContextWithTopics,
+// so retrieve them with sse.TopicsFromContext if the Server's OnSession is set. Set the Server's
+// ValidateTopic to reject the topics which are not valid.
+//
+// The sessions end when the request's context is done, as with any other handler.
+func Handler(s *sse.Server, params ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var topics []string
+		for _, param := range params {
+			if topic := chi.URLParam(r, param); topic != "" {
+				topics = append(topics, topic)
+			}
+		}
+
+		if len(topics) > 0 {
+			r = r.WithContext(sse.ContextWithTopics(r.Context(), topics))
+		}
+
+		s.ServeHTTP(w, r)
+	})
+}
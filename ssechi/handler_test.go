@@ -0,0 +1,36 @@
+package ssechi_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/ssechi"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	topics := make(chan []string, 1)
+	s := &sse.Server{
+		OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+			topics <- sse.TopicsFromContext(sess.Req.Context())
+			sess.Res.WriteHeader(http.StatusNoContent)
+			return sse.Subscription{}, false
+		},
+	}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	router := chi.NewRouter()
+	router.Method(http.MethodGet, "/rooms/{room}/events", ssechi.Handler(s, "room", "missing"))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rooms/lobby/events", http.NoBody))
+	require.Equal(t, http.StatusNoContent, rec.Code, "request not served")
+	require.Equal(t, []string{"lobby"}, <-topics, "invalid topics")
+}
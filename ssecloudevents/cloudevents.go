@@ -0,0 +1,213 @@
+// Package ssecloudevents encodes CloudEvents as go-sse messages and decodes them back, so event streams
+// interoperate with CloudEvents producers and consumers.
+//
+// Events are encoded in one of two modes. In structured mode, the message's data is the event in the JSON
+// event format, so any client can decode it. In binary mode, the message's data is the event's data, the
+// message's ID and type are the event's ID and type, and the other context attributes are sent in comments
+// of the form ": ce-source: /sensors/1" – EventSource doesn't expose comments, so binary mode suits consumers
+// which read the messages themselves, such as replay providers, relays or custom clients, and browsers that
+// need only the data. The message's ID and type are the event's ID and type in both modes, so EventSource
+// clients can listen for the events by type and resume the streams.
+package ssecloudevents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/cloudevents/sdk-go/v2/types"
+
+	"github.com/tmaxmax/go-sse"
+)
+
+// Mode is the mode in which events are encoded. See the package documentation for more information.
+type Mode int
+
+// The available encoding modes.
+const (
+	Structured Mode = iota
+	Binary
+)
+
+// The prefix of the comments in which the context attributes of the binary mode events are sent.
+const attributePrefix = "ce-"
+
+// The errors returned when events can't be encoded or decoded.
+var (
+	ErrBinaryData    = errors.New("go-sse.cloudevents: binary mode event data is not valid UTF-8 text")
+	ErrNotStructured = errors.New("go-sse.cloudevents: event is not in structured mode")
+)
+
+// ToMessage encodes the event as a message in the given mode. The event must be valid. In binary mode,
+// its data must be UTF-8 text, as event streams can't transport binary data, otherwise ErrBinaryData
+// is returned – use the structured mode, which encodes binary data using Base64. As with any message,
+// the newlines in the data are normalized to LF.
+func ToMessage(e event.Event, mode Mode) (*sse.Message, error) {
+	if err := e.Validate(); err != nil {
+		return nil, fmt.Errorf("go-sse.cloudevents: invalid event: %w", err)
+	}
+
+	id, err := sse.NewID(e.ID())
+	if err != nil {
+		return nil, fmt.Errorf("go-sse.cloudevents: invalid event ID: %w", err)
+	}
+	typ, err := sse.NewType(e.Type())
+	if err != nil {
+		return nil, fmt.Errorf("go-sse.cloudevents: invalid event type: %w", err)
+	}
+
+	m := &sse.Message{ID: id, Type: typ}
+
+	if mode == Structured {
+		// The JSON encoding has no newlines, so it is a single data field.
+		data, err := json.Marshal(e)
+		if err != nil {
+			return nil, fmt.Errorf("go-sse.cloudevents: failed to encode event: %w", err)
+		}
+
+		m.AppendData(string(data))
+		return m, nil
+	}
+
+	if !utf8.Valid(e.Data()) {
+		return nil, ErrBinaryData
+	}
+
+	attributes, err := binaryAttributes(e)
+	if err != nil {
+		return nil, err
+	}
+
+	m.AppendComment(attributes...)
+	if len(e.Data()) > 0 {
+		m.AppendData(string(e.Data()))
+	}
+
+	return m, nil
+}
+
+// binaryAttributes returns the comments with the event's context attributes, except the ID and type.
+func binaryAttributes(e event.Event) ([]string, error) {
+	attributes := []string{attributePrefix + "specversion: " + e.SpecVersion(), attributePrefix + "source: " + e.Source()}
+	add := func(name, value string) {
+		if value != "" {
+			attributes = append(attributes, attributePrefix+name+": "+value)
+		}
+	}
+
+	add("datacontenttype", e.DataContentType())
+	add("dataschema", e.DataSchema())
+	add("subject", e.Subject())
+	if !e.Time().IsZero() {
+		add("time", e.Time().Format(time.RFC3339Nano))
+	}
+
+	extensions := e.Extensions()
+	names := make([]string, 0, len(extensions))
+	for name := range extensions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		formatted, err := types.Format(extensions[name])
+		if err != nil {
+			return nil, fmt.Errorf("go-sse.cloudevents: invalid extension %q: %w", name, err)
+		}
+		if strings.ContainsAny(formatted, "\r\n") {
+			return nil, fmt.Errorf("go-sse.cloudevents: extension %q contains newlines", name)
+		}
+
+		add(name, formatted)
+	}
+
+	return attributes, nil
+}
+
+// FromMessage decodes the event encoded in the message, in either mode.
+func FromMessage(m *sse.Message) (event.Event, error) {
+	var data, comments []string
+	for _, line := range strings.Split(strings.TrimSuffix(m.String(), "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "data: "):
+			data = append(data, line[len("data: "):])
+		case strings.HasPrefix(line, ": "):
+			comments = append(comments, line[len(": "):])
+		}
+	}
+
+	attributes := map[string]string{}
+	for _, c := range comments {
+		if name, value, ok := strings.Cut(c, ": "); ok && strings.HasPrefix(name, attributePrefix) {
+			attributes[name[len(attributePrefix):]] = value
+		}
+	}
+
+	if _, ok := attributes["specversion"]; !ok {
+		return decodeStructured(strings.Join(data, "\n"))
+	}
+
+	return decodeBinary(m, attributes, strings.Join(data, "\n"))
+}
+
+// FromEvent decodes the structured mode event received by a client. Binary mode events can't be decoded,
+// as clients don't receive the comments with their context attributes, so an error is returned for them –
+// ErrNotStructured, if their data is not a JSON object.
+func FromEvent(ev sse.Event) (event.Event, error) {
+	return decodeStructured(ev.Data)
+}
+
+func decodeStructured(data string) (event.Event, error) {
+	if !strings.HasPrefix(strings.TrimSpace(data), "{") {
+		return event.Event{}, ErrNotStructured
+	}
+
+	var e event.Event
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return event.Event{}, fmt.Errorf("go-sse.cloudevents: failed to decode event: %w", err)
+	}
+
+	return e, nil
+}
+
+func decodeBinary(m *sse.Message, attributes map[string]string, data string) (event.Event, error) {
+	e := event.New(attributes["specversion"])
+	e.SetID(m.ID.String())
+	e.SetType(m.Type.String())
+
+	for name, value := range attributes {
+		switch name {
+		case "specversion":
+		case "source":
+			e.SetSource(value)
+		case "datacontenttype":
+			e.SetDataContentType(value)
+		case "dataschema":
+			e.SetDataSchema(value)
+		case "subject":
+			e.SetSubject(value)
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return event.Event{}, fmt.Errorf("go-sse.cloudevents: invalid event time: %w", err)
+			}
+			e.SetTime(t)
+		default:
+			e.SetExtension(name, value)
+		}
+	}
+
+	if data != "" {
+		e.DataEncoded = []byte(data)
+	}
+	if err := e.Validate(); err != nil {
+		return event.Event{}, fmt.Errorf("go-sse.cloudevents: invalid event: %w", err)
+	}
+
+	return e, nil
+}
@@ -0,0 +1,87 @@
+package ssecloudevents_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/ssecloudevents"
+)
+
+func newEvent(tb testing.TB) event.Event {
+	tb.Helper()
+
+	e := event.New()
+	e.SetID("1")
+	e.SetType("com.example.update")
+	e.SetSource("/sensors/1")
+	e.SetSubject("temperature")
+	e.SetTime(time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	e.SetExtension("region", "eu")
+	require.NoError(tb, e.SetData(event.ApplicationJSON, map[string]int{"value": 21}))
+
+	return e
+}
+
+func TestStructured(t *testing.T) {
+	t.Parallel()
+
+	e := newEvent(t)
+
+	m, err := ssecloudevents.ToMessage(e, ssecloudevents.Structured)
+	require.NoError(t, err, "encoding failed")
+	require.Equal(t, sse.ID("1"), m.ID, "invalid message ID")
+	require.Equal(t, sse.Type("com.example.update"), m.Type, "invalid message type")
+
+	decoded, err := ssecloudevents.FromMessage(m)
+	require.NoError(t, err, "decoding message failed")
+	require.Equal(t, e.String(), decoded.String(), "invalid decoded event")
+
+	data, err := json.Marshal(e)
+	require.NoError(t, err)
+	require.Equal(t, "id: 1\nevent: com.example.update\ndata: "+string(data)+"\n\n", m.String(), "invalid message")
+
+	decoded, err = ssecloudevents.FromEvent(sse.Event{LastEventID: "1", Type: "com.example.update", Data: string(data)})
+	require.NoError(t, err, "decoding client event failed")
+	require.Equal(t, e.String(), decoded.String(), "invalid decoded client event")
+}
+
+func TestBinary(t *testing.T) {
+	t.Parallel()
+
+	e := newEvent(t)
+
+	m, err := ssecloudevents.ToMessage(e, ssecloudevents.Binary)
+	require.NoError(t, err, "encoding failed")
+
+	expected := `id: 1
+event: com.example.update
+: ce-specversion: 1.0
+: ce-source: /sensors/1
+: ce-datacontenttype: application/json
+: ce-subject: temperature
+: ce-time: 2024-05-01T12:00:00Z
+: ce-region: eu
+data: {"value":21}
+
+`
+	require.Equal(t, expected, m.String(), "invalid message")
+
+	decoded, err := ssecloudevents.FromMessage(m)
+	require.NoError(t, err, "decoding failed")
+	require.Equal(t, e.String(), decoded.String(), "invalid decoded event")
+
+	_, err = ssecloudevents.FromEvent(sse.Event{LastEventID: "1", Type: "com.example.update", Data: `{"value":21}`})
+	require.Error(t, err, "binary event decoded from client event")
+
+	require.NoError(t, e.SetData("application/octet-stream", []byte{0xff}))
+	_, err = ssecloudevents.ToMessage(e, ssecloudevents.Binary)
+	require.ErrorIs(t, err, ssecloudevents.ErrBinaryData, "binary data encoded")
+
+	_, err = ssecloudevents.ToMessage(event.New(), ssecloudevents.Structured)
+	require.Error(t, err, "invalid event encoded")
+}
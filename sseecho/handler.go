@@ -0,0 +1,41 @@
+// Package sseecho serves go-sse event streams on echo servers, subscribing the sessions to the topics
+// given by the requests' route parameters.
+package sseecho
+
+import (
+	"github.com/labstack/echo/v4"
+
+	"github.com/tmaxmax/go-sse"
+)
+
+// Handler returns an echo handler which serves the requests using the Server, subscribing the sessions
+// to the values of the given route parameters, as topics:
+//
+//	e.GET("/rooms/:room/events", sseecho.Handler(s, "room"))
+//
+// Empty parameters are ignored and the sessions are subscribed to the DefaultTopic if all the parameters
+// are empty or none is given. The topics are set on the request's context using sse.ContextWithTopics,
+// so retrieve them with sse.TopicsFromContext if the Server's OnSession is set. Set the Server's
+// ValidateTopic to reject the topics which are not valid.
+//
+// The sessions end when the request's context is done – that is, when the client goes away or the
+// request is canceled by a middleware, such as a timeout middleware. The Server writes its own
+// error responses, so the handler always returns nil.
+func Handler(s *sse.Server, params ...string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var topics []string
+		for _, param := range params {
+			if topic := c.Param(param); topic != "" {
+				topics = append(topics, topic)
+			}
+		}
+
+		r := c.Request()
+		if len(topics) > 0 {
+			r = r.WithContext(sse.ContextWithTopics(r.Context(), topics))
+		}
+
+		s.ServeHTTP(c.Response(), r)
+		return nil
+	}
+}
@@ -0,0 +1,36 @@
+package sseecho_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/sseecho"
+)
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	topics := make(chan []string, 1)
+	s := &sse.Server{
+		OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+			topics <- sse.TopicsFromContext(sess.Req.Context())
+			sess.Res.WriteHeader(http.StatusNoContent)
+			return sse.Subscription{}, false
+		},
+	}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	router := echo.New()
+	router.GET("/rooms/:room/events", sseecho.Handler(s, "room", "missing"))
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/rooms/lobby/events", http.NoBody))
+	require.Equal(t, http.StatusNoContent, rec.Code, "request not served")
+	require.Equal(t, []string{"lobby"}, <-topics, "invalid topics")
+}
@@ -0,0 +1,170 @@
+// Package ssefasthttp serves go-sse event streams on fasthttp servers: it adapts a Server, or any other
+// net/http handler which uses sse.Upgrade, to a fasthttp request handler, which streams the events
+// and flushes them as the handler requests.
+package ssefasthttp
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+// Handler returns a fasthttp request handler which serves the requests using the given handler,
+// usually a *sse.Server:
+//
+//	s := &sse.Server{}
+//	fasthttp.ListenAndServe(":8080", ssefasthttp.Handler(s))
+//
+// The requests are converted to net/http requests, so the handler reads the headers – Last-Event-ID,
+// for example –, the URL and the body as usual. The response's status code and headers are sent once
+// the handler commits the response and the body is streamed, so events are flushed to the client when
+// the handler flushes them.
+//
+// The requests' contexts are canceled when the fasthttp server shuts down or when writing to the client
+// fails. Unlike net/http, fasthttp doesn't report that clients went away, so a disconnected client is
+// detected only when the next event is sent to it – use the Server's KeepAliveInterval to detect
+// disconnected clients of idle streams.
+func Handler(h http.Handler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		var r http.Request
+		if err := fasthttpadaptor.ConvertRequest(ctx, &r, true); err != nil {
+			ctx.Error("Request conversion failed: "+err.Error(), fasthttp.StatusInternalServerError)
+			return
+		}
+
+		reqCtx, cancel := context.WithCancel(ctx)
+		w := &responseWriter{
+			header:    http.Header{},
+			status:    http.StatusOK,
+			committed: make(chan struct{}),
+			writes:    make(chan write),
+			written:   make(chan error),
+			stopped:   make(chan struct{}),
+			ctx:       reqCtx,
+			cancel:    cancel,
+		}
+
+		served := make(chan struct{})
+		go func() {
+			defer close(served)
+			defer cancel()
+
+			h.ServeHTTP(w, r.WithContext(reqCtx))
+		}()
+
+		select {
+		case <-w.committed:
+		case <-served:
+		}
+
+		for key, values := range w.header {
+			for _, value := range values {
+				ctx.Response.Header.Add(key, value)
+			}
+		}
+		ctx.SetStatusCode(w.status)
+
+		select {
+		case <-w.committed:
+			ctx.SetBodyStreamWriter(func(bw *bufio.Writer) { w.stream(bw, served) })
+		default:
+		}
+	}
+}
+
+// write is a write or flush requested by the handler.
+type write struct {
+	p     []byte
+	flush bool
+}
+
+// responseWriter is the response writer given to the handler. The status code and the headers are
+// handed to fasthttp once the response is committed and the writes are then done by the body stream
+// writer of the response, which reports their results back to the handler.
+type responseWriter struct {
+	header     http.Header
+	status     int
+	committed  chan struct{}
+	commitOnce sync.Once
+
+	writes  chan write
+	written chan error
+	// stopped is closed when the body stream writer stops because writing failed, with err set.
+	stopped chan struct{}
+	err     error
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	w.commitOnce.Do(func() {
+		w.status = code
+		close(w.committed)
+	})
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if err := w.do(write{p: p}); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (w *responseWriter) FlushError() error {
+	return w.do(write{flush: true})
+}
+
+func (w *responseWriter) Flush() {
+	_ = w.FlushError()
+}
+
+func (w *responseWriter) do(wr write) error {
+	w.WriteHeader(http.StatusOK)
+
+	select {
+	case w.writes <- wr:
+		return <-w.written
+	case <-w.stopped:
+		return w.err
+	case <-w.ctx.Done():
+		return w.ctx.Err()
+	}
+}
+
+// stream does the handler's writes until it is served or a write fails, in which case the request's
+// context is canceled.
+func (w *responseWriter) stream(bw *bufio.Writer, served <-chan struct{}) {
+	for {
+		select {
+		case wr := <-w.writes:
+			var err error
+			if wr.flush {
+				err = bw.Flush()
+			} else {
+				_, err = bw.Write(wr.p)
+			}
+
+			if err != nil {
+				w.err = err
+				w.cancel()
+				close(w.stopped)
+			}
+			w.written <- err
+
+			if err != nil {
+				return
+			}
+		case <-served:
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package ssefasthttp_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/ssefasthttp"
+)
+
+func serve(tb testing.TB, s *sse.Server) string {
+	tb.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(tb, err, "listen failed")
+
+	fs := &fasthttp.Server{Handler: ssefasthttp.Handler(s)}
+	go fs.Serve(ln) //nolint:errcheck // irrelevant
+	tb.Cleanup(func() { _ = fs.Shutdown() })
+
+	return "http://" + ln.Addr().String()
+}
+
+func TestHandler(t *testing.T) {
+	t.Parallel()
+
+	lastEventIDs := make(chan sse.EventID, 1)
+	s := &sse.Server{
+		OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+			lastEventIDs <- sess.LastEventID
+			return sse.Subscription{Client: sess, LastEventID: sess.LastEventID, Topics: []string{sse.DefaultTopic}}, true
+		},
+	}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	req, err := http.NewRequest(http.MethodGet, serve(t, s), http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Last-Event-ID", "5")
+
+	// The response is sent once the first event is.
+	responses := make(chan *http.Response, 1)
+	go func() {
+		res, err := http.DefaultClient.Do(req) //nolint:bodyclose // closed below
+		if err != nil {
+			close(responses)
+			return
+		}
+		responses <- res
+	}()
+
+	require.Equal(t, "5", (<-lastEventIDs).String(), "invalid last event ID")
+
+	m := &sse.Message{}
+	m.AppendData("hello")
+	require.Eventually(t, func() bool {
+		res, err := s.PublishWithResult(context.Background(), m)
+		return err == nil && res.Subscribers == 1
+	}, time.Second, time.Millisecond, "session not subscribed")
+
+	res, ok := <-responses
+	require.True(t, ok, "request failed")
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode, "invalid status code")
+	require.Equal(t, "text/event-stream", res.Header.Get("Content-Type"), "invalid content type")
+
+	// The stream stays open, so the event is readable only if it was flushed.
+	event := make([]byte, len("data: hello\n\n"))
+	_, err = io.ReadFull(bufio.NewReader(res.Body), event)
+	require.NoError(t, err, "event not received")
+	require.Equal(t, "data: hello\n\n", string(event), "invalid event")
+}
+
+func TestHandler_errorResponse(t *testing.T) {
+	t.Parallel()
+
+	s := &sse.Server{CORS: &sse.CORS{AllowedOrigins: []string{"https://example.com"}}}
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	req, err := http.NewRequest(http.MethodGet, serve(t, s), http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Origin", "https://evil.com")
+
+	res, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "request failed")
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err, "failed to read body")
+	require.Equal(t, http.StatusForbidden, res.StatusCode, "invalid status code")
+	require.Equal(t, sse.ErrOriginNotAllowed.Error()+"\n", string(body), "invalid body")
+}
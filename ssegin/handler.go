@@ -0,0 +1,41 @@
+// Package ssegin serves go-sse event streams on gin engines, subscribing the sessions to the topics
+// given by the requests' route parameters.
+package ssegin
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/tmaxmax/go-sse"
+)
+
+// Handler returns a gin handler which serves the requests using the Server, subscribing the sessions
+// to the values of the given route parameters, as topics:
+//
+//	engine.GET("/rooms/:room/events", ssegin.Handler(s, "room"))
+//
+// Empty parameters are ignored and the sessions are subscribed to the DefaultTopic if all the parameters
+// are empty or none is given. The topics are set on the request's context using sse.ContextWithTopics,
+// so retrieve them with sse.TopicsFromContext if the Server's OnSession is set. Set the Server's
+// ValidateTopic to reject the topics which are not valid.
+//
+// The sessions end when the request's context is done – that is, when the client goes away or the
+// request is canceled by a middleware, such as a timeout middleware. The handlers after this one
+// are not called.
+func Handler(s *sse.Server, params ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var topics []string
+		for _, param := range params {
+			if topic := c.Param(param); topic != "" {
+				topics = append(topics, topic)
+			}
+		}
+
+		r := c.Request
+		if len(topics) > 0 {
+			r = r.WithContext(sse.ContextWithTopics(r.Context(), topics))
+		}
+
+		s.ServeHTTP(c.Writer, r)
+		c.Abort()
+	}
+}
@@ -0,0 +1,189 @@
+// Package ssemetrics implements a Prometheus collector which reports the activity of a go-sse server:
+// the active connections, the events published and delivered for each topic, the subscribers dropped
+// for being too slow, the sizes of the replays and the latencies of the flushes.
+package ssemetrics
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/tmaxmax/go-sse"
+)
+
+// DefaultNamespace is the namespace of the metrics, by default.
+const DefaultNamespace = "sse"
+
+// Collector is a prometheus.Collector which reports the activity of a go-sse server. Its metrics are
+// updated by the callbacks of the Server and of Joe it is installed in – see the Instrument and InstrumentJoe
+// methods – so register it and install it before the server is used:
+//
+//	c := &ssemetrics.Collector{}
+//	c.Instrument(s)
+//	c.InstrumentJoe(joe)
+//	prometheus.MustRegister(c)
+//
+// The published and delivered events are labeled with their topic, so the number of time series grows
+// with the number of topics: do not install the collector in Joe if the topics are unbounded, such as
+// a topic for each user.
+//
+// The zero value is ready to use. The fields must not be modified after the collector is used.
+type Collector struct {
+	// Namespace is the namespace of the metrics. Defaults to DefaultNamespace.
+	Namespace string
+	// FlushBuckets are the buckets of the flush latency histogram, in seconds.
+	// Defaults to prometheus.DefBuckets.
+	FlushBuckets []float64
+	// ReplayBuckets are the buckets of the replay size histogram, in events.
+	// Defaults to exponential buckets from 1 to 1024.
+	ReplayBuckets []float64
+
+	connections   prometheus.Gauge
+	published     *prometheus.CounterVec
+	delivered     *prometheus.CounterVec
+	dropped       prometheus.Counter
+	replaySize    prometheus.Histogram
+	flushDuration prometheus.Histogram
+	initDone      sync.Once
+}
+
+func (c *Collector) init() {
+	c.initDone.Do(func() {
+		if c.Namespace == "" {
+			c.Namespace = DefaultNamespace
+		}
+		if c.FlushBuckets == nil {
+			c.FlushBuckets = prometheus.DefBuckets
+		}
+		if c.ReplayBuckets == nil {
+			c.ReplayBuckets = prometheus.ExponentialBuckets(1, 2, 11)
+		}
+
+		c.connections = prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: c.Namespace,
+			Name:      "active_connections",
+			Help:      "The number of sessions currently subscribed to the provider.",
+		})
+		c.published = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "events_published_total",
+			Help:      "The number of events published to each topic.",
+		}, []string{"topic"})
+		c.delivered = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "events_delivered_total",
+			Help:      "The number of events delivered to the subscribers of each topic.",
+		}, []string{"topic"})
+		c.dropped = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: c.Namespace,
+			Name:      "subscribers_dropped_total",
+			Help:      "The number of sessions ended for being too slow.",
+		})
+		c.replaySize = prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: c.Namespace,
+			Name:      "replay_events",
+			Help:      "The number of events replayed to new sessions.",
+			Buckets:   c.ReplayBuckets,
+		})
+		c.flushDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: c.Namespace,
+			Name:      "flush_duration_seconds",
+			Help:      "The time spent writing and flushing each batch of events to a session.",
+			Buckets:   c.FlushBuckets,
+		})
+	})
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	c.init()
+
+	return []prometheus.Collector{c.connections, c.published, c.delivered, c.dropped, c.replaySize, c.flushDuration}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.collectors() {
+		m.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.collectors() {
+		m.Collect(ch)
+	}
+}
+
+// Instrument sets the Server's callbacks which report the active connections, the dropped subscribers,
+// the replay sizes and the flush latencies. The callbacks the Server already has are kept and called
+// before the collector's. Instrument must be called before the Server is used.
+func (c *Collector) Instrument(s *sse.Server) {
+	c.init()
+
+	onSubscribe := s.OnSubscribe
+	s.OnSubscribe = func(sess *sse.Session, sub sse.Subscription) {
+		if onSubscribe != nil {
+			onSubscribe(sess, sub)
+		}
+		c.connections.Inc()
+	}
+
+	onUnsubscribe := s.OnUnsubscribe
+	s.OnUnsubscribe = func(sess *sse.Session, sub sse.Subscription, reason error) {
+		if onUnsubscribe != nil {
+			onUnsubscribe(sess, sub, reason)
+		}
+		c.connections.Dec()
+		if errors.Is(reason, sse.ErrSlowSubscriber) {
+			c.dropped.Inc()
+		}
+	}
+
+	onFlush := s.OnFlush
+	s.OnFlush = func(ctx context.Context, d time.Duration, bytes int) {
+		if onFlush != nil {
+			onFlush(ctx, d, bytes)
+		}
+		c.flushDuration.Observe(d.Seconds())
+	}
+
+	onReplayDone := s.OnReplayDone
+	s.OnReplayDone = func(ctx context.Context, d time.Duration, events int) {
+		if onReplayDone != nil {
+			onReplayDone(ctx, d, events)
+		}
+		c.replaySize.Observe(float64(events))
+	}
+}
+
+// InstrumentJoe sets Joe's callbacks which report the events published and delivered for each topic.
+// The callbacks Joe already has are kept and called before the collector's. InstrumentJoe must be
+// called before Joe is used.
+func (c *Collector) InstrumentJoe(j *sse.Joe) {
+	c.init()
+
+	onPublish := j.OnPublish
+	j.OnPublish = func(msg *sse.Message, topics []string) {
+		if onPublish != nil {
+			onPublish(msg, topics)
+		}
+		for _, topic := range topics {
+			c.published.WithLabelValues(topic).Inc()
+		}
+	}
+
+	onDispatch := j.OnDispatch
+	j.OnDispatch = func(msg *sse.Message, res sse.PublishResult) {
+		if onDispatch != nil {
+			onDispatch(msg, res)
+		}
+		for topic, delivered := range res.Topics {
+			c.delivered.WithLabelValues(topic).Add(float64(delivered))
+		}
+	}
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
@@ -0,0 +1,118 @@
+package ssemetrics_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/ssemetrics"
+)
+
+func msg(tb testing.TB, data string) *sse.Message {
+	tb.Helper()
+
+	m := &sse.Message{}
+	m.AppendData(data)
+	return m
+}
+
+const connections = `
+# HELP sse_active_connections The number of sessions currently subscribed to the provider.
+# TYPE sse_active_connections gauge
+sse_active_connections %d
+`
+
+func requireConnections(tb testing.TB, c *ssemetrics.Collector, expected int) {
+	tb.Helper()
+
+	require.Eventually(tb, func() bool {
+		exp := fmt.Sprintf(connections, expected)
+		return testutil.CollectAndCompare(c, strings.NewReader(exp), "sse_active_connections") == nil
+	}, 5*time.Second, 10*time.Millisecond, "invalid active connections")
+}
+
+func TestCollector(t *testing.T) {
+	t.Parallel()
+
+	c := &ssemetrics.Collector{}
+	j := &sse.Joe{}
+	s := &sse.Server{
+		Provider: j,
+		OnSession: func(sess *sse.Session) (sse.Subscription, bool) {
+			return sse.Subscription{Client: sess, Topics: []string{"a"}}, true
+		},
+	}
+	c.Instrument(s)
+	c.InstrumentJoe(j)
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(c))
+
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, http.NoBody)
+	require.NoError(t, err)
+	responses := make(chan *http.Response, 1)
+	go func() {
+		res, err := http.DefaultClient.Do(req) //nolint:bodyclose // closed below
+		if err == nil {
+			responses <- res
+		}
+		close(responses)
+	}()
+
+	requireConnections(t, c, 1)
+
+	require.NoError(t, s.Publish(msg(t, "hello"), "a", "b"))
+
+	res, ok := <-responses
+	require.True(t, ok, "request failed")
+	defer res.Body.Close()
+
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "data: hello\n", line, "event not received")
+
+	expected := `
+# HELP sse_events_delivered_total The number of events delivered to the subscribers of each topic.
+# TYPE sse_events_delivered_total counter
+sse_events_delivered_total{topic="a"} 1
+sse_events_delivered_total{topic="b"} 0
+# HELP sse_events_published_total The number of events published to each topic.
+# TYPE sse_events_published_total counter
+sse_events_published_total{topic="a"} 1
+sse_events_published_total{topic="b"} 1
+`
+	require.Eventually(t, func() bool {
+		return testutil.CollectAndCompare(c, strings.NewReader(expected), "sse_events_published_total", "sse_events_delivered_total") == nil
+	}, 5*time.Second, 10*time.Millisecond, "invalid event counts")
+
+	cancel()
+	requireConnections(t, c, 0)
+
+	families, err := reg.Gather()
+	require.NoError(t, err)
+
+	flushes := uint64(0)
+	for _, f := range families {
+		if f.GetName() == "sse_flush_duration_seconds" {
+			flushes = f.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	require.NotZero(t, flushes, "flushes not observed")
+}
@@ -0,0 +1,221 @@
+// Package sseotel instruments a go-sse server with OpenTelemetry tracing: it creates spans for the published
+// messages, for the sessions' subscriptions and for the replays, and propagates the trace context of
+// the published messages to their consumers, in the messages' comments.
+package sseotel
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tmaxmax/go-sse"
+)
+
+// ScopeName is the instrumentation scope name of the tracer.
+const ScopeName = "github.com/tmaxmax/go-sse/sseotel"
+
+// The attributes of the spans.
+const (
+	TopicsKey      = attribute.Key("sse.topics")
+	EventIDKey     = attribute.Key("sse.event.id")
+	EventTypeKey   = attribute.Key("sse.event.type")
+	LastEventIDKey = attribute.Key("sse.last_event_id")
+	ReplayedKey    = attribute.Key("sse.replay.events")
+)
+
+// Tracer creates the spans of a go-sse server. Publish messages with its Publish method to trace them
+// and install it in the Server with Instrument to trace the sessions:
+//
+//	t := &sseotel.Tracer{}
+//	t.Instrument(s)
+//	err := t.Publish(ctx, s, msg, "orders")
+//
+// The trace context of a published message is added to the message as comments of the form
+// ": traceparent: 00-...", one for each field of the propagator, so consumers which read the comments
+// can correlate the events with the producers' traces – see Extract. Browsers' EventSource ignores
+// comments, so the events they receive are unchanged.
+//
+// The zero value is ready to use. The fields must not be modified after the tracer is used.
+type Tracer struct {
+	// TracerProvider creates the tracer. Defaults to the global tracer provider.
+	TracerProvider trace.TracerProvider
+	// Propagator injects the trace context into the published messages and extracts it from them.
+	// Defaults to the global text map propagator.
+	Propagator propagation.TextMapPropagator
+
+	tracer   trace.Tracer
+	sessions map[*sse.Session]trace.Span
+	mu       sync.Mutex
+	initDone sync.Once
+}
+
+func (t *Tracer) init() {
+	t.initDone.Do(func() {
+		if t.TracerProvider == nil {
+			t.TracerProvider = otel.GetTracerProvider()
+		}
+		if t.Propagator == nil {
+			t.Propagator = otel.GetTextMapPropagator()
+		}
+
+		t.tracer = t.TracerProvider.Tracer(ScopeName)
+		t.sessions = map[*sse.Session]trace.Span{}
+	})
+}
+
+// Publish publishes the message to the given topics, like Server.Publish, in a producer span which is
+// a child of the context's span. The span's trace context is injected into a copy of the message,
+// so the given message is not modified.
+func (t *Tracer) Publish(ctx context.Context, s *sse.Server, msg *sse.Message, topics ...string) error {
+	t.init()
+
+	if msg == nil {
+		return sse.ErrNilMessage
+	}
+
+	attrs := []attribute.KeyValue{TopicsKey.StringSlice(topics)}
+	if msg.ID.IsSet() {
+		attrs = append(attrs, EventIDKey.String(msg.ID.String()))
+	}
+	if msg.Type.IsSet() {
+		attrs = append(attrs, EventTypeKey.String(msg.Type.String()))
+	}
+
+	ctx, span := t.tracer.Start(ctx, "sse.publish", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attrs...))
+	defer span.End()
+
+	msg = msg.Clone()
+	t.Inject(ctx, msg)
+
+	if err := s.Publish(msg, topics...); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+
+	return nil
+}
+
+// Instrument sets the Server's callbacks which trace the sessions: a span is created for each session's
+// subscription, as a child of the request context's span, and for each replay to a new session. The callbacks
+// the Server already has are kept and called before the tracer's. Instrument must be called before
+// the Server is used.
+func (t *Tracer) Instrument(s *sse.Server) {
+	t.init()
+
+	onSubscribe := s.OnSubscribe
+	s.OnSubscribe = func(sess *sse.Session, sub sse.Subscription) {
+		if onSubscribe != nil {
+			onSubscribe(sess, sub)
+		}
+
+		attrs := []attribute.KeyValue{TopicsKey.StringSlice(sub.Topics)}
+		if sub.LastEventID.IsSet() {
+			attrs = append(attrs, LastEventIDKey.String(sub.LastEventID.String()))
+		}
+
+		_, span := t.tracer.Start(sess.Req.Context(), "sse.subscribe", trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+
+		t.mu.Lock()
+		t.sessions[sess] = span
+		t.mu.Unlock()
+	}
+
+	onUnsubscribe := s.OnUnsubscribe
+	s.OnUnsubscribe = func(sess *sse.Session, sub sse.Subscription, reason error) {
+		if onUnsubscribe != nil {
+			onUnsubscribe(sess, sub, reason)
+		}
+
+		t.mu.Lock()
+		span := t.sessions[sess]
+		delete(t.sessions, sess)
+		t.mu.Unlock()
+
+		if span == nil {
+			return
+		}
+		// The subscriptions ended by a shutdown are not failures.
+		if reason != nil && !errors.Is(reason, sse.ErrProviderClosed) {
+			span.RecordError(reason)
+			span.SetStatus(codes.Error, reason.Error())
+		}
+		span.End()
+	}
+
+	onReplayDone := s.OnReplayDone
+	s.OnReplayDone = func(ctx context.Context, d time.Duration, events int) {
+		if onReplayDone != nil {
+			onReplayDone(ctx, d, events)
+		}
+
+		end := time.Now()
+		_, span := t.tracer.Start(ctx, "sse.replay", trace.WithTimestamp(end.Add(-d)), trace.WithAttributes(ReplayedKey.Int(events)))
+		span.End(trace.WithTimestamp(end))
+	}
+}
+
+// Inject adds the context's trace context to the message, as comments.
+func (t *Tracer) Inject(ctx context.Context, msg *sse.Message) {
+	t.init()
+
+	t.Propagator.Inject(ctx, commentCarrier{msg: msg})
+}
+
+// Extract returns a copy of the given context with the trace context injected into the message, if it has one.
+// Use it to correlate the received messages with the producers' traces – for example, in a Provider which
+// receives the messages from other instances or in a Server's EncodeHook.
+func (t *Tracer) Extract(ctx context.Context, msg *sse.Message) context.Context {
+	t.init()
+
+	return t.Propagator.Extract(ctx, newCommentCarrier(msg))
+}
+
+// commentCarrier is a propagation.TextMapCarrier which stores the fields in the comments of a message.
+type commentCarrier struct {
+	msg    *sse.Message
+	fields map[string]string
+}
+
+// newCommentCarrier returns a carrier with the fields found in the message's comments.
+func newCommentCarrier(msg *sse.Message) commentCarrier {
+	c := commentCarrier{fields: map[string]string{}}
+
+	s := bufio.NewScanner(strings.NewReader(msg.String()))
+	for s.Scan() {
+		comment, ok := strings.CutPrefix(s.Text(), ": ")
+		if !ok {
+			continue
+		}
+		if key, value, ok := strings.Cut(comment, ": "); ok {
+			c.fields[key] = value
+		}
+	}
+
+	return c
+}
+
+func (c commentCarrier) Get(key string) string {
+	return c.fields[key]
+}
+
+func (c commentCarrier) Set(key, value string) {
+	c.msg.AppendComment(key + ": " + value)
+}
+
+func (c commentCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.fields))
+	for key := range c.fields {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,143 @@
+package sseotel_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/tmaxmax/go-sse"
+	"github.com/tmaxmax/go-sse/sseotel"
+)
+
+type mockProvider struct {
+	subErr error
+	pub    *sse.Message
+}
+
+func (m *mockProvider) Subscribe(ctx context.Context, _ sse.Subscription) error {
+	if m.subErr != nil {
+		return m.subErr
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func (m *mockProvider) Publish(msg *sse.Message, _ []string) error {
+	m.pub = msg
+	return nil
+}
+
+func (m *mockProvider) Shutdown(context.Context) error { return nil }
+
+func newTracer(tb testing.TB) (*sseotel.Tracer, *tracetest.SpanRecorder) {
+	tb.Helper()
+
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	tb.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+	return &sseotel.Tracer{TracerProvider: tp, Propagator: propagation.TraceContext{}}, sr
+}
+
+func TestTracer_Publish(t *testing.T) {
+	t.Parallel()
+
+	tr, sr := newTracer(t)
+	p := &mockProvider{}
+	s := &sse.Server{Provider: p}
+
+	ctx, parent := tr.TracerProvider.Tracer("test").Start(context.Background(), "parent")
+	defer parent.End()
+
+	m := &sse.Message{ID: sse.ID("1")}
+	m.AppendData("hello")
+	require.NoError(t, tr.Publish(ctx, s, m, "a", "b"))
+
+	require.Equal(t, "id: 1\ndata: hello\n\n", m.String(), "published message was modified")
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1, "invalid span count")
+	span := spans[0]
+	require.Equal(t, "sse.publish", span.Name(), "invalid span name")
+	require.Equal(t, trace.SpanKindProducer, span.SpanKind(), "invalid span kind")
+	require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID(), "invalid parent span")
+	require.Contains(t, span.Attributes(), sseotel.TopicsKey.StringSlice([]string{"a", "b"}), "topics not recorded")
+	require.Contains(t, span.Attributes(), sseotel.EventIDKey.String("1"), "event ID not recorded")
+
+	require.True(t, strings.HasPrefix(p.pub.String(), "id: 1\ndata: hello\n: traceparent: 00-"), "trace context not injected: %q", p.pub.String())
+
+	extracted := trace.SpanContextFromContext(tr.Extract(context.Background(), p.pub))
+	require.Equal(t, span.SpanContext().TraceID(), extracted.TraceID(), "invalid extracted trace")
+	require.Equal(t, span.SpanContext().SpanID(), extracted.SpanID(), "invalid extracted span")
+}
+
+func TestTracer_Instrument(t *testing.T) {
+	t.Parallel()
+
+	serve := func(subErr error) sdktrace.ReadOnlySpan {
+		tr, sr := newTracer(t)
+		s := &sse.Server{Provider: &mockProvider{subErr: subErr}}
+		tr.Instrument(s)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/", http.NoBody).WithContext(ctx)
+		req.Header.Set("Last-Event-ID", "5")
+		cancel()
+		s.ServeHTTP(httptest.NewRecorder(), req)
+
+		spans := sr.Ended()
+		require.Len(t, spans, 1, "invalid span count")
+		return spans[0]
+	}
+
+	span := serve(nil)
+	require.Equal(t, "sse.subscribe", span.Name(), "invalid span name")
+	require.Equal(t, trace.SpanKindServer, span.SpanKind(), "invalid span kind")
+	require.Contains(t, span.Attributes(), sseotel.TopicsKey.StringSlice([]string{sse.DefaultTopic}), "topics not recorded")
+	require.Contains(t, span.Attributes(), sseotel.LastEventIDKey.String("5"), "last event ID not recorded")
+	require.Equal(t, codes.Unset, span.Status().Code, "invalid status")
+
+	span = serve(errors.New("failed"))
+	require.Equal(t, codes.Error, span.Status().Code, "error not recorded")
+	require.Equal(t, "failed", span.Status().Description, "invalid status description")
+}
+
+func TestTracer_replay(t *testing.T) {
+	t.Parallel()
+
+	tr, sr := newTracer(t)
+	j := &sse.Joe{ReplayProvider: &sse.FiniteReplayProvider{Count: 5, AutoIDs: true}}
+	s := &sse.Server{Provider: j}
+	tr.Instrument(s)
+	defer s.Shutdown(context.Background()) //nolint:errcheck // irrelevant
+
+	m := &sse.Message{}
+	m.AppendData("hello")
+	require.NoError(t, s.Publish(m))
+	require.NoError(t, s.Publish(m))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody).WithContext(ctx)
+	req.Header.Set("Last-Event-ID", "0")
+	cancel()
+	s.ServeHTTP(httptest.NewRecorder(), req)
+
+	var names []string
+	for _, span := range sr.Ended() {
+		names = append(names, span.Name())
+		if span.Name() == "sse.replay" {
+			require.Contains(t, span.Attributes(), sseotel.ReplayedKey.Int(1), "invalid replayed events")
+		}
+	}
+	require.ElementsMatch(t, []string{"sse.replay", "sse.subscribe"}, names, "invalid spans")
+}
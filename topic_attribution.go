@@ -0,0 +1,69 @@
+package sse
+
+// TopicAttribution configures how the events sent to a subscriber are annotated with the topic they were
+// delivered for, so clients subscribed to multiple topics over a single stream know where each event came from.
+// If a message is published to multiple topics the subscriber is subscribed to, the first of the message's
+// topics, in the order they were given on publish, is used.
+//
+// Joe annotates the events it delivers and the events replayed by the built-in replay providers.
+// Events delivered for the DefaultTopic are not annotated, as it has no name. Aliased topics are named
+// by the topic they stand for – see Joe's Aliases field.
+type TopicAttribution int
+
+// The available topic attributions.
+const (
+	// NoTopicAttribution sends the events as they were published.
+	NoTopicAttribution TopicAttribution = iota
+	// TopicInType prefixes the event type with the topic, separated by a slash: an event of type
+	// "update" delivered for topic "orders" has the type "orders/update". Events without a type
+	// have the type "orders/message". Events delivered for topics which are not valid event types
+	// are not annotated.
+	TopicInType
+	// TopicInComment adds a comment field with the topic before the event's data, of the form
+	// ": topic: orders". The event's fields are not changed, so the annotation is invisible to
+	// browsers' EventSource – use it with clients that read comments.
+	TopicInComment
+)
+
+// TopicCommentPrefix is the text before the topic in the comments added by the TopicInComment attribution.
+const TopicCommentPrefix = "topic: "
+
+// annotate returns the message annotated with the given topic. The given message is not modified.
+func (a TopicAttribution) annotate(m *Message, topic string) *Message {
+	if a == NoTopicAttribution || topic == DefaultTopic {
+		return m
+	}
+
+	switch a {
+	case TopicInType:
+		typ := DefaultEventType
+		if m.Type.IsSet() {
+			typ = m.Type.String()
+		}
+
+		t, err := NewType(topic + "/" + typ)
+		if err != nil {
+			return m
+		}
+
+		m = m.Clone()
+		m.Type = t
+	case TopicInComment:
+		annotated := m.Clone()
+		annotated.chunks = nil
+		annotated.AppendComment(TopicCommentPrefix + topic)
+		annotated.chunks = append(annotated.chunks, m.chunks...)
+		m = annotated
+	}
+
+	return m
+}
+
+// attributedTopic returns the first of the message's topics the subscription receives the messages of.
+func (s *Subscription) attributedTopic(messageTopics []string) string {
+	if topic, ok := s.receivedTopic(messageTopics); ok {
+		return topic
+	}
+
+	return DefaultTopic
+}
@@ -0,0 +1,46 @@
+package sse
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// isTopicPattern tells whether the topic contains the wildcards of path.Match.
+func isTopicPattern(topic string) bool {
+	return strings.ContainsAny(topic, `*?[\`)
+}
+
+// matchTopicPattern tells whether the messages published to the topic are sent to the subscribers of the pattern.
+// The pattern must be valid – see validateTopicPatterns.
+func matchTopicPattern(pattern, topic string) bool {
+	ok, _ := path.Match(pattern, topic)
+	return ok
+}
+
+// validateTopicPatterns returns an error wrapping path.ErrBadPattern if any of the topics is a malformed pattern.
+func validateTopicPatterns(topics []string) error {
+	for _, topic := range topics {
+		if !isTopicPattern(topic) {
+			continue
+		}
+		if _, err := path.Match(topic, ""); err != nil {
+			return fmt.Errorf("go-sse.server: invalid topic pattern %q: %w", topic, err)
+		}
+	}
+
+	return nil
+}
+
+// receivedTopic returns the first of the given topics whose messages are sent to the subscription, if any.
+func (s *Subscription) receivedTopic(topics []string) (string, bool) {
+	for _, topic := range topics {
+		for _, subscribed := range s.Topics {
+			if subscribed == topic || s.matchTopic != nil && s.matchTopic(subscribed, topic) {
+				return topic, true
+			}
+		}
+	}
+
+	return "", false
+}
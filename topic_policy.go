@@ -0,0 +1,117 @@
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// TopicPolicy configures how the Server publishes the messages sent to a topic.
+// See the Server's TopicPolicies field for more information.
+type TopicPolicy struct {
+	// DefaultType is the type set on the messages published to the topic which have no type.
+	// If unset, the messages are published without a type.
+	DefaultType EventType
+	// IDs configures whether the messages published to the topic must have IDs.
+	IDs IDPolicy
+}
+
+// IDPolicy determines whether the messages published to a topic must have IDs.
+type IDPolicy int
+
+// The available ID policies.
+const (
+	// IDOptional allows messages both with and without IDs.
+	IDOptional IDPolicy = iota
+	// IDRequired rejects the messages without IDs with ErrIDRequired.
+	IDRequired
+	// IDForbidden rejects the messages with IDs with ErrIDForbidden, so they don't change the
+	// clients' last event ID – useful for topics whose messages are never replayed, for example.
+	IDForbidden
+	// IDAuto sets the IDs of the messages without IDs. The IDs are increasing decimal numbers derived
+	// from the current time in nanoseconds, so they keep increasing after the server restarts,
+	// as long as the system clock doesn't go backwards.
+	IDAuto
+)
+
+// Errors returned by the Server's Publish method for messages which violate the topic policies.
+// They are wrapped in a *TopicPolicyError.
+var (
+	ErrIDRequired  = errors.New("go-sse.server: message has no ID")
+	ErrIDForbidden = errors.New("go-sse.server: message has an ID")
+)
+
+// TopicPolicyError is returned by the Server's Publish method when a message violates
+// the policy of a topic it is published to.
+type TopicPolicyError struct {
+	Topic string
+	Err   error
+}
+
+func (e *TopicPolicyError) Error() string {
+	return fmt.Sprintf("%v for topic %q", e.Err, e.Topic)
+}
+
+func (e *TopicPolicyError) Unwrap() error {
+	return e.Err
+}
+
+// lastAutoID is the last ID set by the IDAuto policy.
+var lastAutoID atomic.Int64
+
+func nextAutoID() EventID {
+	for {
+		last := lastAutoID.Load()
+		next := time.Now().UnixNano()
+		if next <= last {
+			next = last + 1
+		}
+		if lastAutoID.CompareAndSwap(last, next) {
+			return ID(strconv.FormatInt(next, autoIDBase))
+		}
+	}
+}
+
+// applyTopicPolicies returns the message with the defaults of the topics' policies set,
+// or an error if it violates any of them. The given message is not modified.
+func (s *Server) applyTopicPolicies(m *Message, topics []string) (*Message, error) {
+	if len(s.TopicPolicies) == 0 {
+		return m, nil
+	}
+
+	typ, id := m.Type, m.ID
+	for _, topic := range topics {
+		p := s.TopicPolicies[topic]
+		if !typ.IsSet() && p.DefaultType.IsSet() {
+			typ = p.DefaultType
+		}
+		if !id.IsSet() && p.IDs == IDAuto {
+			id = nextAutoID()
+		}
+	}
+
+	for _, topic := range topics {
+		switch s.TopicPolicies[topic].IDs {
+		case IDRequired:
+			if !id.IsSet() {
+				return nil, &TopicPolicyError{Topic: topic, Err: ErrIDRequired}
+			}
+		case IDForbidden:
+			if id.IsSet() {
+				return nil, &TopicPolicyError{Topic: topic, Err: ErrIDForbidden}
+			}
+		case IDOptional, IDAuto:
+		}
+	}
+
+	if typ == m.Type && id == m.ID {
+		return m, nil
+	}
+
+	m = m.Clone()
+	m.Type, m.ID = typ, id
+
+	return m, nil
+}
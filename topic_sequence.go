@@ -0,0 +1,65 @@
+package sse
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SequenceCommentPrefix is the text before the sequence number in the comments added by SequenceInComment.
+const SequenceCommentPrefix = "seq: "
+
+// SequenceInComment stamps the message with its sequence number in the given topic, in a comment field
+// of the form ": seq: 5 orders", added before the message's data, after its leading comments. The topic
+// is omitted for the DefaultTopic. Use it as Joe's TopicSequence, together with ParseSequenceComment
+// on the client side. The comment is invisible to browsers' EventSource – write a function which puts
+// the number in the data, instead, for those.
+func SequenceInComment(msg *Message, topic string, seq uint64) *Message {
+	comment := SequenceCommentPrefix + strconv.FormatUint(seq, 10)
+	if topic != DefaultTopic {
+		comment += " " + topic
+	}
+
+	// The comment is added after the leading comments, so the numbers of the message's topics are in order.
+	i := 0
+	for i < len(msg.chunks) && msg.chunks[i].isComment {
+		i++
+	}
+
+	stamped := msg.Clone()
+	stamped.chunks = append([]chunk(nil), msg.chunks[:i]...)
+	stamped.AppendComment(comment)
+	stamped.chunks = append(stamped.chunks, msg.chunks[i:]...)
+
+	return stamped
+}
+
+// ParseSequenceComment parses the comments added by SequenceInComment. It returns the topic and the sequence
+// number and whether the comment was added by SequenceInComment.
+func ParseSequenceComment(comment string) (topic string, seq uint64, ok bool) {
+	comment = strings.TrimSpace(comment)
+	if !strings.HasPrefix(comment, SequenceCommentPrefix) {
+		return "", 0, false
+	}
+
+	number, topic, _ := strings.Cut(comment[len(SequenceCommentPrefix):], " ")
+	seq, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return "", 0, false
+	}
+
+	return topic, seq, true
+}
+
+// stampSequences stamps the message with the next sequence number of each of its topics, using TopicSequence.
+func (j *Joe) stampSequences(msg messageWithTopics) messageWithTopics {
+	if j.TopicSequence == nil {
+		return msg
+	}
+
+	for _, topic := range msg.topics {
+		j.sequences[topic]++
+		msg.message = j.TopicSequence(msg.message, topic, j.sequences[topic])
+	}
+
+	return msg
+}
@@ -0,0 +1,102 @@
+package sse
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Codec encodes values of type T as the data of events and decodes them back. The zero value
+// encodes the values as JSON – see NewJSONMessage and Event.DecodeJSON.
+type Codec[T any] struct {
+	// Encode optionally encodes the value as the event's data. The newlines in the data are normalized
+	// to LF, as they are for any message, so Decode must accept them.
+	Encode func(v T) (string, error)
+	// Decode optionally decodes the value from the event's data.
+	Decode func(data string) (T, error)
+}
+
+func (c Codec[T]) encode(v T) (string, error) {
+	if c.Encode != nil {
+		return c.Encode(v)
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("go-sse: failed to encode JSON data: %w", err)
+	}
+
+	return string(data), nil
+}
+
+func (c Codec[T]) decode(data string) (T, error) {
+	if c.Decode != nil {
+		return c.Decode(data)
+	}
+
+	var v T
+	err := Event{Data: data}.DecodeJSON(&v)
+	return v, err
+}
+
+// TypedServer publishes values of type T, as events of a single type, using a Server. Use SubscribeAs
+// with the same event type and codec to receive the values on the client, so both ends of the stream
+// are checked at compile time:
+//
+//	updates := &sse.TypedServer[Update]{Server: s, EventType: "update"}
+//	_ = updates.Publish(Update{Price: 42}, "prices")
+//
+//	sse.SubscribeAs(conn, "update", sse.Codec[Update]{}, func(u Update, err error) { ... })
+//
+// The fields must not be modified after the TypedServer is used.
+type TypedServer[T any] struct {
+	// Server is the server the events are published with. It must not be nil.
+	Server *Server
+	// EventType is the type of the published events. If it is empty, the events have no type.
+	EventType string
+	// Codec encodes the published values. Defaults to JSON.
+	Codec Codec[T]
+}
+
+// Message returns the message in which the value is published, so it can be given an ID or comments,
+// for example, before it is published with the Server.
+func (t *TypedServer[T]) Message(v T) (*Message, error) {
+	data, err := t.Codec.encode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Message{}
+	if t.EventType != "" {
+		if m.Type, err = NewType(t.EventType); err != nil {
+			return nil, err
+		}
+	}
+	m.AppendData(data)
+
+	return m, nil
+}
+
+// Publish publishes the value to the given topics – see Server.Publish.
+func (t *TypedServer[T]) Publish(v T, topics ...string) error {
+	m, err := t.Message(v)
+	if err != nil {
+		return err
+	}
+
+	return t.Server.Publish(m, topics...)
+}
+
+// SubscribeAs subscribes the callback to the connection's events of the given type, decoding their data
+// into values of type T with the codec – use the codec of the TypedServer the events are published with.
+// The callback receives the decoding error, if any, along with the zero value. See Connection.SubscribeEvent
+// for more information.
+func SubscribeAs[T any](conn *Connection, eventType string, codec Codec[T], cb func(v T, err error)) EventCallbackRemover {
+	return conn.SubscribeEvent(eventType, func(e Event) {
+		v, err := codec.decode(e.Data)
+		if err != nil {
+			var zero T
+			v = zero
+		}
+		cb(v, err)
+	})
+}
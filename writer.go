@@ -0,0 +1,61 @@
+package sse
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+)
+
+// Writer is a MessageWriter which writes the messages to any writer, in the event stream format, without
+// the Server or a Session – for CLI tools which produce event streams, for files read back by the Parser
+// or for handlers which write the responses themselves, for example. The data of the messages is written
+// as the Server writes it: each line is a separate data field.
+//
+//	w := sse.NewWriter(os.Stdout)
+//	m := &sse.Message{}
+//	m.AppendData("first line\nsecond line")
+//	if err := w.Send(m); err != nil {
+//		// handle error
+//	}
+//	if err := w.Flush(); err != nil {
+//		// handle error
+//	}
+//
+// The messages are buffered, so call Flush to make sure they are written. A Writer must not be used
+// concurrently.
+type Writer struct {
+	w  io.Writer
+	bw *bufio.Writer
+}
+
+// NewWriter returns a Writer which writes the messages to the given writer.
+//
+// Flush also flushes the given writer, if it has a Flush method – an http.ResponseWriter, for example.
+// Handlers must set the response's Content-Type header to "text/event-stream" themselves.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, bw: bufio.NewWriter(w)}
+}
+
+// Send writes the message. Nothing is written for empty messages, as with Message.WriteTo.
+func (w *Writer) Send(m *Message) error {
+	_, err := m.WriteTo(w.bw)
+	return err
+}
+
+// Flush writes the buffered messages and flushes the underlying writer, if possible.
+func (w *Writer) Flush() error {
+	if err := w.bw.Flush(); err != nil {
+		return err
+	}
+
+	switch f := w.w.(type) {
+	case interface{ Flush() error }:
+		return f.Flush()
+	case http.Flusher:
+		f.Flush()
+	}
+
+	return nil
+}
+
+var _ MessageWriter = (*Writer)(nil)
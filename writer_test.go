@@ -0,0 +1,47 @@
+package sse_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmaxmax/go-sse"
+)
+
+type flushRecorder struct {
+	strings.Builder
+	flushes int
+}
+
+func (f *flushRecorder) Flush() error {
+	f.flushes++
+	return nil
+}
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	out := &flushRecorder{}
+	w := sse.NewWriter(out)
+
+	m := &sse.Message{ID: sse.ID("1"), Type: sse.Type("update")}
+	m.AppendData("first line\nsecond line\r\nthird line")
+
+	require.NoError(t, w.Send(m), "unexpected Send error")
+	require.NoError(t, w.Send(&sse.Message{}), "unexpected Send error for empty message")
+	require.Zero(t, out.Len(), "messages must be buffered")
+	require.NoError(t, w.Flush(), "unexpected Flush error")
+	require.Equal(t, 1, out.flushes, "underlying writer not flushed")
+	require.Equal(t, "id: 1\nevent: update\ndata: first line\ndata: second line\ndata: third line\n\n", out.String(), "invalid encoding")
+
+	p := sse.NewParser(strings.NewReader(out.String()))
+	require.True(t, p.Next(), "written message not parsed")
+	require.Equal(t, sse.Event{LastEventID: "1", Type: "update", Data: "first line\nsecond line\nthird line"}, p.Event(), "invalid round trip")
+
+	rec := httptest.NewRecorder()
+	rw := sse.NewWriter(rec)
+	require.NoError(t, rw.Send(m), "unexpected Send error")
+	require.NoError(t, rw.Flush(), "unexpected Flush error")
+	require.True(t, rec.Flushed, "response writer not flushed")
+}